@@ -0,0 +1,127 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// fileStamp identifies a version of a file, a renewed file gets a new stamp
+type fileStamp struct {
+	modified time.Time
+	size     int64
+}
+
+// Reloader serves the TLS certificate of the given files and reloads it whenever the files change,
+// so a renewed certificate is used without restarting the server
+type Reloader struct {
+	logger         hclog.Logger
+	certFile       string
+	keyFile        string
+	reloadInterval time.Duration
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	certStamp   fileStamp
+	keyStamp    fileStamp
+}
+
+// NewReloader is a function to create new Reloader struct, the certificate is loaded right away
+func NewReloader(newLogger hclog.Logger, certFile, keyFile string, reloadInterval time.Duration) (*Reloader, error) {
+	reloader := &Reloader{
+		logger:         newLogger,
+		certFile:       certFile,
+		keyFile:        keyFile,
+		reloadInterval: reloadInterval,
+	}
+
+	if _, err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// GetCertificate returns the current certificate, it is meant for tls.Config.GetCertificate
+func (reloader *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mu.RLock()
+	defer reloader.mu.RUnlock()
+
+	return reloader.certificate, nil
+}
+
+// Reload loads the certificate again when its files changed since the last load, reporting whether it did,
+// the current certificate is kept when the new files can't be loaded
+func (reloader *Reloader) Reload() (bool, error) {
+
+	// stat the files, following the symlinks swapped by the kubernetes secret volumes
+	certStamp, err := stat(reloader.certFile)
+	if err != nil {
+		return false, err
+	}
+
+	keyStamp, err := stat(reloader.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	reloader.mu.RLock()
+	unchanged := reloader.certificate != nil && certStamp == reloader.certStamp && keyStamp == reloader.keyStamp
+	reloader.mu.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	// the pair is checked to match before it is served
+	certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	reloader.mu.Lock()
+	reloader.certificate = &certificate
+	reloader.certStamp = certStamp
+	reloader.keyStamp = keyStamp
+	reloader.mu.Unlock()
+
+	return true, nil
+}
+
+// Run checks the certificate files on every reload interval until the given context is done
+func (reloader *Reloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(reloader.reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := reloader.Reload()
+			if err != nil {
+				reloader.logger.Error("Unable to reload the TLS certificate, keeping the current one", "error", err)
+
+				continue
+			}
+
+			if reloaded {
+				reloader.logger.Info("Reloaded the TLS certificate", "cert_file", reloader.certFile)
+			}
+		}
+	}
+}
+
+// stat returns the stamp of the given file
+func stat(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+
+	return fileStamp{modified: info.ModTime(), size: info.Size()}, nil
+}
@@ -0,0 +1,233 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// writeCertificate writes a self-signed certificate of the given serial number and its key to the given files,
+// their modification time is set to the given time
+func writeCertificate(t *testing.T, certFile, keyFile string, serialNumber int64, modified time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate the key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject:      pkix.Name{CommonName: "book-service"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create the certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal the key: %v", err)
+	}
+
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modified)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), modified)
+}
+
+// writeFile writes the given content to the given file along with its modification time
+func writeFile(t *testing.T, path string, content []byte, modified time.Time) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatalf("touch %s: %v", path, err)
+	}
+}
+
+// servedSerialNumber returns the serial number of the certificate served by the given reloader
+func servedSerialNumber(t *testing.T, reloader *Reloader) int64 {
+	t.Helper()
+
+	certificate, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("get the certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse the certificate: %v", err)
+	}
+
+	return leaf.SerialNumber.Int64()
+}
+
+func TestReloadServesTheRenewedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	issued := time.Now().Add(-time.Hour)
+	writeCertificate(t, certFile, keyFile, 1, issued)
+
+	reloader, err := NewReloader(hclog.NewNullLogger(), certFile, keyFile, time.Minute)
+	if err != nil {
+		t.Fatalf("new reloader: %v", err)
+	}
+
+	if serialNumber := servedSerialNumber(t, reloader); serialNumber != 1 {
+		t.Fatalf("expected the certificate loaded right away, got %d", serialNumber)
+	}
+
+	if reloaded, err := reloader.Reload(); err != nil || reloaded {
+		t.Fatalf("expected the unchanged files not reloaded, got %v %v", reloaded, err)
+	}
+
+	writeCertificate(t, certFile, keyFile, 2, issued.Add(time.Minute))
+	if reloaded, err := reloader.Reload(); err != nil || !reloaded {
+		t.Fatalf("expected the renewed files reloaded, got %v %v", reloaded, err)
+	}
+
+	if serialNumber := servedSerialNumber(t, reloader); serialNumber != 2 {
+		t.Fatalf("expected the renewed certificate served, got %d", serialNumber)
+	}
+}
+
+func TestReloadKeepsTheCurrentCertificateOnAMismatchedPair(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	issued := time.Now().Add(-time.Hour)
+	writeCertificate(t, certFile, keyFile, 1, issued)
+
+	reloader, err := NewReloader(hclog.NewNullLogger(), certFile, keyFile, time.Minute)
+	if err != nil {
+		t.Fatalf("new reloader: %v", err)
+	}
+
+	// the certificate is renewed before its key, the pair doesn't match yet
+	writeCertificate(t, certFile, filepath.Join(dir, "next.key"), 2, issued.Add(time.Minute))
+	if reloaded, err := reloader.Reload(); err == nil || reloaded {
+		t.Fatalf("expected the mismatched pair refused, got %v %v", reloaded, err)
+	}
+
+	if serialNumber := servedSerialNumber(t, reloader); serialNumber != 1 {
+		t.Fatalf("expected the current certificate kept, got %d", serialNumber)
+	}
+
+	// the pair is loaded once the key is renewed too
+	if err := os.Rename(filepath.Join(dir, "next.key"), keyFile); err != nil {
+		t.Fatalf("renew the key: %v", err)
+	}
+
+	if reloaded, err := reloader.Reload(); err != nil || !reloaded {
+		t.Fatalf("expected the matching pair reloaded, got %v %v", reloaded, err)
+	}
+}
+
+func TestReloadFollowsTheSwappedSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	issued := time.Now().Add(-time.Hour)
+
+	// the kubernetes secret volumes swap a ..data symlink to the directory of the new files
+	for serialNumber, version := range []string{"v1", "v2"} {
+		if err := os.Mkdir(filepath.Join(dir, version), 0700); err != nil {
+			t.Fatalf("mkdir %s: %v", version, err)
+		}
+
+		writeCertificate(t, filepath.Join(dir, version, "tls.crt"), filepath.Join(dir, version, "tls.key"), int64(serialNumber+1), issued.Add(time.Duration(serialNumber)*time.Minute))
+	}
+
+	if err := os.Symlink("v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatalf("link the data: %v", err)
+	}
+
+	for _, name := range []string{"tls.crt", "tls.key"} {
+		if err := os.Symlink(filepath.Join("..data", name), filepath.Join(dir, name)); err != nil {
+			t.Fatalf("link %s: %v", name, err)
+		}
+	}
+
+	reloader, err := NewReloader(hclog.NewNullLogger(), filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), time.Minute)
+	if err != nil {
+		t.Fatalf("new reloader: %v", err)
+	}
+
+	if err := os.Symlink("v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatalf("link the new data: %v", err)
+	}
+
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatalf("swap the data: %v", err)
+	}
+
+	if reloaded, err := reloader.Reload(); err != nil || !reloaded {
+		t.Fatalf("expected the swapped files reloaded, got %v %v", reloaded, err)
+	}
+
+	if serialNumber := servedSerialNumber(t, reloader); serialNumber != 2 {
+		t.Fatalf("expected the new certificate served, got %d", serialNumber)
+	}
+}
+
+func TestNewReloaderRefusesMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := NewReloader(hclog.NewNullLogger(), filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), time.Minute); err == nil {
+		t.Fatalf("expected the missing certificate refused")
+	}
+}
+
+func TestRunReloadsUntilTheContextIsDone(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	issued := time.Now().Add(-time.Hour)
+	writeCertificate(t, certFile, keyFile, 1, issued)
+
+	reloader, err := NewReloader(hclog.NewNullLogger(), certFile, keyFile, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("new reloader: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reloader.Run(ctx)
+		close(done)
+	}()
+
+	writeCertificate(t, certFile, keyFile, 2, issued.Add(time.Minute))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servedSerialNumber(t, reloader) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the renewed certificate picked up by the run")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the run stopped with its context")
+	}
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/fakhripraya/book-service/entities"
 
@@ -43,3 +45,11 @@ func DbURL(dbConfig *DBConfig) string {
 		dbConfig.DBName,
 	)
 }
+
+// ConfigurePool is a function that applies the connection pool limits of the database configuration to the given connection pool
+func ConfigurePool(db *sql.DB, dbConfig *entities.DatabaseConfiguration) {
+	db.SetMaxOpenConns(dbConfig.MaxOpenConns)
+	db.SetMaxIdleConns(dbConfig.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(dbConfig.ConnMaxLifetime) * time.Second)
+	db.SetConnMaxIdleTime(time.Duration(dbConfig.ConnMaxIdleTime) * time.Second)
+}
@@ -0,0 +1,201 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/tracing"
+)
+
+// Sources of an authenticated principal
+const (
+	PrincipalSourceSession = "session"
+	PrincipalSourceBearer  = "bearer"
+)
+
+// Claims determine the current user token holder,
+// UserID and RoleID are optional, the user is always resolved from the db and they are only checked against it
+type Claims struct {
+	Username  string
+	UserID    uint   `json:"UserID,omitempty"`
+	RoleID    uint   `json:"RoleID,omitempty"`
+	Algorithm string `json:"-"` // the algorithm the parsed token was signed with
+	TokenHash string `json:"-"` // hex sha256 of the parsed token, identifies the tokens without jti
+	jwt.StandardClaims
+}
+
+// TokenID returns the key identifying the token of the claims in the denylist,
+// the jti when set or else the hash of the token itself
+func (claims *Claims) TokenID() string {
+	if claims.Id != "" {
+		return claims.Id
+	}
+
+	return "sha256:" + claims.TokenHash
+}
+
+// IsSelfIssued checks whether the token of the claims was signed with the shared secret of this service,
+// the tokens verified against the JWKS are issued elsewhere and this service can't sign them again
+func (claims *Claims) IsSelfIssued() bool {
+	_, ok := jwt.GetSigningMethod(claims.Algorithm).(*jwt.SigningMethodHMAC)
+	return ok
+}
+
+// PrincipalKey returns the key identifying the token holder, the subject claim when set or else the username
+func (claims *Claims) PrincipalKey() string {
+	if claims.Subject != "" {
+		return claims.Subject
+	}
+
+	return claims.Username
+}
+
+// Principal is the authenticated caller of the current request
+type Principal struct {
+	User   *database.MasterUser
+	Claims *Claims
+	Source string
+}
+
+// ParseToken parses the given signed token and validates its signature, exp, nbf, iss and aud claims
+func ParseToken(tokenString string) (*Claims, error) {
+
+	// Initialize a new instance of claims
+	claims := &Claims{}
+
+	// jwt-go validates the exp, iat and nbf claims while parsing
+	token, err := jwt.ParseWithClaims(tokenString, claims, selectVerificationKey)
+
+	if err != nil {
+		if validationErr, ok := err.(*jwt.ValidationError); ok && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, NewMessageError(MsgTokenExpired, err)
+		}
+
+		return nil, NewMessageError(MsgTokenInvalid, err)
+	}
+
+	if !token.Valid {
+		return nil, NewMessageError(MsgTokenInvalid, nil)
+	}
+
+	// the renewal signs the token again with the same algorithm
+	claims.Algorithm = token.Method.Alg()
+
+	// a single token without jti is revoked by its hash
+	tokenHash := sha256.Sum256([]byte(tokenString))
+	claims.TokenHash = hex.EncodeToString(tokenHash[:])
+
+	// the session lifetime and the logout from every device are both counted from the iat
+	if claims.IssuedAt == 0 {
+		return nil, NewMessageError(MsgTokenInvalid, fmt.Errorf("token has no iat"))
+	}
+
+	// the issuer and audience are only enforced when configured
+	if MyIssuer != "" && !claims.VerifyIssuer(MyIssuer, true) {
+		return nil, NewMessageError(MsgTokenInvalid, fmt.Errorf("unexpected token issuer %q", claims.Issuer))
+	}
+
+	if MyAudience != "" && !claims.VerifyAudience(MyAudience, true) {
+		return nil, NewMessageError(MsgTokenInvalid, fmt.Errorf("unexpected token audience %q", claims.Audience))
+	}
+
+	// the session can't be extended past its absolute lifetime
+	deadline := MyTokenPolicy.SessionDeadline(claims)
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		return nil, NewMessageError(MsgSessionExpired, nil)
+	}
+
+	if claims.Username == "" {
+		return nil, NewMessageError(MsgTokenInvalid, fmt.Errorf("token has no username"))
+	}
+
+	return claims, nil
+}
+
+// selectVerificationKey selects the key that verifies the given token based on its algorithm and kid,
+// HMAC tokens use the shared secret while RSA and ECDSA tokens use the JWKS
+func selectVerificationKey(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if MySigningKey == "" {
+			return nil, fmt.Errorf("HMAC signed tokens are not accepted")
+		}
+
+		return []byte(MySigningKey), nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		if MyKeySet == nil {
+			return nil, fmt.Errorf("asymmetric signed tokens are not accepted")
+		}
+
+		kid, _ := token.Header["kid"].(string)
+
+		return MyKeySet.Key(kid, token.Method.Alg())
+	default:
+		return nil, fmt.Errorf("Error while parsing the token with claims")
+	}
+}
+
+// GetPrincipalUser resolves the user holding the given claims from the principal cache and else from the db,
+// the role and the active flag always come from the db so a demoted or deactivated user loses access
+// once the cache entry expires, the user id and role of the claims are only checked against it
+func (book *Book) GetPrincipalUser(ctx context.Context, claims *Claims) (user *database.MasterUser, err error) {
+	ctx, span := tracing.Start(ctx, "Book.GetPrincipalUser")
+	defer func() { tracing.End(span, err) }()
+
+	key := claims.PrincipalKey()
+	if cached, ok := book.principals.Get(key); ok {
+		cachedUser := *cached.(*database.MasterUser)
+
+		// a token issued with another role than the cached one means the role changed meanwhile
+		if claims.RoleID == 0 || claims.RoleID == cachedUser.RoleID {
+			return book.checkPrincipalClaims(&cachedUser, claims)
+		}
+
+		book.principals.Invalidate(key)
+	}
+
+	user, err = book.GetUserByUsername(ctx, claims.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	// cache a copy so handlers can't alter the cached user
+	cached := *user
+	book.principals.Set(key, &cached)
+
+	return book.checkPrincipalClaims(user, claims)
+}
+
+// checkPrincipalClaims checks that the user resolved for the given claims is the one the token was issued to
+func (book *Book) checkPrincipalClaims(user *database.MasterUser, claims *Claims) (*database.MasterUser, error) {
+	if claims.UserID != 0 && claims.UserID != user.ID {
+		return nil, NewMessageError(MsgUnauthorized, fmt.Errorf("token user id %d does not match user %q", claims.UserID, claims.Username))
+	}
+
+	return user, nil
+}
+
+// InvalidatePrincipal drops the cached user of the given principal key,
+// to be called whenever the user changes or must be resolved again
+func (book *Book) InvalidatePrincipal(key string) {
+	book.principals.Invalidate(key)
+}
+
+// GetUserByUsername will get the user info of the given username, a deactivated user is not found
+func (book *Book) GetUserByUsername(ctx context.Context, username string) (*database.MasterUser, error) {
+
+	// work with database
+	// look for the active user in the db
+	var user database.MasterUser
+	if err := config.DB.WithContext(ctx).Where("username = ? AND is_active = ?", username, true).First(&user).Error; err != nil {
+		return nil, NewMessageError(MsgUnauthorized, err)
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,113 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// useSigningKey sets the shared secret and the expected issuer and audience for the duration of the test
+func useSigningKey(t *testing.T, signingKey, issuer, audience string) {
+	previousKey, previousIssuer, previousAudience := MySigningKey, MyIssuer, MyAudience
+	MySigningKey, MyIssuer, MyAudience = signingKey, issuer, audience
+	t.Cleanup(func() { MySigningKey, MyIssuer, MyAudience = previousKey, previousIssuer, previousAudience })
+}
+
+// signTestToken signs the given claims with the given method and key
+func signTestToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims *Claims) string {
+	t.Helper()
+
+	signed, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("sign the token: %v", err)
+	}
+
+	return signed
+}
+
+func TestParseTokenValidatesTheClaims(t *testing.T) {
+	useSigningKey(t, "secret", "user-service", "book-service")
+
+	valid := func() *Claims {
+		return &Claims{
+			Username: "tenant",
+			UserID:   20,
+			RoleID:   1,
+			StandardClaims: jwt.StandardClaims{
+				Issuer:    "user-service",
+				Audience:  "book-service",
+				IssuedAt:  time.Now().Unix(),
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			},
+		}
+	}
+
+	claims, err := ParseToken(signTestToken(t, jwt.SigningMethodHS256, []byte("secret"), valid()))
+	if err != nil {
+		t.Fatalf("parse the token: %v", err)
+	}
+
+	if claims.Username != "tenant" || claims.UserID != 20 || claims.RoleID != 1 || claims.Algorithm != "HS256" || claims.TokenHash == "" {
+		t.Fatalf("expected the claims of the token, got %+v", claims)
+	}
+
+	for name, test := range map[string]struct {
+		change   func(claims *Claims)
+		key      string
+		expected MessageCode
+	}{
+		"expired":         {func(claims *Claims) { claims.ExpiresAt = time.Now().Add(-time.Minute).Unix() }, "secret", MsgTokenExpired},
+		"not yet valid":   {func(claims *Claims) { claims.NotBefore = time.Now().Add(time.Hour).Unix() }, "secret", MsgTokenInvalid},
+		"other issuer":    {func(claims *Claims) { claims.Issuer = "someone-else" }, "secret", MsgTokenInvalid},
+		"other audience":  {func(claims *Claims) { claims.Audience = "another-service" }, "secret", MsgTokenInvalid},
+		"without iat":     {func(claims *Claims) { claims.IssuedAt = 0 }, "secret", MsgTokenInvalid},
+		"without user":    {func(claims *Claims) { claims.Username = "" }, "secret", MsgTokenInvalid},
+		"other signature": {func(claims *Claims) {}, "another-secret", MsgTokenInvalid},
+	} {
+		claims := valid()
+		test.change(claims)
+
+		_, err := ParseToken(signTestToken(t, jwt.SigningMethodHS256, []byte(test.key), claims))
+		if code := messageCode(err); code != test.expected {
+			t.Fatalf("%s: expected %s, got %v", name, test.expected, err)
+		}
+	}
+
+	// an unsigned token is never accepted
+	unsigned := signTestToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid())
+	if _, err := ParseToken(unsigned); messageCode(err) != MsgTokenInvalid {
+		t.Fatalf("expected the unsigned token refused, got %v", err)
+	}
+}
+
+func TestParseTokenWithoutIssuerAndAudience(t *testing.T) {
+	useSigningKey(t, "secret", "", "")
+
+	// the issuer and audience are only enforced when configured
+	token := signTestToken(t, jwt.SigningMethodHS256, []byte("secret"), &Claims{
+		Username: "tenant",
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    "anyone",
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	})
+
+	if _, err := ParseToken(token); err != nil {
+		t.Fatalf("expected the token accepted, got %v", err)
+	}
+}
+
+func TestClaimsKeys(t *testing.T) {
+	claims := &Claims{Username: "tenant", TokenHash: "abc"}
+	if claims.TokenID() != "sha256:abc" || claims.PrincipalKey() != "tenant" {
+		t.Fatalf("expected the keys of a token without jti and sub, got %s and %s", claims.TokenID(), claims.PrincipalKey())
+	}
+
+	claims.Id = "jti-1"
+	claims.Subject = "user-20"
+	if claims.TokenID() != "jti-1" || claims.PrincipalKey() != "user-20" {
+		t.Fatalf("expected the jti and sub used, got %s and %s", claims.TokenID(), claims.PrincipalKey())
+	}
+}
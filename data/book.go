@@ -2,67 +2,27 @@ package data
 
 import (
 	"crypto/rand"
-	"fmt"
 	"io"
-	"net/http"
+	"math"
 	"strconv"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
-	"github.com/fakhripraya/book-service/config"
 	"github.com/fakhripraya/book-service/database"
-	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/tracing"
 	"github.com/hashicorp/go-hclog"
-	"github.com/srinathgs/mysqlstore"
 	"gorm.io/gorm"
 )
 
-// Claims determine the current user token holder
-type Claims struct {
-	Username string
-	jwt.StandardClaims
-}
-
 // Book defines a struct for book flow
 type Book struct {
-	logger hclog.Logger
+	logger      hclog.Logger
+	principals  *TTLCache
+	revocations *TTLCache
 }
 
 // NewBook is a function to create new Book struct
-func NewBook(newLogger hclog.Logger) *Book {
-	return &Book{newLogger}
-}
-
-// GetCurrentUser will get the current user login info
-func (book *Book) GetCurrentUser(rw http.ResponseWriter, r *http.Request, store *mysqlstore.MySQLStore) (*database.MasterUser, error) {
-
-	// Get a session (existing/new)
-	session, err := store.Get(r, "session-name")
-	if err != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
-
-		return nil, err
-	}
-
-	// check the logged in user from the session
-	// if user available, get the user info from the session
-	if session.Values["userLoggedin"] == nil {
-		rw.WriteHeader(http.StatusUnauthorized)
-
-		return nil, fmt.Errorf("Error 401")
-	}
-
-	// work with database
-	// look for the current user logged in in the db
-	var currentUser database.MasterUser
-	if err := config.DB.Where("username = ?", session.Values["userLoggedin"].(string)).First(&currentUser).Error; err != nil {
-		rw.WriteHeader(http.StatusUnauthorized)
-
-		return nil, err
-	}
-
-	return &currentUser, nil
-
+func NewBook(newLogger hclog.Logger, newPrincipalCache *TTLCache, newRevocationCache *TTLCache) *Book {
+	return &Book{newLogger, newPrincipalCache, newRevocationCache}
 }
 
 // GenerateCode will generate the new given type code
@@ -94,8 +54,79 @@ func (book *Book) GenerateCode(codeType, country, city string) (string, error) {
 
 }
 
+// GetRoomPrice is a function to get the amount due for booking the given room for the given period,
+// the room price is quoted for the period of its RoomPriceUOM and converted to the booked period
+func (book *Book) GetRoomPrice(db *gorm.DB, kostID, roomID, roomDetailID, periodID uint) (price float64, err error) {
+
+	// trace the operation within the trace of the given db session
+	ctx, span := tracing.Start(db.Statement.Context, "Book.GetRoomPrice")
+	defer func() { tracing.End(span, err) }()
+	db = db.WithContext(ctx)
+
+	// look for the booked room of the kost
+	var room database.DBKostRoom
+	if dbErr := db.Where("id = ? AND kost_id = ? AND is_active = ?", roomID, kostID, true).First(&room).Error; dbErr != nil {
+		return 0, NotFoundError(MsgRoomNotFound, dbErr)
+	}
+
+	// the booked room detail must be one of the room
+	var roomDetail database.DBKostRoomDetail
+	if dbErr := db.Where("id = ? AND room_id = ? AND is_active = ?", roomDetailID, room.ID, true).First(&roomDetail).Error; dbErr != nil {
+		return 0, NotFoundError(MsgRoomNotFound, dbErr)
+	}
+
+	// the booked period must be offered by the kost
+	var periodCount int64
+	if dbErr := db.Model(&database.DBKostPeriod{}).
+		Where("kost_id = ? AND period_id = ? AND is_active = ?", kostID, periodID, true).
+		Count(&periodCount).Error; dbErr != nil {
+		return 0, dbErr
+	}
+
+	if periodCount == 0 {
+		return 0, NewMessageError(MsgPeriodNotOffered, nil)
+	}
+
+	if periodID == room.RoomPriceUOM {
+		return room.RoomPrice, nil
+	}
+
+	// convert the room price to the booked period by the length of both periods
+	var periods []database.MasterPeriod
+	if dbErr := db.Where("id IN ?", []uint{periodID, room.RoomPriceUOM}).Find(&periods).Error; dbErr != nil {
+		return 0, dbErr
+	}
+
+	var bookedDays, quotedDays int
+	for _, period := range periods {
+		days, ok := PeriodDays(period.PeriodDesc)
+		if !ok {
+			return 0, NewMessageError(MsgPeriodNotOffered, nil)
+		}
+
+		if period.ID == periodID {
+			bookedDays = days
+		} else {
+			quotedDays = days
+		}
+	}
+
+	if bookedDays == 0 || quotedDays == 0 {
+		return 0, NewMessageError(MsgPeriodNotOffered, nil)
+	}
+
+	return math.Round(room.RoomPrice*float64(bookedDays)/float64(quotedDays)*100) / 100, nil
+
+}
+
 // AddTransaction is a function to add transaction based on the given transaction entry, this transaction is not scoped
-func (book *Book) AddTransaction(currentUser *database.MasterUser, ReferenceID, TrxCategory uint, mustPay float64) (uint, error) {
+// and runs within the given db session, either config.DB or an open transaction
+func (book *Book) AddTransaction(tx *gorm.DB, currentUser *database.MasterUser, ReferenceID, TrxCategory uint, mustPay float64) (trxID uint, err error) {
+
+	// trace the operation within the trace of the given db session
+	ctx, span := tracing.Start(tx.Statement.Context, "Book.AddTransaction")
+	defer func() { tracing.End(span, err) }()
+	tx = tx.WithContext(ctx)
 
 	// set variables
 	var newTransaction database.DBTransaction
@@ -112,7 +143,7 @@ func (book *Book) AddTransaction(currentUser *database.MasterUser, ReferenceID,
 	newTransaction.ModifiedBy = currentUser.Username
 
 	// insert the new transaction to database
-	if dbErr = config.DB.Create(&newTransaction).Error; dbErr != nil {
+	if dbErr = tx.Create(&newTransaction).Error; dbErr != nil {
 		return 0, dbErr
 	}
 
@@ -121,7 +152,13 @@ func (book *Book) AddTransaction(currentUser *database.MasterUser, ReferenceID,
 }
 
 // AddTransactionDetail is a function to add transaction detail based on the given transaction entry, this transaction is not scoped
-func (book *Book) AddTransactionDetail(currentUser *database.MasterUser, status, trxID, PaymentMethodID uint, payment float64) error {
+// and runs within the given db session, either config.DB or an open transaction
+func (book *Book) AddTransactionDetail(tx *gorm.DB, currentUser *database.MasterUser, status, trxID, PaymentMethodID uint, payment float64) (err error) {
+
+	// trace the operation within the trace of the given db session
+	ctx, span := tracing.Start(tx.Statement.Context, "Book.AddTransactionDetail")
+	defer func() { tracing.End(span, err) }()
+	tx = tx.WithContext(ctx)
 
 	// set variables
 	var newTransactionDetail database.DBTransactionDetail
@@ -138,7 +175,7 @@ func (book *Book) AddTransactionDetail(currentUser *database.MasterUser, status,
 	newTransactionDetail.ModifiedBy = currentUser.Username
 
 	// insert the new transaction detail to database
-	if dbErr = config.DB.Create(&newTransactionDetail).Error; dbErr != nil {
+	if dbErr = tx.Create(&newTransactionDetail).Error; dbErr != nil {
 		return dbErr
 	}
 
@@ -146,10 +183,34 @@ func (book *Book) AddTransactionDetail(currentUser *database.MasterUser, status,
 
 }
 
-// UpdateTransaction is a function to update transaction based on the given transaction entry
-func (book *Book) UpdateTransaction(currentUser *database.MasterUser, targetTransaction *database.DBTransaction) error {
+// UpdateRoomBook is a function to update the room book based on the given room book entry,
+// it fails with a MsgVersionConflict error when the room book changed since it was read
+func (book *Book) UpdateRoomBook(db *gorm.DB, currentUser *database.MasterUser, targetBook *database.DBTransactionRoomBook) (err error) {
+
+	// trace the operation within the trace of the given db session
+	ctx, span := tracing.Start(db.Statement.Context, "Book.UpdateRoomBook")
+	defer func() { tracing.End(span, err) }()
+	db = db.WithContext(ctx)
 
-	err := config.DB.Transaction(func(tx *gorm.DB) error {
+	targetBook.Modified = time.Now().Local()
+	targetBook.ModifiedBy = currentUser.Username
+
+	// update the room book unless another request changed it since it was read
+	return SaveVersioned(db, targetBook, &targetBook.Version)
+
+}
+
+// UpdateTransaction is a function to update transaction based on the given transaction entry,
+// the transaction scope is nested in the given db session and it fails with a MsgVersionConflict error
+// when the transaction changed since it was read
+func (book *Book) UpdateTransaction(db *gorm.DB, currentUser *database.MasterUser, targetTransaction *database.DBTransaction) (err error) {
+
+	// trace the operation within the trace of the given db session
+	ctx, span := tracing.Start(db.Statement.Context, "Book.UpdateTransaction")
+	defer func() { tracing.End(span, err) }()
+	db = db.WithContext(ctx)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
 
 		// set variables
 		var dbErr error
@@ -157,8 +218,8 @@ func (book *Book) UpdateTransaction(currentUser *database.MasterUser, targetTran
 		targetTransaction.Modified = time.Now().Local()
 		targetTransaction.ModifiedBy = currentUser.Username
 
-		// update the transaction
-		dbErr = config.DB.Where("id = ?", targetTransaction.ID).Save(&targetTransaction).Error
+		// update the transaction unless another request changed it since it was read
+		dbErr = SaveVersioned(tx, targetTransaction, &targetTransaction.Version)
 		if dbErr != nil {
 			return dbErr
 		}
@@ -178,10 +239,16 @@ func (book *Book) UpdateTransaction(currentUser *database.MasterUser, targetTran
 
 }
 
-// UpdateTransactionDetail is a function to update transaction detail based on the given transaction entry
-func (book *Book) UpdateTransactionDetail(currentUser *database.MasterUser, targetTransactionDetail *database.DBTransactionDetail) error {
+// UpdateTransactionDetail is a function to update transaction detail based on the given transaction entry,
+// the transaction scope is nested in the given db session
+func (book *Book) UpdateTransactionDetail(db *gorm.DB, currentUser *database.MasterUser, targetTransactionDetail *database.DBTransactionDetail) (err error) {
 
-	err := config.DB.Transaction(func(tx *gorm.DB) error {
+	// trace the operation within the trace of the given db session
+	ctx, span := tracing.Start(db.Statement.Context, "Book.UpdateTransactionDetail")
+	defer func() { tracing.End(span, err) }()
+	db = db.WithContext(ctx)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
 
 		// set variables
 		var dbErr error
@@ -190,7 +257,7 @@ func (book *Book) UpdateTransactionDetail(currentUser *database.MasterUser, targ
 		targetTransactionDetail.ModifiedBy = currentUser.Username
 
 		// update the transaction detail
-		dbErr = config.DB.Save(&targetTransactionDetail).Error
+		dbErr = tx.Save(&targetTransactionDetail).Error
 
 		if dbErr != nil {
 			return dbErr
@@ -211,11 +278,17 @@ func (book *Book) UpdateTransactionDetail(currentUser *database.MasterUser, targ
 
 }
 
-// AddRoomBookMember is a function to add book member based on the given book entity
-func (book *Book) AddRoomBookMember(currentUser *database.MasterUser, roomBookID uint, targetRoomBookMember []database.DBTransactionRoomBookMember) error {
+// AddRoomBookMember is a function to add book member based on the given book entity,
+// the transaction scope is nested in the given db session
+func (book *Book) AddRoomBookMember(db *gorm.DB, currentUser *database.MasterUser, roomBookID uint, targetRoomBookMember []database.DBTransactionRoomBookMember) (err error) {
+
+	// trace the operation within the trace of the given db session
+	ctx, span := tracing.Start(db.Statement.Context, "Book.AddRoomBookMember")
+	defer func() { tracing.End(span, err) }()
+	db = db.WithContext(ctx)
 
 	// add the room book member to the database with transaction scope
-	err := config.DB.Transaction(func(tx *gorm.DB) error {
+	err = db.Transaction(func(tx *gorm.DB) error {
 
 		// set variable
 		var dbErr error
@@ -250,19 +323,23 @@ func (book *Book) AddRoomBookMember(currentUser *database.MasterUser, roomBookID
 	return nil
 }
 
-// AddVerificationPhoto is a function to add verification photo based on the given book entity
-func (book *Book) AddVerificationPhoto(currentUser *database.MasterUser, referenceID uint, targetVerification entities.TransactionVerification) error {
+// AddVerificationPhoto is a function to add verification photo based on the given book entity,
+// the transaction scope is nested in the given db session
+func (book *Book) AddVerificationPhoto(db *gorm.DB, currentUser *database.MasterUser, referenceID uint, targetVerification database.DBTransactionVerification) (err error) {
+
+	// trace the operation within the trace of the given db session
+	ctx, span := tracing.Start(db.Statement.Context, "Book.AddVerificationPhoto")
+	defer func() { tracing.End(span, err) }()
+	db = db.WithContext(ctx)
 
 	// add the new transaction verification photo to the database with transaction scope
-	err := config.DB.Transaction(func(tx *gorm.DB) error {
+	err = db.Transaction(func(tx *gorm.DB) error {
 
 		// set variable
 		var dbErr error
-		var newVerification database.DBTransactionVerification
+		var newVerification = targetVerification
 
 		newVerification.ReferenceID = referenceID
-		newVerification.PictDesc = targetVerification.PictDesc
-		newVerification.URL = targetVerification.URL
 		newVerification.IsActive = true
 		newVerification.Created = time.Now().Local()
 		newVerification.CreatedBy = currentUser.Username
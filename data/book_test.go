@@ -0,0 +1,117 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fakhripraya/book-service/database"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns an in-memory database holding the given tables for the duration of the test
+func newTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.Replace(t.Name(), "/", "_", -1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open the test database: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get the test connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatalf("migrate the test database: %v", err)
+	}
+
+	return db
+}
+
+// messageCode returns the message code carried by the given error
+func messageCode(err error) MessageCode {
+	var messageError *MessageError
+	if errors.As(err, &messageError) {
+		return messageError.Code
+	}
+
+	return ""
+}
+
+func TestGetRoomPrice(t *testing.T) {
+	db := newTestDB(t, &database.DBKostRoom{}, &database.DBKostRoomDetail{}, &database.DBKostPeriod{}, &database.MasterPeriod{})
+	book := NewBook(hclog.NewNullLogger(), nil, nil)
+
+	// a room of kost 1 priced monthly, the kost offers the monthly and the annual periods
+	monthly := database.MasterPeriod{PeriodDesc: "Monthly", IsActive: true, Created: time.Now(), Modified: time.Now()}
+	annual := database.MasterPeriod{PeriodDesc: "Annual", IsActive: true, Created: time.Now(), Modified: time.Now()}
+	weekly := database.MasterPeriod{PeriodDesc: "Weekly", IsActive: true, Created: time.Now(), Modified: time.Now()}
+	for _, period := range []*database.MasterPeriod{&monthly, &annual, &weekly} {
+		if err := db.Create(period).Error; err != nil {
+			t.Fatalf("seed the period: %v", err)
+		}
+	}
+
+	room := database.DBKostRoom{KostID: 1, RoomPrice: 1500000, RoomPriceUOM: monthly.ID, IsActive: true}
+	if err := db.Create(&room).Error; err != nil {
+		t.Fatalf("seed the room: %v", err)
+	}
+
+	roomDetail := database.DBKostRoomDetail{KostID: 1, RoomID: room.ID, RoomNumber: "A1", IsActive: true}
+	if err := db.Create(&roomDetail).Error; err != nil {
+		t.Fatalf("seed the room detail: %v", err)
+	}
+
+	for _, periodID := range []uint{monthly.ID, annual.ID} {
+		if err := db.Create(&database.DBKostPeriod{KostID: 1, PeriodID: periodID, IsActive: true}).Error; err != nil {
+			t.Fatalf("seed the kost period: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		kostID       uint
+		roomID       uint
+		roomDetailID uint
+		periodID     uint
+		price        float64
+		code         MessageCode
+	}{
+		{"quoted period", 1, room.ID, roomDetail.ID, monthly.ID, 1500000, ""},
+		{"converted period", 1, room.ID, roomDetail.ID, annual.ID, 18250000, ""},
+		{"period not offered", 1, room.ID, roomDetail.ID, weekly.ID, 0, MsgPeriodNotOffered},
+		{"room of another kost", 2, room.ID, roomDetail.ID, monthly.ID, 0, MsgRoomNotFound},
+		{"room detail of another room", 1, room.ID, roomDetail.ID + 1, monthly.ID, 0, MsgRoomNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			price, err := book.GetRoomPrice(db, test.kostID, test.roomID, test.roomDetailID, test.periodID)
+			if test.code != "" {
+				if code := messageCode(err); code != test.code {
+					t.Fatalf("expected %s, got %v", test.code, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if price != test.price {
+				t.Fatalf("expected the price %v, got %v", test.price, price)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package data
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// cacheEntry is a single cached value with its expiry
+type cacheEntry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// TTLCache defines a struct for a size bounded in-process cache whose entries expire after a TTL,
+// the least recently used entry is evicted once the cache is full
+type TTLCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+// NewTTLCache is a function to create new TTLCache struct, a non positive ttl disables the cache
+func NewTTLCache(ttl time.Duration, maxEntries int) *TTLCache {
+	return &TTLCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+// Get returns the cached value of the given key if it has not expired yet
+func (cache *TTLCache) Get(key string) (interface{}, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		cache.remove(element)
+
+		return nil, false
+	}
+
+	cache.order.MoveToFront(element)
+
+	return entry.value, true
+}
+
+// Set caches the given value under the given key for the cache TTL
+func (cache *TTLCache) Set(key string, value interface{}) {
+	if cache.ttl <= 0 || cache.maxEntries <= 0 {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	expiresAt := time.Now().Add(cache.ttl)
+	if element, ok := cache.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		cache.order.MoveToFront(element)
+
+		return
+	}
+
+	cache.entries[key] = cache.order.PushFront(&cacheEntry{key, value, expiresAt})
+
+	// evict the least recently used entries over the size bound
+	for cache.order.Len() > cache.maxEntries {
+		cache.remove(cache.order.Back())
+	}
+}
+
+// Invalidate removes the given key from the cache
+func (cache *TTLCache) Invalidate(key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		cache.remove(element)
+	}
+}
+
+// Purge removes every entry from the cache
+func (cache *TTLCache) Purge() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.entries = map[string]*list.Element{}
+	cache.order.Init()
+}
+
+// remove drops the given element, the caller must hold the lock
+func (cache *TTLCache) remove(element *list.Element) {
+	cache.order.Remove(element)
+	delete(cache.entries, element.Value.(*cacheEntry).key)
+}
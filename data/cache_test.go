@@ -0,0 +1,126 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"gorm.io/gorm"
+)
+
+func TestTTLCacheExpiry(t *testing.T) {
+	cache := NewTTLCache(20*time.Millisecond, 10)
+	cache.Set("tenant", 1)
+
+	if value, ok := cache.Get("tenant"); !ok || value != 1 {
+		t.Fatalf("expected the cached value, got %v %v", value, ok)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if _, ok := cache.Get("tenant"); ok {
+		t.Fatal("expected the entry expired")
+	}
+
+	// a disabled cache keeps nothing
+	disabled := NewTTLCache(0, 10)
+	disabled.Set("tenant", 1)
+	if _, ok := disabled.Get("tenant"); ok {
+		t.Fatal("expected nothing cached with a zero TTL")
+	}
+}
+
+func TestTTLCacheEvictsTheLeastRecentlyUsed(t *testing.T) {
+	cache := NewTTLCache(time.Minute, 2)
+	cache.Set("first", 1)
+	cache.Set("second", 2)
+
+	// reading the first entry makes the second one the least recently used
+	cache.Get("first")
+	cache.Set("third", 3)
+
+	if _, ok := cache.Get("second"); ok {
+		t.Fatal("expected the least recently used entry evicted")
+	}
+
+	for _, key := range []string{"first", "third"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Fatalf("expected %s kept", key)
+		}
+	}
+
+	cache.Invalidate("first")
+	if _, ok := cache.Get("first"); ok {
+		t.Fatal("expected the invalidated entry removed")
+	}
+
+	cache.Purge()
+	if _, ok := cache.Get("third"); ok {
+		t.Fatal("expected the purged cache empty")
+	}
+}
+
+// countingQueries returns the number of the queries made on the master user table
+func countingQueries(t *testing.T) *int {
+	t.Helper()
+
+	count := 0
+	err := config.DB.Callback().Query().Before("gorm:query").Register("test:count_users", func(tx *gorm.DB) {
+		if tx.Statement.Table == "master_users" {
+			count++
+		}
+	})
+	if err != nil {
+		t.Fatalf("register the query counter: %v", err)
+	}
+
+	return &count
+}
+
+func TestGetPrincipalUserIsCached(t *testing.T) {
+	book := newTestBook(t)
+	ctx := context.Background()
+
+	user := &database.MasterUser{RoleID: 1, Username: "tenant", Password: []byte("hash"), IsActive: true}
+	if err := config.DB.Create(user).Error; err != nil {
+		t.Fatalf("seed the user: %v", err)
+	}
+
+	queries := countingQueries(t)
+	claims := &Claims{Username: "tenant", UserID: user.ID, RoleID: 1}
+
+	for i := 0; i < 3; i++ {
+		resolved, err := book.GetPrincipalUser(ctx, claims)
+		if err != nil || resolved.ID != user.ID {
+			t.Fatalf("expected the user resolved, got %v", err)
+		}
+
+		// the cached user can't be altered through the resolved one
+		resolved.RoleID = 3
+	}
+
+	if *queries != 1 {
+		t.Fatalf("expected a single user lookup, got %d", *queries)
+	}
+
+	// a token issued with another role resolves the user again
+	if _, err := book.GetPrincipalUser(ctx, &Claims{Username: "tenant", RoleID: 2}); err != nil {
+		t.Fatalf("resolve the user: %v", err)
+	}
+
+	if *queries != 2 {
+		t.Fatalf("expected the role change to look the user up again, got %d lookups", *queries)
+	}
+
+	// a token of another user id is refused even from the cache
+	if _, err := book.GetPrincipalUser(ctx, &Claims{Username: "tenant", UserID: user.ID + 1}); messageCode(err) != MsgUnauthorized {
+		t.Fatalf("expected the user id mismatch refused, got %v", err)
+	}
+
+	book.InvalidatePrincipal("tenant")
+	if _, err := book.GetPrincipalUser(ctx, claims); err != nil || *queries != 3 {
+		t.Fatalf("expected the invalidated user looked up again, got %d lookups: %v", *queries, err)
+	}
+}
@@ -3,7 +3,12 @@ package data
 import (
 	"github.com/fakhripraya/book-service/entities"
 
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"reflect"
 	"strings"
 
 	"github.com/spf13/viper"
@@ -12,6 +17,35 @@ import (
 // MySigningKey is a variable that defines JWT secret
 var MySigningKey string
 
+// MyIssuer is a variable that defines the expected JWT issuer, empty skips the check
+var MyIssuer string
+
+// MyAudience is a variable that defines the expected JWT audience, empty skips the check
+var MyAudience string
+
+// MySessionName is a variable that defines the name of the session cookie
+var MySessionName = "session-name"
+
+// secretKeys are the config keys that may be read from the file named by their <KEY>_FILE env var,
+// e.g. JWT_SECRET_FILE=/run/secrets/jwt_secret for the docker and kubernetes secrets
+var secretKeys = []string{
+	"database.password",
+	"jwt.secret",
+	"mysqlstore.secret",
+	"notification.smtp.password",
+	"notification.sms.apikey",
+	"notification.push.apikey",
+}
+
+// TLSVersions maps the supported tls.minversion settings to their TLS version
+var TLSVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// redacted replaces the secrets printed by RedactConfig
+const redacted = "[REDACTED]"
+
 // ConfigInit is a function to initialize app configuration
 func ConfigInit(config *entities.Configuration) error {
 
@@ -26,6 +60,78 @@ func ConfigInit(config *entities.Configuration) error {
 	viper.SetConfigName("config." + environment)
 	viper.AddConfigPath("./config")
 	viper.AutomaticEnv()
+	bindEnvKeys("", reflect.TypeOf(*config))
+
+	// defaults of the optional settings
+	viper.SetDefault("api.readtimeout", 5)
+	viper.SetDefault("api.readheadertimeout", 5)
+	viper.SetDefault("api.writetimeout", 10)
+	viper.SetDefault("api.idletimeout", 120)
+	viper.SetDefault("api.http2", true)
+	viper.SetDefault("tls.minversion", "1.2")
+	viper.SetDefault("tls.reloadinterval", 60)
+	viper.SetDefault("admin.port", 0)
+	viper.SetDefault("idempotency.window", 86400)
+	viper.SetDefault("idempotency.locktimeout", 60)
+	viper.SetDefault("idempotency.purgeinterval", 3600)
+	viper.SetDefault("ratelimit.sweepinterval", 600)
+	viper.SetDefault("ratelimit.trustforwardedfor", false)
+	viper.SetDefault("ratelimit.rules.addbook.userrate", 5)
+	viper.SetDefault("ratelimit.rules.addbook.userburst", 5)
+	viper.SetDefault("ratelimit.rules.addbook.iprate", 20)
+	viper.SetDefault("ratelimit.rules.addbook.ipburst", 20)
+	viper.SetDefault("database.maxopenconns", 20)
+	viper.SetDefault("database.maxidleconns", 10)
+	viper.SetDefault("database.connmaxlifetime", 300)
+	viper.SetDefault("database.connmaxidletime", 60)
+	viper.SetDefault("database.automigrate", true)
+	viper.SetDefault("mysqlstore.tablename", "dbMasterSession")
+	viper.SetDefault("mysqlstore.sessionname", "session-name")
+	viper.SetDefault("mysqlstore.maxage", 604800)
+	viper.SetDefault("cors.allowedorigins", []string{"*"})
+	viper.SetDefault("cors.allowedmethods", []string{"GET", "HEAD", "POST", "PATCH", "DELETE"})
+	viper.SetDefault("cors.allowedheaders", []string{"Authorization", "Content-Type", "Accept-Language", "X-Request-ID", "Idempotency-Key", "If-Match"})
+	viper.SetDefault("cors.exposedheaders", []string{"X-Request-ID", "Content-Language", "Retry-After", "Idempotent-Replayed", "ETag"})
+	viper.SetDefault("cors.allowcredentials", false)
+	viper.SetDefault("cors.maxage", 600)
+	viper.SetDefault("cache.principalttl", 60)
+	viper.SetDefault("cache.revocationttl", 30)
+	viper.SetDefault("cache.maxentries", 10000)
+	viper.SetDefault("outbox.pollinterval", 5)
+	viper.SetDefault("outbox.lease", 60)
+	viper.SetDefault("outbox.basebackoff", 10)
+	viper.SetDefault("outbox.maxbackoff", 3600)
+	viper.SetDefault("outbox.batchsize", 50)
+	viper.SetDefault("outbox.maxattempts", 10)
+	viper.SetDefault("webhook.pollinterval", 5)
+	viper.SetDefault("webhook.lease", 60)
+	viper.SetDefault("webhook.basebackoff", 30)
+	viper.SetDefault("webhook.maxbackoff", 21600)
+	viper.SetDefault("webhook.batchsize", 50)
+	viper.SetDefault("webhook.maxattempts", 8)
+	viper.SetDefault("webhook.timeout", 10)
+	viper.SetDefault("webhook.allowprivatenetworks", false)
+	viper.SetDefault("scheduler.lease", 300)
+	viper.SetDefault("scheduler.expiryinterval", 300)
+	viper.SetDefault("scheduler.ownerapprovaldeadline", 172800)
+	viper.SetDefault("scheduler.tenantapprovaldeadline", 86400)
+	viper.SetDefault("scheduler.batchsize", 100)
+	viper.SetDefault("scheduler.rentinterval", 3600)
+	viper.SetDefault("scheduler.billingleaddays", 7)
+	viper.SetDefault("scheduler.reminderdays", 3)
+	viper.SetDefault("scheduler.overduegracedays", 3)
+	viper.SetDefault("scheduler.latefeepercent", 0)
+	viper.SetDefault("tracing.exporter", "none")
+	viper.SetDefault("tracing.sampleratio", 1)
+	viper.SetDefault("tracing.servicename", "book-service")
+	viper.SetDefault("health.checktimeout", 2)
+	viper.SetDefault("lifecycle.draindelay", 0)
+	viper.SetDefault("lifecycle.draintimeout", 30)
+	viper.SetDefault("lifecycle.workertimeout", 10)
+	viper.SetDefault("roles.tenant", 1)
+	viper.SetDefault("roles.owner", 2)
+	viper.SetDefault("roles.admin", 3)
+	viper.SetDefault("roles.staff", 4)
 
 	// Change _ underscore in env to . dot notation in viper
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
@@ -34,12 +140,336 @@ func ConfigInit(config *entities.Configuration) error {
 		return err
 	}
 
+	// the secret files take precedence over the config file
+	if err := loadSecretFiles(); err != nil {
+		return err
+	}
+
 	err := viper.Unmarshal(&config)
 	if err != nil {
 		return err
 	}
 
+	// the config file may name its environment, otherwise it is the application state
+	if config.API.Environment == "" {
+		config.API.Environment = environment
+	}
+
+	// refuse to start with a configuration that can't work
+	if err := validateConfig(config); err != nil {
+		return err
+	}
+
 	MySigningKey = config.Jwt.Secret
+	MyIssuer = config.Jwt.Issuer
+	MyAudience = config.Jwt.Audience
+	MyTokenPolicy = NewTokenPolicy(&config.Jwt)
+	MySessionName = config.MySQLStore.SessionName
 
 	return nil
 }
+
+// bindEnvKeys binds every setting of the given configuration type to its env var, e.g. jwt.issuer to JWT_ISSUER,
+// viper.AutomaticEnv alone only resolves the settings having a default or a config file entry
+func bindEnvKeys(prefix string, configType reflect.Type) {
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+
+		key := strings.ToLower(field.Name)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			bindEnvKeys(key, field.Type)
+		case reflect.Map:
+			// the map keys, such as the rate limit rule names, are only known from the config file
+		default:
+			viper.BindEnv(key)
+		}
+	}
+}
+
+// validateConfig checks the loaded configuration, every problem found is reported in the returned error
+func validateConfig(config *entities.Configuration) error {
+	var problems []string
+
+	// server
+	if config.API.Port < 1 || config.API.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("api.port must be between 1 and 65535, got %d", config.API.Port))
+	}
+
+	if config.API.ReadTimeout < 0 || config.API.ReadHeaderTimeout < 0 || config.API.WriteTimeout < 0 || config.API.IdleTimeout < 0 {
+		problems = append(problems, "api timeouts must not be negative")
+	}
+
+	// tls
+	if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+		problems = append(problems, "tls.certfile and tls.keyfile must be set together")
+	}
+
+	if _, ok := TLSVersions[config.TLS.MinVersion]; !ok {
+		problems = append(problems, fmt.Sprintf("tls.minversion must be 1.2 or 1.3, got %q", config.TLS.MinVersion))
+	}
+
+	if config.TLS.CertFile != "" && config.TLS.ReloadInterval <= 0 {
+		problems = append(problems, "tls.reloadinterval must be greater than 0")
+	}
+
+	// admin listener
+	if config.Admin.Port < 0 || config.Admin.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("admin.port must be between 0 and 65535, got %d", config.Admin.Port))
+	}
+
+	if config.Admin.Port != 0 && config.Admin.Port == config.API.Port && config.Admin.Host == config.API.Host {
+		problems = append(problems, "admin.port must differ from api.port")
+	}
+
+	// idempotency keys, a retry must not take over a request still being handled
+	if config.Idempotency.Window <= 0 {
+		problems = append(problems, "idempotency.window must be greater than 0")
+	}
+
+	if config.Idempotency.LockTimeout <= config.API.WriteTimeout {
+		problems = append(problems, "idempotency.locktimeout must be longer than api.writetimeout")
+	}
+
+	// rate limits, an idle bucket must be refilled by the time it is dropped
+	if config.RateLimit.SweepInterval <= 0 {
+		problems = append(problems, "ratelimit.sweepinterval must be greater than 0")
+	}
+
+	for name, rule := range config.RateLimit.Rules {
+		if rule.UserRate < 0 || rule.IPRate < 0 {
+			problems = append(problems, fmt.Sprintf("ratelimit.rules.%s rates must not be negative", name))
+		}
+
+		if rule.UserRate > 0 && rule.UserBurst < 1 || rule.IPRate > 0 && rule.IPBurst < 1 {
+			problems = append(problems, fmt.Sprintf("ratelimit.rules.%s bursts must be at least 1", name))
+		}
+
+		if rule.UserRate > 0 && float64(rule.UserBurst)/rule.UserRate*60 > float64(config.RateLimit.SweepInterval) ||
+			rule.IPRate > 0 && float64(rule.IPBurst)/rule.IPRate*60 > float64(config.RateLimit.SweepInterval) {
+			problems = append(problems, fmt.Sprintf("ratelimit.rules.%s takes longer than ratelimit.sweepinterval to refill", name))
+		}
+	}
+
+	// background workers, a zero poll interval panics their ticker and a zero batch or attempt count stalls them
+	problems = append(problems, checkPositive(
+		setting{"outbox.pollinterval", config.Outbox.PollInterval},
+		setting{"outbox.lease", config.Outbox.Lease},
+		setting{"outbox.basebackoff", config.Outbox.BaseBackoff},
+		setting{"outbox.maxbackoff", config.Outbox.MaxBackoff},
+		setting{"outbox.batchsize", config.Outbox.BatchSize},
+		setting{"outbox.maxattempts", config.Outbox.MaxAttempts},
+		setting{"webhook.pollinterval", config.Webhook.PollInterval},
+		setting{"webhook.lease", config.Webhook.Lease},
+		setting{"webhook.basebackoff", config.Webhook.BaseBackoff},
+		setting{"webhook.maxbackoff", config.Webhook.MaxBackoff},
+		setting{"webhook.batchsize", config.Webhook.BatchSize},
+		setting{"webhook.maxattempts", config.Webhook.MaxAttempts},
+		setting{"webhook.timeout", config.Webhook.Timeout},
+		setting{"scheduler.lease", config.Scheduler.Lease},
+		setting{"scheduler.batchsize", config.Scheduler.BatchSize},
+		setting{"health.checktimeout", config.Health.CheckTimeout},
+		setting{"lifecycle.draintimeout", config.Lifecycle.DrainTimeout},
+		setting{"lifecycle.workertimeout", config.Lifecycle.WorkerTimeout},
+	)...)
+
+	// the zero values of these settings disable their feature
+	problems = append(problems, checkNotNegative(
+		setting{"scheduler.expiryinterval", config.Scheduler.ExpiryInterval},
+		setting{"scheduler.rentinterval", config.Scheduler.RentInterval},
+		setting{"scheduler.billingleaddays", config.Scheduler.BillingLeadDays},
+		setting{"scheduler.reminderdays", config.Scheduler.ReminderDays},
+		setting{"scheduler.overduegracedays", config.Scheduler.OverdueGraceDays},
+		setting{"idempotency.purgeinterval", config.Idempotency.PurgeInterval},
+		setting{"lifecycle.draindelay", config.Lifecycle.DrainDelay},
+		setting{"cache.principalttl", config.Cache.PrincipalTTL},
+		setting{"cache.revocationttl", config.Cache.RevocationTTL},
+		setting{"cache.maxentries", config.Cache.MaxEntries},
+		setting{"jwt.jwksrefreshinterval", config.Jwt.JWKSRefreshInterval},
+		setting{"jwt.keyrotationgraceperiod", config.Jwt.KeyRotationGracePeriod},
+		setting{"jwt.tokenlifetime", config.Jwt.TokenLifetime},
+		setting{"jwt.renewalwindow", config.Jwt.RenewalWindow},
+		setting{"jwt.maxsessionlifetime", config.Jwt.MaxSessionLifetime},
+	)...)
+
+	if config.Outbox.MaxBackoff < config.Outbox.BaseBackoff {
+		problems = append(problems, "outbox.maxbackoff must not be shorter than outbox.basebackoff")
+	}
+
+	if config.Webhook.MaxBackoff < config.Webhook.BaseBackoff {
+		problems = append(problems, "webhook.maxbackoff must not be shorter than webhook.basebackoff")
+	}
+
+	// a delivery still being posted must not be claimed again by another replica
+	if config.Webhook.Lease <= config.Webhook.Timeout {
+		problems = append(problems, "webhook.lease must be longer than webhook.timeout")
+	}
+
+	if config.Scheduler.ExpiryInterval > 0 && (config.Scheduler.OwnerApprovalDeadline <= 0 || config.Scheduler.TenantApprovalDeadline <= 0) {
+		problems = append(problems, "scheduler.ownerapprovaldeadline and scheduler.tenantapprovaldeadline must be greater than 0")
+	}
+
+	if config.Scheduler.LateFeePercent < 0 {
+		problems = append(problems, "scheduler.latefeepercent must not be negative")
+	}
+
+	// database
+	if config.Database.Host == "" {
+		problems = append(problems, "database.host is required")
+	}
+
+	if config.Database.Port < 1 || config.Database.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("database.port must be between 1 and 65535, got %d", config.Database.Port))
+	}
+
+	if config.Database.Dbname == "" {
+		problems = append(problems, "database.dbname is required")
+	}
+
+	if config.Database.MaxOpenConns < 0 || config.Database.MaxIdleConns < 0 {
+		problems = append(problems, "database.maxopenconns and database.maxidleconns must not be negative")
+	}
+
+	if config.Database.MaxOpenConns > 0 && config.Database.MaxIdleConns > config.Database.MaxOpenConns {
+		problems = append(problems, "database.maxidleconns must not exceed database.maxopenconns")
+	}
+
+	if config.Database.ConnMaxLifetime < 0 || config.Database.ConnMaxIdleTime < 0 {
+		problems = append(problems, "database.connmaxlifetime and database.connmaxidletime must not be negative")
+	}
+
+	// tokens are verified with the JWT secret unless a JWKS is configured
+	if config.Jwt.Secret == "" && config.Jwt.JWKSURL == "" && config.Jwt.JWKSFile == "" {
+		problems = append(problems, "jwt.secret is required unless jwt.jwksurl or jwt.jwksfile is set")
+	}
+
+	// session store
+	if config.MySQLStore.Secret == "" {
+		problems = append(problems, "mysqlstore.secret is required")
+	}
+
+	if config.MySQLStore.SessionName == "" || config.MySQLStore.TableName == "" {
+		problems = append(problems, "mysqlstore.sessionname and mysqlstore.tablename are required")
+	}
+
+	if config.MySQLStore.MaxAge <= 0 {
+		problems = append(problems, "mysqlstore.maxage must be greater than 0")
+	}
+
+	// roles, a role id shared by two roles would grant the permissions of either
+	roleIDs := map[uint]bool{}
+	for _, roleID := range []uint{config.Roles.Tenant, config.Roles.Owner, config.Roles.Admin, config.Roles.Staff} {
+		if roleID == 0 {
+			problems = append(problems, "roles.tenant, roles.owner, roles.admin and roles.staff are required")
+			break
+		}
+
+		if roleIDs[roleID] {
+			problems = append(problems, fmt.Sprintf("roles must have distinct ids, %d is used twice", roleID))
+			break
+		}
+
+		roleIDs[roleID] = true
+	}
+
+	// cors, browsers reject the credentials of a wildcard origin
+	if len(config.CORS.AllowedOrigins) == 0 {
+		problems = append(problems, "cors.allowedorigins is required")
+	}
+
+	if config.CORS.AllowCredentials {
+		for _, origin := range config.CORS.AllowedOrigins {
+			if origin == "*" {
+				problems = append(problems, "cors.allowcredentials can't be used along with the * origin")
+				break
+			}
+		}
+	}
+
+	if len(problems) != 0 {
+		return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+	}
+
+	return nil
+}
+
+// setting is a numeric configuration value along with its key, for the range checks
+type setting struct {
+	key   string
+	value int
+}
+
+// checkPositive returns a problem for every given setting that is not greater than 0
+func checkPositive(settings ...setting) []string {
+	var problems []string
+	for _, s := range settings {
+		if s.value <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be greater than 0, got %d", s.key, s.value))
+		}
+	}
+
+	return problems
+}
+
+// checkNotNegative returns a problem for every given setting that is negative
+func checkNotNegative(settings ...setting) []string {
+	var problems []string
+	for _, s := range settings {
+		if s.value < 0 {
+			problems = append(problems, fmt.Sprintf("%s must not be negative, got %d", s.key, s.value))
+		}
+	}
+
+	return problems
+}
+
+// loadSecretFiles sets every secret whose <KEY>_FILE env var names a file to the content of the file
+func loadSecretFiles() error {
+	for _, key := range secretKeys {
+		envName := strings.ToUpper(strings.Replace(key, ".", "_", -1))
+
+		path := os.Getenv(envName + "_FILE")
+		if path == "" {
+			continue
+		}
+
+		// a secret given twice is most likely a mistake
+		if os.Getenv(envName) != "" {
+			return fmt.Errorf("only one of %s and %s_FILE can be set", envName, envName)
+		}
+
+		secret, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read %s_FILE: %w", envName, err)
+		}
+
+		// the secret files usually end with a new line
+		viper.Set(key, strings.TrimRight(string(secret), "\r\n"))
+	}
+
+	return nil
+}
+
+// RedactConfig returns a copy of the given configuration without its secrets, safe to print
+func RedactConfig(config entities.Configuration) entities.Configuration {
+	secrets := []*string{
+		&config.Database.Password,
+		&config.Jwt.Secret,
+		&config.MySQLStore.Secret,
+		&config.Notification.SMTP.Password,
+		&config.Notification.SMS.APIKey,
+		&config.Notification.Push.APIKey,
+	}
+
+	for _, secret := range secrets {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+
+	return config
+}
@@ -0,0 +1,212 @@
+package data
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/spf13/viper"
+)
+
+// setEnv sets the given env vars for the duration of the test
+func setEnv(t *testing.T, vars map[string]string) {
+	t.Helper()
+
+	for name, value := range vars {
+		previous, ok := os.LookupEnv(name)
+		os.Setenv(name, value)
+
+		name := name
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(name, previous)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+func TestBindEnvKeys(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	// none of these settings has a default
+	setEnv(t, map[string]string{
+		"JWT_ISSUER":                   "https://auth.example.com",
+		"JWT_AUDIENCE":                 "book-service",
+		"JWT_JWKSURL":                  "https://auth.example.com/.well-known/jwks.json",
+		"TLS_CERTFILE":                 "/etc/tls/tls.crt",
+		"TLS_KEYFILE":                  "/etc/tls/tls.key",
+		"DATABASE_HOST":                "db",
+		"NOTIFICATION_SMTP_HOST":       "smtp.example.com",
+		"CORS_ALLOWEDORIGINS":          "https://a.example.com,https://b.example.com",
+		"WEBHOOK_ALLOWPRIVATENETWORKS": "true",
+	})
+
+	viper.AutomaticEnv()
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	bindEnvKeys("", reflect.TypeOf(entities.Configuration{}))
+
+	var config entities.Configuration
+	if err := viper.Unmarshal(&config); err != nil {
+		t.Fatalf("unmarshal the configuration: %v", err)
+	}
+
+	if config.Jwt.Issuer != "https://auth.example.com" || config.Jwt.Audience != "book-service" || config.Jwt.JWKSURL == "" {
+		t.Fatalf("expected the jwt settings read from the env, got %+v", config.Jwt)
+	}
+
+	if config.TLS.CertFile != "/etc/tls/tls.crt" || config.TLS.KeyFile != "/etc/tls/tls.key" {
+		t.Fatalf("expected the tls settings read from the env, got %+v", config.TLS)
+	}
+
+	if config.Database.Host != "db" || config.Notification.SMTP.Host != "smtp.example.com" || !config.Webhook.AllowPrivateNetworks {
+		t.Fatalf("expected the nested settings read from the env, got %+v", config)
+	}
+
+	if len(config.CORS.AllowedOrigins) != 2 {
+		t.Fatalf("expected the list settings split on commas, got %v", config.CORS.AllowedOrigins)
+	}
+}
+
+// validConfig returns a configuration holding the defaults of ConfigInit and the required settings
+func validConfig() *entities.Configuration {
+	return &entities.Configuration{
+		API:      entities.APIConfiguration{Port: 8080, ReadTimeout: 5, ReadHeaderTimeout: 5, WriteTimeout: 10, IdleTimeout: 120},
+		Database: entities.DatabaseConfiguration{Host: "localhost", Port: 3306, Dbname: "book", MaxOpenConns: 20, MaxIdleConns: 10, ConnMaxLifetime: 300, ConnMaxIdleTime: 60},
+		Jwt:      entities.JwtConfiguration{Secret: "secret"},
+		MySQLStore: entities.MySQLStoreConfiguration{
+			Secret:      "secret",
+			TableName:   "dbMasterSession",
+			SessionName: "session-name",
+			MaxAge:      604800,
+		},
+		Cache:     entities.CacheConfiguration{PrincipalTTL: 60, RevocationTTL: 30, MaxEntries: 10000},
+		Outbox:    entities.OutboxConfiguration{PollInterval: 5, Lease: 60, BaseBackoff: 10, MaxBackoff: 3600, BatchSize: 50, MaxAttempts: 10},
+		Webhook:   entities.WebhookConfiguration{PollInterval: 5, Lease: 60, BaseBackoff: 30, MaxBackoff: 21600, BatchSize: 50, MaxAttempts: 8, Timeout: 10},
+		Scheduler: entities.SchedulerConfiguration{Lease: 300, ExpiryInterval: 300, OwnerApprovalDeadline: 172800, TenantApprovalDeadline: 86400, BatchSize: 100, RentInterval: 3600},
+		Health:    entities.HealthConfiguration{CheckTimeout: 2},
+		Lifecycle: entities.LifecycleConfiguration{DrainTimeout: 30, WorkerTimeout: 10},
+		CORS:      entities.CORSConfiguration{AllowedOrigins: []string{"*"}},
+		TLS:       entities.TLSConfiguration{MinVersion: "1.2", ReloadInterval: 60},
+		RateLimit: entities.RateLimitConfiguration{
+			SweepInterval: 600,
+			Rules:         map[string]entities.RateLimitRule{"addbook": {UserRate: 5, UserBurst: 5, IPRate: 20, IPBurst: 20}},
+		},
+		Idempotency: entities.IdempotencyConfiguration{Window: 86400, LockTimeout: 60, PurgeInterval: 3600},
+		Roles:       entities.RoleConfiguration{Tenant: 1, Owner: 2, Admin: 3, Staff: 4},
+	}
+}
+
+func TestValidateConfigAcceptsTheDefaults(t *testing.T) {
+	if err := validateConfig(validConfig()); err != nil {
+		t.Fatalf("expected the defaults valid, got %v", err)
+	}
+
+	// a jwks replaces the jwt secret
+	config := validConfig()
+	config.Jwt.Secret = ""
+	config.Jwt.JWKSURL = "https://auth.example.com/.well-known/jwks.json"
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("expected a jwks without secret valid, got %v", err)
+	}
+}
+
+func TestValidateConfigRefusesTheBrokenSettings(t *testing.T) {
+	for problem, breakConfig := range map[string]func(config *entities.Configuration){
+		"api.port must be between 1 and 65535":                  func(config *entities.Configuration) { config.API.Port = 0 },
+		"tls.certfile and tls.keyfile must be set together":     func(config *entities.Configuration) { config.TLS.CertFile = "/etc/tls/tls.crt" },
+		"tls.minversion must be 1.2 or 1.3":                     func(config *entities.Configuration) { config.TLS.MinVersion = "1.1" },
+		"admin.port must differ from api.port":                  func(config *entities.Configuration) { config.Admin.Port = config.API.Port },
+		"idempotency.locktimeout must be longer than api.write": func(config *entities.Configuration) { config.Idempotency.LockTimeout = config.API.WriteTimeout },
+		"ratelimit.rules.addbook bursts must be at least 1": func(config *entities.Configuration) {
+			config.RateLimit.Rules["addbook"] = entities.RateLimitRule{UserRate: 5}
+		},
+		"ratelimit.rules.addbook takes longer than":            func(config *entities.Configuration) { config.RateLimit.SweepInterval = 30 },
+		"outbox.pollinterval must be greater than 0, got 0":    func(config *entities.Configuration) { config.Outbox.PollInterval = 0 },
+		"webhook.maxattempts must be greater than 0, got -1":   func(config *entities.Configuration) { config.Webhook.MaxAttempts = -1 },
+		"scheduler.rentinterval must not be negative, got -1":  func(config *entities.Configuration) { config.Scheduler.RentInterval = -1 },
+		"outbox.maxbackoff must not be shorter than":           func(config *entities.Configuration) { config.Outbox.MaxBackoff = 1 },
+		"webhook.lease must be longer than webhook.timeout":    func(config *entities.Configuration) { config.Webhook.Lease = config.Webhook.Timeout },
+		"scheduler.ownerapprovaldeadline and":                  func(config *entities.Configuration) { config.Scheduler.OwnerApprovalDeadline = 0 },
+		"database.maxidleconns must not exceed":                func(config *entities.Configuration) { config.Database.MaxIdleConns = 30 },
+		"jwt.secret is required unless":                        func(config *entities.Configuration) { config.Jwt.Secret = "" },
+		"mysqlstore.secret is required":                        func(config *entities.Configuration) { config.MySQLStore.Secret = "" },
+		"roles must have distinct ids, 2 is used twice":        func(config *entities.Configuration) { config.Roles.Admin = config.Roles.Owner },
+		"cors.allowcredentials can't be used along with the *": func(config *entities.Configuration) { config.CORS.AllowCredentials = true },
+	} {
+		config := validConfig()
+		breakConfig(config)
+
+		if err := validateConfig(config); err == nil || !strings.Contains(err.Error(), problem) {
+			t.Fatalf("expected %q reported, got %v", problem, err)
+		}
+	}
+
+	// the whole configuration is checked at once
+	config := validConfig()
+	config.API.Port = 0
+	config.Database.Host = ""
+	config.Roles.Tenant = 0
+
+	err := validateConfig(config)
+	if err == nil || strings.Count(err.Error(), "\n  - ") != 3 {
+		t.Fatalf("expected every problem reported, got %v", err)
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	secretFile := filepath.Join(t.TempDir(), "jwt_secret")
+	if err := ioutil.WriteFile(secretFile, []byte("from-the-file\n"), 0600); err != nil {
+		t.Fatalf("write the secret file: %v", err)
+	}
+
+	setEnv(t, map[string]string{"JWT_SECRET_FILE": secretFile})
+	if err := loadSecretFiles(); err != nil {
+		t.Fatalf("load the secret files: %v", err)
+	}
+
+	// the trailing new line isn't part of the secret
+	if secret := viper.GetString("jwt.secret"); secret != "from-the-file" {
+		t.Fatalf("expected the secret read from the file, got %q", secret)
+	}
+
+	// a secret given twice is refused
+	setEnv(t, map[string]string{"JWT_SECRET": "from-the-env"})
+	if err := loadSecretFiles(); err == nil || !strings.Contains(err.Error(), "only one of JWT_SECRET and JWT_SECRET_FILE") {
+		t.Fatalf("expected the secret given twice refused, got %v", err)
+	}
+
+	setEnv(t, map[string]string{"JWT_SECRET": "", "DATABASE_PASSWORD_FILE": filepath.Join(t.TempDir(), "missing")})
+	if err := loadSecretFiles(); err == nil || !strings.Contains(err.Error(), "unable to read DATABASE_PASSWORD_FILE") {
+		t.Fatalf("expected the missing secret file reported, got %v", err)
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	config := validConfig()
+	config.Notification.SMS.APIKey = "sms-key"
+
+	redactedConfig := RedactConfig(*config)
+	if redactedConfig.Jwt.Secret != redacted || redactedConfig.MySQLStore.Secret != redacted || redactedConfig.Notification.SMS.APIKey != redacted {
+		t.Fatalf("expected the secrets redacted, got %+v", redactedConfig)
+	}
+
+	// an unset secret stays visibly unset
+	if redactedConfig.Database.Password != "" || redactedConfig.Notification.Push.APIKey != "" {
+		t.Fatalf("expected the unset secrets left empty, got %+v", redactedConfig)
+	}
+
+	// the configuration in use keeps its secrets
+	if config.Jwt.Secret != "secret" || config.Notification.SMS.APIKey != "sms-key" || redactedConfig.Database.Host != config.Database.Host {
+		t.Fatalf("expected only the copy redacted, got %+v", config)
+	}
+}
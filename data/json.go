@@ -2,6 +2,7 @@ package data
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 )
 
@@ -19,3 +20,21 @@ func FromJSON(payload interface{}, reader io.Reader) error {
 
 	return d.Decode(payload)
 }
+
+// FromStrictJSON deserializes the object from JSON string
+// in an io.Reader to the given interface, rejecting unknown fields and trailing data
+func FromStrictJSON(payload interface{}, reader io.Reader) error {
+	d := json.NewDecoder(reader)
+	d.DisallowUnknownFields()
+
+	if err := d.Decode(payload); err != nil {
+		return err
+	}
+
+	// the body must hold a single JSON value
+	if d.More() {
+		return fmt.Errorf("json: unexpected data after the request body")
+	}
+
+	return nil
+}
@@ -0,0 +1,319 @@
+package data
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+)
+
+// MyKeySet is a variable that holds the JWKS used to verify asymmetric tokens, nil disables them
+var MyKeySet *KeySet
+
+// minKeySetRefreshGap is the minimum time between two refreshes triggered by an unknown kid
+const minKeySetRefreshGap = time.Minute
+
+// jsonWebKey is a single RSA or EC public key of a JWKS document
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jsonWebKeySet is a JWKS document
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// verificationKey is a cached public key, retiredAt is set once the key disappears from the JWKS,
+// an entry is never changed once stored in the key set since Key reads it without the lock
+type verificationKey struct {
+	key       interface{}
+	alg       string
+	retiredAt time.Time
+}
+
+// KeySet defines a struct for a cached JWKS that is refreshed from a file or a URL
+type KeySet struct {
+	logger          hclog.Logger
+	url             string
+	file            string
+	refreshInterval time.Duration
+	gracePeriod     time.Duration
+	client          *http.Client
+
+	mu   sync.RWMutex
+	keys map[string]*verificationKey
+
+	// refreshMu serializes the refreshes, lastAttempt is the start of the last one whether it succeeded or not
+	refreshMu   sync.Mutex
+	lastAttempt time.Time
+}
+
+// NewKeySet is a function to create new KeySet struct based on the given JWT configuration
+func NewKeySet(newLogger hclog.Logger, jwtConfig *entities.JwtConfiguration) *KeySet {
+	refreshInterval := time.Duration(jwtConfig.JWKSRefreshInterval) * time.Second
+	if refreshInterval <= 0 {
+		refreshInterval = 15 * time.Minute
+	}
+
+	return &KeySet{
+		logger:          newLogger,
+		url:             jwtConfig.JWKSURL,
+		file:            jwtConfig.JWKSFile,
+		refreshInterval: refreshInterval,
+		gracePeriod:     time.Duration(jwtConfig.KeyRotationGracePeriod) * time.Second,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		keys:            map[string]*verificationKey{},
+	}
+}
+
+// Refresh reloads the JWKS, keys that are gone stay usable until the rotation grace period ends
+func (keySet *KeySet) Refresh() error {
+	keySet.refreshMu.Lock()
+	defer keySet.refreshMu.Unlock()
+
+	return keySet.refresh()
+}
+
+// refreshUnknown refreshes the JWKS for an unknown kid at most once every minKeySetRefreshGap, the gap starts
+// with the attempt so a failing JWKS endpoint isn't hit again by every request, and the requests arriving during
+// a refresh wait for it instead of starting their own
+func (keySet *KeySet) refreshUnknown() {
+	keySet.refreshMu.Lock()
+	defer keySet.refreshMu.Unlock()
+
+	if time.Since(keySet.lastAttempt) < minKeySetRefreshGap {
+		return
+	}
+
+	if err := keySet.refresh(); err != nil {
+		keySet.logger.Error("Unable to refresh the JWKS", "error", err)
+	}
+}
+
+// refresh reloads the JWKS, the caller must hold refreshMu
+func (keySet *KeySet) refresh() error {
+	keySet.lastAttempt = time.Now()
+
+	document, err := keySet.load()
+	if err != nil {
+		return err
+	}
+
+	// parse every usable key of the document
+	fresh := map[string]*verificationKey{}
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			keySet.logger.Warn("Skipping invalid JWKS key", "kid", jwk.Kid, "error", err)
+			continue
+		}
+
+		fresh[jwk.Kid] = &verificationKey{key: key, alg: jwk.Alg}
+	}
+
+	keySet.mu.Lock()
+	defer keySet.mu.Unlock()
+
+	now := time.Now()
+	for kid, old := range keySet.keys {
+		if _, ok := fresh[kid]; ok {
+			continue
+		}
+
+		// keep the removed key around during the rotation grace period,
+		// retiring it on a copy since the current entry may be in use by Key
+		retired := old
+		if retired.retiredAt.IsZero() {
+			retiredCopy := *old
+			retiredCopy.retiredAt = now
+			retired = &retiredCopy
+		}
+
+		if now.Sub(retired.retiredAt) < keySet.gracePeriod {
+			fresh[kid] = retired
+		}
+	}
+
+	keySet.keys = fresh
+
+	return nil
+}
+
+// Key returns the public key of the given kid that can verify the given algorithm
+func (keySet *KeySet) Key(kid, alg string) (interface{}, error) {
+	verification := keySet.lookup(kid)
+
+	// the key may have been added since the last refresh
+	if verification == nil {
+		keySet.refreshUnknown()
+		verification = keySet.lookup(kid)
+	}
+
+	if verification == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if !verification.retiredAt.IsZero() && time.Since(verification.retiredAt) >= keySet.gracePeriod {
+		return nil, fmt.Errorf("signing key %q has been rotated out", kid)
+	}
+
+	if verification.alg != "" && verification.alg != alg {
+		return nil, fmt.Errorf("signing key %q does not allow %s", kid, alg)
+	}
+
+	// the key type must match the token algorithm family
+	switch verification.key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") && !strings.HasPrefix(alg, "PS") {
+			return nil, fmt.Errorf("signing key %q is not usable with %s", kid, alg)
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return nil, fmt.Errorf("signing key %q is not usable with %s", kid, alg)
+		}
+	}
+
+	return verification.key, nil
+}
+
+// Run refreshes the JWKS periodically until the given context is done
+func (keySet *KeySet) Run(ctx context.Context) {
+	ticker := time.NewTicker(keySet.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := keySet.Refresh(); err != nil {
+				keySet.logger.Error("Unable to refresh the JWKS", "error", err)
+			}
+		}
+	}
+}
+
+// lookup returns the cached key of the given kid
+func (keySet *KeySet) lookup(kid string) *verificationKey {
+	keySet.mu.RLock()
+	defer keySet.mu.RUnlock()
+
+	return keySet.keys[kid]
+}
+
+// load reads the JWKS document from the configured file or URL
+func (keySet *KeySet) load() (*jsonWebKeySet, error) {
+	var raw []byte
+	var err error
+
+	if keySet.file != "" {
+		raw, err = ioutil.ReadFile(keySet.file)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		response, err := keySet.client.Get(keySet.url)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected JWKS response status %d", response.StatusCode)
+		}
+
+		raw, err = ioutil.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var document jsonWebKeySet
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// publicKey decodes the RSA or EC public key of the JWK
+func (jwk *jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %q", jwk.Crv)
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+// decodeBigInt decodes a base64url encoded unsigned big endian integer
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(raw), nil
+}
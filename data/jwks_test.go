@@ -0,0 +1,292 @@
+package data
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+)
+
+// newRSAKey generates an RSA key for the test tokens
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate the RSA key: %v", err)
+	}
+
+	return key
+}
+
+// newJWKS returns a JWKS document holding the public part of the given keys keyed by kid
+func newJWKS(keys map[string]*rsa.PrivateKey) *jsonWebKeySet {
+	document := &jsonWebKeySet{}
+	for kid, key := range keys {
+		document.Keys = append(document.Keys, jsonWebKey{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+
+	return document
+}
+
+// writeJWKS writes a JWKS document holding the public part of the given keys keyed by kid
+func writeJWKS(t *testing.T, path string, keys map[string]*rsa.PrivateKey) {
+	t.Helper()
+
+	raw, err := json.Marshal(newJWKS(keys))
+	if err != nil {
+		t.Fatalf("encode the JWKS: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, raw, 0600); err != nil {
+		t.Fatalf("write the JWKS: %v", err)
+	}
+}
+
+// newFileKeySet returns a key set reading the JWKS of the given keys from a file, along with the file path
+func newFileKeySet(t *testing.T, gracePeriod int, keys map[string]*rsa.PrivateKey) (*KeySet, string) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatalf("create the JWKS directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "jwks.json")
+	writeJWKS(t, path, keys)
+
+	keySet := NewKeySet(hclog.NewNullLogger(), &entities.JwtConfiguration{JWKSFile: path, KeyRotationGracePeriod: gracePeriod})
+	if err := keySet.Refresh(); err != nil {
+		t.Fatalf("load the JWKS: %v", err)
+	}
+
+	return keySet, path
+}
+
+func TestKeySetRotationGrace(t *testing.T) {
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+
+	// the removed key stays usable during the grace period
+	keySet, path := newFileKeySet(t, 3600, map[string]*rsa.PrivateKey{"old": oldKey})
+	if _, err := keySet.Key("old", "RS256"); err != nil {
+		t.Fatalf("expected the current key, got %v", err)
+	}
+
+	if _, err := keySet.Key("old", "ES256"); err == nil {
+		t.Fatal("expected the key to refuse another algorithm")
+	}
+
+	writeJWKS(t, path, map[string]*rsa.PrivateKey{"new": newKey})
+	if err := keySet.Refresh(); err != nil {
+		t.Fatalf("refresh the JWKS: %v", err)
+	}
+
+	if _, err := keySet.Key("old", "RS256"); err != nil {
+		t.Fatalf("expected the rotated key within the grace period, got %v", err)
+	}
+
+	if _, err := keySet.Key("new", "RS256"); err != nil {
+		t.Fatalf("expected the new key, got %v", err)
+	}
+
+	// without a grace period the removed key is refused right away
+	keySet, path = newFileKeySet(t, 0, map[string]*rsa.PrivateKey{"old": oldKey})
+	writeJWKS(t, path, map[string]*rsa.PrivateKey{"new": newKey})
+	if err := keySet.Refresh(); err != nil {
+		t.Fatalf("refresh the JWKS: %v", err)
+	}
+
+	if _, err := keySet.Key("old", "RS256"); err == nil {
+		t.Fatal("expected the rotated key to be refused")
+	}
+}
+
+func TestKeySetUnknownKidRefreshesOnce(t *testing.T) {
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(50 * time.Millisecond)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	keySet := NewKeySet(hclog.NewNullLogger(), &entities.JwtConfiguration{JWKSURL: server.URL})
+
+	// a flood of unknown kids against a failing JWKS endpoint fetches it a single time
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := keySet.Key("random-"+strconv.Itoa(i), "RS256"); err == nil {
+				t.Errorf("expected the unknown kid %d to be refused", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, err := keySet.Key("random", "RS256"); err == nil {
+		t.Fatal("expected the unknown kid to be refused")
+	}
+
+	if count := atomic.LoadInt32(&fetches); count != 1 {
+		t.Fatalf("expected a single JWKS fetch, got %d", count)
+	}
+}
+
+func TestParseTokenWithKeySet(t *testing.T) {
+	key := newRSAKey(t)
+	keySet, _ := newFileKeySet(t, 0, map[string]*rsa.PrivateKey{"current": key})
+
+	previous := MyKeySet
+	MyKeySet = keySet
+	defer func() { MyKeySet = previous }()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &Claims{
+		Username: "tenant",
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	})
+	token.Header["kid"] = "current"
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign the token: %v", err)
+	}
+
+	claims, err := ParseToken(signed)
+	if err != nil {
+		t.Fatalf("parse the token: %v", err)
+	}
+
+	if claims.Algorithm != "RS256" || claims.IsSelfIssued() {
+		t.Fatalf("expected a RS256 token issued elsewhere, got %s", claims.Algorithm)
+	}
+
+	// a token issued elsewhere can't be renewed with the shared secret
+	if _, _, err := MyTokenPolicy.RenewToken(claims); err == nil {
+		t.Fatal("expected the renewal of a RS256 token to fail")
+	}
+
+	// a token signed by a key outside the JWKS is refused
+	token.Header["kid"] = "current"
+	forged, err := token.SignedString(newRSAKey(t))
+	if err != nil {
+		t.Fatalf("sign the forged token: %v", err)
+	}
+
+	if _, err := ParseToken(forged); err == nil {
+		t.Fatal("expected the forged token to be refused")
+	}
+}
+
+// serveJWKS serves the given JWKS document for the duration of the test
+func serveJWKS(t *testing.T, document *jsonWebKeySet) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(rw).Encode(document)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestKeySetVerifiesES256Tokens(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate the EC key: %v", err)
+	}
+
+	server := serveJWKS(t, &jsonWebKeySet{Keys: []jsonWebKey{
+		{
+			Kty: "EC",
+			Kid: "ec",
+			Use: "sig",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+			Y:   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+		},
+
+		// the encryption keys and the invalid keys are skipped without failing the refresh
+		{Kty: "RSA", Kid: "encryption", Use: "enc", N: "AQAB", E: "AQAB"},
+		{Kty: "EC", Kid: "off-curve", Crv: "P-256", X: "AQ", Y: "AQ"},
+		{Kty: "oct", Kid: "symmetric"},
+	}})
+
+	keySet := NewKeySet(hclog.NewNullLogger(), &entities.JwtConfiguration{JWKSURL: server.URL})
+	if err := keySet.Refresh(); err != nil {
+		t.Fatalf("load the JWKS: %v", err)
+	}
+
+	for _, kid := range []string{"encryption", "off-curve", "symmetric"} {
+		if keySet.lookup(kid) != nil {
+			t.Fatalf("expected the key %s skipped", kid)
+		}
+	}
+
+	// an EC key can't verify an RSA token
+	if _, err := keySet.Key("ec", "RS256"); err == nil {
+		t.Fatal("expected the EC key to refuse RS256")
+	}
+
+	previous := MyKeySet
+	MyKeySet = keySet
+	defer func() { MyKeySet = previous }()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, &Claims{
+		Username: "tenant",
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	})
+	token.Header["kid"] = "ec"
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign the token: %v", err)
+	}
+
+	claims, err := ParseToken(signed)
+	if err != nil || claims.Algorithm != "ES256" {
+		t.Fatalf("expected the ES256 token accepted, got %v", err)
+	}
+}
+
+func TestKeySetFetchesTheKeyAddedSinceTheLastRefresh(t *testing.T) {
+	server := serveJWKS(t, newJWKS(map[string]*rsa.PrivateKey{"new": newRSAKey(t)}))
+
+	// the issuer signs with a key published after the key set was created
+	keySet := NewKeySet(hclog.NewNullLogger(), &entities.JwtConfiguration{JWKSURL: server.URL})
+	if _, err := keySet.Key("new", "RS256"); err != nil {
+		t.Fatalf("expected the unknown kid fetched from the JWKS, got %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// MessageCode is a key used to look up a user-facing message in the message catalog
+type MessageCode string
+
+// Success message codes
+const (
+	MsgBookRequested      MessageCode = "BOOK_REQUESTED"
+	MsgBookApproved       MessageCode = "BOOK_APPROVED"
+	MsgBookRejected       MessageCode = "BOOK_REJECTED"
+	MsgLoggedOut          MessageCode = "LOGGED_OUT"
+	MsgLoggedOutAll       MessageCode = "LOGGED_OUT_ALL"
+	MsgWebhookRemoved     MessageCode = "WEBHOOK_REMOVED"
+	MsgWebhookRedelivered MessageCode = "WEBHOOK_REDELIVERED"
+)
+
+// Error message codes
+const (
+	MsgInternalError         MessageCode = "INTERNAL_ERROR"
+	MsgDatabaseError         MessageCode = "DATABASE_ERROR"
+	MsgSessionError          MessageCode = "SESSION_ERROR"
+	MsgUnauthorized          MessageCode = "UNAUTHORIZED"
+	MsgTokenInvalid          MessageCode = "TOKEN_INVALID"
+	MsgTokenExpired          MessageCode = "TOKEN_EXPIRED"
+	MsgTokenRevoked          MessageCode = "TOKEN_REVOKED"
+	MsgSessionExpired        MessageCode = "SESSION_EXPIRED"
+	MsgTokenRenewFailed      MessageCode = "TOKEN_RENEW_FAILED"
+	MsgInvalidRequestBody    MessageCode = "INVALID_REQUEST_BODY"
+	MsgBookNotFound          MessageCode = "BOOK_NOT_FOUND"
+	MsgKostNotFound          MessageCode = "KOST_NOT_FOUND"
+	MsgRoomNotFound          MessageCode = "ROOM_NOT_FOUND"
+	MsgPeriodNotOffered      MessageCode = "PERIOD_NOT_OFFERED"
+	MsgTransactionNotFound   MessageCode = "TRANSACTION_NOT_FOUND"
+	MsgInvalidBookStatus     MessageCode = "INVALID_BOOK_STATUS"
+	MsgOwnerOnlyApproval     MessageCode = "OWNER_ONLY_APPROVAL"
+	MsgTenantOnlyApproval    MessageCode = "TENANT_ONLY_APPROVAL"
+	MsgForbidden             MessageCode = "FORBIDDEN"
+	MsgRequestTooLarge       MessageCode = "REQUEST_TOO_LARGE"
+	MsgValidationFailed      MessageCode = "VALIDATION_FAILED"
+	MsgWebhookNotFound       MessageCode = "WEBHOOK_NOT_FOUND"
+	MsgDeliveryNotFound      MessageCode = "DELIVERY_NOT_FOUND"
+	MsgTooManyRequests       MessageCode = "TOO_MANY_REQUESTS"
+	MsgIdempotencyKeyInvalid MessageCode = "IDEMPOTENCY_KEY_INVALID"
+	MsgIdempotencyKeyReused  MessageCode = "IDEMPOTENCY_KEY_REUSED"
+	MsgIdempotencyInProgress MessageCode = "IDEMPOTENCY_IN_PROGRESS"
+	MsgVersionConflict       MessageCode = "VERSION_CONFLICT"
+	MsgPreconditionFailed    MessageCode = "PRECONDITION_FAILED"
+)
+
+// Field validation message codes, formatted with the field name and the rule parameter
+const (
+	MsgFieldRequired    MessageCode = "FIELD_REQUIRED"
+	MsgFieldGreaterThan MessageCode = "FIELD_GREATER_THAN"
+	MsgFieldMin         MessageCode = "FIELD_MIN"
+	MsgFieldMax         MessageCode = "FIELD_MAX"
+	MsgFieldNotPast     MessageCode = "FIELD_NOT_PAST"
+	MsgFieldURL         MessageCode = "FIELD_URL"
+	MsgFieldPublicURL   MessageCode = "FIELD_PUBLIC_URL"
+	MsgFieldUnknown     MessageCode = "FIELD_UNKNOWN"
+	MsgFieldInvalid     MessageCode = "FIELD_INVALID"
+	MsgFieldOneOf       MessageCode = "FIELD_ONE_OF"
+)
+
+// Supported languages of the message catalog
+const (
+	LanguageIndonesian = "id"
+	LanguageEnglish    = "en"
+)
+
+// DefaultLanguage is the language used when the client doesn't ask for a supported one
+const DefaultLanguage = LanguageIndonesian
+
+// messageCatalog holds every user-facing message keyed by language and message code
+var messageCatalog = map[string]map[MessageCode]string{
+	LanguageIndonesian: {
+		MsgBookRequested:         "Sukses request booking",
+		MsgBookApproved:          "Sukses Approve booking",
+		MsgBookRejected:          "Sukses Reject booking",
+		MsgLoggedOut:             "Sukses logout",
+		MsgLoggedOutAll:          "Sukses logout dari semua perangkat",
+		MsgWebhookRemoved:        "Sukses menghapus webhook",
+		MsgWebhookRedelivered:    "Webhook akan dikirim ulang",
+		MsgInternalError:         "Terjadi kesalahan pada server",
+		MsgDatabaseError:         "Terjadi kesalahan saat mengakses database",
+		MsgSessionError:          "Sesi tidak dapat dibaca",
+		MsgUnauthorized:          "Silakan login terlebih dahulu",
+		MsgTokenInvalid:          "Token tidak valid",
+		MsgTokenExpired:          "Token sudah kedaluwarsa",
+		MsgTokenRevoked:          "Token sudah dicabut, silakan login kembali",
+		MsgSessionExpired:        "Sesi sudah berakhir, silakan login kembali",
+		MsgTokenRenewFailed:      "Gagal memperbarui token",
+		MsgInvalidRequestBody:    "Format request tidak valid",
+		MsgBookNotFound:          "Booking tidak ditemukan",
+		MsgKostNotFound:          "Kost tidak ditemukan",
+		MsgRoomNotFound:          "Kamar tidak ditemukan",
+		MsgPeriodNotOffered:      "Periode sewa tidak tersedia di kost ini",
+		MsgTransactionNotFound:   "Transaksi tidak ditemukan",
+		MsgInvalidBookStatus:     "Status booking tidak valid untuk di approve",
+		MsgOwnerOnlyApproval:     "Hanya owner atau staff kost yang bisa approve book ini",
+		MsgTenantOnlyApproval:    "Hanya tenant kost yang bisa approve book ini",
+		MsgForbidden:             "Anda tidak memiliki akses untuk aksi ini",
+		MsgRequestTooLarge:       "Ukuran request terlalu besar",
+		MsgValidationFailed:      "Data request tidak valid",
+		MsgWebhookNotFound:       "Webhook tidak ditemukan",
+		MsgDeliveryNotFound:      "Pengiriman webhook tidak ditemukan",
+		MsgTooManyRequests:       "Terlalu banyak request, silakan coba lagi nanti",
+		MsgIdempotencyKeyInvalid: "Idempotency-Key tidak valid",
+		MsgIdempotencyKeyReused:  "Idempotency-Key sudah dipakai untuk request lain",
+		MsgIdempotencyInProgress: "Request dengan Idempotency-Key ini masih diproses",
+		MsgVersionConflict:       "Booking sudah diubah oleh request lain, silakan muat ulang dan coba lagi",
+		MsgPreconditionFailed:    "Booking sudah berubah sejak terakhir dibaca (If-Match tidak sesuai)",
+		MsgFieldRequired:         "%s wajib diisi",
+		MsgFieldGreaterThan:      "%s harus lebih besar dari %s",
+		MsgFieldMin:              "%s minimal %s",
+		MsgFieldMax:              "%s maksimal %s",
+		MsgFieldNotPast:          "%s tidak boleh tanggal yang sudah lewat",
+		MsgFieldURL:              "%s harus berupa URL yang valid",
+		MsgFieldPublicURL:        "%s harus mengarah ke alamat publik",
+		MsgFieldUnknown:          "%s tidak dikenal",
+		MsgFieldInvalid:          "%s tidak valid",
+		MsgFieldOneOf:            "%s harus salah satu dari: %s",
+	},
+	LanguageEnglish: {
+		MsgBookRequested:         "Booking requested successfully",
+		MsgBookApproved:          "Booking approved successfully",
+		MsgBookRejected:          "Booking rejected successfully",
+		MsgLoggedOut:             "Logged out successfully",
+		MsgLoggedOutAll:          "Logged out from every device successfully",
+		MsgWebhookRemoved:        "Webhook removed successfully",
+		MsgWebhookRedelivered:    "The webhook will be delivered again",
+		MsgInternalError:         "An internal server error occurred",
+		MsgDatabaseError:         "An error occurred while accessing the database",
+		MsgSessionError:          "The session could not be read",
+		MsgUnauthorized:          "Please log in first",
+		MsgTokenInvalid:          "Invalid token",
+		MsgTokenExpired:          "The token has expired",
+		MsgTokenRevoked:          "The token has been revoked, please log in again",
+		MsgSessionExpired:        "The session has ended, please log in again",
+		MsgTokenRenewFailed:      "Failed to renew the token",
+		MsgInvalidRequestBody:    "Invalid request body",
+		MsgBookNotFound:          "Booking not found",
+		MsgKostNotFound:          "Kost not found",
+		MsgRoomNotFound:          "Room not found",
+		MsgPeriodNotOffered:      "The rent period is not offered by this kost",
+		MsgTransactionNotFound:   "Transaction not found",
+		MsgInvalidBookStatus:     "The booking status is not valid for approval",
+		MsgOwnerOnlyApproval:     "Only the kost owner or staff can approve this booking",
+		MsgTenantOnlyApproval:    "Only the kost tenant can approve this booking",
+		MsgForbidden:             "You are not allowed to perform this action",
+		MsgRequestTooLarge:       "The request body is too large",
+		MsgValidationFailed:      "The request contains invalid fields",
+		MsgWebhookNotFound:       "Webhook not found",
+		MsgDeliveryNotFound:      "Webhook delivery not found",
+		MsgTooManyRequests:       "Too many requests, please try again later",
+		MsgIdempotencyKeyInvalid: "The Idempotency-Key is invalid",
+		MsgIdempotencyKeyReused:  "The Idempotency-Key was already used for another request",
+		MsgIdempotencyInProgress: "The request with this Idempotency-Key is still being processed",
+		MsgVersionConflict:       "The booking was changed by another request, please reload it and try again",
+		MsgPreconditionFailed:    "The booking changed since it was last read (If-Match does not match)",
+		MsgFieldRequired:         "%s is required",
+		MsgFieldGreaterThan:      "%s must be greater than %s",
+		MsgFieldMin:              "%s must be at least %s",
+		MsgFieldMax:              "%s must be at most %s",
+		MsgFieldNotPast:          "%s must not be a past date",
+		MsgFieldURL:              "%s must be a valid URL",
+		MsgFieldPublicURL:        "%s must resolve to a public address",
+		MsgFieldUnknown:          "%s is not a known field",
+		MsgFieldInvalid:          "%s is invalid",
+		MsgFieldOneOf:            "%s must be one of: %s",
+	},
+}
+
+// MessageError is an error that carries the message code shown to the client
+type MessageError struct {
+	Code MessageCode
+	Err  error
+}
+
+// NewMessageError is a function to create a new MessageError wrapping the given cause, the cause may be nil
+func NewMessageError(code MessageCode, err error) *MessageError {
+	return &MessageError{Code: code, Err: err}
+}
+
+// NotFoundError wraps the given lookup error with the given message code when the looked up row doesn't exist,
+// any other database error is returned as is since the client isn't at fault
+func NotFoundError(code MessageCode, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return NewMessageError(code, err)
+	}
+
+	return err
+}
+
+// Error returns the underlying cause when available, otherwise the default language message
+func (messageError *MessageError) Error() string {
+	if messageError.Err != nil {
+		return string(messageError.Code) + ": " + messageError.Err.Error()
+	}
+
+	return Translate(DefaultLanguage, messageError.Code)
+}
+
+// Unwrap returns the underlying cause of the message error
+func (messageError *MessageError) Unwrap() error {
+	return messageError.Err
+}
+
+// Translate returns the message of the given code in the given language,
+// falling back to the default language and then to the code itself
+func Translate(language string, code MessageCode, args ...interface{}) string {
+	message, ok := messageCatalog[language][code]
+	if !ok {
+		message, ok = messageCatalog[DefaultLanguage][code]
+		if !ok {
+			return string(code)
+		}
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+
+	return message
+}
+
+// NegotiateLanguage picks the best supported language from the given Accept-Language header value
+func NegotiateLanguage(acceptLanguage string) string {
+
+	type languageRange struct {
+		tag     string
+		quality float64
+	}
+
+	// parse every language range with its quality value
+	var ranges []languageRange
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err == nil {
+					quality = q
+				}
+			}
+		}
+
+		ranges = append(ranges, languageRange{tag, quality})
+	}
+
+	// the most preferred language range comes first, keeping the header order on ties
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+
+	for _, languageRange := range ranges {
+		if languageRange.quality <= 0 {
+			continue
+		}
+
+		if languageRange.tag == "*" {
+			return DefaultLanguage
+		}
+
+		// only the primary subtag matters, "en-US" is served as "en"
+		primary := strings.SplitN(languageRange.tag, "-", 2)[0]
+		if _, ok := messageCatalog[primary]; ok {
+			return primary
+		}
+	}
+
+	return DefaultLanguage
+}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	for acceptLanguage, expected := range map[string]string{
+		"":                          DefaultLanguage,
+		"en":                        LanguageEnglish,
+		"en-US,en;q=0.9":            LanguageEnglish,
+		"EN-gb":                     LanguageEnglish,
+		"id, en":                    LanguageIndonesian,
+		"en;q=0.5, id;q=0.8":        LanguageIndonesian,
+		"fr, en;q=0.7":              LanguageEnglish,
+		"fr, de":                    DefaultLanguage,
+		"*":                         DefaultLanguage,
+		"en;q=0, fr":                DefaultLanguage,
+		"en;q=oops":                 LanguageEnglish,
+		"fr;q=0.9, *;q=0.8, en;q=1": LanguageEnglish,
+	} {
+		if language := NegotiateLanguage(acceptLanguage); language != expected {
+			t.Fatalf("expected %q negotiated as %s, got %s", acceptLanguage, expected, language)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	if message := Translate(LanguageEnglish, MsgBookNotFound); message != "Booking not found" {
+		t.Fatalf("expected the english message, got %s", message)
+	}
+
+	// an unsupported language falls back to the default language
+	if message := Translate("fr", MsgBookNotFound); message != messageCatalog[DefaultLanguage][MsgBookNotFound] {
+		t.Fatalf("expected the default language message, got %s", message)
+	}
+
+	// an unknown code falls back to the code itself
+	if message := Translate(LanguageEnglish, MessageCode("UNKNOWN_CODE")); message != "UNKNOWN_CODE" {
+		t.Fatalf("expected the code itself, got %s", message)
+	}
+
+	if message := Translate(LanguageEnglish, MsgFieldMin, "duration", "1"); message != "duration must be at least 1" {
+		t.Fatalf("expected the arguments formatted into the message, got %s", message)
+	}
+}
+
+func TestMessageCatalogIsComplete(t *testing.T) {
+	for language, messages := range messageCatalog {
+		for code := range messageCatalog[DefaultLanguage] {
+			if _, ok := messages[code]; !ok {
+				t.Fatalf("expected %s translated in %s", code, language)
+			}
+		}
+
+		if len(messages) != len(messageCatalog[DefaultLanguage]) {
+			t.Fatalf("expected %s to hold the codes of the default language only", language)
+		}
+	}
+}
+
+func TestNotFoundError(t *testing.T) {
+	var messageError *MessageError
+	if err := NotFoundError(MsgKostNotFound, fmt.Errorf("find kost: %w", gorm.ErrRecordNotFound)); !errors.As(err, &messageError) || messageError.Code != MsgKostNotFound {
+		t.Fatalf("expected a missing row reported with the message code, got %v", err)
+	}
+
+	// any other database error isn't the client's fault
+	cause := errors.New("connection refused")
+	if err := NotFoundError(MsgKostNotFound, cause); err != cause {
+		t.Fatalf("expected the database error returned as is, got %v", err)
+	}
+}
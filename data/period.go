@@ -0,0 +1,47 @@
+package data
+
+import (
+	"strings"
+	"time"
+)
+
+// Transaction categories stored in the DBTransaction TrxCategory column
+const (
+	TrxCategoryBooking uint = 0 // the first payment made with the booking
+	TrxCategoryRent    uint = 1 // the rent of every following period
+)
+
+// periodLengths maps the MasterPeriod descriptions to the length of a rent period in years, months and days
+var periodLengths = map[string][3]int{
+	"annual":   {1, 0, 0},
+	"yearly":   {1, 0, 0},
+	"tahunan":  {1, 0, 0},
+	"monthly":  {0, 1, 0},
+	"bulanan":  {0, 1, 0},
+	"weekly":   {0, 0, 7},
+	"mingguan": {0, 0, 7},
+	"daily":    {0, 0, 1},
+	"harian":   {0, 0, 1},
+}
+
+// NextPeriodStart returns the start of the rent period following the one starting at the given time,
+// false is returned when the given period description is not a known recurring period
+func NextPeriodStart(periodDesc string, start time.Time) (time.Time, bool) {
+	length, ok := periodLengths[strings.ToLower(strings.TrimSpace(periodDesc))]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return start.AddDate(length[0], length[1], length[2]), true
+}
+
+// PeriodDays returns the approximate length in days of the given period, used to convert a room price
+// quoted for one period to another, false is returned when the given period description is not known
+func PeriodDays(periodDesc string) (int, bool) {
+	length, ok := periodLengths[strings.ToLower(strings.TrimSpace(periodDesc))]
+	if !ok {
+		return 0, false
+	}
+
+	return length[0]*365 + length[1]*30 + length[2], true
+}
@@ -0,0 +1,32 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextPeriodStart(t *testing.T) {
+	start := time.Date(2021, time.January, 31, 0, 0, 0, 0, time.UTC)
+
+	next, ok := NextPeriodStart(" Bulanan ", start)
+	if !ok || !next.Equal(start.AddDate(0, 1, 0)) {
+		t.Fatalf("expected the next monthly period, got %v %v", next, ok)
+	}
+
+	if _, ok := NextPeriodStart("sekali", start); ok {
+		t.Fatal("expected an unknown period to not recur")
+	}
+}
+
+func TestPeriodDays(t *testing.T) {
+	tests := map[string]int{"annual": 365, "Monthly": 30, "mingguan": 7, "harian": 1}
+	for periodDesc, expected := range tests {
+		if days, ok := PeriodDays(periodDesc); !ok || days != expected {
+			t.Fatalf("expected %s to last %d days, got %d %v", periodDesc, expected, days, ok)
+		}
+	}
+
+	if _, ok := PeriodDays("sekali"); ok {
+		t.Fatal("expected an unknown period to have no length")
+	}
+}
@@ -0,0 +1,132 @@
+package data
+
+import (
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/gorm"
+)
+
+// Role is a role of the book API, the MasterUser RoleID column is mapped to it by the role configuration
+type Role string
+
+// Roles of the book API
+const (
+	RoleTenant Role = "tenant"
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleStaff  Role = "staff"
+)
+
+// Permission is an action a role is allowed to take on the book API
+type Permission string
+
+// Permissions of the book API
+const (
+	PermissionBookRead          Permission = "book:read"
+	PermissionBookCreate        Permission = "book:create"
+	PermissionBookApproveOwner  Permission = "book:approve:owner"
+	PermissionBookApproveTenant Permission = "book:approve:tenant"
+	PermissionWebhookManage     Permission = "webhook:manage"
+)
+
+// rolePermissions maps every role to the permissions it is granted
+var rolePermissions = map[Role][]Permission{
+	RoleTenant: {
+		PermissionBookRead,
+		PermissionBookCreate,
+		PermissionBookApproveTenant,
+	},
+	RoleOwner: {
+		PermissionBookRead,
+		PermissionBookCreate,
+		PermissionBookApproveOwner,
+		PermissionBookApproveTenant,
+		PermissionWebhookManage,
+	},
+	RoleStaff: {
+		PermissionBookRead,
+		PermissionBookCreate,
+		PermissionBookApproveOwner,
+		PermissionBookApproveTenant,
+	},
+	RoleAdmin: {
+		PermissionBookRead,
+		PermissionBookCreate,
+		PermissionBookApproveOwner,
+		PermissionBookApproveTenant,
+		PermissionWebhookManage,
+	},
+}
+
+// Policy defines a struct for the book authorization rules
+type Policy struct {
+	logger hclog.Logger
+	roles  map[uint]Role
+}
+
+// NewPolicy is a function to create new Policy struct, the role ids are the ones of the user service role table
+func NewPolicy(newLogger hclog.Logger, roleConfig *entities.RoleConfiguration) *Policy {
+	return &Policy{
+		logger: newLogger,
+		roles: map[uint]Role{
+			roleConfig.Tenant: RoleTenant,
+			roleConfig.Owner:  RoleOwner,
+			roleConfig.Admin:  RoleAdmin,
+			roleConfig.Staff:  RoleStaff,
+		},
+	}
+}
+
+// RoleOf returns the role of the given user, an unknown role id is granted nothing
+func (policy *Policy) RoleOf(user *database.MasterUser) (Role, bool) {
+	role, ok := policy.roles[user.RoleID]
+	return role, ok
+}
+
+// IsAdmin checks whether the given user holds the admin role
+func (policy *Policy) IsAdmin(user *database.MasterUser) bool {
+	role, _ := policy.RoleOf(user)
+	return role == RoleAdmin
+}
+
+// HasPermission checks whether the role of the given user grants the given permission
+func (policy *Policy) HasPermission(user *database.MasterUser, permission Permission) bool {
+	role, ok := policy.RoleOf(user)
+	if !ok {
+		return false
+	}
+
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanManageKost checks whether the given user may act as the owner of the given kost,
+// which is the owner itself, an admin or an active staff member of the kost,
+// the staff assignment is read through the given db session, either config.DB or an open transaction
+func (policy *Policy) CanManageKost(db *gorm.DB, user *database.MasterUser, kost *database.DBKost) (bool, error) {
+	if policy.IsAdmin(user) || user.ID == kost.OwnerID {
+		return true, nil
+	}
+
+	// look for an active staff assignment of the user on the kost
+	var staffCount int64
+	if err := db.Model(&database.DBKostStaff{}).
+		Where("kost_id = ? AND user_id = ? AND is_active = ?", kost.ID, user.ID, true).
+		Count(&staffCount).Error; err != nil {
+		return false, err
+	}
+
+	return staffCount > 0, nil
+}
+
+// CanActAsTenant checks whether the given user may act as the tenant of the given book,
+// which is the booker itself or an admin
+func (policy *Policy) CanActAsTenant(user *database.MasterUser, book *database.DBTransactionRoomBook) bool {
+	return policy.IsAdmin(user) || user.ID == book.BookerID
+}
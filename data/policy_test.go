@@ -0,0 +1,106 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+)
+
+// the test users of the policy, one for each configured role and one of an unknown role
+var (
+	policyTenant  = &database.MasterUser{ID: 20, RoleID: 1}
+	policyOwner   = &database.MasterUser{ID: 10, RoleID: 2}
+	policyAdmin   = &database.MasterUser{ID: 30, RoleID: 3}
+	policyStaff   = &database.MasterUser{ID: 40, RoleID: 4}
+	policyUnknown = &database.MasterUser{ID: 50, RoleID: 99}
+)
+
+// newTestPolicy returns a policy mapping the role ids of the test users
+func newTestPolicy() *Policy {
+	return NewPolicy(hclog.NewNullLogger(), &entities.RoleConfiguration{Tenant: 1, Owner: 2, Admin: 3, Staff: 4})
+}
+
+func TestPolicyHasPermission(t *testing.T) {
+	policy := newTestPolicy()
+
+	for _, test := range []struct {
+		user       *database.MasterUser
+		permission Permission
+		expected   bool
+	}{
+		{policyTenant, PermissionBookCreate, true},
+		{policyTenant, PermissionBookApproveTenant, true},
+		{policyTenant, PermissionBookApproveOwner, false},
+		{policyTenant, PermissionWebhookManage, false},
+		{policyOwner, PermissionBookApproveOwner, true},
+		{policyOwner, PermissionWebhookManage, true},
+		{policyStaff, PermissionBookApproveOwner, true},
+		{policyStaff, PermissionWebhookManage, false},
+		{policyAdmin, PermissionWebhookManage, true},
+		{policyUnknown, PermissionBookRead, false},
+	} {
+		if granted := policy.HasPermission(test.user, test.permission); granted != test.expected {
+			t.Fatalf("expected role id %d granted %s %v, got %v", test.user.RoleID, test.permission, test.expected, granted)
+		}
+	}
+}
+
+func TestPolicyCanManageKost(t *testing.T) {
+	db := newTestDB(t, &database.DBKostStaff{})
+	policy := newTestPolicy()
+	kost := &database.DBKost{ID: 1, OwnerID: policyOwner.ID}
+
+	// the staff of another kost and a former staff of the kost manage nothing
+	otherStaff := &database.MasterUser{ID: 41, RoleID: 4}
+	formerStaff := &database.MasterUser{ID: 42, RoleID: 4}
+	for _, staff := range []database.DBKostStaff{
+		{KostID: kost.ID, UserID: policyStaff.ID, IsActive: true},
+		{KostID: 2, UserID: otherStaff.ID, IsActive: true},
+		{KostID: kost.ID, UserID: formerStaff.ID, IsActive: true},
+	} {
+		if err := db.Create(&staff).Error; err != nil {
+			t.Fatalf("seed the kost staff: %v", err)
+		}
+	}
+
+	// the column defaults to active, so the former staff is deactivated once created
+	if err := db.Model(&database.DBKostStaff{}).Where("user_id = ?", formerStaff.ID).Update("is_active", false).Error; err != nil {
+		t.Fatalf("deactivate the former staff: %v", err)
+	}
+
+	for _, test := range []struct {
+		user     *database.MasterUser
+		expected bool
+	}{
+		{policyOwner, true},
+		{policyAdmin, true},
+		{policyStaff, true},
+		{otherStaff, false},
+		{formerStaff, false},
+		{policyTenant, false},
+	} {
+		canManage, err := policy.CanManageKost(db, test.user, kost)
+		if err != nil {
+			t.Fatalf("check the kost management: %v", err)
+		}
+
+		if canManage != test.expected {
+			t.Fatalf("expected user %d managing the kost %v, got %v", test.user.ID, test.expected, canManage)
+		}
+	}
+}
+
+func TestPolicyCanActAsTenant(t *testing.T) {
+	policy := newTestPolicy()
+	book := &database.DBTransactionRoomBook{BookerID: policyTenant.ID}
+
+	if !policy.CanActAsTenant(policyTenant, book) || !policy.CanActAsTenant(policyAdmin, book) {
+		t.Fatalf("expected the booker and the admin acting as the tenant")
+	}
+
+	if policy.CanActAsTenant(policyOwner, book) || policy.CanActAsTenant(policyStaff, book) {
+		t.Fatalf("expected the owner and the staff not acting as the tenant")
+	}
+}
@@ -0,0 +1,217 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/tracing"
+)
+
+// TokenPolicy defines the lifetime and renewal rules of the session tokens
+type TokenPolicy struct {
+	Lifetime           time.Duration // lifetime of a newly signed token
+	RenewalWindow      time.Duration // a token is only renewed once it expires within this window
+	MaxSessionLifetime time.Duration // absolute lifetime of a session counted from the token iat, zero is unlimited
+}
+
+// MyTokenPolicy is a variable that defines the session token rules
+var MyTokenPolicy = TokenPolicy{
+	Lifetime:      7 * 24 * time.Hour,
+	RenewalWindow: 24 * time.Hour,
+}
+
+// NewTokenPolicy builds the token policy of the given JWT configuration, unset values keep their defaults
+func NewTokenPolicy(jwtConfig *entities.JwtConfiguration) TokenPolicy {
+	policy := MyTokenPolicy
+
+	if jwtConfig.TokenLifetime > 0 {
+		policy.Lifetime = time.Duration(jwtConfig.TokenLifetime) * time.Second
+	}
+
+	if jwtConfig.RenewalWindow > 0 {
+		policy.RenewalWindow = time.Duration(jwtConfig.RenewalWindow) * time.Second
+	}
+
+	if jwtConfig.MaxSessionLifetime > 0 {
+		policy.MaxSessionLifetime = time.Duration(jwtConfig.MaxSessionLifetime) * time.Second
+	}
+
+	return policy
+}
+
+// SessionDeadline returns the absolute end of the session of the given claims, zero when unlimited
+func (policy TokenPolicy) SessionDeadline(claims *Claims) time.Time {
+	if policy.MaxSessionLifetime <= 0 || claims.IssuedAt == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(claims.IssuedAt, 0).Add(policy.MaxSessionLifetime)
+}
+
+// ShouldRenew checks whether the token of the given claims is close enough to its expiry to be renewed
+func (policy TokenPolicy) ShouldRenew(claims *Claims) bool {
+	if claims.ExpiresAt == 0 {
+		return false
+	}
+
+	// nothing to renew once the session reached its absolute lifetime
+	deadline := policy.SessionDeadline(claims)
+	if !deadline.IsZero() && claims.ExpiresAt >= deadline.Unix() {
+		return false
+	}
+
+	return time.Until(time.Unix(claims.ExpiresAt, 0)) <= policy.RenewalWindow
+}
+
+// RenewToken signs a new token for the given claims with a renewed expiration time,
+// the iat is kept so the session can't outlive its absolute lifetime,
+// only the self issued tokens can be renewed and they are signed again with their own HMAC algorithm
+func (policy TokenPolicy) RenewToken(claims *Claims) (string, time.Duration, error) {
+	if !claims.IsSelfIssued() {
+		return "", 0, fmt.Errorf("tokens signed with %s are not issued by this service", claims.Algorithm)
+	}
+
+	now := time.Now()
+
+	expirationTime := now.Add(policy.Lifetime)
+	deadline := policy.SessionDeadline(claims)
+	if !deadline.IsZero() && expirationTime.After(deadline) {
+		expirationTime = deadline
+	}
+
+	claims.ExpiresAt = expirationTime.Unix()
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(claims.Algorithm), claims)
+	tokenString, err := token.SignedString([]byte(MySigningKey))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return tokenString, expirationTime.Sub(now), nil
+}
+
+// revocationSnapshot is the cached denylist state of a single username
+type revocationSnapshot struct {
+	revokedBefore time.Time
+	tokenIDs      map[string]bool
+}
+
+// IsTokenRevoked checks the denylist for the token of the given claims,
+// either the token itself or every token of its holder may have been revoked
+func (book *Book) IsTokenRevoked(ctx context.Context, claims *Claims) (revoked bool, err error) {
+	ctx, span := tracing.Start(ctx, "Book.IsTokenRevoked")
+	defer func() { tracing.End(span, err) }()
+
+	snapshot, err := book.getRevocationSnapshot(ctx, claims.Username)
+	if err != nil {
+		return false, err
+	}
+
+	if snapshot.tokenIDs[claims.TokenID()] {
+		return true, nil
+	}
+
+	// the iat has a one second granularity, only the tokens issued in an earlier second than the logout are revoked
+	return time.Unix(claims.IssuedAt, 0).Before(snapshot.revokedBefore), nil
+}
+
+// getRevocationSnapshot returns the denylist state of the given username from the cache or else from the db
+func (book *Book) getRevocationSnapshot(ctx context.Context, username string) (*revocationSnapshot, error) {
+	if cached, ok := book.revocations.Get(username); ok {
+		return cached.(*revocationSnapshot), nil
+	}
+
+	// look for every live denylist entry of the username
+	var entries []database.DBTokenDenylist
+	if err := config.DB.WithContext(ctx).Where("username = ? AND is_active = ? AND expires_at > ?", username, true, time.Now()).
+		Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	snapshot := &revocationSnapshot{tokenIDs: map[string]bool{}}
+	for _, entry := range entries {
+		if entry.TokenID != "" {
+			snapshot.tokenIDs[entry.TokenID] = true
+		}
+
+		if entry.RevokedBefore != nil && entry.RevokedBefore.After(snapshot.revokedBefore) {
+			snapshot.revokedBefore = *entry.RevokedBefore
+		}
+	}
+
+	book.revocations.Set(username, snapshot)
+
+	return snapshot, nil
+}
+
+// RevokeToken adds the token of the given claims to the denylist until it expires,
+// only this token is revoked, by its jti or else by its hash
+func (book *Book) RevokeToken(ctx context.Context, claims *Claims) (err error) {
+	ctx, span := tracing.Start(ctx, "Book.RevokeToken")
+	defer func() { tracing.End(span, err) }()
+
+	// a token without exp stays valid for its whole session
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if claims.ExpiresAt == 0 {
+		expiresAt = time.Now().Add(MyTokenPolicy.Lifetime)
+		if MyTokenPolicy.MaxSessionLifetime > MyTokenPolicy.Lifetime {
+			expiresAt = time.Now().Add(MyTokenPolicy.MaxSessionLifetime)
+		}
+	}
+
+	return book.addDenylistEntry(ctx, claims.Username, claims.TokenID(), nil, expiresAt)
+}
+
+// RevokeAllTokens adds every token issued to the holder of the given claims before the current second to the denylist,
+// logging the user out everywhere, a token issued within the same second is a new login and stays valid
+// while the token of the given claims is revoked by itself
+func (book *Book) RevokeAllTokens(ctx context.Context, claims *Claims) (err error) {
+	ctx, span := tracing.Start(ctx, "Book.RevokeAllTokens")
+	defer func() { tracing.End(span, err) }()
+
+	if err := book.RevokeToken(ctx, claims); err != nil {
+		return err
+	}
+
+	// stored to the second like the iat it is compared with
+	now := time.Now().Truncate(time.Second)
+
+	// the entry is only needed until the last token issued before it expires
+	expiresAt := now.Add(MyTokenPolicy.Lifetime)
+	if MyTokenPolicy.MaxSessionLifetime > MyTokenPolicy.Lifetime {
+		expiresAt = now.Add(MyTokenPolicy.MaxSessionLifetime)
+	}
+
+	return book.addDenylistEntry(ctx, claims.Username, "", &now, expiresAt)
+}
+
+// addDenylistEntry inserts a new denylist entry to the database
+func (book *Book) addDenylistEntry(ctx context.Context, username, tokenID string, revokedBefore *time.Time, expiresAt time.Time) error {
+
+	// set variables
+	var newEntry database.DBTokenDenylist
+
+	newEntry.Username = username
+	newEntry.TokenID = tokenID
+	newEntry.RevokedBefore = revokedBefore
+	newEntry.ExpiresAt = expiresAt
+	newEntry.IsActive = true
+	newEntry.Created = time.Now().Local()
+	newEntry.CreatedBy = username
+	newEntry.Modified = time.Now().Local()
+	newEntry.ModifiedBy = username
+
+	// insert the new denylist entry to database
+	if err := config.DB.WithContext(ctx).Create(&newEntry).Error; err != nil {
+		return err
+	}
+
+	// the next request of the user must see the new entry
+	book.revocations.Invalidate(username)
+
+	return nil
+}
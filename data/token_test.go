@@ -0,0 +1,165 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+)
+
+// newTestBook returns a book whose denylist lives in a test database
+func newTestBook(t *testing.T) *Book {
+	t.Helper()
+
+	db := newTestDB(t, &database.DBTokenDenylist{}, &database.MasterUser{})
+	previous := config.DB
+	config.DB = db
+	t.Cleanup(func() { config.DB = previous })
+
+	return NewBook(hclog.NewNullLogger(), NewTTLCache(time.Minute, 100), NewTTLCache(time.Minute, 100))
+}
+
+// newTestClaims returns the claims of a token of the given user issued at the given time
+func newTestClaims(username, tokenHash string, issuedAt time.Time) *Claims {
+	return &Claims{
+		Username:  username,
+		Algorithm: "HS256",
+		TokenHash: tokenHash,
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  issuedAt.Unix(),
+			ExpiresAt: issuedAt.Add(time.Hour).Unix(),
+		},
+	}
+}
+
+func TestRevokeTokenOnlyRevokesThatToken(t *testing.T) {
+	book := newTestBook(t)
+	ctx := context.Background()
+	loggedOut := newTestClaims("tenant", "aaa", time.Now())
+	otherDevice := newTestClaims("tenant", "bbb", time.Now())
+
+	if err := book.RevokeToken(ctx, loggedOut); err != nil {
+		t.Fatalf("revoke the token: %v", err)
+	}
+
+	if revoked, err := book.IsTokenRevoked(ctx, loggedOut); err != nil || !revoked {
+		t.Fatalf("expected the logged out token revoked, got %v %v", revoked, err)
+	}
+
+	if revoked, err := book.IsTokenRevoked(ctx, otherDevice); err != nil || revoked {
+		t.Fatalf("expected the other device still logged in, got %v %v", revoked, err)
+	}
+}
+
+func TestRevokeAllTokensKeepsLoginsOfTheSameSecond(t *testing.T) {
+	book := newTestBook(t)
+	ctx := context.Background()
+	now := time.Now()
+	current := newTestClaims("tenant", "current", now.Add(-time.Minute))
+	otherDevice := newTestClaims("tenant", "other", now.Add(-2*time.Second))
+
+	if err := book.RevokeAllTokens(ctx, current); err != nil {
+		t.Fatalf("revoke every token: %v", err)
+	}
+
+	for _, claims := range []*Claims{current, otherDevice} {
+		if revoked, err := book.IsTokenRevoked(ctx, claims); err != nil || !revoked {
+			t.Fatalf("expected the token %s revoked, got %v %v", claims.TokenHash, revoked, err)
+		}
+	}
+
+	// a login right after the logout carries an iat of the same second or later
+	loginAt := time.Now()
+	for _, issuedAt := range []time.Time{loginAt, loginAt.Add(time.Second)} {
+		relogin := newTestClaims("tenant", "relogin", issuedAt)
+		if revoked, err := book.IsTokenRevoked(ctx, relogin); err != nil || revoked {
+			t.Fatalf("expected the new login at %v valid, got %v %v", issuedAt, revoked, err)
+		}
+	}
+
+	// another user is not affected
+	if revoked, err := book.IsTokenRevoked(ctx, newTestClaims("owner", "owner", now.Add(-time.Hour))); err != nil || revoked {
+		t.Fatalf("expected another user still logged in, got %v %v", revoked, err)
+	}
+}
+
+func TestTokenRenewal(t *testing.T) {
+	previous := MySigningKey
+	MySigningKey = "secret"
+	defer func() { MySigningKey = previous }()
+
+	policy := TokenPolicy{Lifetime: time.Hour, RenewalWindow: 10 * time.Minute, MaxSessionLifetime: 2 * time.Hour}
+	issuedAt := time.Now().Add(-110 * time.Minute)
+
+	// a token far from its expiry is not renewed
+	claims := newTestClaims("tenant", "", issuedAt)
+	claims.ExpiresAt = time.Now().Add(30 * time.Minute).Unix()
+	if policy.ShouldRenew(claims) {
+		t.Fatal("expected a token far from its expiry to not be renewed")
+	}
+
+	// the renewed token keeps its iat and can't outlive the session
+	claims.ExpiresAt = time.Now().Add(5 * time.Minute).Unix()
+	if !policy.ShouldRenew(claims) {
+		t.Fatal("expected a token close to its expiry to be renewed")
+	}
+
+	signed, lifetime, err := policy.RenewToken(claims)
+	if err != nil {
+		t.Fatalf("renew the token: %v", err)
+	}
+
+	if lifetime > 10*time.Minute+time.Second {
+		t.Fatalf("expected the renewal capped by the session lifetime, got %v", lifetime)
+	}
+
+	renewed := &Claims{}
+	if _, err := jwt.ParseWithClaims(signed, renewed, func(*jwt.Token) (interface{}, error) { return []byte("secret"), nil }); err != nil {
+		t.Fatalf("parse the renewed token: %v", err)
+	}
+
+	if renewed.IssuedAt != issuedAt.Unix() {
+		t.Fatalf("expected the iat kept, got %d", renewed.IssuedAt)
+	}
+
+	// nothing to renew once the session reached its absolute lifetime
+	if policy.ShouldRenew(renewed) {
+		t.Fatal("expected a token expiring with its session to not be renewed")
+	}
+}
+
+func TestNewTokenPolicy(t *testing.T) {
+	policy := NewTokenPolicy(&entities.JwtConfiguration{RenewalWindow: 600, MaxSessionLifetime: 7200})
+	if policy.Lifetime != MyTokenPolicy.Lifetime || policy.RenewalWindow != 10*time.Minute || policy.MaxSessionLifetime != 2*time.Hour {
+		t.Fatalf("expected the configured values over the defaults, got %+v", policy)
+	}
+
+	if deadline := policy.SessionDeadline(&Claims{}); !deadline.IsZero() {
+		t.Fatalf("expected no deadline without iat, got %v", deadline)
+	}
+}
+
+func TestParseTokenRefusesTheSessionPastItsLifetime(t *testing.T) {
+	previousKey, previousPolicy := MySigningKey, MyTokenPolicy
+	MySigningKey = "secret"
+	MyTokenPolicy = TokenPolicy{Lifetime: time.Hour, RenewalWindow: 10 * time.Minute, MaxSessionLifetime: time.Hour}
+	defer func() { MySigningKey, MyTokenPolicy = previousKey, previousPolicy }()
+
+	// a token still valid by its exp is refused once the session is older than its absolute lifetime
+	claims := newTestClaims("tenant", "", time.Now().Add(-2*time.Hour))
+	claims.ExpiresAt = time.Now().Add(time.Hour).Unix()
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("sign the token: %v", err)
+	}
+
+	if _, err := ParseToken(signed); messageCode(err) != MsgSessionExpired {
+		t.Fatalf("expected the session expired, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError is a single invalid field reported back to the client
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is a collection of every invalid field found on a payload
+type ValidationErrors []FieldError
+
+// Error returns the invalid fields joined in a single string
+func (validationErrors ValidationErrors) Error() string {
+	fields := make([]string, 0, len(validationErrors))
+	for _, fieldError := range validationErrors {
+		fields = append(fields, fieldError.Field+" ("+fieldError.Rule+")")
+	}
+
+	return "invalid fields: " + strings.Join(fields, ", ")
+}
+
+// Validation defines a struct for payload validation
+type Validation struct {
+	validate *validator.Validate
+}
+
+// NewValidation is a function to create new Validation struct with the service custom rules registered
+func NewValidation() *Validation {
+	validate := validator.New()
+
+	// report the json field name instead of the go field name
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+
+		return name
+	})
+
+	// notpast validates that a date is not before today
+	validate.RegisterValidation("notpast", func(fl validator.FieldLevel) bool {
+		date, ok := fl.Field().Interface().(time.Time)
+		if !ok {
+			return false
+		}
+
+		year, month, day := time.Now().Local().Date()
+		today := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+
+		return !date.Local().Before(today)
+	})
+
+	return &Validation{validate}
+}
+
+// Validate checks the given payload against its validate tags
+// and returns every invalid field translated to the given language
+func (validation *Validation) Validate(payload interface{}, language string) ValidationErrors {
+	err := validation.validate.Struct(payload)
+	if err == nil {
+		return nil
+	}
+
+	fieldErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return ValidationErrors{{Field: "", Rule: "invalid", Message: Translate(language, MsgInvalidRequestBody)}}
+	}
+
+	var validationErrors ValidationErrors
+	for _, fieldError := range fieldErrors {
+		validationErrors = append(validationErrors, newFieldError(fieldError, language))
+	}
+
+	return validationErrors
+}
+
+// UnknownFieldError returns the field error of a field that is not part of the payload
+func UnknownFieldError(field, language string) FieldError {
+	return FieldError{
+		Field:   field,
+		Rule:    "unknown",
+		Message: Translate(language, MsgFieldUnknown, field),
+	}
+}
+
+// newFieldError translates a single validator field error to the given language
+func newFieldError(fieldError validator.FieldError, language string) FieldError {
+
+	// strip the root struct name from the namespace, "TransactionRoomBook.members[0].member_name" becomes "members[0].member_name"
+	field := fieldError.Namespace()
+	if index := strings.Index(field, "."); index >= 0 {
+		field = field[index+1:]
+	}
+
+	var message string
+	switch fieldError.Tag() {
+	case "required":
+		message = Translate(language, MsgFieldRequired, field)
+	case "gt":
+		message = Translate(language, MsgFieldGreaterThan, field, fieldError.Param())
+	case "gte", "min":
+		message = Translate(language, MsgFieldMin, field, fieldError.Param())
+	case "lte", "max":
+		message = Translate(language, MsgFieldMax, field, fieldError.Param())
+	case "notpast":
+		message = Translate(language, MsgFieldNotPast, field)
+	case "url":
+		message = Translate(language, MsgFieldURL, field)
+	case "oneof":
+		message = Translate(language, MsgFieldOneOf, field, strings.Join(strings.Fields(fieldError.Param()), ", "))
+	default:
+		message = Translate(language, MsgFieldInvalid, field)
+	}
+
+	return FieldError{
+		Field:   field,
+		Rule:    fieldError.Tag(),
+		Message: message,
+	}
+}
@@ -0,0 +1,79 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fakhripraya/book-service/entities"
+)
+
+// validBookRequest returns a book payload passing every rule
+func validBookRequest() *entities.TransactionRoomBookRequest {
+	return &entities.TransactionRoomBookRequest{
+		KostID:          1,
+		RoomID:          1,
+		RoomDetailID:    1,
+		PaymentMethodID: 1,
+		PeriodID:        1,
+		BookDate:        time.Now(),
+		Members:         []entities.TransactionRoomBookMemberRequest{{MemberName: "Tenant"}},
+		VerificationData: entities.TransactionVerificationRequest{
+			PictDesc: "KTP",
+			URL:      "https://example.com/ktp.jpg",
+		},
+	}
+}
+
+func TestValidateAcceptsAValidPayload(t *testing.T) {
+	if validationErrors := NewValidation().Validate(validBookRequest(), LanguageEnglish); validationErrors != nil {
+		t.Fatalf("expected no invalid field, got %v", validationErrors)
+	}
+}
+
+func TestValidateReportsEveryInvalidField(t *testing.T) {
+	book := validBookRequest()
+	book.KostID = 0
+	book.Payment = -1
+	book.BookDate = time.Now().AddDate(0, 0, -1)
+	book.Members = []entities.TransactionRoomBookMemberRequest{{MemberName: ""}}
+	book.VerificationData.URL = "not a url"
+
+	validationErrors := NewValidation().Validate(book, LanguageEnglish)
+
+	expected := map[string]FieldError{
+		"kost_id":                {Field: "kost_id", Rule: "required", Message: "kost_id is required"},
+		"Payment":                {Field: "Payment", Rule: "gte", Message: "Payment must be at least 0"},
+		"book_date":              {Field: "book_date", Rule: "notpast", Message: "book_date must not be a past date"},
+		"members[0].member_name": {Field: "members[0].member_name", Rule: "required", Message: "members[0].member_name is required"},
+		"verification_data.url":  {Field: "verification_data.url", Rule: "url", Message: "verification_data.url must be a valid URL"},
+	}
+
+	if len(validationErrors) != len(expected) {
+		t.Fatalf("expected %d invalid fields, got %v", len(expected), validationErrors)
+	}
+
+	for _, fieldError := range validationErrors {
+		if fieldError != expected[fieldError.Field] {
+			t.Fatalf("expected %+v, got %+v", expected[fieldError.Field], fieldError)
+		}
+	}
+}
+
+func TestValidateTranslatesTheMessages(t *testing.T) {
+	book := validBookRequest()
+	book.Members = nil
+
+	validationErrors := NewValidation().Validate(book, LanguageIndonesian)
+	if len(validationErrors) != 1 || validationErrors[0].Message != "members wajib diisi" {
+		t.Fatalf("expected the message in indonesian, got %v", validationErrors)
+	}
+}
+
+func TestValidateOneOf(t *testing.T) {
+	subscription := &entities.WebhookSubscriptionRequest{URL: "https://example.com/hook", EventTypes: []string{"book.created", "book.deleted"}}
+
+	validationErrors := NewValidation().Validate(subscription, LanguageEnglish)
+	if len(validationErrors) != 1 || validationErrors[0].Field != "event_types[1]" || validationErrors[0].Rule != "oneof" {
+		t.Fatalf("expected the unknown event type reported, got %v", validationErrors)
+	}
+}
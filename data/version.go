@@ -0,0 +1,34 @@
+package data
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrStaleVersion is the cause of a versioned update failing because the row changed since it was read
+var ErrStaleVersion = errors.New("the row was updated by another request")
+
+// SaveVersioned saves every field of the given model only when its row still has the version the model was read with,
+// the version of the model is incremented on success, otherwise a MsgVersionConflict error wrapping ErrStaleVersion is returned
+func SaveVersioned(tx *gorm.DB, model interface{}, version *uint) error {
+	expected := *version
+	*version = expected + 1
+
+	// the primary key of the model along with the version it was read with identify the row to update
+	result := tx.Model(model).Where("version = ?", expected).Select("*").Updates(model)
+	if result.Error != nil {
+		*version = expected
+
+		return result.Error
+	}
+
+	// no row updated means another request changed the row or removed it
+	if result.RowsAffected == 0 {
+		*version = expected
+
+		return NewMessageError(MsgVersionConflict, ErrStaleVersion)
+	}
+
+	return nil
+}
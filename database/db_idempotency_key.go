@@ -0,0 +1,27 @@
+package database
+
+import "time"
+
+// DBIdempotencyKey is an entity that directly communicate with the IdempotencyKey table in the database,
+// it holds the response of a request made with an Idempotency-Key header so its retries get the same response
+type DBIdempotencyKey struct {
+	ID             uint      `gorm:"primary_key;autoIncrement;not null" json:"id"`
+	UserID         uint      `gorm:"not null;uniqueIndex:idx_idempotency_user_key" json:"user_id"`
+	IdempotencyKey string    `gorm:"not null;size:191;uniqueIndex:idx_idempotency_user_key" json:"idempotency_key"`
+	RequestHash    string    `gorm:"not null;size:64" json:"request_hash"` // sha256 of the method, the path and the body of the request
+	Status         uint      `gorm:"not null;default:0" json:"status"`
+	ResponseCode   int       `json:"response_code"`
+	ResponseHeader string    `gorm:"type:text" json:"response_header"` // JSON of the replayed response headers
+	ResponseBody   string    `gorm:"type:mediumtext" json:"response_body"`
+	ExpiresAt      time.Time `gorm:"type:datetime;index" json:"expires_at"` // the key can be used for another request after this time
+	IsActive       bool      `gorm:"not null;default:true" json:"is_active"`
+	Created        time.Time `gorm:"type:datetime" json:"created"`
+	CreatedBy      string    `json:"created_by"`
+	Modified       time.Time `gorm:"type:datetime" json:"modified"`
+	ModifiedBy     string    `json:"modified_by"`
+}
+
+// DBIdempotencyKeyTable set the migrated struct table name
+func (dbIdempotencyKey *DBIdempotencyKey) DBIdempotencyKeyTable() string {
+	return "dbIdempotencyKey"
+}
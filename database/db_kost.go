@@ -237,3 +237,20 @@ func (dbKostRoomPict *DBKostRoomPict) KostRoomPictTable() string {
 func (dbKostRoomFacilities *DBKostRoomFacilities) KostRoomFacilitiesTable() string {
 	return "dbKostRoomFacilities"
 }
+
+// DBKostStaff will migrate a kost staff table with the given specification into the database
+type DBKostStaff struct {
+	ID         uint      `gorm:"primary_key;autoIncrement;not null" json:"id"`
+	KostID     uint      `gorm:"not null" json:"kost_id"`
+	UserID     uint      `gorm:"not null" json:"user_id"`
+	IsActive   bool      `gorm:"not null;default:true" json:"is_active"`
+	Created    time.Time `gorm:"type:datetime" json:"created"`
+	CreatedBy  string    `json:"created_by"`
+	Modified   time.Time `gorm:"type:datetime" json:"modified"`
+	ModifiedBy string    `json:"modified_by"`
+}
+
+// KostStaffTable set the migrated struct table name
+func (dbKostStaff *DBKostStaff) KostStaffTable() string {
+	return "dbKostStaff"
+}
@@ -0,0 +1,24 @@
+package database
+
+import "time"
+
+// DBNotification is an entity that directly communicate with the Notification table in the database
+type DBNotification struct {
+	ID         uint      `gorm:"primary_key;autoIncrement;not null" json:"id"`
+	UserID     uint      `gorm:"not null;index" json:"user_id"`
+	DedupeKey  *string   `gorm:"uniqueIndex;size:191" json:"dedupe_key"` // the outbox event dedupe key, nil for the notifications sent outside the outbox
+	Event      string    `gorm:"not null" json:"event"`
+	Title      string    `gorm:"not null" json:"title"`
+	Body       string    `gorm:"not null" json:"body"`
+	IsRead     bool      `gorm:"not null;default:false" json:"is_read"`
+	IsActive   bool      `gorm:"not null;default:true" json:"is_active"`
+	Created    time.Time `gorm:"type:datetime" json:"created"`
+	CreatedBy  string    `json:"created_by"`
+	Modified   time.Time `gorm:"type:datetime" json:"modified"`
+	ModifiedBy string    `json:"modified_by"`
+}
+
+// DBNotificationTable set the migrated struct table name
+func (dbNotification *DBNotification) DBNotificationTable() string {
+	return "dbNotification"
+}
@@ -0,0 +1,46 @@
+package database
+
+import "time"
+
+// DBOutboxEvent is an entity that directly communicate with the OutboxEvent table in the database
+type DBOutboxEvent struct {
+	ID            uint       `gorm:"primary_key;autoIncrement;not null" json:"id"`
+	EventType     string     `gorm:"not null" json:"event_type"`
+	AggregateID   uint       `gorm:"not null" json:"aggregate_id"`
+	DedupeKey     string     `gorm:"not null;uniqueIndex;size:191" json:"dedupe_key"` // identifies the event for the subscribers, delivered at least once
+	Payload       string     `gorm:"type:text;not null" json:"payload"`
+	Status        uint       `gorm:"not null;default:0;index" json:"status"` // 0 = pending, 1 = published, 2 = failed
+	Attempts      uint       `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"type:datetime;index" json:"next_attempt_at"`
+	LastError     string     `gorm:"type:text" json:"last_error"`
+	PublishedAt   *time.Time `gorm:"type:datetime" json:"published_at"`
+	IsActive      bool       `gorm:"not null;default:true" json:"is_active"`
+	Created       time.Time  `gorm:"type:datetime" json:"created"`
+	CreatedBy     string     `json:"created_by"`
+	Modified      time.Time  `gorm:"type:datetime" json:"modified"`
+	ModifiedBy    string     `json:"modified_by"`
+}
+
+// DBOutboxEventTable set the migrated struct table name
+func (dbOutboxEvent *DBOutboxEvent) DBOutboxEventTable() string {
+	return "dbOutboxEvent"
+}
+
+// DBOutboxDelivery is an entity that directly communicate with the OutboxDelivery table in the database,
+// it records every target an outbox event was delivered to so a retried event skips them
+type DBOutboxDelivery struct {
+	ID          uint      `gorm:"primary_key;autoIncrement;not null" json:"id"`
+	DedupeKey   string    `gorm:"not null;uniqueIndex:idx_outbox_delivery_target;size:191" json:"dedupe_key"`
+	Target      string    `gorm:"not null;uniqueIndex:idx_outbox_delivery_target;size:64" json:"target"` // the publisher name, or publisher:channel for the notification channels
+	DeliveredAt time.Time `gorm:"type:datetime" json:"delivered_at"`
+	IsActive    bool      `gorm:"not null;default:true" json:"is_active"`
+	Created     time.Time `gorm:"type:datetime" json:"created"`
+	CreatedBy   string    `json:"created_by"`
+	Modified    time.Time `gorm:"type:datetime" json:"modified"`
+	ModifiedBy  string    `json:"modified_by"`
+}
+
+// DBOutboxDeliveryTable set the migrated struct table name
+func (dbOutboxDelivery *DBOutboxDelivery) DBOutboxDeliveryTable() string {
+	return "dbOutboxDelivery"
+}
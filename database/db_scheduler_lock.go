@@ -0,0 +1,22 @@
+package database
+
+import "time"
+
+// DBSchedulerLock is an entity that directly communicate with the SchedulerLock table in the database,
+// a scheduled job only runs on the replica holding its lock
+type DBSchedulerLock struct {
+	ID          uint      `gorm:"primary_key;autoIncrement;not null" json:"id"`
+	JobName     string    `gorm:"not null;uniqueIndex;size:191" json:"job_name"`
+	Holder      string    `gorm:"not null" json:"holder"`            // the replica currently running the job
+	LockedUntil time.Time `gorm:"type:datetime" json:"locked_until"` // the lock is free once this time has passed
+	IsActive    bool      `gorm:"not null;default:true" json:"is_active"`
+	Created     time.Time `gorm:"type:datetime" json:"created"`
+	CreatedBy   string    `json:"created_by"`
+	Modified    time.Time `gorm:"type:datetime" json:"modified"`
+	ModifiedBy  string    `json:"modified_by"`
+}
+
+// DBSchedulerLockTable set the migrated struct table name
+func (dbSchedulerLock *DBSchedulerLock) DBSchedulerLockTable() string {
+	return "dbSchedulerLock"
+}
@@ -0,0 +1,22 @@
+package database
+
+import "time"
+
+// DBTokenDenylist is an entity that directly communicate with the TokenDenylist table in the database
+type DBTokenDenylist struct {
+	ID            uint       `gorm:"primary_key;autoIncrement;not null" json:"id"`
+	Username      string     `gorm:"index;not null" json:"username"`
+	TokenID       string     `gorm:"index" json:"token_id"`                 // jti or sha256:<hash> of the revoked token, empty when every token of the user is revoked
+	RevokedBefore *time.Time `gorm:"type:datetime" json:"revoked_before"`   // every token of the user issued in an earlier second is revoked
+	ExpiresAt     time.Time  `gorm:"type:datetime;index" json:"expires_at"` // the entry can be purged after this time
+	IsActive      bool       `gorm:"not null;default:true" json:"is_active"`
+	Created       time.Time  `gorm:"type:datetime" json:"created"`
+	CreatedBy     string     `json:"created_by"`
+	Modified      time.Time  `gorm:"type:datetime" json:"modified"`
+	ModifiedBy    string     `json:"modified_by"`
+}
+
+// DBTokenDenylistTable set the migrated struct table name
+func (dbTokenDenylist *DBTokenDenylist) DBTokenDenylistTable() string {
+	return "dbTokenDenylist"
+}
@@ -4,16 +4,22 @@ import "time"
 
 // DBTransaction is an entity that directly communicate with the Transaction table in the database
 type DBTransaction struct {
-	ID             uint      `gorm:"primary_key;autoIncrement;not null" json:"id"`
-	TrxReferenceID uint      `gorm:"not null" json:"trx_reference_id"`
-	TrxCategory    uint      `gorm:"not null" json:"trx_category"` // kategori transaksi (bayar kost, bayar perpanjang, dll)
-	PaidOff        float64   `gorm:"not null" json:"paid_off"`
-	MustPay        float64   `gorm:"not null" json:"must_pay"`
-	IsActive       bool      `gorm:"not null;default:true" json:"is_active"`
-	Created        time.Time `gorm:"type:datetime" json:"created"`
-	CreatedBy      string    `json:"created_by"`
-	Modified       time.Time `gorm:"type:datetime" json:"modified"`
-	ModifiedBy     string    `json:"modified_by"`
+	ID             uint       `gorm:"primary_key;autoIncrement;not null" json:"id"`
+	TrxReferenceID uint       `gorm:"not null;uniqueIndex:idx_transaction_period" json:"trx_reference_id"`
+	TrxCategory    uint       `gorm:"not null;uniqueIndex:idx_transaction_period" json:"trx_category"` // kategori transaksi (bayar kost, bayar perpanjang, dll)
+	PaidOff        float64    `gorm:"not null" json:"paid_off"`
+	MustPay        float64    `gorm:"not null" json:"must_pay"`
+	PeriodStart    *time.Time `gorm:"type:datetime;uniqueIndex:idx_transaction_period" json:"period_start"` // the rent period paid by a rent transaction
+	DueDate        *time.Time `gorm:"type:datetime;index" json:"due_date"`
+	LateFee        float64    `gorm:"not null;default:0" json:"late_fee"` // added to MustPay once overdue
+	RemindedAt     *time.Time `gorm:"type:datetime" json:"reminded_at"`
+	OverdueAt      *time.Time `gorm:"type:datetime" json:"overdue_at"`
+	Version        uint       `gorm:"not null;default:1" json:"version"` // incremented by every update, a stale version fails the update
+	IsActive       bool       `gorm:"not null;default:true" json:"is_active"`
+	Created        time.Time  `gorm:"type:datetime" json:"created"`
+	CreatedBy      string     `json:"created_by"`
+	Modified       time.Time  `gorm:"type:datetime" json:"modified"`
+	ModifiedBy     string     `json:"modified_by"`
 }
 
 // DBTransactionDetail is an entity that directly communicate with the TransactionDetail table in the database
@@ -1,23 +1,29 @@
 package database
 
-import "time"
+import (
+	"strconv"
+	"time"
+)
 
 // DBTransactionRoomBook is an entity that directly communicate with the TransactionRoomBook table in the database
 type DBTransactionRoomBook struct {
-	ID           uint      `gorm:"primary_key;autoIncrement;not null" json:"id"`
-	BookerID     uint      `gorm:"not null" json:"booker_id"`
-	KostID       uint      `gorm:"not null" json:"kost_id"`
-	RoomID       uint      `gorm:"not null" json:"room_id"`
-	RoomDetailID uint      `gorm:"not null" json:"room_detail_id"`
-	PeriodID     uint      `gorm:"not null" json:"period_id"`
-	Status       uint      `gorm:"not null" json:"status"`
-	BookCode     string    `gorm:"not null" json:"book_code"`
-	BookDate     time.Time `gorm:"not null" json:"book_date"`
-	IsActive     bool      `gorm:"not null;default:true" json:"is_active"`
-	Created      time.Time `gorm:"type:datetime" json:"created"`
-	CreatedBy    string    `json:"created_by"`
-	Modified     time.Time `gorm:"type:datetime" json:"modified"`
-	ModifiedBy   string    `json:"modified_by"`
+	ID           uint       `gorm:"primary_key;autoIncrement;not null" json:"id"`
+	BookerID     uint       `gorm:"not null" json:"booker_id"`
+	KostID       uint       `gorm:"not null" json:"kost_id"`
+	RoomID       uint       `gorm:"not null" json:"room_id"`
+	RoomDetailID uint       `gorm:"not null" json:"room_detail_id"`
+	PeriodID     uint       `gorm:"not null" json:"period_id"`
+	Status       uint       `gorm:"not null" json:"status"`
+	BookCode     string     `gorm:"not null" json:"book_code"`
+	BookDate     time.Time  `gorm:"not null" json:"book_date"`
+	NextDueDate  *time.Time `gorm:"type:datetime" json:"next_due_date"` // the due date of the next unpaid rent period
+	OverdueSince *time.Time `gorm:"type:datetime" json:"overdue_since"` // set while a rent transaction is unpaid past its grace period
+	Version      uint       `gorm:"not null;default:1" json:"version"`  // incremented by every update, a stale version fails the update
+	IsActive     bool       `gorm:"not null;default:true" json:"is_active"`
+	Created      time.Time  `gorm:"type:datetime" json:"created"`
+	CreatedBy    string     `json:"created_by"`
+	Modified     time.Time  `gorm:"type:datetime" json:"modified"`
+	ModifiedBy   string     `json:"modified_by"`
 }
 
 // DBTransactionRoomBookMember is an entity that directly communicate with the TransactionRoomBookMember table in the database
@@ -43,3 +49,8 @@ func (dbTransactionRoomBook *DBTransactionRoomBook) DBTransactionRoomBookTable()
 func (dbTransactionRoomBookMember *DBTransactionRoomBookMember) DBTransactionRoomBookMemberTable() string {
 	return "dbTransactionRoomBookMember"
 }
+
+// ETag returns the strong entity tag of the room book version, the approvals expect it back in If-Match
+func (dbTransactionRoomBook *DBTransactionRoomBook) ETag() string {
+	return `"` + strconv.FormatUint(uint64(dbTransactionRoomBook.ID), 10) + "-" + strconv.FormatUint(uint64(dbTransactionRoomBook.Version), 10) + `"`
+}
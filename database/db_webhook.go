@@ -0,0 +1,69 @@
+package database
+
+import "time"
+
+// DBWebhookSubscription is an entity that directly communicate with the WebhookSubscription table in the database
+type DBWebhookSubscription struct {
+	ID         uint      `gorm:"primary_key;autoIncrement;not null" json:"id"`
+	OwnerID    uint      `gorm:"not null;index" json:"owner_id"`
+	URL        string    `gorm:"not null;size:500" json:"url"`
+	Secret     string    `gorm:"not null" json:"-"`                     // signs the delivered payloads
+	EventTypes string    `gorm:"type:text;not null" json:"event_types"` // comma separated booking event types
+	IsActive   bool      `gorm:"not null;default:true" json:"is_active"`
+	Created    time.Time `gorm:"type:datetime" json:"created"`
+	CreatedBy  string    `json:"created_by"`
+	Modified   time.Time `gorm:"type:datetime" json:"modified"`
+	ModifiedBy string    `json:"modified_by"`
+}
+
+// DBWebhookSubscriptionTable set the migrated struct table name
+func (dbWebhookSubscription *DBWebhookSubscription) DBWebhookSubscriptionTable() string {
+	return "dbWebhookSubscription"
+}
+
+// DBWebhookDelivery is an entity that directly communicate with the WebhookDelivery table in the database
+type DBWebhookDelivery struct {
+	ID             uint       `gorm:"primary_key;autoIncrement;not null" json:"id"`
+	SubscriptionID uint       `gorm:"not null;uniqueIndex:idx_webhook_delivery_dedupe" json:"subscription_id"`
+	OutboxEventID  uint       `gorm:"not null" json:"outbox_event_id"`
+	EventType      string     `gorm:"not null" json:"event_type"`
+	DedupeKey      string     `gorm:"not null;uniqueIndex:idx_webhook_delivery_dedupe;size:191" json:"dedupe_key"` // one delivery per subscription and event
+	Payload        string     `gorm:"type:text;not null" json:"payload"`
+	Status         uint       `gorm:"not null;default:0;index" json:"status"` // 0 = pending, 1 = delivered, 2 = failed
+	Attempts       uint       `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"type:datetime;index" json:"next_attempt_at"`
+	ResponseStatus int        `json:"response_status"`
+	LastError      string     `gorm:"type:text" json:"last_error"`
+	DeliveredAt    *time.Time `gorm:"type:datetime" json:"delivered_at"`
+	IsActive       bool       `gorm:"not null;default:true" json:"is_active"`
+	Created        time.Time  `gorm:"type:datetime" json:"created"`
+	CreatedBy      string     `json:"created_by"`
+	Modified       time.Time  `gorm:"type:datetime" json:"modified"`
+	ModifiedBy     string     `json:"modified_by"`
+}
+
+// DBWebhookDeliveryTable set the migrated struct table name
+func (dbWebhookDelivery *DBWebhookDelivery) DBWebhookDeliveryTable() string {
+	return "dbWebhookDelivery"
+}
+
+// DBWebhookAttempt is an entity that directly communicate with the WebhookAttempt table in the database,
+// every request made for a delivery is logged here
+type DBWebhookAttempt struct {
+	ID             uint      `gorm:"primary_key;autoIncrement;not null" json:"id"`
+	DeliveryID     uint      `gorm:"not null;index" json:"delivery_id"`
+	ResponseStatus int       `json:"response_status"`
+	ResponseBody   string    `gorm:"type:text" json:"response_body"` // truncated
+	Error          string    `gorm:"type:text" json:"error"`
+	Duration       int64     `json:"duration"` // in milliseconds
+	IsActive       bool      `gorm:"not null;default:true" json:"is_active"`
+	Created        time.Time `gorm:"type:datetime" json:"created"`
+	CreatedBy      string    `json:"created_by"`
+	Modified       time.Time `gorm:"type:datetime" json:"modified"`
+	ModifiedBy     string    `json:"modified_by"`
+}
+
+// DBWebhookAttemptTable set the migrated struct table name
+func (dbWebhookAttempt *DBWebhookAttempt) DBWebhookAttemptTable() string {
+	return "dbWebhookAttempt"
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a schema change of the tables and columns owned by the book service,
+// every migration checks the current schema first so it can be applied on every startup
+type Migration struct {
+	Name  string
+	Apply func(migrator gorm.Migrator) error
+}
+
+// migrations holds the schema changes of the book service in the order they were introduced
+var migrations = []Migration{
+	{Name: "create_kost_staff", Apply: createTables(&DBKostStaff{})},
+	{Name: "create_token_denylist", Apply: createTables(&DBTokenDenylist{})},
+	{Name: "create_notification", Apply: createTables(&DBNotification{})},
+	{Name: "create_outbox", Apply: createTables(&DBOutboxEvent{}, &DBOutboxDelivery{})},
+	{Name: "create_webhook", Apply: createTables(&DBWebhookSubscription{}, &DBWebhookDelivery{}, &DBWebhookAttempt{})},
+	{Name: "create_scheduler_lock", Apply: createTables(&DBSchedulerLock{})},
+	{Name: "add_rent_billing", Apply: steps(
+		addColumns(&DBTransaction{}, "PeriodStart", "DueDate", "LateFee", "RemindedAt", "OverdueAt"),
+		createIndexes(&DBTransaction{}, "idx_transaction_period", "DueDate"),
+		addColumns(&DBTransactionRoomBook{}, "NextDueDate", "OverdueSince"),
+	)},
+	{Name: "create_idempotency_key", Apply: createTables(&DBIdempotencyKey{})},
+	{Name: "add_version", Apply: steps(
+		addColumns(&DBTransaction{}, "Version"),
+		addColumns(&DBTransactionRoomBook{}, "Version"),
+	)},
+}
+
+// Migrate applies the schema changes missing from the given database
+func Migrate(db *gorm.DB) error {
+	migrator := db.Migrator()
+	for _, migration := range migrations {
+		if err := migration.Apply(migrator); err != nil {
+			return fmt.Errorf("migration %s failed: %w", migration.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// steps applies the given schema changes in order as a single migration
+func steps(changes ...func(migrator gorm.Migrator) error) func(migrator gorm.Migrator) error {
+	return func(migrator gorm.Migrator) error {
+		for _, change := range changes {
+			if err := change(migrator); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// createTables creates the tables of the given models that don't exist yet
+func createTables(models ...interface{}) func(migrator gorm.Migrator) error {
+	return func(migrator gorm.Migrator) error {
+		for _, model := range models {
+			if migrator.HasTable(model) {
+				continue
+			}
+
+			if err := migrator.CreateTable(model); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// addColumns adds the columns of the given model fields that don't exist yet, a missing table is created whole
+func addColumns(model interface{}, fields ...string) func(migrator gorm.Migrator) error {
+	return func(migrator gorm.Migrator) error {
+		if !migrator.HasTable(model) {
+			return migrator.CreateTable(model)
+		}
+
+		for _, field := range fields {
+			if migrator.HasColumn(model, field) {
+				continue
+			}
+
+			if err := migrator.AddColumn(model, field); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// createIndexes creates the given indexes of the given model that don't exist yet,
+// an index is named either by its name or by the field it is declared on
+func createIndexes(model interface{}, names ...string) func(migrator gorm.Migrator) error {
+	return func(migrator gorm.Migrator) error {
+		for _, name := range names {
+			if migrator.HasIndex(model, name) {
+				continue
+			}
+
+			if err := migrator.CreateIndex(model, name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns an empty in-memory database for the duration of the test
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.Replace(t.Name(), "/", "_", -1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open the test database: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get the test connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	return db
+}
+
+func TestMigrateIsRepeatable(t *testing.T) {
+	db := newTestDB(t)
+
+	// the second run finds every change already applied
+	for i := 0; i < 2; i++ {
+		if err := Migrate(db); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+
+	for _, model := range []interface{}{
+		&DBTransaction{},
+		&DBTransactionRoomBook{},
+		&DBKostStaff{},
+		&DBTokenDenylist{},
+		&DBNotification{},
+		&DBOutboxEvent{},
+		&DBOutboxDelivery{},
+		&DBWebhookSubscription{},
+		&DBWebhookDelivery{},
+		&DBWebhookAttempt{},
+		&DBSchedulerLock{},
+		&DBIdempotencyKey{},
+	} {
+		if !db.Migrator().HasTable(model) {
+			t.Fatalf("expected the table of %T", model)
+		}
+	}
+}
+
+// legacyTransaction is the transaction table as it was before the rent billing
+type legacyTransaction struct {
+	ID             uint `gorm:"primary_key;autoIncrement;not null"`
+	TrxReferenceID uint `gorm:"not null"`
+	TrxCategory    uint `gorm:"not null"`
+	PaidOff        float64
+	MustPay        float64
+	IsActive       bool
+	Created        time.Time
+	CreatedBy      string
+	Modified       time.Time
+	ModifiedBy     string
+}
+
+// TableName returns the table of the transactions
+func (legacyTransaction) TableName() string {
+	return "db_transactions"
+}
+
+// legacyTransactionRoomBook is the room book table as it was before the rent billing
+type legacyTransactionRoomBook struct {
+	ID           uint `gorm:"primary_key;autoIncrement;not null"`
+	BookerID     uint
+	KostID       uint
+	RoomID       uint
+	RoomDetailID uint
+	PeriodID     uint
+	Status       uint
+	BookCode     string
+	BookDate     time.Time
+	IsActive     bool
+	Created      time.Time
+	CreatedBy    string
+	Modified     time.Time
+	ModifiedBy   string
+}
+
+// TableName returns the table of the room books
+func (legacyTransactionRoomBook) TableName() string {
+	return "db_transaction_room_books"
+}
+
+func TestMigrateAddsColumnsToExistingTables(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&legacyTransaction{}, &legacyTransactionRoomBook{}); err != nil {
+		t.Fatalf("create the existing tables: %v", err)
+	}
+
+	// an existing row gets the defaults of the new columns
+	if err := db.Create(&legacyTransaction{TrxReferenceID: 1}).Error; err != nil {
+		t.Fatalf("seed the transaction: %v", err)
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	migrator := db.Migrator()
+	for _, field := range []string{"PeriodStart", "DueDate", "LateFee", "RemindedAt", "OverdueAt", "Version"} {
+		if !migrator.HasColumn(&DBTransaction{}, field) {
+			t.Fatalf("expected the transaction column of %s", field)
+		}
+	}
+
+	for _, index := range []string{"idx_transaction_period", "DueDate"} {
+		if !migrator.HasIndex(&DBTransaction{}, index) {
+			t.Fatalf("expected the transaction index %s", index)
+		}
+	}
+
+	for _, field := range []string{"NextDueDate", "OverdueSince", "Version"} {
+		if !migrator.HasColumn(&DBTransactionRoomBook{}, field) {
+			t.Fatalf("expected the room book column of %s", field)
+		}
+	}
+
+	var transaction DBTransaction
+	if err := db.First(&transaction).Error; err != nil {
+		t.Fatalf("read the migrated transaction: %v", err)
+	}
+
+	if transaction.LateFee != 0 || transaction.PeriodStart != nil || transaction.Version != 1 {
+		t.Fatalf("expected the defaults of the new columns, got %+v", transaction)
+	}
+}
@@ -1,7 +1,7 @@
 package entities
 
-// ApprovalRoomBook is an entity to communicate with the ApprovalRoomBook client side
-type ApprovalRoomBook struct {
-	BookID       uint `json:"book_id"`
+// ApprovalRoomBookRequest is an entity to receive a room book approval from the client side
+type ApprovalRoomBookRequest struct {
+	BookID       uint `json:"book_id" validate:"required"`
 	FlagApproval bool `json:"flag_approval"`
 }
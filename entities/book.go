@@ -6,51 +6,111 @@ import (
 	"github.com/fakhripraya/book-service/database"
 )
 
-// TransactionRoomBook is an entity to communicate with the TransactionRoomBook client side
-type TransactionRoomBook struct {
-	ID               uint                                   `json:"id"`
-	BookerID         uint                                   `json:"booker_id"`
-	KostID           uint                                   `json:"kost_id"`
-	RoomID           uint                                   `json:"room_id"`
-	RoomDetailID     uint                                   `json:"room_detail_id"`
-	PaymentMethodID  uint                                   `json:"payment_method_id"`
-	PeriodID         uint                                   `json:"period_id"`
-	Status           uint                                   `json:"status"`
-	BookDate         time.Time                              `json:"book_date"`
-	Payment          float64                                `json:"Payment"`
-	MustPay          float64                                `json:"must_pay"`
-	Members          []database.DBTransactionRoomBookMember `json:"members"`
-	VerificationData TransactionVerification                `json:"verification_data"`
-	IsActive         bool                                   `json:"is_active"`
-	Created          time.Time                              `json:"created"`
-	CreatedBy        string                                 `json:"created_by"`
-	Modified         time.Time                              `json:"modified"`
-	ModifiedBy       string                                 `json:"modified_by"`
-}
-
-// TransactionRoomBookMember is an entity to communicate with the TransactionRoomBookMember client side
-type TransactionRoomBookMember struct {
-	ID         uint      `json:"id"`
-	RoomBookID uint      `json:"room_book_id"`
-	MemberName string    `json:"member_name"`
-	Phone      string    `json:"phone"`
-	Gender     bool      `json:"gender"`
-	IsActive   bool      `json:"is_active"`
-	Created    time.Time `json:"created"`
-	CreatedBy  string    `json:"created_by"`
-	Modified   time.Time `json:"modified"`
-	ModifiedBy string    `json:"modified_by"`
-}
-
-// TransactionVerification is an entity to communicate with the TransactionVerification client side
-type TransactionVerification struct {
-	ID          uint      `json:"id"`
-	ReferenceID uint      `json:"reference_id"`
-	PictDesc    string    `json:"pict_desc"`
-	URL         string    `json:"url"`
-	IsActive    bool      `json:"is_active"`
-	Created     time.Time `json:"created"`
-	CreatedBy   string    `json:"created_by"`
-	Modified    time.Time `json:"modified"`
-	ModifiedBy  string    `json:"modified_by"`
+// TransactionRoomBookRequest is an entity to receive a new room book from the client side
+type TransactionRoomBookRequest struct {
+	KostID           uint                               `json:"kost_id" validate:"required"`
+	RoomID           uint                               `json:"room_id" validate:"required"`
+	RoomDetailID     uint                               `json:"room_detail_id" validate:"required"`
+	PaymentMethodID  uint                               `json:"payment_method_id" validate:"required"`
+	PeriodID         uint                               `json:"period_id" validate:"required"`
+	BookDate         time.Time                          `json:"book_date" validate:"required,notpast"`
+	Payment          float64                            `json:"Payment" validate:"gte=0"` // the amount due is derived from the room price
+	Members          []TransactionRoomBookMemberRequest `json:"members" validate:"required,min=1,dive"`
+	VerificationData TransactionVerificationRequest     `json:"verification_data"`
+}
+
+// TransactionRoomBookMemberRequest is an entity to receive a room book member from the client side
+type TransactionRoomBookMemberRequest struct {
+	MemberName string `json:"member_name" validate:"required,max=100"`
+	Phone      string `json:"phone" validate:"max=20"`
+	Gender     bool   `json:"gender"`
+}
+
+// TransactionVerificationRequest is an entity to receive a transaction verification photo from the client side
+type TransactionVerificationRequest struct {
+	PictDesc string `json:"pict_desc" validate:"required"`
+	URL      string `json:"url" validate:"required,url"`
+}
+
+// TransactionRoomBookResponse is an entity to send a room book to the client side
+type TransactionRoomBookResponse struct {
+	ID           uint       `json:"id"`
+	BookerID     uint       `json:"booker_id"`
+	KostID       uint       `json:"kost_id"`
+	RoomID       uint       `json:"room_id"`
+	RoomDetailID uint       `json:"room_detail_id"`
+	PeriodID     uint       `json:"period_id"`
+	Status       uint       `json:"status"`
+	BookCode     string     `json:"book_code"`
+	BookDate     time.Time  `json:"book_date"`
+	NextDueDate  *time.Time `json:"next_due_date"`
+	OverdueSince *time.Time `json:"overdue_since"`
+	Version      uint       `json:"version"`
+	ETag         string     `json:"etag"` // the approvals of a listed booking send it back in If-Match
+	Created      time.Time  `json:"created"`
+	Modified     time.Time  `json:"modified"`
+}
+
+// ToDBTransactionRoomBook maps the request to a new room book model, server owned fields are left to the caller
+func (request *TransactionRoomBookRequest) ToDBTransactionRoomBook() database.DBTransactionRoomBook {
+	return database.DBTransactionRoomBook{
+		KostID:       request.KostID,
+		RoomID:       request.RoomID,
+		RoomDetailID: request.RoomDetailID,
+		PeriodID:     request.PeriodID,
+		BookDate:     request.BookDate,
+	}
+}
+
+// ToDBTransactionRoomBookMembers maps the requested members to new room book member models
+func (request *TransactionRoomBookRequest) ToDBTransactionRoomBookMembers() []database.DBTransactionRoomBookMember {
+	members := make([]database.DBTransactionRoomBookMember, 0, len(request.Members))
+	for _, member := range request.Members {
+		members = append(members, database.DBTransactionRoomBookMember{
+			MemberName: member.MemberName,
+			Phone:      member.Phone,
+			Gender:     member.Gender,
+		})
+	}
+
+	return members
+}
+
+// ToDBTransactionVerification maps the request to a new transaction verification model
+func (request *TransactionVerificationRequest) ToDBTransactionVerification() database.DBTransactionVerification {
+	return database.DBTransactionVerification{
+		PictDesc: request.PictDesc,
+		URL:      request.URL,
+	}
+}
+
+// NewTransactionRoomBookResponse maps the given room book model to its client side response
+func NewTransactionRoomBookResponse(book *database.DBTransactionRoomBook) TransactionRoomBookResponse {
+	return TransactionRoomBookResponse{
+		ID:           book.ID,
+		BookerID:     book.BookerID,
+		KostID:       book.KostID,
+		RoomID:       book.RoomID,
+		RoomDetailID: book.RoomDetailID,
+		PeriodID:     book.PeriodID,
+		Status:       book.Status,
+		BookCode:     book.BookCode,
+		BookDate:     book.BookDate,
+		NextDueDate:  book.NextDueDate,
+		OverdueSince: book.OverdueSince,
+		Version:      book.Version,
+		ETag:         book.ETag(),
+		Created:      book.Created,
+		Modified:     book.Modified,
+	}
+}
+
+// NewTransactionRoomBookResponses maps the given room book models to their client side responses
+func NewTransactionRoomBookResponses(books []database.DBTransactionRoomBook) []TransactionRoomBookResponse {
+	responses := make([]TransactionRoomBookResponse, 0, len(books))
+	for i := range books {
+		responses = append(responses, NewTransactionRoomBookResponse(&books[i]))
+	}
+
+	return responses
 }
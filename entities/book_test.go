@@ -0,0 +1,64 @@
+package entities
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fakhripraya/book-service/database"
+)
+
+func TestTransactionRoomBookRequestLeavesTheServerOwnedFields(t *testing.T) {
+	request := &TransactionRoomBookRequest{
+		KostID:       1,
+		RoomID:       2,
+		RoomDetailID: 3,
+		PeriodID:     4,
+		BookDate:     time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		Members:      []TransactionRoomBookMemberRequest{{MemberName: "Tenant", Phone: "0812", Gender: true}},
+	}
+
+	book := request.ToDBTransactionRoomBook()
+	if book.KostID != 1 || book.RoomID != 2 || book.RoomDetailID != 3 || book.PeriodID != 4 || !book.BookDate.Equal(request.BookDate) {
+		t.Fatalf("expected the requested fields mapped, got %+v", book)
+	}
+
+	if book.ID != 0 || book.BookerID != 0 || book.Status != 0 || book.IsActive || book.Version != 0 || book.CreatedBy != "" {
+		t.Fatalf("expected the server owned fields left to the caller, got %+v", book)
+	}
+
+	members := request.ToDBTransactionRoomBookMembers()
+	if len(members) != 1 || members[0].MemberName != "Tenant" || members[0].Phone != "0812" || !members[0].Gender {
+		t.Fatalf("expected the requested members mapped, got %+v", members)
+	}
+
+	if members[0].RoomBookID != 0 || members[0].IsActive || members[0].CreatedBy != "" {
+		t.Fatalf("expected the server owned member fields left to the caller, got %+v", members[0])
+	}
+}
+
+func TestTransactionRoomBookResponse(t *testing.T) {
+	book := &database.DBTransactionRoomBook{ID: 7, BookerID: 20, KostID: 1, Status: 1, BookCode: "BOOK-TEST", Version: 3, IsActive: true, CreatedBy: "tenant"}
+
+	response := NewTransactionRoomBookResponse(book)
+	if response.ID != 7 || response.BookerID != 20 || response.Version != 3 || response.ETag != book.ETag() {
+		t.Fatalf("expected the booking mapped, got %+v", response)
+	}
+
+	// the audit fields of the storage don't reach the client
+	body, err := json.Marshal(response)
+	if err != nil {
+		t.Fatalf("marshal the response: %v", err)
+	}
+
+	for _, field := range []string{"is_active", "IsActive", "created_by", "CreatedBy"} {
+		if strings.Contains(string(body), `"`+field+`"`) {
+			t.Fatalf("expected %s left out of the response, got %s", field, body)
+		}
+	}
+
+	if responses := NewTransactionRoomBookResponses(nil); responses == nil || len(responses) != 0 {
+		t.Fatalf("expected an empty list rather than null, got %v", responses)
+	}
+}
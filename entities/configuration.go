@@ -2,35 +2,216 @@ package entities
 
 // Configuration Entity
 type Configuration struct {
-	API        APIConfiguration
-	Database   DatabaseConfiguration
-	Jwt        JwtConfiguration
-	MySQLStore MySQLStoreConfiguration
+	API          APIConfiguration
+	Database     DatabaseConfiguration
+	Jwt          JwtConfiguration
+	MySQLStore   MySQLStoreConfiguration
+	Cache        CacheConfiguration
+	Notification NotificationConfiguration
+	Outbox       OutboxConfiguration
+	Webhook      WebhookConfiguration
+	Scheduler    SchedulerConfiguration
+	Tracing      TracingConfiguration
+	Health       HealthConfiguration
+	Lifecycle    LifecycleConfiguration
+	CORS         CORSConfiguration
+	TLS          TLSConfiguration
+	Admin        AdminConfiguration
+	RateLimit    RateLimitConfiguration
+	Idempotency  IdempotencyConfiguration
+	Roles        RoleConfiguration
 }
 
 // APIConfiguration is an entity that stores the app configuration
 type APIConfiguration struct {
-	Environment string
-	Host        string
-	Port        int
-	Debug       bool
+	Environment       string
+	Host              string
+	Port              int
+	Debug             bool
+	ReadTimeout       int // in seconds, to read the whole request from the client
+	ReadHeaderTimeout int // in seconds, to read the request headers from the client
+	WriteTimeout      int // in seconds, to write the response to the client, counted from the end of the request headers
+	IdleTimeout       int // in seconds, for connections using TCP Keep-Alive
+	HTTP2             bool
 }
 
 // DatabaseConfiguration is an entity that stores the database configuration
 type DatabaseConfiguration struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	Dbname   string
+	Host            string
+	Port            int
+	User            string
+	Password        string
+	Dbname          string
+	MaxOpenConns    int // 0 is unlimited
+	MaxIdleConns    int
+	ConnMaxLifetime int  // in seconds, 0 is unlimited
+	ConnMaxIdleTime int  // in seconds, 0 is unlimited
+	AutoMigrate     bool // applies the schema changes of the book service on startup
 }
 
-// JwtConfiguration is an entity that stores the JWT secret
+// JwtConfiguration is an entity that stores the JWT secret, the JWKS of asymmetric keys and the expected token claims
 type JwtConfiguration struct {
-	Secret string
+	Secret                 string
+	Issuer                 string
+	Audience               string
+	JWKSURL                string
+	JWKSFile               string
+	JWKSRefreshInterval    int // in seconds
+	KeyRotationGracePeriod int // in seconds
+	TokenLifetime          int // in seconds
+	RenewalWindow          int // in seconds
+	MaxSessionLifetime     int // in seconds
 }
 
 // MySQLStoreConfiguration is an entity that stores the MySqlStore secret
 type MySQLStoreConfiguration struct {
-	Secret string
+	Secret      string
+	TableName   string
+	SessionName string // name of the session cookie
+	MaxAge      int    // in seconds
+}
+
+// CacheConfiguration is an entity that stores the in-process cache configuration
+type CacheConfiguration struct {
+	PrincipalTTL  int // in seconds, 0 disables the principal cache, role and status changes of the users apply after at most this delay
+	RevocationTTL int // in seconds, 0 disables the revocation cache, revocations of other replicas apply after at most this delay
+	MaxEntries    int
+}
+
+// NotificationConfiguration is an entity that stores the enabled notification channels
+type NotificationConfiguration struct {
+	Language string
+	SMTP     SMTPConfiguration
+	SMS      GatewayConfiguration
+	Push     GatewayConfiguration
+	InApp    bool
+	Fake     bool // log the notifications instead of delivering them, for local development
+}
+
+// SMTPConfiguration is an entity that stores the SMTP server of the email notifications
+type SMTPConfiguration struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// GatewayConfiguration is an entity that stores an HTTP notification gateway
+type GatewayConfiguration struct {
+	URL    string
+	APIKey string
+}
+
+// OutboxConfiguration is an entity that stores the outbox dispatcher configuration
+type OutboxConfiguration struct {
+	PollInterval int // in seconds
+	Lease        int // in seconds, how long a claimed event is hidden from the other replicas
+	BaseBackoff  int // in seconds
+	MaxBackoff   int // in seconds
+	BatchSize    int
+	MaxAttempts  int
+}
+
+// WebhookConfiguration is an entity that stores the owner webhook deliverer configuration
+type WebhookConfiguration struct {
+	PollInterval int // in seconds
+	Lease        int // in seconds, how long a claimed delivery is hidden from the other replicas
+	BaseBackoff  int // in seconds
+	MaxBackoff   int // in seconds
+	BatchSize    int
+	MaxAttempts  int
+	Timeout      int // in seconds, for every request made to a subscriber
+
+	// deliver to the private, loopback and link-local addresses, for local development only
+	AllowPrivateNetworks bool
+}
+
+// SchedulerConfiguration is an entity that stores the background jobs configuration
+type SchedulerConfiguration struct {
+	Lease                  int // in seconds, how long a replica holds a job lock
+	ExpiryInterval         int // in seconds, 0 disables the booking expiry
+	OwnerApprovalDeadline  int // in seconds, a booking is expired when the owner doesn't answer in time
+	TenantApprovalDeadline int // in seconds, an owner approved booking is expired when the tenant doesn't confirm in time
+	BatchSize              int
+	RentInterval           int     // in seconds, 0 disables the rent billing
+	BillingLeadDays        int     // the rent transaction of a period is generated this many days before it is due
+	ReminderDays           int     // the tenant is reminded this many days before the rent is due
+	OverdueGraceDays       int     // an unpaid rent is overdue this many days after it is due
+	LateFeePercent         float64 // of the rent, added once the rent is overdue
+}
+
+// TracingConfiguration is an entity that stores the OpenTelemetry span exporter
+type TracingConfiguration struct {
+	Exporter    string // none, stdout or otlp
+	Endpoint    string // host:port of the OTLP HTTP collector
+	Insecure    bool
+	SampleRatio float64 // of the traces started by this service, the callers decide for theirs
+	ServiceName string
+}
+
+// HealthConfiguration is an entity that stores the readiness probe configuration
+type HealthConfiguration struct {
+	CheckTimeout int // in seconds, for every readiness check
+}
+
+// LifecycleConfiguration is an entity that stores the graceful shutdown configuration
+type LifecycleConfiguration struct {
+	DrainDelay    int // in seconds, the replica keeps serving while the load balancer notices it is no longer ready
+	DrainTimeout  int // in seconds, for the in-flight requests and then for closing the resources
+	WorkerTimeout int // in seconds, for the background workers to end their current run once the requests are drained
+}
+
+// CORSConfiguration is an entity that stores the cross-origin requests allowed by the browsers
+type CORSConfiguration struct {
+	AllowedOrigins   []string // * allows every origin, it can't be used along with the credentials
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int // in seconds, of the cached preflight responses
+}
+
+// TLSConfiguration is an entity that stores the TLS certificate of the API listener, no certificate serves plain HTTP
+type TLSConfiguration struct {
+	CertFile       string
+	KeyFile        string
+	MinVersion     string // 1.2 or 1.3
+	ReloadInterval int    // in seconds, how often the certificate files are checked for changes
+}
+
+// AdminConfiguration is an entity that stores the admin listener serving the probes and the metrics
+type AdminConfiguration struct {
+	Host string
+	Port int // 0 serves the admin endpoints on the API listener
+}
+
+// RateLimitConfiguration is an entity that stores the rate limit rules of the routes, keyed by the rule name of the route
+type RateLimitConfiguration struct {
+	SweepInterval     int  // in seconds, idle buckets are dropped after this delay
+	TrustForwardedFor bool // the client ip is taken from X-Forwarded-For, only safe behind a load balancer setting it
+	Rules             map[string]RateLimitRule
+}
+
+// RateLimitRule is an entity that stores the token buckets of a rate limited route, a zero rate disables its bucket
+type RateLimitRule struct {
+	UserRate  float64 // requests per minute per user
+	UserBurst int
+	IPRate    float64 // requests per minute per client ip
+	IPBurst   int
+}
+
+// IdempotencyConfiguration is an entity that stores the idempotency keys configuration
+type IdempotencyConfiguration struct {
+	Window        int // in seconds, how long a response is replayed to the retries
+	LockTimeout   int // in seconds, a key left in progress this long is taken over by the next retry, must be longer than api.writetimeout
+	PurgeInterval int // in seconds, 0 disables the purge of the expired keys
+}
+
+// RoleConfiguration is an entity that stores the role ids of the user service role table, matched against MasterUser.RoleID
+type RoleConfiguration struct {
+	Tenant uint
+	Owner  uint
+	Admin  uint
+	Staff  uint
 }
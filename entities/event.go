@@ -0,0 +1,33 @@
+package entities
+
+import "time"
+
+// Booking event types published to the notification channels and the downstream integrations
+const (
+	EventBookCreated        = "book.created"
+	EventBookOwnerApproved  = "book.owner_approved"
+	EventBookOwnerRejected  = "book.owner_rejected"
+	EventBookTenantApproved = "book.tenant_approved"
+	EventBookTenantRejected = "book.tenant_rejected"
+	EventPaymentReceived    = "payment.received"
+	EventBookExpired        = "book.expired"
+	EventRentDue            = "rent.due"
+	EventRentOverdue        = "rent.overdue"
+)
+
+// BookEvent is an entity to communicate a booking state change to the event subscribers
+type BookEvent struct {
+	Type       string     `json:"type"`
+	BookID     uint       `json:"book_id"`
+	BookCode   string     `json:"book_code"`
+	KostID     uint       `json:"kost_id"`
+	KostName   string     `json:"kost_name"`
+	OwnerID    uint       `json:"owner_id"`
+	BookerID   uint       `json:"booker_id"`
+	Status     uint       `json:"status"`
+	ActorID    uint       `json:"actor_id"`
+	ActorName  string     `json:"actor_name"`
+	Amount     float64    `json:"amount"`
+	DueDate    *time.Time `json:"due_date,omitempty"`
+	OccurredAt time.Time  `json:"occurred_at"`
+}
@@ -0,0 +1,112 @@
+package entities
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fakhripraya/book-service/database"
+)
+
+// WebhookSubscriptionRequest is an entity to receive a new webhook subscription from the client side
+type WebhookSubscriptionRequest struct {
+	URL        string   `json:"url" validate:"required,url,max=500"`
+	EventTypes []string `json:"event_types" validate:"required,min=1,dive,oneof=book.created book.owner_approved book.owner_rejected book.tenant_approved book.tenant_rejected payment.received book.expired rent.due rent.overdue"`
+}
+
+// WebhookSubscriptionResponse is an entity to send a webhook subscription to the client side,
+// the secret is only sent once when the subscription is created
+type WebhookSubscriptionResponse struct {
+	ID         uint      `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Secret     string    `json:"secret,omitempty"`
+	Created    time.Time `json:"created"`
+}
+
+// WebhookDeliveryResponse is an entity to send a webhook delivery log to the client side
+type WebhookDeliveryResponse struct {
+	ID             uint                     `json:"id"`
+	EventType      string                   `json:"event_type"`
+	EventID        string                   `json:"event_id"`
+	Status         uint                     `json:"status"`
+	Attempts       uint                     `json:"attempts"`
+	NextAttemptAt  time.Time                `json:"next_attempt_at"`
+	ResponseStatus int                      `json:"response_status"`
+	LastError      string                   `json:"last_error"`
+	DeliveredAt    *time.Time               `json:"delivered_at"`
+	Created        time.Time                `json:"created"`
+	Log            []WebhookAttemptResponse `json:"log"`
+}
+
+// WebhookAttemptResponse is an entity to send a single request made for a webhook delivery to the client side
+type WebhookAttemptResponse struct {
+	ResponseStatus int       `json:"response_status"`
+	ResponseBody   string    `json:"response_body"`
+	Error          string    `json:"error"`
+	Duration       int64     `json:"duration"`
+	Created        time.Time `json:"created"`
+}
+
+// NewWebhookSubscriptionResponse maps the given subscription model to its client side response
+func NewWebhookSubscriptionResponse(subscription *database.DBWebhookSubscription, withSecret bool) WebhookSubscriptionResponse {
+	response := WebhookSubscriptionResponse{
+		ID:         subscription.ID,
+		URL:        subscription.URL,
+		EventTypes: []string{},
+		Created:    subscription.Created,
+	}
+
+	if subscription.EventTypes != "" {
+		response.EventTypes = strings.Split(subscription.EventTypes, ",")
+	}
+
+	if withSecret {
+		response.Secret = subscription.Secret
+	}
+
+	return response
+}
+
+// NewWebhookSubscriptionResponses maps the given subscription models to their client side responses
+func NewWebhookSubscriptionResponses(subscriptions []database.DBWebhookSubscription) []WebhookSubscriptionResponse {
+	responses := make([]WebhookSubscriptionResponse, 0, len(subscriptions))
+	for i := range subscriptions {
+		responses = append(responses, NewWebhookSubscriptionResponse(&subscriptions[i], false))
+	}
+
+	return responses
+}
+
+// NewWebhookDeliveryResponses maps the given delivery models and their attempt logs to their client side responses
+func NewWebhookDeliveryResponses(deliveries []database.DBWebhookDelivery, attempts map[uint][]database.DBWebhookAttempt) []WebhookDeliveryResponse {
+	responses := make([]WebhookDeliveryResponse, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		response := WebhookDeliveryResponse{
+			ID:             delivery.ID,
+			EventType:      delivery.EventType,
+			EventID:        delivery.DedupeKey,
+			Status:         delivery.Status,
+			Attempts:       delivery.Attempts,
+			NextAttemptAt:  delivery.NextAttemptAt,
+			ResponseStatus: delivery.ResponseStatus,
+			LastError:      delivery.LastError,
+			DeliveredAt:    delivery.DeliveredAt,
+			Created:        delivery.Created,
+			Log:            []WebhookAttemptResponse{},
+		}
+
+		for _, attempt := range attempts[delivery.ID] {
+			response.Log = append(response.Log, WebhookAttemptResponse{
+				ResponseStatus: attempt.ResponseStatus,
+				ResponseBody:   attempt.ResponseBody,
+				Error:          attempt.Error,
+				Duration:       attempt.Duration,
+				Created:        attempt.Created,
+			})
+		}
+
+		responses = append(responses, response)
+	}
+
+	return responses
+}
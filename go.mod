@@ -4,13 +4,22 @@ go 1.15
 
 require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/go-playground/validator/v10 v10.4.1
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
-	github.com/gorilla/sessions v1.2.1 // indirect
+	github.com/gorilla/sessions v1.2.1
 	github.com/hashicorp/go-hclog v0.15.0
 	github.com/joho/godotenv v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.5
+	github.com/prometheus/client_golang v1.11.1
 	github.com/spf13/viper v1.7.1
 	github.com/srinathgs/mysqlstore v0.0.0-20200417050510-9cbb9420fc4c
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	gorm.io/driver/mysql v1.0.3
+	gorm.io/driver/sqlite v1.1.4
 	gorm.io/gorm v1.20.11
 )
@@ -1,7 +1,15 @@
 package handlers
 
 import (
+	"errors"
+	"net/http"
+
 	"github.com/fakhripraya/book-service/data"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/idempotency"
+	"github.com/fakhripraya/book-service/ratelimit"
+	"github.com/fakhripraya/book-service/webhook"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/srinathgs/mysqlstore"
@@ -13,19 +21,166 @@ type KeyBook struct{}
 // KeyApproval is a key used for the Approval object in the context
 type KeyApproval struct{}
 
+// KeyWebhook is a key used for the WebhookSubscription object in the context
+type KeyWebhook struct{}
+
+// KeyPrincipal is a key used for the authenticated Principal object in the context
+type KeyPrincipal struct{}
+
+// KeyLanguage is a key used for the negotiated response language in the context
+type KeyLanguage struct{}
+
+// KeyRequestID is a key used for the correlation id of the request in the context
+type KeyRequestID struct{}
+
+// KeyRequestLog is a key used for the request-scoped logger in the context
+type KeyRequestLog struct{}
+
+// requestLog holds the logger of a single request, the logger gains fields as the request goes through the middlewares
+// so the access log written once the request is done carries them all
+type requestLog struct {
+	logger hclog.Logger
+}
+
 // BookHandler is a handler struct for book changes
 type BookHandler struct {
-	logger hclog.Logger
-	book   *data.Book
-	store  *mysqlstore.MySQLStore
+	logger      hclog.Logger
+	book        *data.Book
+	store       *mysqlstore.MySQLStore
+	validation  *data.Validation
+	policy      *data.Policy
+	webhooks    *webhook.Service
+	limiter     *ratelimit.Limiter
+	idempotency *idempotency.Service
 }
 
 // NewBookHandler returns a new book handler with the given logger
-func NewBookHandler(newLogger hclog.Logger, newBook *data.Book, newStore *mysqlstore.MySQLStore) *BookHandler {
-	return &BookHandler{newLogger, newBook, newStore}
+func NewBookHandler(newLogger hclog.Logger, newBook *data.Book, newStore *mysqlstore.MySQLStore, newValidation *data.Validation, newPolicy *data.Policy, newWebhooks *webhook.Service, newLimiter *ratelimit.Limiter, newIdempotency *idempotency.Service) *BookHandler {
+	return &BookHandler{newLogger, newBook, newStore, newValidation, newPolicy, newWebhooks, newLimiter, newIdempotency}
 }
 
 // GenericError is a generic error message returned by a server
 type GenericError struct {
+	Code    string `json:"code,omitempty"`
 	Message string `json:"message"`
 }
+
+// ValidationError is a collection of validation error messages returned by a server
+type ValidationError struct {
+	Code    string                `json:"code"`
+	Message string                `json:"message"`
+	Fields  data.ValidationErrors `json:"fields"`
+}
+
+// getPrincipal returns the authenticated principal of the given request,
+// it must only be called behind MiddlewareValidateAuth
+func getPrincipal(r *http.Request) *data.Principal {
+	return r.Context().Value(KeyPrincipal{}).(*data.Principal)
+}
+
+// displayName returns the name of the given user shown to other users
+func displayName(user *database.MasterUser) string {
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+
+	return user.Username
+}
+
+// newBookEvent builds the outbox event of the given booking state change made by the given actor
+func newBookEvent(eventType string, book *database.DBTransactionRoomBook, kost *database.DBKost, actor *database.MasterUser) entities.BookEvent {
+	return entities.BookEvent{
+		Type:      eventType,
+		BookID:    book.ID,
+		BookCode:  book.BookCode,
+		KostID:    kost.ID,
+		KostName:  kost.KostName,
+		OwnerID:   kost.OwnerID,
+		BookerID:  book.BookerID,
+		Status:    book.Status,
+		ActorID:   actor.ID,
+		ActorName: displayName(actor),
+	}
+}
+
+// requestLogger returns the logger of the given request tagged with its request id,
+// or else the handler logger when the request went around MiddlewareLogRequest
+func (bookHandler *BookHandler) requestLogger(r *http.Request) hclog.Logger {
+	if log, ok := r.Context().Value(KeyRequestLog{}).(*requestLog); ok {
+		return log.logger
+	}
+
+	return bookHandler.logger
+}
+
+// addLogFields adds the given key value pairs to every following log line of the given request
+func addLogFields(r *http.Request, args ...interface{}) {
+	if log, ok := r.Context().Value(KeyRequestLog{}).(*requestLog); ok {
+		log.logger = log.logger.With(args...)
+	}
+}
+
+// logTransactionError logs the given error of a rolled back db transaction along with the request id
+func (bookHandler *BookHandler) logTransactionError(r *http.Request, err error) {
+	var messageError *data.MessageError
+	if errors.As(err, &messageError) {
+		bookHandler.requestLogger(r).Warn("Transaction rolled back", "code", messageError.Code, "error", err)
+
+		return
+	}
+
+	bookHandler.requestLogger(r).Error("Transaction rolled back", "error", err)
+}
+
+// getLanguage returns the negotiated response language of the given request
+func getLanguage(r *http.Request) string {
+	if language, ok := r.Context().Value(KeyLanguage{}).(string); ok {
+		return language
+	}
+
+	return data.NegotiateLanguage(r.Header.Get("Accept-Language"))
+}
+
+// writeMessage writes the localized message of the given code to the response writer
+func (bookHandler *BookHandler) writeMessage(rw http.ResponseWriter, r *http.Request, code data.MessageCode) {
+	data.ToJSON(&GenericError{Code: string(code), Message: data.Translate(getLanguage(r), code)}, rw)
+}
+
+// writeError writes the localized message of the given error to the response writer,
+// errors without a message code are logged and reported as the given fallback code
+func (bookHandler *BookHandler) writeError(rw http.ResponseWriter, r *http.Request, err error, fallback data.MessageCode) {
+	var messageError *data.MessageError
+	if errors.As(err, &messageError) {
+		bookHandler.writeMessage(rw, r, messageError.Code)
+
+		return
+	}
+
+	bookHandler.requestLogger(r).Error("Unhandled request error", "code", fallback, "error", err)
+	bookHandler.writeMessage(rw, r, fallback)
+}
+
+// transactionErrorStatus returns the status code of a failed transaction, a stale version is a conflict
+// and the other errors carrying a message are the client's fault, any other error such as a lost database
+// connection is a server error so the idempotency key of the request is released for a retry
+func transactionErrorStatus(err error) int {
+	if errors.Is(err, data.ErrStaleVersion) {
+		return http.StatusConflict
+	}
+
+	var messageError *data.MessageError
+	if errors.As(err, &messageError) {
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+// writeValidationError writes every invalid field of the request to the response writer
+func (bookHandler *BookHandler) writeValidationError(rw http.ResponseWriter, r *http.Request, fields data.ValidationErrors) {
+	data.ToJSON(&ValidationError{
+		Code:    string(data.MsgValidationFailed),
+		Message: data.Translate(getLanguage(r), data.MsgValidationFailed),
+		Fields:  fields,
+	}, rw)
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/data"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// the role ids of the test users
+const (
+	testRoleTenant uint = 1
+	testRoleOwner  uint = 2
+	testRoleAdmin  uint = 3
+	testRoleStaff  uint = 4
+)
+
+// the test users
+var (
+	testOwner    = &database.MasterUser{ID: 10, RoleID: testRoleOwner, Username: "owner"}
+	testTenant   = &database.MasterUser{ID: 20, RoleID: testRoleTenant, Username: "tenant"}
+	testStranger = &database.MasterUser{ID: 30, RoleID: testRoleOwner, Username: "stranger"}
+	testStaff    = &database.MasterUser{ID: 40, RoleID: testRoleStaff, Username: "staff"}
+)
+
+// raceDriverName is the sqlite driver of the race tests
+const raceDriverName = "sqlite3_read_uncommitted"
+
+func init() {
+	// the connections of the race tests read the rows of the shared cache without waiting on its table locks,
+	// so an update sees the row committed by a concurrent transaction like the current read of a MySQL update does
+	sql.Register(raceDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			_, err := conn.Exec("PRAGMA read_uncommitted = 1", nil)
+
+			return err
+		},
+	})
+}
+
+// newTestDB replaces config.DB with an in-memory database holding the booking tables for the duration of the test,
+// a single connection serializes the transactions of the concurrent requests
+func newTestDB(t *testing.T) *gorm.DB {
+	return openTestDB(t, sqlite.Open(testDSN(t)), 1)
+}
+
+// newRaceTestDB is like newTestDB but lets two transactions run at once, the test orders their statements
+func newRaceTestDB(t *testing.T) *gorm.DB {
+	return openTestDB(t, &sqlite.Dialector{DriverName: raceDriverName, DSN: testDSN(t)}, 2)
+}
+
+// testDSN returns the name of the in-memory database of the given test
+func testDSN(t *testing.T) string {
+	return fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.Replace(t.Name(), "/", "_", -1))
+}
+
+// openTestDB replaces config.DB with the given database holding the booking tables for the duration of the test
+func openTestDB(t *testing.T, dialector gorm.Dialector, connections int) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open the test database: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get the test connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(connections)
+
+	// the in-memory database lives as long as one of its connections
+	sqlDB.SetMaxIdleConns(connections)
+
+	if err := db.AutoMigrate(
+		&database.DBKost{},
+		&database.DBKostStaff{},
+		&database.DBKostRoom{},
+		&database.DBKostRoomDetail{},
+		&database.DBKostPeriod{},
+		&database.MasterPeriod{},
+		&database.DBTransactionRoomBook{},
+		&database.DBTransactionRoomBookMember{},
+		&database.DBTransaction{},
+		&database.DBTransactionDetail{},
+		&database.DBTransactionVerification{},
+		&database.DBOutboxEvent{},
+		&database.DBIdempotencyKey{},
+	); err != nil {
+		t.Fatalf("migrate the test database: %v", err)
+	}
+
+	previous := config.DB
+	config.DB = db
+	t.Cleanup(func() {
+		config.DB = previous
+		sqlDB.Close()
+	})
+
+	return db
+}
+
+// newTestBookHandler returns a book handler with the dependencies the booking handlers use
+func newTestBookHandler() *BookHandler {
+	logger := hclog.NewNullLogger()
+	policy := data.NewPolicy(logger, &entities.RoleConfiguration{
+		Tenant: testRoleTenant,
+		Owner:  testRoleOwner,
+		Admin:  testRoleAdmin,
+		Staff:  testRoleStaff,
+	})
+
+	return NewBookHandler(logger, data.NewBook(logger, nil, nil), nil, nil, policy, nil, nil, nil)
+}
+
+// seedBook inserts a kost of testOwner along with a room book of testTenant in the given status
+func seedBook(t *testing.T, db *gorm.DB, status uint) *database.DBTransactionRoomBook {
+	t.Helper()
+
+	kost := &database.DBKost{OwnerID: testOwner.ID, KostName: "Kost Test", Country: "Indonesia", City: "Jakarta", IsActive: true, Created: time.Now(), Modified: time.Now()}
+	if err := db.Create(kost).Error; err != nil {
+		t.Fatalf("seed the kost: %v", err)
+	}
+
+	book := &database.DBTransactionRoomBook{
+		BookerID: testTenant.ID,
+		KostID:   kost.ID,
+		Status:   status,
+		BookCode: "BOOK-TEST",
+		BookDate: time.Now(),
+		Version:  1,
+		IsActive: true,
+		Created:  time.Now(),
+		Modified: time.Now(),
+	}
+	if err := db.Create(book).Error; err != nil {
+		t.Fatalf("seed the room book: %v", err)
+	}
+
+	return book
+}
+
+// seedStaff assigns testStaff to the given kost
+func seedStaff(t *testing.T, db *gorm.DB, kostID uint) {
+	t.Helper()
+
+	if err := db.Create(&database.DBKostStaff{KostID: kostID, UserID: testStaff.ID, IsActive: true, Created: time.Now(), Modified: time.Now()}).Error; err != nil {
+		t.Fatalf("seed the kost staff: %v", err)
+	}
+}
+
+// newPrincipalRequest returns a request made by the given user
+func newPrincipalRequest(method, target string, user *database.MasterUser) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	ctx := context.WithValue(r.Context(), KeyPrincipal{}, &data.Principal{User: user})
+
+	return r.WithContext(ctx)
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ifMatch reports whether the If-Match header of the request matches the given entity tag, a missing header matches any,
+// the weak tags never match since If-Match uses the strong comparison
+func ifMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -6,34 +6,32 @@ import (
 	"github.com/fakhripraya/book-service/config"
 	"github.com/fakhripraya/book-service/data"
 	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
 )
 
 // GetMyBook is a method to fetch the given room info
 func (bookHandler *BookHandler) GetMyBook(rw http.ResponseWriter, r *http.Request) {
 
 	// get the current user login
-	var currentUser *database.MasterUser
-	currentUser, err := bookHandler.book.GetCurrentUser(rw, r, bookHandler.store)
-	if err != nil {
-		data.ToJSON(&GenericError{Message: err.Error()}, rw)
-
-		return
-	}
+	currentUser := getPrincipal(r).User
 
 	// look for the current room book in the db
 	var myKost database.DBTransactionRoomBook
-	if err := config.DB.Where("booker_id = ?", currentUser.ID).First(&myKost).Error; err != nil {
+	if err := config.DB.WithContext(r.Context()).Where("booker_id = ?", currentUser.ID).First(&myKost).Error; err != nil {
 		rw.WriteHeader(http.StatusBadRequest)
-		data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		bookHandler.writeError(rw, r, err, data.MsgBookNotFound)
 
 		return
 	}
 
+	// tag the response with the booking version, the approvals can send it back in If-Match
+	rw.Header().Set("ETag", myKost.ETag())
+
 	// parse the given instance to the response writer
-	err = data.ToJSON(myKost, rw)
+	err := data.ToJSON(entities.NewTransactionRoomBookResponse(&myKost), rw)
 	if err != nil {
 		rw.WriteHeader(http.StatusBadRequest)
-		data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		bookHandler.writeError(rw, r, err, data.MsgInternalError)
 
 		return
 	}
@@ -46,28 +44,152 @@ func (bookHandler *BookHandler) GetMyBook(rw http.ResponseWriter, r *http.Reques
 func (bookHandler *BookHandler) GetMyBookList(rw http.ResponseWriter, r *http.Request) {
 
 	// get the current user login
-	var currentUser *database.MasterUser
-	currentUser, err := bookHandler.book.GetCurrentUser(rw, r, bookHandler.store)
+	currentUser := getPrincipal(r).User
+
+	// look for the current book list in the db
+	var kostList []database.DBTransactionRoomBook
+	if err := config.DB.WithContext(r.Context()).Where("booker_id = ?", currentUser.ID).Find(&kostList).Error; err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		bookHandler.writeError(rw, r, err, data.MsgBookNotFound)
+
+		return
+	}
+
+	// parse the given instance to the response writer
+	err := data.ToJSON(entities.NewTransactionRoomBookResponses(kostList), rw)
 	if err != nil {
-		data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		rw.WriteHeader(http.StatusBadRequest)
+		bookHandler.writeError(rw, r, err, data.MsgInternalError)
 
 		return
 	}
 
-	// look for the current book list in the db
-	var kostList database.DBTransactionRoomBook
-	if err := config.DB.Where("booker_id = ?", currentUser.ID).Find(&kostList).Error; err != nil {
+	rw.WriteHeader(http.StatusOK)
+	return
+}
+
+// GetBook is a method to fetch the given book info, readable by its booker and by whoever manages its kost
+func (bookHandler *BookHandler) GetBook(rw http.ResponseWriter, r *http.Request) {
+
+	// get the requested book id
+	bookID, ok := getPathID(r, "id")
+	if !ok {
+		rw.WriteHeader(http.StatusBadRequest)
+		bookHandler.writeMessage(rw, r, data.MsgBookNotFound)
+
+		return
+	}
+
+	// get the current user login
+	currentUser := getPrincipal(r).User
+
+	// look for the requested book in the db
+	db := config.DB.WithContext(r.Context())
+	var targetBook database.DBTransactionRoomBook
+	if err := db.Where("id = ?", bookID).First(&targetBook).Error; err != nil {
 		rw.WriteHeader(http.StatusBadRequest)
-		data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		bookHandler.writeError(rw, r, err, data.MsgBookNotFound)
 
 		return
 	}
 
+	// the booker reads its own book, the owner, the kost staff and the admins read the books of the kost
+	if !bookHandler.policy.CanActAsTenant(currentUser, &targetBook) {
+		bookedKost := &database.DBKost{}
+		if err := db.Where("id = ?", targetBook.KostID).First(&bookedKost).Error; err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			bookHandler.writeError(rw, r, err, data.MsgKostNotFound)
+
+			return
+		}
+
+		canManage, err := bookHandler.policy.CanManageKost(db, currentUser, bookedKost)
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
+
+			return
+		}
+
+		if !canManage {
+			rw.WriteHeader(http.StatusForbidden)
+			bookHandler.writeMessage(rw, r, data.MsgForbidden)
+
+			return
+		}
+	}
+
+	// tag the response with the booking version, the approvals can send it back in If-Match
+	rw.Header().Set("ETag", targetBook.ETag())
+
 	// parse the given instance to the response writer
-	err = data.ToJSON(kostList, rw)
+	err := data.ToJSON(entities.NewTransactionRoomBookResponse(&targetBook), rw)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		bookHandler.writeError(rw, r, err, data.MsgInternalError)
+
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	return
+}
+
+// GetKostBookList is a method to fetch the book list of the given kost for its owner, the kost staff and the admins
+func (bookHandler *BookHandler) GetKostBookList(rw http.ResponseWriter, r *http.Request) {
+
+	// get the requested kost id
+	kostID, ok := getPathID(r, "id")
+	if !ok {
+		rw.WriteHeader(http.StatusBadRequest)
+		bookHandler.writeMessage(rw, r, data.MsgKostNotFound)
+
+		return
+	}
+
+	// get the current user login
+	currentUser := getPrincipal(r).User
+
+	// look for the requested kost in the db
+	db := config.DB.WithContext(r.Context())
+	targetKost := &database.DBKost{}
+	if err := db.Where("id = ?", kostID).First(&targetKost).Error; err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		bookHandler.writeError(rw, r, err, data.MsgKostNotFound)
+
+		return
+	}
+
+	// only owner, the kost staff or an admin can read the books of the kost
+	canManage, err := bookHandler.policy.CanManageKost(db, currentUser, targetKost)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
+
+		return
+	}
+
+	if !canManage {
+		rw.WriteHeader(http.StatusForbidden)
+		bookHandler.writeMessage(rw, r, data.MsgForbidden)
+
+		return
+	}
+
+	// look for the kost book list in the db
+	var bookList []database.DBTransactionRoomBook
+	if err := db.Where("kost_id = ?", targetKost.ID).Find(&bookList).Error; err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		bookHandler.writeError(rw, r, err, data.MsgBookNotFound)
+
+		return
+	}
+
+	// parse the given instance to the response writer, every listed book carries its own entity tag
+	err = data.ToJSON(entities.NewTransactionRoomBookResponses(bookList), rw)
 	if err != nil {
 		rw.WriteHeader(http.StatusBadRequest)
-		data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		bookHandler.writeError(rw, r, err, data.MsgInternalError)
 
 		return
 	}
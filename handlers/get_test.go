@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/gorilla/mux"
+)
+
+// getByID sends a request made by the given user to the given handler reading the given path id
+func getByID(handler http.HandlerFunc, user *database.MasterUser, id uint) *httptest.ResponseRecorder {
+	r := newPrincipalRequest(http.MethodGet, "/", user)
+	r = mux.SetURLVars(r, map[string]string{"id": strconv.FormatUint(uint64(id), 10)})
+
+	rw := httptest.NewRecorder()
+	handler(rw, r)
+
+	return rw
+}
+
+func TestGetBookETag(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 0)
+	seedStaff(t, db, book.KostID)
+
+	// the booker, the owner and the staff of the kost all get the version to approve with
+	for _, user := range []*database.MasterUser{testTenant, testOwner, testStaff} {
+		rw := getByID(bookHandler.GetBook, user, book.ID)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %s, got %d: %s", user.Username, rw.Code, rw.Body.String())
+		}
+
+		if etag := rw.Header().Get("ETag"); etag != book.ETag() {
+			t.Fatalf("expected the ETag %s for %s, got %s", book.ETag(), user.Username, etag)
+		}
+	}
+
+	rw := getByID(bookHandler.GetBook, testStranger, book.ID)
+	if rw.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a stranger, got %d", rw.Code)
+	}
+}
+
+func TestGetKostBookListETag(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 0)
+	seedStaff(t, db, book.KostID)
+
+	// the owner and the staff of the kost list its books
+	for _, user := range []*database.MasterUser{testOwner, testStaff} {
+		rw := getByID(bookHandler.GetKostBookList, user, book.KostID)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %s, got %d: %s", user.Username, rw.Code, rw.Body.String())
+		}
+
+		var books []entities.TransactionRoomBookResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &books); err != nil {
+			t.Fatalf("decode the book list: %v", err)
+		}
+
+		if len(books) != 1 || books[0].ETag != book.ETag() {
+			t.Fatalf("expected the listed book tagged %s for %s, got %+v", book.ETag(), user.Username, books)
+		}
+	}
+
+	// the booker doesn't manage the kost
+	rw := getByID(bookHandler.GetKostBookList, testTenant, book.KostID)
+	if rw.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for the booker, got %d", rw.Code)
+	}
+
+	rw = getByID(bookHandler.GetKostBookList, testStranger, book.KostID)
+	if rw.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a stranger, got %d", rw.Code)
+	}
+}
@@ -1,101 +1,240 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
-	"fmt"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/fakhripraya/book-service/data"
+	"github.com/fakhripraya/book-service/database"
 	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/idempotency"
+	"github.com/fakhripraya/book-service/metrics"
+	"github.com/fakhripraya/book-service/tracing"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
 )
 
-// MiddlewareValidateAuth validates the request and calls next if ok
+// MiddlewareValidateAuth validates the request and calls next if ok,
+// the token is taken from the Authorization bearer header or else from the session cookie
 func (bookHandler *BookHandler) MiddlewareValidateAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 
+		// bearer tokens are used by the mobile app and other services
+		if tokenString, ok := getBearerToken(r); ok {
+
+			// validate the token claims
+			claims, err := data.ParseToken(tokenString)
+			if err != nil {
+				rw.WriteHeader(http.StatusUnauthorized)
+				bookHandler.writeError(rw, r, err, data.MsgTokenInvalid)
+
+				return
+			}
+
+			// reject revoked tokens
+			if bookHandler.isRevoked(rw, r, claims) {
+				return
+			}
+
+			bookHandler.serveAuthenticated(rw, r, next, claims, data.PrincipalSourceBearer)
+
+			return
+		}
+
 		// Get a session (existing/new)
-		session, err := bookHandler.store.Get(r, "session-name")
+		session, err := bookHandler.getSession(r)
 		if err != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
-			data.ToJSON(&GenericError{Message: err.Error()}, rw)
+			bookHandler.writeError(rw, r, err, data.MsgSessionError)
 
 			return
 		}
 
 		// check the token from the session
 		// if token available, get the token from the session
-		if session.Values["token"] == nil {
+		tokenString, _ := session.Values["token"].(string)
+		if tokenString == "" {
 			rw.WriteHeader(http.StatusUnauthorized)
+			bookHandler.writeMessage(rw, r, data.MsgUnauthorized)
+
 			return
 		}
 
-		// determine the cookie value that holds the token
-		tokenString := session.Values["token"].(string)
+		// validate the token claims
+		claims, err := data.ParseToken(tokenString)
+		if err != nil {
+			rw.WriteHeader(http.StatusUnauthorized)
+			bookHandler.writeError(rw, r, err, data.MsgTokenInvalid)
 
-		if tokenString != "" {
+			return
+		}
+
+		// revoked tokens must not be renewed
+		if bookHandler.isRevoked(rw, r, claims) {
+			return
+		}
 
-			// Initialize a new instance of claims
-			claims := &data.Claims{}
+		// renew the session token once it gets close to its expiry,
+		// only possible for the tokens signed with the secret held by this service
+		if data.MySigningKey != "" && claims.IsSelfIssued() && data.MyTokenPolicy.ShouldRenew(claims) {
+			tokenString, lifetime, err := data.MyTokenPolicy.RenewToken(claims)
+			if err != nil {
+				rw.WriteHeader(http.StatusInternalServerError)
+				bookHandler.writeError(rw, r, err, data.MsgTokenRenewFailed)
 
-			token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, fmt.Errorf("Error while parsing the token with claims")
-				}
+				return
+			}
 
-				return []byte(data.MySigningKey), nil
-			})
+			// renew the token in the session
+			session.Options.MaxAge = int(lifetime.Seconds())
+			session.Values["token"] = tokenString
+			session.Values["userLoggedin"] = claims.Username
+			if err := bookHandler.saveSession(rw, r, session); err != nil {
+				bookHandler.requestLogger(r).Error("Unable to save the renewed session", "error", err)
+			}
+		}
 
-			if err != nil {
-				if err == jwt.ErrSignatureInvalid {
-					rw.WriteHeader(http.StatusUnauthorized)
-					data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		bookHandler.serveAuthenticated(rw, r, next, claims, data.PrincipalSourceSession)
+	})
+}
 
-					return
-				}
+// getSession reads the session of the request from the session store
+func (bookHandler *BookHandler) getSession(r *http.Request) (*sessions.Session, error) {
+	_, span := tracing.Start(r.Context(), "SessionStore.Get")
 
-				rw.WriteHeader(http.StatusBadRequest)
-				data.ToJSON(&GenericError{Message: err.Error()}, rw)
+	session, err := bookHandler.store.Get(r, data.MySessionName)
+	if err != nil {
+		metrics.SessionStoreError("get")
+	}
 
-				return
-			}
+	tracing.End(span, err)
 
-			if token.Valid {
+	return session, err
+}
 
-				// create a new token for the current use, with a renewed expiration time
-				expirationTime := time.Now().Add(time.Second * 86400 * 7)
-				claims.StandardClaims.ExpiresAt = expirationTime.Unix()
-				token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-				tokenString, err := token.SignedString([]byte(data.MySigningKey))
+// saveSession writes the given session to the session store and its cookie to the response
+func (bookHandler *BookHandler) saveSession(rw http.ResponseWriter, r *http.Request, session *sessions.Session) error {
+	_, span := tracing.Start(r.Context(), "SessionStore.Save")
 
-				if err != nil {
-					rw.WriteHeader(http.StatusInternalServerError)
-					data.ToJSON(&GenericError{Message: err.Error()}, rw)
+	err := session.Save(r, rw)
+	if err != nil {
+		metrics.SessionStoreError("save")
+	}
 
-					return
-				}
+	tracing.End(span, err)
 
-				// renew the token in the session
-				session.Options.MaxAge = 86400 * 7
-				session.Values["token"] = tokenString
-				session.Values["userLoggedin"] = claims.Username
-				session.Save(r, rw)
+	return err
+}
 
-				next.ServeHTTP(rw, r)
-			} else {
-				rw.WriteHeader(http.StatusUnauthorized)
-				data.ToJSON(&GenericError{Message: "Token invalid"}, rw)
+// isRevoked checks the denylist for the given claims, the error response is written when the token is revoked
+func (bookHandler *BookHandler) isRevoked(rw http.ResponseWriter, r *http.Request, claims *data.Claims) bool {
+	revoked, err := bookHandler.book.IsTokenRevoked(r.Context(), claims)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
 
-				return
-			}
-		} else {
-			rw.WriteHeader(http.StatusUnauthorized)
-			data.ToJSON(&GenericError{Message: "Token invalid"}, rw)
+		return true
+	}
 
-			return
+	if revoked {
+		rw.WriteHeader(http.StatusUnauthorized)
+		bookHandler.writeMessage(rw, r, data.MsgTokenRevoked)
+
+		return true
+	}
+
+	return false
+}
+
+// getBearerToken returns the token of the Authorization bearer header if the request has one
+func getBearerToken(r *http.Request) (string, bool) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < len("Bearer ") || !strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+
+	return strings.TrimSpace(authorization[len("Bearer "):]), true
+}
+
+// serveAuthenticated resolves the principal of the given claims, adds it to the context and calls next
+func (bookHandler *BookHandler) serveAuthenticated(rw http.ResponseWriter, r *http.Request, next http.Handler, claims *data.Claims, source string) {
+
+	// resolve the token holder from the claims, the principal cache or the db
+	currentUser, err := bookHandler.book.GetPrincipalUser(r.Context(), claims)
+	if err != nil {
+		rw.WriteHeader(http.StatusUnauthorized)
+		bookHandler.writeError(rw, r, err, data.MsgUnauthorized)
+
+		return
+	}
+
+	// tag the following log lines with the current user
+	addLogFields(r, "user_id", currentUser.ID)
+
+	// add the principal to the context
+	principal := &data.Principal{User: currentUser, Claims: claims, Source: source}
+	ctx := context.WithValue(r.Context(), KeyPrincipal{}, principal)
+	r = r.WithContext(ctx)
+
+	// Call the next handler, which can be another middleware in the chain, or the final handler.
+	next.ServeHTTP(rw, r)
+}
+
+// maxRequestBodySize is the largest request body accepted by the parse middlewares
+const maxRequestBodySize = 1 << 20
+
+// parseRequest strictly decodes the request body to the given payload and validates it,
+// the error response is written and false returned when the payload is not acceptable
+func (bookHandler *BookHandler) parseRequest(rw http.ResponseWriter, r *http.Request, payload interface{}) bool {
+
+	// limit the request body size
+	r.Body = http.MaxBytesReader(rw, r.Body, maxRequestBodySize)
+
+	// parse the request body to the given instance
+	err := data.FromStrictJSON(payload, r.Body)
+	if err != nil {
+
+		// occurs when the body exceeds the size limit
+		if strings.Contains(err.Error(), "http: request body too large") {
+			rw.WriteHeader(http.StatusRequestEntityTooLarge)
+			bookHandler.writeMessage(rw, r, data.MsgRequestTooLarge)
+
+			return false
 		}
-	})
+
+		// occurs when the client sends a field that is not part of the payload
+		if strings.HasPrefix(err.Error(), "json: unknown field ") {
+			field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), "\"")
+			rw.WriteHeader(http.StatusBadRequest)
+			bookHandler.writeValidationError(rw, r, data.ValidationErrors{data.UnknownFieldError(field, getLanguage(r))})
+
+			return false
+		}
+
+		rw.WriteHeader(http.StatusBadRequest)
+		bookHandler.writeMessage(rw, r, data.MsgInvalidRequestBody)
+
+		return false
+	}
+
+	// validate the payload against its declarative rules
+	validationErrors := bookHandler.validation.Validate(payload, getLanguage(r))
+	if len(validationErrors) != 0 {
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		bookHandler.writeValidationError(rw, r, validationErrors)
+
+		return false
+	}
+
+	return true
 }
 
 // MiddlewareParseBookRequest parses the book payload in the request body from json
@@ -106,14 +245,10 @@ func (bookHandler *BookHandler) MiddlewareParseBookRequest(next http.Handler) ht
 		rw.Header().Add("Content-Type", "application/json")
 
 		// create the book instance
-		book := &entities.TransactionRoomBook{}
-
-		// parse the request body to the given instance
-		err := data.FromJSON(book, r.Body)
-		if err != nil {
-			rw.WriteHeader(http.StatusBadRequest)
-			data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		book := &entities.TransactionRoomBookRequest{}
 
+		// parse and validate the request body to the given instance
+		if !bookHandler.parseRequest(rw, r, book) {
 			return
 		}
 
@@ -134,14 +269,10 @@ func (bookHandler *BookHandler) MiddlewareParseApprovalRequest(next http.Handler
 		rw.Header().Add("Content-Type", "application/json")
 
 		// create the approval instance
-		approval := &entities.ApprovalRoomBook{}
-
-		// parse the request body to the given instance
-		err := data.FromJSON(approval, r.Body)
-		if err != nil {
-			rw.WriteHeader(http.StatusBadRequest)
-			data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		approval := &entities.ApprovalRoomBookRequest{}
 
+		// parse and validate the request body to the given instance
+		if !bookHandler.parseRequest(rw, r, approval) {
 			return
 		}
 
@@ -153,3 +284,378 @@ func (bookHandler *BookHandler) MiddlewareParseApprovalRequest(next http.Handler
 		next.ServeHTTP(rw, r)
 	})
 }
+
+// MiddlewareParseWebhookRequest parses the webhook subscription payload in the request body from json
+func (bookHandler *BookHandler) MiddlewareParseWebhookRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+
+		// validate content type to be application/json
+		rw.Header().Add("Content-Type", "application/json")
+
+		// create the webhook subscription instance
+		subscription := &entities.WebhookSubscriptionRequest{}
+
+		// parse and validate the request body to the given instance
+		if !bookHandler.parseRequest(rw, r, subscription) {
+			return
+		}
+
+		// add the webhook subscription to the context
+		ctx := context.WithValue(r.Context(), KeyWebhook{}, subscription)
+		r = r.WithContext(ctx)
+
+		// Call the next handler, which can be another middleware in the chain, or the final handler.
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// maxRequestIDLength is the longest X-Request-ID accepted from the client
+const maxRequestIDLength = 128
+
+// accessRecorder records the status code and the body size written by the wrapped handler
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+// WriteHeader records the first status code written
+func (recorder *accessRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the body size and the implicit 200 status of a body written without a status code
+func (recorder *accessRecorder) Write(body []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	size, err := recorder.ResponseWriter.Write(body)
+	recorder.size += size
+
+	return size, err
+}
+
+// MiddlewareLogRequest tags the request with the X-Request-ID of the caller or a new one, adds a request-scoped logger
+// to the context and writes the access log once the request is done, it must be used on the router so the route is known
+func (bookHandler *BookHandler) MiddlewareLogRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+
+		// keep the id of the caller so the logs of both services can be correlated
+		requestID := r.Header.Get("X-Request-ID")
+		if !isValidRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		rw.Header().Set("X-Request-ID", requestID)
+
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		// tag every log line of the request
+		log := &requestLog{logger: bookHandler.logger.With("request_id", requestID, "method", r.Method, "route", route)}
+		if traceID := tracing.TraceID(r.Context()); traceID != "" {
+			log.logger = log.logger.With("trace_id", traceID)
+		}
+
+		// add the request id and the logger to the context
+		ctx := context.WithValue(r.Context(), KeyRequestID{}, requestID)
+		ctx = context.WithValue(ctx, KeyRequestLog{}, log)
+		r = r.WithContext(ctx)
+
+		// Call the next handler, which can be another middleware in the chain, or the final handler.
+		recorder := &accessRecorder{ResponseWriter: rw}
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		log.logger.Info("Request handled", "path", r.URL.Path, "status", status, "size", recorder.size, "duration", time.Since(started))
+	})
+}
+
+// isValidRequestID checks the given request id is safe to log and echo back to the client
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for _, char := range requestID {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' || strings.ContainsRune("-_.:", char)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID generates a new random request id
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// MiddlewareNegotiateLanguage picks the response language from the Accept-Language header and adds it to the context
+func (bookHandler *BookHandler) MiddlewareNegotiateLanguage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+
+		// negotiate the language against the message catalog
+		language := data.NegotiateLanguage(r.Header.Get("Accept-Language"))
+		rw.Header().Set("Content-Language", language)
+		rw.Header().Add("Vary", "Accept-Language")
+
+		// add the language to the context
+		ctx := context.WithValue(r.Context(), KeyLanguage{}, language)
+		r = r.WithContext(ctx)
+
+		// Call the next handler, which can be another middleware in the chain, or the final handler.
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// MiddlewareRateLimit only calls next while the current user and its client ip have requests left in the given rule,
+// it must be used after MiddlewareValidateAuth
+func (bookHandler *BookHandler) MiddlewareRateLimit(rule string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+
+			// take a token from the buckets of the rule, a failing store must not block the requests
+			result, err := bookHandler.limiter.Take(r.Context(), rule, getPrincipal(r).User.ID, r)
+			if err != nil {
+				bookHandler.requestLogger(r).Error("Unable to check the rate limit, allowing the request", "rule", rule, "error", err)
+				next.ServeHTTP(rw, r)
+
+				return
+			}
+
+			if !result.Allowed {
+				metrics.RateLimited(rule)
+				bookHandler.requestLogger(r).Warn("Rate limit exceeded", "rule", rule, "retry_after", result.RetryAfter)
+
+				// round up so the client never retries too early
+				retryAfter := int((result.RetryAfter + time.Second - 1) / time.Second)
+				rw.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				rw.WriteHeader(http.StatusTooManyRequests)
+				bookHandler.writeMessage(rw, r, data.MsgTooManyRequests)
+
+				return
+			}
+
+			if result.Remaining >= 0 {
+				rw.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			}
+
+			// Call the next handler, which can be another middleware in the chain, or the final handler.
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// Idempotency headers
+const (
+	HeaderIdempotencyKey      = "Idempotency-Key"
+	HeaderIdempotencyReplayed = "Idempotent-Replayed"
+)
+
+// maxIdempotencyKeyLength is the longest Idempotency-Key accepted from the client
+const maxIdempotencyKeyLength = 191
+
+// idempotencyRecorder keeps a copy of the response written by the wrapped handler
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the first status code written
+func (recorder *idempotencyRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// Write keeps a copy of the body and records the implicit 200 status of a body written without a status code
+func (recorder *idempotencyRecorder) Write(body []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	recorder.body.Write(body)
+
+	return recorder.ResponseWriter.Write(body)
+}
+
+// MiddlewareIdempotency replays the original response to the retries of a request made with an Idempotency-Key header,
+// the key is scoped to the current user and a key reused for another request is rejected,
+// it must be used after MiddlewareValidateAuth and before the parse middlewares
+func (bookHandler *BookHandler) MiddlewareIdempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+
+		// the header is optional
+		key := r.Header.Get(HeaderIdempotencyKey)
+		if key == "" {
+			next.ServeHTTP(rw, r)
+
+			return
+		}
+
+		if !isValidIdempotencyKey(key) {
+			rw.WriteHeader(http.StatusBadRequest)
+			bookHandler.writeMessage(rw, r, data.MsgIdempotencyKeyInvalid)
+
+			return
+		}
+
+		// read the request body to tell a retry from another request, then restore it for the next handlers
+		body, err := ioutil.ReadAll(http.MaxBytesReader(rw, r.Body, maxRequestBodySize))
+		if err != nil {
+			rw.WriteHeader(http.StatusRequestEntityTooLarge)
+			bookHandler.writeMessage(rw, r, data.MsgRequestTooLarge)
+
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		requestHash := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "\n" + string(body)))
+
+		// take the key
+		currentUser := getPrincipal(r).User
+		idempotencyKey, outcome, err := bookHandler.idempotency.Begin(r.Context(), currentUser, key, hex.EncodeToString(requestHash[:]))
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
+
+			return
+		}
+
+		switch outcome {
+		case idempotency.OutcomeMismatch:
+			rw.WriteHeader(http.StatusUnprocessableEntity)
+			bookHandler.writeMessage(rw, r, data.MsgIdempotencyKeyReused)
+
+			return
+		case idempotency.OutcomeInProgress:
+			rw.Header().Set("Retry-After", "1")
+			rw.WriteHeader(http.StatusConflict)
+			bookHandler.writeMessage(rw, r, data.MsgIdempotencyInProgress)
+
+			return
+		case idempotency.OutcomeReplay:
+			header, err := bookHandler.idempotency.StoredHeader(idempotencyKey)
+			if err != nil {
+				rw.WriteHeader(http.StatusInternalServerError)
+				bookHandler.writeError(rw, r, err, data.MsgInternalError)
+
+				return
+			}
+
+			// the stored headers replace the ones set by the previous middlewares
+			for name, values := range header {
+				rw.Header()[http.CanonicalHeaderKey(name)] = values
+			}
+
+			rw.Header().Set(HeaderIdempotencyReplayed, "true")
+			rw.WriteHeader(idempotencyKey.ResponseCode)
+			rw.Write([]byte(idempotencyKey.ResponseBody))
+
+			return
+		}
+
+		addLogFields(r, "idempotency_key", key)
+
+		// a panicking handler leaves no response to store, the key is released so a retry handles the request again
+		handled := false
+		defer func() {
+			if !handled {
+				bookHandler.releaseIdempotencyKey(r, idempotencyKey)
+			}
+		}()
+
+		// Call the next handler, which can be another middleware in the chain, or the final handler.
+		// the key is kept alive meanwhile so a slow request is not taken over by its retry
+		recorder := &idempotencyRecorder{ResponseWriter: rw}
+		func() {
+			stopKeepAlive := bookHandler.idempotency.KeepAlive(idempotencyKey)
+			defer stopKeepAlive()
+
+			next.ServeHTTP(recorder, r)
+		}()
+		handled = true
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		// a server error may succeed on retry, every other response is final
+		if status >= http.StatusInternalServerError {
+			bookHandler.releaseIdempotencyKey(r, idempotencyKey)
+
+			return
+		}
+
+		// the key outlives the request, a client giving up must not leave it in progress
+		if err := bookHandler.idempotency.Complete(context.Background(), idempotencyKey, status, rw.Header(), recorder.body.Bytes()); err != nil {
+			bookHandler.requestLogger(r).Error("Unable to store the idempotent response", "error", err)
+		}
+	})
+}
+
+// releaseIdempotencyKey frees the given key of a request that failed, the key outlives the request
+// so a client giving up must not leave it in progress
+func (bookHandler *BookHandler) releaseIdempotencyKey(r *http.Request, idempotencyKey *database.DBIdempotencyKey) {
+	if err := bookHandler.idempotency.Release(context.Background(), idempotencyKey); err != nil {
+		bookHandler.requestLogger(r).Error("Unable to release the idempotency key", "error", err)
+	}
+}
+
+// isValidIdempotencyKey checks the given idempotency key is printable and fits in the db
+func isValidIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+
+	for _, char := range key {
+		if char < ' ' || char > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MiddlewareRequirePermission only calls next if the role of the current user grants the given permission
+func (bookHandler *BookHandler) MiddlewareRequirePermission(permission data.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+
+			// check the permission against the current user role
+			if !bookHandler.policy.HasPermission(getPrincipal(r).User, permission) {
+				rw.WriteHeader(http.StatusForbidden)
+				bookHandler.writeMessage(rw, r, data.MsgForbidden)
+
+				return
+			}
+
+			// Call the next handler, which can be another middleware in the chain, or the final handler.
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
@@ -0,0 +1,509 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/fakhripraya/book-service/data"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/idempotency"
+	"github.com/fakhripraya/book-service/ratelimit"
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/gorm"
+)
+
+// newIdempotentHandler returns the given handler behind the idempotency middleware
+func newIdempotentHandler(next http.HandlerFunc) http.Handler {
+	bookHandler := newTestBookHandler()
+	bookHandler.idempotency = idempotency.NewService(hclog.NewNullLogger(), &entities.IdempotencyConfiguration{Window: 3600, LockTimeout: 30})
+
+	return bookHandler.MiddlewareIdempotency(next)
+}
+
+// sendIdempotent sends a request of testTenant with the given idempotency key and body to the given handler
+func sendIdempotent(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	r := newPrincipalRequest(http.MethodPost, "/book", testTenant)
+	r.Body = ioutil.NopCloser(strings.NewReader(body))
+	r.Header.Set(HeaderIdempotencyKey, key)
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, r)
+
+	return rw
+}
+
+// countIdempotencyKeys returns the number of the stored idempotency keys
+func countIdempotencyKeys(t *testing.T, db *gorm.DB) int64 {
+	t.Helper()
+
+	var count int64
+	if err := db.Model(&database.DBIdempotencyKey{}).Count(&count).Error; err != nil {
+		t.Fatalf("count the idempotency keys: %v", err)
+	}
+
+	return count
+}
+
+func TestIdempotencyReplaysTheResponse(t *testing.T) {
+	newTestDB(t)
+
+	var calls int32
+	handler := newIdempotentHandler(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.Header().Set("ETag", `"1-1"`)
+		rw.Header().Set("X-Request-ID", "first")
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte(`{"message":"created"}`))
+	})
+
+	first := sendIdempotent(handler, "key-1", `{"kost_id":1}`)
+	retry := sendIdempotent(handler, "key-1", `{"kost_id":1}`)
+
+	if calls != 1 {
+		t.Fatalf("expected the request handled once, got %d", calls)
+	}
+
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Fatalf("expected the first response replayed, got %d: %s", retry.Code, retry.Body.String())
+	}
+
+	if retry.Header().Get(HeaderIdempotencyReplayed) != "true" || retry.Header().Get("ETag") != `"1-1"` {
+		t.Fatalf("expected the replayed headers, got %v", retry.Header())
+	}
+
+	if retry.Header().Get("X-Request-ID") != "" {
+		t.Fatalf("expected the headers of the first request left out, got %v", retry.Header())
+	}
+}
+
+func TestIdempotencyKeyReusedForAnotherRequest(t *testing.T) {
+	newTestDB(t)
+
+	handler := newIdempotentHandler(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusCreated)
+	})
+
+	sendIdempotent(handler, "key-1", `{"kost_id":1}`)
+
+	if rw := sendIdempotent(handler, "key-1", `{"kost_id":2}`); rw.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a key reused with another body, got %d", rw.Code)
+	}
+}
+
+func TestIdempotencyKeyInProgress(t *testing.T) {
+	newTestDB(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := newIdempotentHandler(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		rw.WriteHeader(http.StatusCreated)
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- sendIdempotent(handler, "key-1", `{"kost_id":1}`)
+	}()
+	<-started
+
+	// the retry of a request still being handled is told to come back later
+	rw := sendIdempotent(handler, "key-1", `{"kost_id":1}`)
+	if rw.Code != http.StatusConflict || rw.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 409 with Retry-After, got %d", rw.Code)
+	}
+
+	close(release)
+	if first := <-done; first.Code != http.StatusCreated {
+		t.Fatalf("expected the first request handled, got %d", first.Code)
+	}
+}
+
+func TestIdempotencyReleasesTheKeyOnServerError(t *testing.T) {
+	db := newTestDB(t)
+
+	var calls int32
+	handler := newIdempotentHandler(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			rw.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		rw.WriteHeader(http.StatusCreated)
+	})
+
+	if rw := sendIdempotent(handler, "key-1", `{"kost_id":1}`); rw.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the server error, got %d", rw.Code)
+	}
+
+	if count := countIdempotencyKeys(t, db); count != 0 {
+		t.Fatalf("expected the key released, got %d keys", count)
+	}
+
+	// the retry handles the request again
+	if rw := sendIdempotent(handler, "key-1", `{"kost_id":1}`); rw.Code != http.StatusCreated || calls != 2 {
+		t.Fatalf("expected the retry handled, got %d after %d calls", rw.Code, calls)
+	}
+}
+
+func TestIdempotencyReleasesTheKeyOnPanic(t *testing.T) {
+	db := newTestDB(t)
+
+	handler := newIdempotentHandler(func(rw http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	})
+
+	func() {
+		defer func() {
+			if recovered := recover(); recovered == nil {
+				t.Fatalf("expected the panic to reach the server")
+			}
+		}()
+
+		sendIdempotent(handler, "key-1", `{"kost_id":1}`)
+	}()
+
+	if count := countIdempotencyKeys(t, db); count != 0 {
+		t.Fatalf("expected the key released, got %d keys", count)
+	}
+}
+
+func TestNegotiateLanguageLocalizesTheMessages(t *testing.T) {
+	bookHandler := newTestBookHandler()
+	handler := bookHandler.MiddlewareNegotiateLanguage(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+		bookHandler.writeMessage(rw, r, data.MsgBookNotFound)
+	}))
+
+	for acceptLanguage, expected := range map[string]string{
+		"en-US,en;q=0.9": data.LanguageEnglish,
+		"fr":             data.DefaultLanguage,
+	} {
+		r := newPrincipalRequest(http.MethodGet, "/book/1", testTenant)
+		r.Header.Set("Accept-Language", acceptLanguage)
+
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+
+		var body GenericError
+		if err := json.NewDecoder(rw.Body).Decode(&body); err != nil {
+			t.Fatalf("decode the response: %v", err)
+		}
+
+		if body.Code != string(data.MsgBookNotFound) || body.Message != data.Translate(expected, data.MsgBookNotFound) {
+			t.Fatalf("expected the message in %s, got %+v", expected, body)
+		}
+
+		if rw.Header().Get("Content-Language") != expected || rw.Header().Get("Vary") != "Accept-Language" {
+			t.Fatalf("expected the negotiated language headers, got %v", rw.Header())
+		}
+	}
+}
+
+func TestParseBookRequest(t *testing.T) {
+	bookHandler := newTestBookHandler()
+	bookHandler.validation = data.NewValidation()
+
+	called := false
+	handler := bookHandler.MiddlewareParseBookRequest(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	for name, test := range map[string]struct {
+		body   string
+		status int
+		field  string
+	}{
+		"unknown field": {`{"kost_id":1,"discount":100}`, http.StatusBadRequest, "discount"},
+		"malformed":     {`{"kost_id":`, http.StatusBadRequest, ""},
+		"too large":     {`{"kost_id":1,"members":[{"member_name":"` + strings.Repeat("a", maxRequestBodySize) + `"}]}`, http.StatusRequestEntityTooLarge, ""},
+		"invalid":       {`{"kost_id":1}`, http.StatusUnprocessableEntity, "room_id"},
+	} {
+		r := newPrincipalRequest(http.MethodPost, "/book", testTenant)
+		r.Body = ioutil.NopCloser(strings.NewReader(test.body))
+
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+
+		if rw.Code != test.status || called {
+			t.Fatalf("%s: expected the request refused with %d, got %d", name, test.status, rw.Code)
+		}
+
+		if test.field == "" {
+			continue
+		}
+
+		var body ValidationError
+		if err := json.NewDecoder(rw.Body).Decode(&body); err != nil {
+			t.Fatalf("%s: decode the response: %v", name, err)
+		}
+
+		if len(body.Fields) == 0 || body.Fields[0].Field != test.field {
+			t.Fatalf("%s: expected %s reported, got %+v", name, test.field, body.Fields)
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	called := false
+	handler := newTestBookHandler().MiddlewareRequirePermission(data.PermissionBookApproveOwner)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	for _, test := range []struct {
+		user   *database.MasterUser
+		status int
+	}{
+		{testTenant, http.StatusForbidden},
+		{testOwner, http.StatusOK},
+		{testStaff, http.StatusOK},
+		{&database.MasterUser{ID: 50, RoleID: 99}, http.StatusForbidden},
+	} {
+		called = false
+
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, newPrincipalRequest(http.MethodPatch, "/approve/owner", test.user))
+
+		if rw.Code != test.status || called != (test.status == http.StatusOK) {
+			t.Fatalf("expected role id %d answered with %d, got %d", test.user.RoleID, test.status, rw.Code)
+		}
+	}
+}
+
+// newAuthBookHandler returns a book handler resolving the principals of testTenant from the test database
+func newAuthBookHandler(t *testing.T, db *gorm.DB) *BookHandler {
+	t.Helper()
+
+	if err := db.AutoMigrate(&database.MasterUser{}, &database.DBTokenDenylist{}); err != nil {
+		t.Fatalf("migrate the user tables: %v", err)
+	}
+
+	tenant := *testTenant
+	tenant.IsActive = true
+	tenant.Password = []byte("hash")
+	if err := db.Create(&tenant).Error; err != nil {
+		t.Fatalf("seed the tenant: %v", err)
+	}
+
+	previousKey := data.MySigningKey
+	data.MySigningKey = "secret"
+	t.Cleanup(func() { data.MySigningKey = previousKey })
+
+	bookHandler := newTestBookHandler()
+	bookHandler.book = data.NewBook(hclog.NewNullLogger(), data.NewTTLCache(time.Minute, 100), data.NewTTLCache(time.Minute, 100))
+
+	return bookHandler
+}
+
+// signBearerToken signs a token of the given username issued now with the test secret
+func signBearerToken(t *testing.T, username string, expiresAt time.Time) string {
+	return signBearerTokenAt(t, username, time.Now(), expiresAt)
+}
+
+// signBearerTokenAt signs a token of the given username issued at the given time with the test secret
+func signBearerTokenAt(t *testing.T, username string, issuedAt, expiresAt time.Time) string {
+	t.Helper()
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &data.Claims{
+		Username: username,
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  issuedAt.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("sign the token: %v", err)
+	}
+
+	return signed
+}
+
+func TestValidateAuthWithBearerToken(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newAuthBookHandler(t, db)
+
+	var principal *data.Principal
+	handler := bookHandler.MiddlewareValidateAuth(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		principal = getPrincipal(r)
+	}))
+
+	for name, test := range map[string]struct {
+		authorization string
+		status        int
+		code          data.MessageCode
+	}{
+		"valid":        {"Bearer " + signBearerToken(t, testTenant.Username, time.Now().Add(time.Hour)), http.StatusOK, ""},
+		"lowercase":    {"bearer " + signBearerToken(t, testTenant.Username, time.Now().Add(time.Hour)), http.StatusOK, ""},
+		"expired":      {"Bearer " + signBearerToken(t, testTenant.Username, time.Now().Add(-time.Hour)), http.StatusUnauthorized, data.MsgTokenExpired},
+		"malformed":    {"Bearer not-a-token", http.StatusUnauthorized, data.MsgTokenInvalid},
+		"unknown user": {"Bearer " + signBearerToken(t, "nobody", time.Now().Add(time.Hour)), http.StatusUnauthorized, data.MsgUnauthorized},
+	} {
+		principal = nil
+
+		r := httptest.NewRequest(http.MethodGet, "/book", nil)
+		r.Header.Set("Authorization", test.authorization)
+
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+
+		if rw.Code != test.status {
+			t.Fatalf("%s: expected %d, got %d: %s", name, test.status, rw.Code, rw.Body.String())
+		}
+
+		if test.status != http.StatusOK {
+			var body GenericError
+			if err := json.NewDecoder(rw.Body).Decode(&body); err != nil || body.Code != string(test.code) {
+				t.Fatalf("%s: expected %s, got %+v", name, test.code, body)
+			}
+
+			continue
+		}
+
+		// the handlers read the caller from the context instead of the session
+		if principal == nil || principal.User.ID != testTenant.ID || principal.Source != data.PrincipalSourceBearer || principal.Claims.Username != testTenant.Username {
+			t.Fatalf("%s: expected the principal of the tenant, got %+v", name, principal)
+		}
+	}
+}
+
+func TestValidateAuthRefusesRevokedBearerToken(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newAuthBookHandler(t, db)
+
+	token := signBearerToken(t, testTenant.Username, time.Now().Add(time.Hour))
+	claims, err := data.ParseToken(token)
+	if err != nil {
+		t.Fatalf("parse the token: %v", err)
+	}
+
+	if err := bookHandler.book.RevokeToken(context.Background(), claims); err != nil {
+		t.Fatalf("revoke the token: %v", err)
+	}
+
+	called := false
+	handler := bookHandler.MiddlewareValidateAuth(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/book", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, r)
+
+	if rw.Code != http.StatusUnauthorized || called {
+		t.Fatalf("expected the revoked token refused, got %d", rw.Code)
+	}
+}
+
+// readLogLines decodes the JSON log lines written to the given buffer
+func readLogLines(t *testing.T, buffer *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var lines []map[string]interface{}
+	decoder := json.NewDecoder(buffer)
+	for decoder.More() {
+		var line map[string]interface{}
+		if err := decoder.Decode(&line); err != nil {
+			t.Fatalf("decode the log line: %v", err)
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func TestLogRequestTagsTheLogLines(t *testing.T) {
+	var buffer bytes.Buffer
+	bookHandler := newTestBookHandler()
+	bookHandler.logger = hclog.New(&hclog.LoggerOptions{Output: &buffer, JSONFormat: true})
+
+	router := mux.NewRouter()
+	router.Use(bookHandler.MiddlewareLogRequest)
+	router.HandleFunc("/v1/{id:[0-9]+}", func(rw http.ResponseWriter, r *http.Request) {
+		addLogFields(r, "user_id", testTenant.ID)
+		bookHandler.requestLogger(r).Warn("Booking not found")
+		rw.WriteHeader(http.StatusNotFound)
+	})
+
+	for requestID, expected := range map[string]string{
+		"caller-id-1":            "caller-id-1",
+		"":                       "",
+		"bad id\nforged=entry":   "",
+		strings.Repeat("a", 200): "",
+	} {
+		buffer.Reset()
+
+		r := httptest.NewRequest(http.MethodGet, "/v1/7", nil)
+		r.Header.Set("X-Request-ID", requestID)
+
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, r)
+
+		// an unusable id of the caller is replaced by a new one
+		echoed := rw.Header().Get("X-Request-ID")
+		if expected != "" && echoed != expected || expected == "" && (echoed == "" || echoed == requestID) {
+			t.Fatalf("expected the request id %q answered for %q, got %q", expected, requestID, echoed)
+		}
+
+		lines := readLogLines(t, &buffer)
+		if len(lines) != 2 {
+			t.Fatalf("expected the handler and access log lines, got %d", len(lines))
+		}
+
+		// every line of the request carries its id, the fields added by the handlers reach the access log
+		for _, line := range lines {
+			if line["request_id"] != echoed || line["route"] != "/v1/{id:[0-9]+}" || line["user_id"] != float64(testTenant.ID) {
+				t.Fatalf("expected the line tagged with the request, got %v", line)
+			}
+		}
+
+		if access := lines[1]; access["@message"] != "Request handled" || access["status"] != float64(http.StatusNotFound) {
+			t.Fatalf("expected the access log with the status, got %v", access)
+		}
+	}
+}
+
+func TestRateLimitAnswersTooManyRequests(t *testing.T) {
+	bookHandler := newTestBookHandler()
+	bookHandler.limiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(time.Hour), &entities.RateLimitConfiguration{
+		SweepInterval: 3600,
+		Rules:         map[string]entities.RateLimitRule{"addbook": {UserRate: 1, UserBurst: 1}},
+	})
+
+	var calls int32
+	handler := bookHandler.MiddlewareRateLimit("addbook")(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.WriteHeader(http.StatusCreated)
+	}))
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, newPrincipalRequest(http.MethodPost, "/book", testTenant))
+	if rw.Code != http.StatusCreated || rw.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("expected the first request allowed, got %d %v", rw.Code, rw.Header())
+	}
+
+	// a token is earned every minute
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, newPrincipalRequest(http.MethodPost, "/book", testTenant))
+	if rw.Code != http.StatusTooManyRequests || rw.Header().Get("Retry-After") != "60" {
+		t.Fatalf("expected the second request limited for a minute, got %d %v", rw.Code, rw.Header())
+	}
+
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("expected the limited request not handled, got %d calls", calls)
+	}
+}
@@ -1,14 +1,16 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/fakhripraya/book-service/config"
 	"github.com/fakhripraya/book-service/data"
 	"github.com/fakhripraya/book-service/database"
 	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/metrics"
+	"github.com/fakhripraya/book-service/outbox"
 	"gorm.io/gorm"
 )
 
@@ -16,50 +18,66 @@ import (
 func (bookHandler *BookHandler) OwnerApprovalBookTransaction(rw http.ResponseWriter, r *http.Request) {
 
 	// get the approval via context
-	approvalReq := r.Context().Value(KeyApproval{}).(*entities.ApprovalRoomBook)
+	approvalReq := r.Context().Value(KeyApproval{}).(*entities.ApprovalRoomBookRequest)
 
 	// get the current user login
-	var currentUser *database.MasterUser
-	currentUser, err := bookHandler.book.GetCurrentUser(rw, r, bookHandler.store)
-	if err != nil {
-		data.ToJSON(&GenericError{Message: err.Error()}, rw)
+	currentUser := getPrincipal(r).User
 
-		return
-	}
+	// tag the following log lines with the requested book
+	addLogFields(r, "book_id", approvalReq.BookID)
+
+	// the approved room book, its new version is sent back to the client
+	var targetBook database.DBTransactionRoomBook
 
 	// proceed to create the new approval with transaction scope
-	err = config.DB.Transaction(func(tx *gorm.DB) error {
+	err := config.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
 
 		// set variables
-		var targetBook database.DBTransactionRoomBook
 		var dbErr error
 
-		// look for the requested book
-		if dbErr := config.DB.Where("id = ?", approvalReq.BookID).First(&targetBook).Error; err != nil {
-			rw.WriteHeader(http.StatusBadRequest)
+		// look for the requested book, it isn't locked so a concurrent approval or the booking expiry
+		// changing it meanwhile fails the versioned update below with a conflict
+		if dbErr := tx.Where("id = ?", approvalReq.BookID).First(&targetBook).Error; dbErr != nil {
+			dbErr = data.NotFoundError(data.MsgBookNotFound, dbErr)
+			rw.WriteHeader(transactionErrorStatus(dbErr))
 
 			return dbErr
 		}
 
 		bookedKost := &database.DBKost{}
-		if dbErr := config.DB.Where("id = ?", targetBook.KostID).First(&bookedKost).Error; err != nil {
-			rw.WriteHeader(http.StatusBadRequest)
+		if dbErr := tx.Where("id = ?", targetBook.KostID).First(&bookedKost).Error; dbErr != nil {
+			dbErr = data.NotFoundError(data.MsgKostNotFound, dbErr)
+			rw.WriteHeader(transactionErrorStatus(dbErr))
 
 			return dbErr
 		}
 
-		// occurs when transaction already been approved by the owner
-		if targetBook.Status != 0 {
+		// only owner, the kost staff or an admin can approve the book transaction in this method
+		canManage, dbErr := bookHandler.policy.CanManageKost(tx, currentUser, bookedKost)
+		if dbErr != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+
+			return dbErr
+		}
+
+		if !canManage {
 			rw.WriteHeader(http.StatusForbidden)
 
-			return fmt.Errorf("Status booking tidak valid untuk di approve")
+			return data.NewMessageError(data.MsgOwnerOnlyApproval, nil)
+		}
+
+		// the client may only approve the booking version it has read
+		if !ifMatch(r, targetBook.ETag()) {
+			rw.WriteHeader(http.StatusPreconditionFailed)
+
+			return data.NewMessageError(data.MsgPreconditionFailed, nil)
 		}
 
-		// only owner can approve the book transaction in this method
-		if currentUser.ID != bookedKost.OwnerID {
+		// occurs when transaction already been approved by the owner
+		if targetBook.Status != 0 {
 			rw.WriteHeader(http.StatusForbidden)
 
-			return fmt.Errorf("Hanya owner kost yang bisa approve book ini")
+			return data.NewMessageError(data.MsgInvalidBookStatus, nil)
 		}
 
 		// TODO: buat dokumentasi
@@ -75,11 +93,26 @@ func (bookHandler *BookHandler) OwnerApprovalBookTransaction(rw http.ResponseWri
 			targetBook.ModifiedBy = currentUser.Username
 		}
 
-		// update the room book
-		dbErr = config.DB.Save(targetBook).Error
+		// update the room book unless another request changed it meanwhile
+		dbErr = bookHandler.book.UpdateRoomBook(tx, currentUser, &targetBook)
 
 		if dbErr != nil {
-			rw.WriteHeader(http.StatusBadRequest)
+			rw.WriteHeader(transactionErrorStatus(dbErr))
+
+			return dbErr
+		}
+
+		// tell the tenant about the owner decision once committed
+		eventType := entities.EventBookOwnerRejected
+		if approvalReq.FlagApproval == true {
+			eventType = entities.EventBookOwnerApproved
+		}
+
+		bookEvent := newBookEvent(eventType, &targetBook, bookedKost, currentUser)
+		dbErr = outbox.AddBookEvent(tx, currentUser, outbox.BookEventKey(&bookEvent), bookEvent)
+
+		if dbErr != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
 
 			return dbErr
 		}
@@ -90,17 +123,26 @@ func (bookHandler *BookHandler) OwnerApprovalBookTransaction(rw http.ResponseWri
 
 	// if transaction error
 	if err != nil {
-		data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		bookHandler.logTransactionError(r, err)
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
 
 		return
 	}
 
-	// TODO: send notification
+	// count the owner decision
+	if approvalReq.FlagApproval == true {
+		metrics.BookTransition(metrics.BookStatus(0), 1, 1)
+	} else {
+		metrics.BookTransition(metrics.BookStatus(0), 3, 1)
+	}
+
+	// send the new version of the booking for the next conditional request
+	rw.Header().Set("ETag", targetBook.ETag())
 
 	if approvalReq.FlagApproval == true {
-		data.ToJSON(&GenericError{Message: "Sukses Approve booking"}, rw)
+		bookHandler.writeMessage(rw, r, data.MsgBookApproved)
 	} else {
-		data.ToJSON(&GenericError{Message: "Sukses Reject booking"}, rw)
+		bookHandler.writeMessage(rw, r, data.MsgBookRejected)
 	}
 
 	return
@@ -111,57 +153,76 @@ func (bookHandler *BookHandler) OwnerApprovalBookTransaction(rw http.ResponseWri
 func (bookHandler *BookHandler) TenantApprovalBookTransaction(rw http.ResponseWriter, r *http.Request) {
 
 	// get the approval via context
-	approvalReq := r.Context().Value(KeyApproval{}).(*entities.ApprovalRoomBook)
+	approvalReq := r.Context().Value(KeyApproval{}).(*entities.ApprovalRoomBookRequest)
 
 	// get the current user login
-	var currentUser *database.MasterUser
-	currentUser, err := bookHandler.book.GetCurrentUser(rw, r, bookHandler.store)
-	if err != nil {
-		data.ToJSON(&GenericError{Message: err.Error()}, rw)
+	currentUser := getPrincipal(r).User
 
-		return
-	}
+	// tag the following log lines with the requested book
+	addLogFields(r, "book_id", approvalReq.BookID)
+
+	// the approved room book, its new version is sent back to the client
+	var targetBook database.DBTransactionRoomBook
 
 	// proceed to create the new approval with transaction scope
-	err = config.DB.Transaction(func(tx *gorm.DB) error {
+	err := config.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
 
 		// set variables
-		var targetBook database.DBTransactionRoomBook
 		var targetTransaction database.DBTransaction
 		var targetTransactionDetail database.DBTransactionDetail
 		var dbErr error
 
-		// look for the requested book
-		if dbErr := config.DB.Where("id = ?", approvalReq.BookID).First(&targetBook).Error; err != nil {
-			rw.WriteHeader(http.StatusBadRequest)
+		// look for the requested book, it isn't locked so a concurrent approval or the booking expiry
+		// changing it meanwhile fails the versioned update below with a conflict
+		if dbErr := tx.Where("id = ?", approvalReq.BookID).First(&targetBook).Error; dbErr != nil {
+			dbErr = data.NotFoundError(data.MsgBookNotFound, dbErr)
+			rw.WriteHeader(transactionErrorStatus(dbErr))
 
 			return dbErr
 		}
 
+		// only tenant or an admin can approve the book transaction in this method
+		if !bookHandler.policy.CanActAsTenant(currentUser, &targetBook) {
+			rw.WriteHeader(http.StatusForbidden)
+
+			return data.NewMessageError(data.MsgTenantOnlyApproval, nil)
+		}
+
+		// the client may only approve the booking version it has read
+		if !ifMatch(r, targetBook.ETag()) {
+			rw.WriteHeader(http.StatusPreconditionFailed)
+
+			return data.NewMessageError(data.MsgPreconditionFailed, nil)
+		}
+
 		// occurs when transaction already been approved by the tenant
 		if targetBook.Status != 1 {
 			rw.WriteHeader(http.StatusForbidden)
 
-			return fmt.Errorf("Status booking tidak valid untuk di approve")
+			return data.NewMessageError(data.MsgInvalidBookStatus, nil)
 		}
 
-		// only tenant can approve the book transaction in this method
-		if currentUser.ID != targetBook.BookerID {
-			rw.WriteHeader(http.StatusForbidden)
+		// look for the booked kost to notify its owner
+		bookedKost := &database.DBKost{}
+		if dbErr := tx.Where("id = ?", targetBook.KostID).First(&bookedKost).Error; dbErr != nil {
+			dbErr = data.NotFoundError(data.MsgKostNotFound, dbErr)
+			rw.WriteHeader(transactionErrorStatus(dbErr))
 
-			return fmt.Errorf("Hanya tenant kost yang bisa approve book ini")
+			return dbErr
 		}
 
 		// look for the base transaction
-		if dbErr := config.DB.Where("trx_reference_id = ?", targetBook.ID).First(&targetTransaction).Error; err != nil {
-			rw.WriteHeader(http.StatusBadRequest)
+		if dbErr := tx.Where("trx_reference_id = ?", targetBook.ID).First(&targetTransaction).Error; dbErr != nil {
+			dbErr = data.NotFoundError(data.MsgTransactionNotFound, dbErr)
+			rw.WriteHeader(transactionErrorStatus(dbErr))
 
 			return dbErr
 		}
 
 		// look for the base transaction detail
-		if dbErr := config.DB.Where("trx_id = ?", targetTransaction.ID).First(&targetTransactionDetail).Error; dbErr != nil {
-			rw.WriteHeader(http.StatusBadRequest)
+		if dbErr := tx.Where("trx_id = ?", targetTransaction.ID).First(&targetTransactionDetail).Error; dbErr != nil {
+			dbErr = data.NotFoundError(data.MsgTransactionNotFound, dbErr)
+			rw.WriteHeader(transactionErrorStatus(dbErr))
 
 			return dbErr
 		}
@@ -179,11 +240,11 @@ func (bookHandler *BookHandler) TenantApprovalBookTransaction(rw http.ResponseWr
 			targetBook.ModifiedBy = currentUser.Username
 		}
 
-		// update the room book
-		dbErr = config.DB.Save(targetBook).Error
+		// update the room book unless another request changed it meanwhile
+		dbErr = bookHandler.book.UpdateRoomBook(tx, currentUser, &targetBook)
 
 		if dbErr != nil {
-			rw.WriteHeader(http.StatusBadRequest)
+			rw.WriteHeader(transactionErrorStatus(dbErr))
 
 			return dbErr
 		}
@@ -193,11 +254,11 @@ func (bookHandler *BookHandler) TenantApprovalBookTransaction(rw http.ResponseWr
 			targetTransaction.PaidOff = targetTransaction.PaidOff + targetTransactionDetail.Payment
 		}
 
-		// add the base transaction to the database
-		dbErr = bookHandler.book.UpdateTransaction(currentUser, &targetTransaction)
+		// update the base transaction unless another request changed it meanwhile
+		dbErr = bookHandler.book.UpdateTransaction(tx, currentUser, &targetTransaction)
 
 		if dbErr != nil {
-			rw.WriteHeader(http.StatusBadRequest)
+			rw.WriteHeader(transactionErrorStatus(dbErr))
 
 			return dbErr
 		}
@@ -211,33 +272,73 @@ func (bookHandler *BookHandler) TenantApprovalBookTransaction(rw http.ResponseWr
 		}
 
 		// add the base transaction to the database
-		dbErr = bookHandler.book.UpdateTransactionDetail(currentUser, &targetTransactionDetail)
+		dbErr = bookHandler.book.UpdateTransactionDetail(tx, currentUser, &targetTransactionDetail)
 
 		if dbErr != nil {
-			rw.WriteHeader(http.StatusBadRequest)
+			rw.WriteHeader(transactionErrorStatus(dbErr))
 
 			return dbErr
 		}
 
+		// tell the kost owner about the tenant confirmation once committed
+		eventType := entities.EventBookTenantRejected
+		if approvalReq.FlagApproval == true {
+			eventType = entities.EventBookTenantApproved
+		}
+
+		bookEvent := newBookEvent(eventType, &targetBook, bookedKost, currentUser)
+		bookEvent.Amount = targetTransactionDetail.Payment
+		dbErr = outbox.AddBookEvent(tx, currentUser, outbox.BookEventKey(&bookEvent), bookEvent)
+
+		if dbErr != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+
+			return dbErr
+		}
+
+		// the booking payment is received once the tenant confirms
+		if approvalReq.FlagApproval == true {
+			paymentEvent := bookEvent
+			paymentEvent.Type = entities.EventPaymentReceived
+			dbErr = outbox.AddBookEvent(tx, currentUser, entities.EventPaymentReceived+":"+strconv.FormatUint(uint64(targetTransactionDetail.ID), 10), paymentEvent)
+
+			if dbErr != nil {
+				rw.WriteHeader(http.StatusInternalServerError)
+
+				return dbErr
+			}
+		}
+
 		return nil
 
 	})
 
 	// if transaction error
 	if err != nil {
-		data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		bookHandler.logTransactionError(r, err)
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
 
 		return
 	}
 
-	// TODO: send notification
+	// count the tenant decision along with its payment
+	if approvalReq.FlagApproval == true {
+		metrics.BookTransition(metrics.BookStatus(1), 2, 1)
+	} else {
+		metrics.BookTransition(metrics.BookStatus(1), 3, 1)
+	}
+
+	metrics.PaymentApproval(approvalReq.FlagApproval)
+
+	// send the new version of the booking for the next conditional request
+	rw.Header().Set("ETag", targetBook.ETag())
 
 	// send status ok if reach this point
 	rw.WriteHeader(http.StatusOK)
 	if approvalReq.FlagApproval == true {
-		data.ToJSON(&GenericError{Message: "Sukses Approve booking"}, rw)
+		bookHandler.writeMessage(rw, r, data.MsgBookApproved)
 	} else {
-		data.ToJSON(&GenericError{Message: "Sukses Reject booking"}, rw)
+		bookHandler.writeMessage(rw, r, data.MsgBookRejected)
 	}
 
 	return
@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/fakhripraya/book-service/data"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"gorm.io/gorm"
+)
+
+// approve sends an approval of the given book made by the given user to the given approval handler
+func approve(handler http.HandlerFunc, user *database.MasterUser, bookID uint, ifMatch string) *httptest.ResponseRecorder {
+	r := newPrincipalRequest(http.MethodPatch, "/approve", user)
+	if ifMatch != "" {
+		r.Header.Set("If-Match", ifMatch)
+	}
+
+	approvalReq := &entities.ApprovalRoomBookRequest{BookID: bookID, FlagApproval: true}
+	r = r.WithContext(context.WithValue(r.Context(), KeyApproval{}, approvalReq))
+
+	rw := httptest.NewRecorder()
+	handler(rw, r)
+
+	return rw
+}
+
+// countOutboxEvents returns the number of the booking events added to the outbox
+func countOutboxEvents(t *testing.T, db *gorm.DB) int64 {
+	t.Helper()
+
+	var count int64
+	if err := db.Model(&database.DBOutboxEvent{}).Count(&count).Error; err != nil {
+		t.Fatalf("count the outbox events: %v", err)
+	}
+
+	return count
+}
+
+// seedTransaction inserts the booking transaction of the given book along with its payment
+func seedTransaction(t *testing.T, db *gorm.DB, book *database.DBTransactionRoomBook) *database.DBTransaction {
+	t.Helper()
+
+	transaction := &database.DBTransaction{TrxReferenceID: book.ID, TrxCategory: data.TrxCategoryBooking, MustPay: 1500000, Version: 1, IsActive: true}
+	if err := db.Create(transaction).Error; err != nil {
+		t.Fatalf("seed the transaction: %v", err)
+	}
+
+	if err := db.Create(&database.DBTransactionDetail{TrxID: transaction.ID, PaymentMethodID: 1, Payment: 500000, IsActive: true}).Error; err != nil {
+		t.Fatalf("seed the transaction detail: %v", err)
+	}
+
+	return transaction
+}
+
+// bumpVersionBeforeUpdate commits a change of the given row of the given table once,
+// between the read and the update of the request under test
+func bumpVersionBeforeUpdate(t *testing.T, db *gorm.DB, table string, id uint) {
+	t.Helper()
+
+	changed := false
+	err := db.Callback().Update().Before("gorm:update").Register("test:concurrent_change", func(tx *gorm.DB) {
+		if changed || tx.Statement.Table != table {
+			return
+		}
+
+		changed = true
+		tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE "+table+" SET version = version + 1 WHERE id = ?", id)
+	})
+	if err != nil {
+		t.Fatalf("register the concurrent change: %v", err)
+	}
+}
+
+// raceRequests sends the given requests at once on a database of newRaceTestDB and returns their status codes,
+// every request reads the room book before any goes on, then the first update of the room book runs alone
+// and the other requests only update it once the first request is done
+func raceRequests(t *testing.T, db *gorm.DB, requests ...func() int) []int {
+	t.Helper()
+
+	var mu sync.Mutex
+	reads, updates := 0, 0
+	allRead := make(chan struct{})
+	firstDone := make(chan struct{})
+	var once sync.Once
+
+	err := db.Callback().Query().After("gorm:query").Register("test:read_barrier", func(tx *gorm.DB) {
+		if tx.Statement.Table != "db_transaction_room_books" {
+			return
+		}
+
+		mu.Lock()
+		reads++
+		if reads == len(requests) {
+			close(allRead)
+		}
+		mu.Unlock()
+
+		<-allRead
+	})
+	if err != nil {
+		t.Fatalf("register the read barrier: %v", err)
+	}
+
+	err = db.Callback().Update().Before("gorm:update").Register("test:update_order", func(tx *gorm.DB) {
+		if tx.Statement.Table != "db_transaction_room_books" {
+			return
+		}
+
+		mu.Lock()
+		updates++
+		first := updates == 1
+		mu.Unlock()
+
+		if !first {
+			<-firstDone
+		}
+	})
+	if err != nil {
+		t.Fatalf("register the update order: %v", err)
+	}
+
+	codes := make([]int, len(requests))
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = requests[i]()
+			once.Do(func() { close(firstDone) })
+		}(i)
+	}
+	wg.Wait()
+
+	return codes
+}
+
+// readBook returns the stored state of the given room book
+func readBook(t *testing.T, db *gorm.DB, id uint) *database.DBTransactionRoomBook {
+	t.Helper()
+
+	var stored database.DBTransactionRoomBook
+	if err := db.First(&stored, id).Error; err != nil {
+		t.Fatalf("read the room book: %v", err)
+	}
+
+	return &stored
+}
+
+func TestOwnerApprovalConcurrentApprovals(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 0)
+	etag := book.ETag()
+
+	// both approvals were made on the same read of the booking
+	codes := make([]int, 2)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = approve(bookHandler.OwnerApprovalBookTransaction, testOwner, book.ID, etag).Code
+		}(i)
+	}
+	wg.Wait()
+
+	sort.Ints(codes)
+	if codes[0] != http.StatusOK || codes[1] != http.StatusPreconditionFailed {
+		t.Fatalf("expected one 200 and one 412, got %v", codes)
+	}
+
+	var stored database.DBTransactionRoomBook
+	if err := db.First(&stored, book.ID).Error; err != nil {
+		t.Fatalf("read the room book: %v", err)
+	}
+
+	if stored.Status != 1 || stored.Version != 2 {
+		t.Fatalf("expected the room book approved once at version 2, got status %d version %d", stored.Status, stored.Version)
+	}
+
+	if count := countOutboxEvents(t, db); count != 1 {
+		t.Fatalf("expected a single outbox event, got %d", count)
+	}
+}
+
+func TestOwnerApprovalRace(t *testing.T) {
+	db := newRaceTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 0)
+
+	// both approvals read the booking before either updates it, neither sends If-Match
+	ownerApproval := func() int {
+		return approve(bookHandler.OwnerApprovalBookTransaction, testOwner, book.ID, "").Code
+	}
+	codes := raceRequests(t, db, ownerApproval, ownerApproval)
+
+	sort.Ints(codes)
+	if codes[0] != http.StatusOK || codes[1] != http.StatusConflict {
+		t.Fatalf("expected one 200 and one 409, got %v", codes)
+	}
+
+	if stored := readBook(t, db, book.ID); stored.Status != 1 || stored.Version != 2 {
+		t.Fatalf("expected the room book approved once at version 2, got status %d version %d", stored.Status, stored.Version)
+	}
+
+	if count := countOutboxEvents(t, db); count != 1 {
+		t.Fatalf("expected a single outbox event, got %d", count)
+	}
+}
+
+func TestOwnerApprovalStaleVersion(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 0)
+
+	// another request commits a change of the booking between the read and the update of the approval
+	bumpVersionBeforeUpdate(t, db, "db_transaction_room_books", book.ID)
+
+	rw := approve(bookHandler.OwnerApprovalBookTransaction, testOwner, book.ID, "")
+	if rw.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	if rw.Header().Get("ETag") != "" {
+		t.Fatalf("expected no ETag on a conflict, got %s", rw.Header().Get("ETag"))
+	}
+
+	if count := countOutboxEvents(t, db); count != 0 {
+		t.Fatalf("expected the outbox event rolled back, got %d", count)
+	}
+}
+
+func TestTenantApprovalRace(t *testing.T) {
+	db := newRaceTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 1)
+	transaction := seedTransaction(t, db, book)
+
+	// both confirmations read the booking before either updates it, neither sends If-Match
+	tenantApproval := func() int {
+		return approve(bookHandler.TenantApprovalBookTransaction, testTenant, book.ID, "").Code
+	}
+	codes := raceRequests(t, db, tenantApproval, tenantApproval)
+
+	sort.Ints(codes)
+	if codes[0] != http.StatusOK || codes[1] != http.StatusConflict {
+		t.Fatalf("expected one 200 and one 409, got %v", codes)
+	}
+
+	if stored := readBook(t, db, book.ID); stored.Status != 2 || stored.Version != 2 {
+		t.Fatalf("expected the room book confirmed once at version 2, got status %d version %d", stored.Status, stored.Version)
+	}
+
+	// the payment is only counted once
+	var stored database.DBTransaction
+	if err := db.First(&stored, transaction.ID).Error; err != nil {
+		t.Fatalf("read the transaction: %v", err)
+	}
+
+	if stored.PaidOff != 500000 || stored.Version != 2 {
+		t.Fatalf("expected the payment counted once at version 2, got %v at version %d", stored.PaidOff, stored.Version)
+	}
+}
+
+func TestOwnerAndTenantDecisionsRace(t *testing.T) {
+	db := newRaceTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 0)
+	seedTransaction(t, db, book)
+
+	// the tenant confirms on the same read as the owner approval, before the owner approved
+	codes := raceRequests(t, db,
+		func() int { return approve(bookHandler.OwnerApprovalBookTransaction, testOwner, book.ID, "").Code },
+		func() int { return approve(bookHandler.TenantApprovalBookTransaction, testTenant, book.ID, "").Code },
+	)
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusForbidden {
+		t.Fatalf("expected the owner approval and not the tenant confirmation, got %v", codes)
+	}
+
+	stored := readBook(t, db, book.ID)
+	if stored.Status != 1 || stored.Version != 2 {
+		t.Fatalf("expected the room book pending the tenant at version 2, got status %d version %d", stored.Status, stored.Version)
+	}
+
+	// the tenant confirms the approved booking once it read it again
+	rw := approve(bookHandler.TenantApprovalBookTransaction, testTenant, book.ID, stored.ETag())
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected the tenant confirmation of the approved booking, got %d: %s", rw.Code, rw.Body.String())
+	}
+}
+
+func TestTenantApprovalStaleTransaction(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 1)
+	transaction := seedTransaction(t, db, book)
+
+	// another request commits a change of the transaction between the read and the update of the confirmation
+	bumpVersionBeforeUpdate(t, db, "db_transactions", transaction.ID)
+
+	rw := approve(bookHandler.TenantApprovalBookTransaction, testTenant, book.ID, "")
+	if rw.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	// the room book update made before is rolled back along with the events
+	if stored := readBook(t, db, book.ID); stored.Status != 1 || stored.Version != 1 {
+		t.Fatalf("expected the room book left pending at version 1, got status %d version %d", stored.Status, stored.Version)
+	}
+
+	if count := countOutboxEvents(t, db); count != 0 {
+		t.Fatalf("expected the outbox events rolled back, got %d", count)
+	}
+}
+
+func TestOwnerApprovalIfMatch(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 0)
+
+	// a user who can't manage the kost learns nothing about the booking version
+	rw := approve(bookHandler.OwnerApprovalBookTransaction, testStranger, book.ID, `"0-0"`)
+	if rw.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a stranger, got %d", rw.Code)
+	}
+
+	rw = approve(bookHandler.OwnerApprovalBookTransaction, testOwner, book.ID, `"0-0"`)
+	if rw.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for a stale If-Match, got %d", rw.Code)
+	}
+
+	rw = approve(bookHandler.OwnerApprovalBookTransaction, testOwner, book.ID, book.ETag())
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a matching If-Match, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	book.Version++
+	if etag := rw.Header().Get("ETag"); etag != book.ETag() {
+		t.Fatalf("expected the new ETag %s, got %s", book.ETag(), etag)
+	}
+}
+
+func TestTenantApprovalIfMatch(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 1)
+
+	// a user who isn't the booker learns nothing about the booking version
+	rw := approve(bookHandler.TenantApprovalBookTransaction, testStranger, book.ID, `"0-0"`)
+	if rw.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a stranger, got %d", rw.Code)
+	}
+
+	rw = approve(bookHandler.TenantApprovalBookTransaction, testTenant, book.ID, `"0-0"`)
+	if rw.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for a stale If-Match, got %d", rw.Code)
+	}
+}
@@ -2,12 +2,15 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/fakhripraya/book-service/config"
 	"github.com/fakhripraya/book-service/data"
 	"github.com/fakhripraya/book-service/database"
 	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/metrics"
+	"github.com/fakhripraya/book-service/outbox"
 	"gorm.io/gorm"
 )
 
@@ -17,35 +20,47 @@ func (bookHandler *BookHandler) AddBook(rw http.ResponseWriter, r *http.Request)
 	//TODO: Bikin validasi bila kamar sudah di book
 	//TODO: Bikin validasi tidak bisa book bila kost milik user(dia ownernya)
 	// get the book via context
-	bookReq := r.Context().Value(KeyBook{}).(*entities.TransactionRoomBook)
+	bookReq := r.Context().Value(KeyBook{}).(*entities.TransactionRoomBookRequest)
 
 	// get the current user login
-	var currentUser *database.MasterUser
-	currentUser, err := bookHandler.book.GetCurrentUser(rw, r, bookHandler.store)
+	currentUser := getPrincipal(r).User
+
+	// the amount due is derived from the room price, the client only tells how much it pays
+	mustPay, err := bookHandler.book.GetRoomPrice(config.DB.WithContext(r.Context()), bookReq.KostID, bookReq.RoomID, bookReq.RoomDetailID, bookReq.PeriodID)
 	if err != nil {
-		data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		bookHandler.logTransactionError(r, err)
+		rw.WriteHeader(transactionErrorStatus(err))
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
+
+		return
+	}
+
+	if bookReq.Payment > mustPay {
+		language := getLanguage(r)
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		bookHandler.writeValidationError(rw, r, data.ValidationErrors{{
+			Field:   "Payment",
+			Rule:    "lte",
+			Message: data.Translate(language, data.MsgFieldMax, "Payment", strconv.FormatFloat(mustPay, 'f', -1, 64)),
+		}})
 
 		return
 	}
 
 	// proceed to create the new book with transaction scope
-	err = config.DB.Transaction(func(tx *gorm.DB) error {
+	err = config.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
 
 		// set variables
-		var newBook database.DBTransactionRoomBook
+		var newBook = bookReq.ToDBTransactionRoomBook()
 		var kostTarget database.DBKost
 		var dbErr error
 
 		// look for the target kost to book
-		if dbErr = config.DB.Where("id = ?", bookReq.KostID).First(&kostTarget).Error; err != nil {
-			return dbErr
+		if dbErr = tx.Where("id = ?", bookReq.KostID).First(&kostTarget).Error; dbErr != nil {
+			return data.NotFoundError(data.MsgKostNotFound, dbErr)
 		}
 
 		newBook.BookerID = currentUser.ID
-		newBook.KostID = bookReq.KostID
-		newBook.RoomID = bookReq.RoomID
-		newBook.RoomDetailID = bookReq.RoomDetailID
-		newBook.PeriodID = bookReq.PeriodID
 		newBook.Status = 0 // status 0 = baru // TODO: create a documented status later
 		newBook.BookCode, dbErr = bookHandler.book.GenerateCode("K", kostTarget.Country[0:1], kostTarget.City[0:1])
 
@@ -53,7 +68,6 @@ func (bookHandler *BookHandler) AddBook(rw http.ResponseWriter, r *http.Request)
 			return dbErr
 		}
 
-		newBook.BookDate = bookReq.BookDate
 		newBook.IsActive = true
 		newBook.Created = time.Now().Local()
 		newBook.CreatedBy = currentUser.Username
@@ -65,28 +79,30 @@ func (bookHandler *BookHandler) AddBook(rw http.ResponseWriter, r *http.Request)
 			return dbErr
 		}
 
+		addLogFields(r, "book_id", newBook.ID)
+
 		// add the verification data to the database
-		dbErr = bookHandler.book.AddVerificationPhoto(currentUser, newBook.ID, bookReq.VerificationData)
+		dbErr = bookHandler.book.AddVerificationPhoto(tx, currentUser, newBook.ID, bookReq.VerificationData.ToDBTransactionVerification())
 
 		if dbErr != nil {
 			return dbErr
 		}
 
 		// add the room book member to the database
-		dbErr = bookHandler.book.AddRoomBookMember(currentUser, newBook.ID, bookReq.Members)
+		dbErr = bookHandler.book.AddRoomBookMember(tx, currentUser, newBook.ID, bookReq.ToDBTransactionRoomBookMembers())
 
 		if dbErr != nil {
 			return dbErr
 		}
 
 		// add the base transaction to the database with transaction scope
-		dbErr = config.DB.Transaction(func(tx *gorm.DB) error {
+		dbErr = tx.Transaction(func(tx *gorm.DB) error {
 
 			var dbErr2 error
 
 			// add the base transaction to the database
 			var trxID uint
-			trxID, dbErr2 = bookHandler.book.AddTransaction(currentUser, newBook.ID, 0, bookReq.MustPay) // TODO: 0 adalah kategori, bikin dokumentasi ntr
+			trxID, dbErr2 = bookHandler.book.AddTransaction(tx, currentUser, newBook.ID, data.TrxCategoryBooking, mustPay)
 
 			if dbErr2 != nil {
 				return dbErr2
@@ -94,7 +110,7 @@ func (bookHandler *BookHandler) AddBook(rw http.ResponseWriter, r *http.Request)
 
 			// insert the new transaction detail to database
 			// status 0 cause its not yet approved/rejected by the transaction endpoint
-			dbErr2 = bookHandler.book.AddTransactionDetail(currentUser, 0, trxID, bookReq.PaymentMethodID, bookReq.Payment)
+			dbErr2 = bookHandler.book.AddTransactionDetail(tx, currentUser, 0, trxID, bookReq.PaymentMethodID, bookReq.Payment)
 
 			if dbErr2 != nil {
 				return dbErr2
@@ -108,21 +124,95 @@ func (bookHandler *BookHandler) AddBook(rw http.ResponseWriter, r *http.Request)
 			return dbErr
 		}
 
-		return nil
+		// tell the kost owner about the new booking once committed
+		bookEvent := newBookEvent(entities.EventBookCreated, &newBook, &kostTarget, currentUser)
+
+		return outbox.AddBookEvent(tx, currentUser, outbox.BookEventKey(&bookEvent), bookEvent)
 
 	})
 
 	// if transaction error
 	if err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
-		data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		bookHandler.logTransactionError(r, err)
+		rw.WriteHeader(transactionErrorStatus(err))
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
 
 		return
 	}
 
+	metrics.BookTransition(metrics.BookStatusNew, 0, 1)
+
 	// return status ok if reach this point
 	rw.WriteHeader(http.StatusOK)
-	data.ToJSON(&GenericError{Message: "Sukses request booking"}, rw)
+	bookHandler.writeMessage(rw, r, data.MsgBookRequested)
+	return
+
+}
+
+// Logout is a method to revoke the current token and clear the session
+func (bookHandler *BookHandler) Logout(rw http.ResponseWriter, r *http.Request) {
+
+	// get the current principal
+	principal := getPrincipal(r)
+
+	// add the current token to the denylist
+	err := bookHandler.book.RevokeToken(r.Context(), principal.Claims)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
+
+		return
+	}
+
+	bookHandler.clearSession(rw, r, principal)
+
+	rw.WriteHeader(http.StatusOK)
+	bookHandler.writeMessage(rw, r, data.MsgLoggedOut)
 	return
 
 }
+
+// LogoutEverywhere is a method to revoke every token of the current user, logging the user out from every device
+func (bookHandler *BookHandler) LogoutEverywhere(rw http.ResponseWriter, r *http.Request) {
+
+	// get the current principal
+	principal := getPrincipal(r)
+
+	// add every token of the current user to the denylist
+	err := bookHandler.book.RevokeAllTokens(r.Context(), principal.Claims)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
+
+		return
+	}
+
+	// the user is resolved again on the next login
+	bookHandler.book.InvalidatePrincipal(principal.Claims.PrincipalKey())
+
+	bookHandler.clearSession(rw, r, principal)
+
+	rw.WriteHeader(http.StatusOK)
+	bookHandler.writeMessage(rw, r, data.MsgLoggedOutAll)
+	return
+
+}
+
+// clearSession removes the session cookie of a principal authenticated by session
+func (bookHandler *BookHandler) clearSession(rw http.ResponseWriter, r *http.Request, principal *data.Principal) {
+	if principal.Source != data.PrincipalSourceSession {
+		return
+	}
+
+	session, err := bookHandler.getSession(r)
+	if err != nil {
+		bookHandler.requestLogger(r).Error("Unable to read the session to clear", "error", err)
+
+		return
+	}
+
+	session.Options.MaxAge = -1
+	if err := bookHandler.saveSession(rw, r, session); err != nil {
+		bookHandler.requestLogger(r).Error("Unable to clear the session", "error", err)
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"gorm.io/gorm"
+)
+
+// seedRoom inserts a monthly priced room of the given kost offering the monthly period
+func seedRoom(t *testing.T, db *gorm.DB, kostID uint, price float64) entities.TransactionRoomBookRequest {
+	t.Helper()
+
+	period := &database.MasterPeriod{PeriodDesc: "Monthly", IsActive: true, Created: time.Now(), Modified: time.Now()}
+	if err := db.Create(period).Error; err != nil {
+		t.Fatalf("seed the period: %v", err)
+	}
+
+	if err := db.Create(&database.DBKostPeriod{KostID: kostID, PeriodID: period.ID, IsActive: true}).Error; err != nil {
+		t.Fatalf("seed the kost period: %v", err)
+	}
+
+	room := &database.DBKostRoom{KostID: kostID, RoomPrice: price, RoomPriceUOM: period.ID, IsActive: true}
+	if err := db.Create(room).Error; err != nil {
+		t.Fatalf("seed the room: %v", err)
+	}
+
+	roomDetail := &database.DBKostRoomDetail{KostID: kostID, RoomID: room.ID, RoomNumber: "A1", IsActive: true}
+	if err := db.Create(roomDetail).Error; err != nil {
+		t.Fatalf("seed the room detail: %v", err)
+	}
+
+	return entities.TransactionRoomBookRequest{
+		KostID:          kostID,
+		RoomID:          room.ID,
+		RoomDetailID:    roomDetail.ID,
+		PaymentMethodID: 1,
+		PeriodID:        period.ID,
+		BookDate:        time.Now().Add(24 * time.Hour),
+		Members:         []entities.TransactionRoomBookMemberRequest{{MemberName: "Tenant"}},
+	}
+}
+
+// addBook sends the given book request made by the given user to AddBook
+func addBook(bookHandler *BookHandler, user *database.MasterUser, bookReq *entities.TransactionRoomBookRequest) *httptest.ResponseRecorder {
+	r := newPrincipalRequest(http.MethodPost, "/add", user)
+	r = r.WithContext(context.WithValue(r.Context(), KeyBook{}, bookReq))
+
+	rw := httptest.NewRecorder()
+	bookHandler.AddBook(rw, r)
+
+	return rw
+}
+
+func TestAddBookDerivesMustPay(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 0)
+	bookReq := seedRoom(t, db, book.KostID, 1500000)
+	bookReq.Payment = 500000
+
+	rw := addBook(bookHandler, testTenant, &bookReq)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	var transaction database.DBTransaction
+	if err := db.Order("id desc").First(&transaction).Error; err != nil {
+		t.Fatalf("read the transaction: %v", err)
+	}
+
+	if transaction.MustPay != 1500000 {
+		t.Fatalf("expected the room price as the amount due, got %v", transaction.MustPay)
+	}
+}
+
+func TestAddBookRefusesOverpayment(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 0)
+	bookReq := seedRoom(t, db, book.KostID, 1500000)
+	bookReq.Payment = 1500001
+
+	rw := addBook(bookHandler, testTenant, &bookReq)
+	if rw.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	var count int64
+	if err := db.Model(&database.DBTransaction{}).Count(&count).Error; err != nil {
+		t.Fatalf("count the transactions: %v", err)
+	}
+
+	if count != 0 {
+		t.Fatalf("expected no transaction, got %d", count)
+	}
+}
+
+func TestAddBookUnknownRoom(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 0)
+	bookReq := seedRoom(t, db, book.KostID, 1500000)
+	bookReq.RoomID++
+
+	rw := addBook(bookHandler, testTenant, &bookReq)
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rw.Code, rw.Body.String())
+	}
+}
+
+func TestAddBookDatabaseFailure(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newTestBookHandler()
+	book := seedBook(t, db, 0)
+	bookReq := seedRoom(t, db, book.KostID, 1500000)
+	bookReq.Payment = 500000
+
+	// a database failure is not the client's fault, the retry of the request must be handled again
+	if err := db.Migrator().DropTable(&database.DBTransaction{}); err != nil {
+		t.Fatalf("drop the transaction table: %v", err)
+	}
+
+	rw := addBook(bookHandler, testTenant, &bookReq)
+	if rw.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d: %s", rw.Code, rw.Body.String())
+	}
+}
+
+// sendWithBearer sends a request with the given bearer token to the given handler behind the auth middleware
+func sendWithBearer(bookHandler *BookHandler, handler http.HandlerFunc, method, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/book", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	rw := httptest.NewRecorder()
+	bookHandler.MiddlewareValidateAuth(handler).ServeHTTP(rw, r)
+
+	return rw
+}
+
+func TestLogoutRevokesOnlyTheCurrentToken(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newAuthBookHandler(t, db)
+	ok := func(rw http.ResponseWriter, r *http.Request) {}
+
+	current := signBearerToken(t, testTenant.Username, time.Now().Add(time.Hour))
+	otherDevice := signBearerToken(t, testTenant.Username, time.Now().Add(2*time.Hour))
+
+	if rw := sendWithBearer(bookHandler, bookHandler.Logout, http.MethodPost, current); rw.Code != http.StatusOK {
+		t.Fatalf("expected the logout to succeed, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	if rw := sendWithBearer(bookHandler, ok, http.MethodGet, current); rw.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the logged out token refused, got %d", rw.Code)
+	}
+
+	if rw := sendWithBearer(bookHandler, ok, http.MethodGet, otherDevice); rw.Code != http.StatusOK {
+		t.Fatalf("expected the other device still logged in, got %d", rw.Code)
+	}
+}
+
+func TestLogoutEverywhereRevokesEveryToken(t *testing.T) {
+	db := newTestDB(t)
+	bookHandler := newAuthBookHandler(t, db)
+	ok := func(rw http.ResponseWriter, r *http.Request) {}
+
+	current := signBearerTokenAt(t, testTenant.Username, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+	otherDevice := signBearerTokenAt(t, testTenant.Username, time.Now().Add(-2*time.Minute), time.Now().Add(time.Hour))
+
+	if rw := sendWithBearer(bookHandler, bookHandler.LogoutEverywhere, http.MethodPost, current); rw.Code != http.StatusOK {
+		t.Fatalf("expected the logout to succeed, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	for _, token := range []string{current, otherDevice} {
+		if rw := sendWithBearer(bookHandler, ok, http.MethodGet, token); rw.Code != http.StatusUnauthorized {
+			t.Fatalf("expected the tokens issued before the logout refused, got %d", rw.Code)
+		}
+	}
+
+	// a login after the logout is valid
+	relogin := signBearerToken(t, testTenant.Username, time.Now().Add(time.Hour))
+	if rw := sendWithBearer(bookHandler, ok, http.MethodGet, relogin); rw.Code != http.StatusOK {
+		t.Fatalf("expected the new login valid, got %d", rw.Code)
+	}
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/fakhripraya/book-service/data"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// maxListedDeliveries is the number of latest deliveries returned by the delivery log
+const maxListedDeliveries = 100
+
+// GetWebhooks is a method to fetch the webhook subscriptions of the current user
+func (bookHandler *BookHandler) GetWebhooks(rw http.ResponseWriter, r *http.Request) {
+
+	// get the current user login
+	currentUser := getPrincipal(r).User
+
+	// look for the subscriptions in the db
+	subscriptions, err := bookHandler.webhooks.GetSubscriptions(r.Context(), currentUser.ID)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
+
+		return
+	}
+
+	// parse the given instance to the response writer
+	err = data.ToJSON(entities.NewWebhookSubscriptionResponses(subscriptions), rw)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		bookHandler.writeError(rw, r, err, data.MsgInternalError)
+
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	return
+}
+
+// AddWebhook is a method to subscribe the current user to the booking events of their kosts
+func (bookHandler *BookHandler) AddWebhook(rw http.ResponseWriter, r *http.Request) {
+
+	// get the webhook subscription via context
+	webhookReq := r.Context().Value(KeyWebhook{}).(*entities.WebhookSubscriptionRequest)
+
+	// get the current user login
+	currentUser := getPrincipal(r).User
+
+	// only http endpoints can receive the deliveries
+	target, err := url.Parse(webhookReq.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		language := getLanguage(r)
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		bookHandler.writeValidationError(rw, r, data.ValidationErrors{{
+			Field:   "url",
+			Rule:    "url",
+			Message: data.Translate(language, data.MsgFieldURL, "url"),
+		}})
+
+		return
+	}
+
+	// the owners read the responses of their webhooks, so the deliveries must not reach the internal addresses
+	if err := bookHandler.webhooks.CheckURL(r.Context(), webhookReq.URL); err != nil {
+		bookHandler.requestLogger(r).Warn("Refused the webhook URL", "url", target.Host, "error", err)
+
+		language := getLanguage(r)
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		bookHandler.writeValidationError(rw, r, data.ValidationErrors{{
+			Field:   "url",
+			Rule:    "public_url",
+			Message: data.Translate(language, data.MsgFieldPublicURL, "url"),
+		}})
+
+		return
+	}
+
+	// create the new subscription
+	subscription, err := bookHandler.webhooks.AddSubscription(r.Context(), currentUser, webhookReq.URL, webhookReq.EventTypes)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
+
+		return
+	}
+
+	// the secret is only shown once, the owner needs it to verify the signatures
+	rw.WriteHeader(http.StatusCreated)
+	data.ToJSON(entities.NewWebhookSubscriptionResponse(subscription, true), rw)
+	return
+}
+
+// RemoveWebhook is a method to unsubscribe the given webhook of the current user
+func (bookHandler *BookHandler) RemoveWebhook(rw http.ResponseWriter, r *http.Request) {
+
+	// get the current user login
+	currentUser := getPrincipal(r).User
+
+	// get the subscription id from the path
+	subscriptionID, ok := getPathID(r, "id")
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		bookHandler.writeMessage(rw, r, data.MsgWebhookNotFound)
+
+		return
+	}
+
+	// deactivate the subscription
+	err := bookHandler.webhooks.RemoveSubscription(r.Context(), currentUser, subscriptionID)
+	if err == gorm.ErrRecordNotFound {
+		rw.WriteHeader(http.StatusNotFound)
+		bookHandler.writeMessage(rw, r, data.MsgWebhookNotFound)
+
+		return
+	}
+
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
+
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	bookHandler.writeMessage(rw, r, data.MsgWebhookRemoved)
+	return
+}
+
+// GetWebhookDeliveries is a method to fetch the delivery log of the given webhook of the current user
+func (bookHandler *BookHandler) GetWebhookDeliveries(rw http.ResponseWriter, r *http.Request) {
+
+	// get the current user login
+	currentUser := getPrincipal(r).User
+
+	// get the subscription id from the path
+	subscriptionID, ok := getPathID(r, "id")
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		bookHandler.writeMessage(rw, r, data.MsgWebhookNotFound)
+
+		return
+	}
+
+	// look for the latest deliveries of the subscription
+	deliveries, err := bookHandler.webhooks.GetDeliveries(r.Context(), currentUser.ID, subscriptionID, maxListedDeliveries)
+	if err == gorm.ErrRecordNotFound {
+		rw.WriteHeader(http.StatusNotFound)
+		bookHandler.writeMessage(rw, r, data.MsgWebhookNotFound)
+
+		return
+	}
+
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
+
+		return
+	}
+
+	// look for the requests made for every delivery
+	deliveryIDs := make([]uint, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		deliveryIDs = append(deliveryIDs, delivery.ID)
+	}
+
+	attempts, err := bookHandler.webhooks.GetAttempts(r.Context(), deliveryIDs)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
+
+		return
+	}
+
+	// parse the given instance to the response writer
+	err = data.ToJSON(entities.NewWebhookDeliveryResponses(deliveries, attempts), rw)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		bookHandler.writeError(rw, r, err, data.MsgInternalError)
+
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	return
+}
+
+// RedeliverWebhook is a method to queue the given webhook delivery of the current user again
+func (bookHandler *BookHandler) RedeliverWebhook(rw http.ResponseWriter, r *http.Request) {
+
+	// get the current user login
+	currentUser := getPrincipal(r).User
+
+	// get the delivery id from the path
+	deliveryID, ok := getPathID(r, "id")
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		bookHandler.writeMessage(rw, r, data.MsgDeliveryNotFound)
+
+		return
+	}
+
+	// queue the delivery again, the deliverer picks it up on its next poll
+	_, err := bookHandler.webhooks.Redeliver(r.Context(), currentUser, deliveryID)
+	if err == gorm.ErrRecordNotFound {
+		rw.WriteHeader(http.StatusNotFound)
+		bookHandler.writeMessage(rw, r, data.MsgDeliveryNotFound)
+
+		return
+	}
+
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		bookHandler.writeError(rw, r, err, data.MsgDatabaseError)
+
+		return
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+	bookHandler.writeMessage(rw, r, data.MsgWebhookRedelivered)
+	return
+}
+
+// getPathID returns the given numeric path variable of the request
+func getPathID(r *http.Request, name string) (uint, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)[name], 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+
+	return uint(id), true
+}
@@ -0,0 +1,147 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fakhripraya/book-service/data"
+	"github.com/hashicorp/go-hclog"
+)
+
+// Build information of the running binary, set at build time with
+// go build -ldflags "-X github.com/fakhripraya/book-service/health.Commit=<sha> -X github.com/fakhripraya/book-service/health.BuildTime=<time>"
+var (
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// Probe statuses
+const (
+	StatusOK           = "ok"
+	StatusUnavailable  = "unavailable"
+	StatusShuttingDown = "shutting_down"
+	StatusFailed       = "failed"
+)
+
+// Check reports whether a dependency of the service can be used, it must give up once the given context is done
+type Check func(ctx context.Context) error
+
+// namedCheck is a readiness check along with the name it is reported under
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Status is the body of the liveness and readiness probes
+type Status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Version is the body of the build info endpoint
+type Version struct {
+	Commit      string `json:"commit"`
+	BuildTime   string `json:"buildTime"`
+	GoVersion   string `json:"goVersion"`
+	Environment string `json:"environment"`
+}
+
+// Handler serves the liveness, readiness and build info probes of the orchestrator
+type Handler struct {
+	logger       hclog.Logger
+	environment  string
+	timeout      time.Duration
+	checks       []namedCheck
+	shuttingDown int32
+}
+
+// NewHandler is a function to create new Handler struct, every readiness check is given at most the given timeout
+func NewHandler(newLogger hclog.Logger, environment string, timeout time.Duration) *Handler {
+	return &Handler{logger: newLogger, environment: environment, timeout: timeout}
+}
+
+// AddCheck adds a dependency the service needs to serve requests, checked by every readiness probe
+func (handler *Handler) AddCheck(name string, check Check) {
+	handler.checks = append(handler.checks, namedCheck{name: name, check: check})
+}
+
+// SetShuttingDown fails every following readiness probe so no new traffic is routed to the draining replica
+func (handler *Handler) SetShuttingDown() {
+	atomic.StoreInt32(&handler.shuttingDown, 1)
+}
+
+// Healthz reports the process is alive, it doesn't check any dependency so a db outage doesn't restart every replica
+func (handler *Handler) Healthz(rw http.ResponseWriter, r *http.Request) {
+	writeStatus(rw, http.StatusOK, &Status{Status: StatusOK})
+}
+
+// Readyz reports whether the service can serve requests, running every check concurrently
+func (handler *Handler) Readyz(rw http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&handler.shuttingDown) == 1 {
+		writeStatus(rw, http.StatusServiceUnavailable, &Status{Status: StatusShuttingDown})
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), handler.timeout)
+	defer cancel()
+
+	// run the checks
+	results := make([]error, len(handler.checks))
+	var wg sync.WaitGroup
+	for i := range handler.checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = handler.checks[i].check(ctx)
+		}(i)
+	}
+
+	wg.Wait()
+
+	// the check errors are only logged, they may tell the db address
+	status := &Status{Status: StatusOK, Checks: map[string]string{}}
+	for i, err := range results {
+		if err != nil {
+			handler.logger.Warn("Readiness check failed", "check", handler.checks[i].name, "error", err)
+			status.Status = StatusUnavailable
+			status.Checks[handler.checks[i].name] = StatusFailed
+
+			continue
+		}
+
+		status.Checks[handler.checks[i].name] = StatusOK
+	}
+
+	if status.Status != StatusOK {
+		writeStatus(rw, http.StatusServiceUnavailable, status)
+
+		return
+	}
+
+	writeStatus(rw, http.StatusOK, status)
+}
+
+// Version reports the build of the running binary and its config environment
+func (handler *Handler) Version(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	data.ToJSON(&Version{
+		Commit:      Commit,
+		BuildTime:   BuildTime,
+		GoVersion:   runtime.Version(),
+		Environment: handler.environment,
+	}, rw)
+}
+
+// writeStatus writes the given probe status, probes must never be cached
+func writeStatus(rw http.ResponseWriter, code int, status *Status) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(code)
+	data.ToJSON(status, rw)
+}
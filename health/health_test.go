@@ -0,0 +1,96 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// probe calls the given probe and decodes its status
+func probe(t *testing.T, handlerFunc http.HandlerFunc) (int, *Status) {
+	t.Helper()
+
+	rw := httptest.NewRecorder()
+	handlerFunc(rw, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rw.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected the probe never cached, got %v", rw.Header())
+	}
+
+	var status Status
+	if err := json.NewDecoder(rw.Body).Decode(&status); err != nil {
+		t.Fatalf("decode the status: %v", err)
+	}
+
+	return rw.Code, &status
+}
+
+func TestReadyzReportsEveryCheck(t *testing.T) {
+	handler := NewHandler(hclog.NewNullLogger(), "test", time.Second)
+	handler.AddCheck("database", func(ctx context.Context) error { return nil })
+
+	if code, status := probe(t, handler.Readyz); code != http.StatusOK || status.Status != StatusOK || status.Checks["database"] != StatusOK {
+		t.Fatalf("expected the service ready, got %d %+v", code, status)
+	}
+
+	// the error is only logged, it may tell the db address
+	handler.AddCheck("session store", func(ctx context.Context) error { return errors.New("dial tcp 10.0.0.5:3306: refused") })
+
+	code, status := probe(t, handler.Readyz)
+	if code != http.StatusServiceUnavailable || status.Status != StatusUnavailable || status.Checks["session store"] != StatusFailed || status.Checks["database"] != StatusOK {
+		t.Fatalf("expected the failed check reported, got %d %+v", code, status)
+	}
+}
+
+func TestReadyzGivesUpOnASlowCheck(t *testing.T) {
+	handler := NewHandler(hclog.NewNullLogger(), "test", 20*time.Millisecond)
+	handler.AddCheck("database", func(ctx context.Context) error {
+		<-ctx.Done()
+
+		return ctx.Err()
+	})
+
+	started := time.Now()
+	if code, _ := probe(t, handler.Readyz); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the slow check failed, got %d", code)
+	}
+
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("expected the check given up after its timeout, took %v", elapsed)
+	}
+}
+
+func TestReadyzFailsWhileShuttingDown(t *testing.T) {
+	handler := NewHandler(hclog.NewNullLogger(), "test", time.Second)
+	handler.SetShuttingDown()
+
+	if code, status := probe(t, handler.Readyz); code != http.StatusServiceUnavailable || status.Status != StatusShuttingDown {
+		t.Fatalf("expected the draining replica not ready, got %d %+v", code, status)
+	}
+
+	// the process is still alive
+	if code, status := probe(t, handler.Healthz); code != http.StatusOK || status.Status != StatusOK {
+		t.Fatalf("expected the draining replica alive, got %d %+v", code, status)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	rw := httptest.NewRecorder()
+	NewHandler(hclog.NewNullLogger(), "staging", time.Second).Version(rw, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	var version Version
+	if err := json.NewDecoder(rw.Body).Decode(&version); err != nil {
+		t.Fatalf("decode the version: %v", err)
+	}
+
+	if version.Commit != Commit || version.GoVersion != runtime.Version() || version.Environment != "staging" {
+		t.Fatalf("expected the build info, got %+v", version)
+	}
+}
@@ -0,0 +1,238 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Idempotency key statuses
+const (
+	StatusInProgress uint = 0 // the first request is still being handled
+	StatusCompleted  uint = 1 // the response is stored and replayed to the retries
+)
+
+// Outcome tells the caller of Begin what to do with the request
+type Outcome int
+
+// Outcomes of Begin
+const (
+	OutcomeProceed    Outcome = iota // the key is new, the request must be handled then completed or released
+	OutcomeReplay                    // the key has a stored response to replay
+	OutcomeMismatch                  // the key was used for a different request
+	OutcomeInProgress                // the key is held by a request still being handled
+)
+
+// ReplayedHeaders are the response headers stored along with the response and replayed to the retries,
+// the headers tied to a single request such as X-Request-ID, Set-Cookie or the rate limits are left out
+var ReplayedHeaders = []string{"Content-Type", "Content-Language", "ETag", "Location"}
+
+// Service defines a struct for the idempotency keys of the requests
+type Service struct {
+	logger      hclog.Logger
+	window      time.Duration
+	lockTimeout time.Duration
+}
+
+// NewService is a function to create new idempotency Service struct based on the given idempotency configuration
+func NewService(newLogger hclog.Logger, idempotencyConfig *entities.IdempotencyConfiguration) *Service {
+	return &Service{
+		logger:      newLogger,
+		window:      time.Duration(idempotencyConfig.Window) * time.Second,
+		lockTimeout: time.Duration(idempotencyConfig.LockTimeout) * time.Second,
+	}
+}
+
+// Begin takes the given key of the given user for a request of the given hash,
+// the returned key is only set when the request proceeds or is replayed
+func (service *Service) Begin(ctx context.Context, currentUser *database.MasterUser, key, requestHash string) (*database.DBIdempotencyKey, Outcome, error) {
+	db := config.DB.WithContext(ctx)
+
+	// a key found expired is removed and taken again once
+	for attempt := 0; attempt < 2; attempt++ {
+		now := time.Now().Local()
+
+		newKey := database.DBIdempotencyKey{
+			UserID:         currentUser.ID,
+			IdempotencyKey: key,
+			RequestHash:    requestHash,
+			Status:         StatusInProgress,
+			ExpiresAt:      now.Add(service.window),
+			IsActive:       true,
+			Created:        now,
+			CreatedBy:      currentUser.Username,
+			Modified:       now,
+			ModifiedBy:     currentUser.Username,
+		}
+
+		// insert the new key, the unique index tells a key already in use
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&newKey)
+		if result.Error != nil {
+			return nil, OutcomeProceed, result.Error
+		}
+
+		if result.RowsAffected == 1 {
+			return &newKey, OutcomeProceed, nil
+		}
+
+		// look for the key in use
+		var existingKey database.DBIdempotencyKey
+		err := db.Where("user_id = ? AND idempotency_key = ?", currentUser.ID, key).First(&existingKey).Error
+		if err == gorm.ErrRecordNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, OutcomeProceed, err
+		}
+
+		// an expired key can be used for another request
+		if !existingKey.ExpiresAt.After(now) {
+			if err := db.Where("id = ? AND expires_at <= ?", existingKey.ID, now).Delete(&database.DBIdempotencyKey{}).Error; err != nil {
+				return nil, OutcomeProceed, err
+			}
+
+			continue
+		}
+
+		if existingKey.RequestHash != requestHash {
+			return nil, OutcomeMismatch, nil
+		}
+
+		if existingKey.Status == StatusCompleted {
+			return &existingKey, OutcomeReplay, nil
+		}
+
+		// take over a key left in progress by a crashed replica, the key of a request still being handled is kept alive
+		result = db.Model(&database.DBIdempotencyKey{}).
+			Where("id = ? AND status = ? AND modified <= ?", existingKey.ID, StatusInProgress, now.Add(-service.lockTimeout)).
+			Updates(map[string]interface{}{
+				"modified":    now,
+				"modified_by": currentUser.Username,
+			})
+
+		if result.Error != nil {
+			return nil, OutcomeProceed, result.Error
+		}
+
+		if result.RowsAffected == 1 {
+			return &existingKey, OutcomeProceed, nil
+		}
+
+		return nil, OutcomeInProgress, nil
+	}
+
+	return nil, OutcomeInProgress, nil
+}
+
+// KeepAlive refreshes the given key on every third of the lock timeout until the returned function is called,
+// so a retry doesn't take over the key while its request is still being handled
+func (service *Service) KeepAlive(idempotencyKey *database.DBIdempotencyKey) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(service.lockTimeout / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), service.lockTimeout/3)
+				err := config.DB.WithContext(ctx).Model(&database.DBIdempotencyKey{}).
+					Where("id = ? AND status = ?", idempotencyKey.ID, StatusInProgress).
+					Update("modified", time.Now().Local()).Error
+				cancel()
+
+				if err != nil {
+					service.logger.Error("Unable to keep the idempotency key alive", "id", idempotencyKey.ID, "error", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Complete stores the response of the request holding the given key so its retries get the same response,
+// only the ReplayedHeaders of the given response header are stored
+func (service *Service) Complete(ctx context.Context, idempotencyKey *database.DBIdempotencyKey, responseCode int, responseHeader http.Header, responseBody []byte) error {
+	replayed := http.Header{}
+	for _, name := range ReplayedHeaders {
+		if values := responseHeader.Values(name); len(values) != 0 {
+			replayed[name] = values
+		}
+	}
+
+	header, err := json.Marshal(replayed)
+	if err != nil {
+		return err
+	}
+
+	return config.DB.WithContext(ctx).Model(idempotencyKey).Updates(map[string]interface{}{
+		"status":          StatusCompleted,
+		"response_code":   responseCode,
+		"response_header": string(header),
+		"response_body":   string(responseBody),
+		"modified":        time.Now().Local(),
+	}).Error
+}
+
+// StoredHeader returns the response headers stored along with the response of the given key
+func (service *Service) StoredHeader(idempotencyKey *database.DBIdempotencyKey) (http.Header, error) {
+	header := http.Header{}
+	if idempotencyKey.ResponseHeader == "" {
+		return header, nil
+	}
+
+	if err := json.Unmarshal([]byte(idempotencyKey.ResponseHeader), &header); err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
+
+// Release frees the given key of a request that failed, so a retry handles the request again
+func (service *Service) Release(ctx context.Context, idempotencyKey *database.DBIdempotencyKey) error {
+	return config.DB.WithContext(ctx).
+		Where("id = ? AND status = ?", idempotencyKey.ID, StatusInProgress).
+		Delete(&database.DBIdempotencyKey{}).Error
+}
+
+// PurgeExpired deletes a batch of expired keys, returning the number of deleted keys
+func (service *Service) PurgeExpired(ctx context.Context, batchSize int) (int, error) {
+	db := config.DB.WithContext(ctx)
+
+	// look for the expired keys
+	var expiredIDs []uint
+	if err := db.Model(&database.DBIdempotencyKey{}).
+		Where("expires_at <= ?", time.Now().Local()).
+		Order("id").
+		Limit(batchSize).
+		Pluck("id", &expiredIDs).Error; err != nil {
+		return 0, err
+	}
+
+	if len(expiredIDs) == 0 {
+		return 0, nil
+	}
+
+	result := db.Where("id IN ?", expiredIDs).Delete(&database.DBIdempotencyKey{})
+
+	return int(result.RowsAffected), result.Error
+}
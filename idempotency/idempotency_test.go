@@ -0,0 +1,230 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// the user of the test keys
+var testUser = &database.MasterUser{ID: 1, Username: "tenant"}
+
+// newTestDB opens an in-memory sqlite database holding the idempotency keys and makes it the application database
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.Replace(t.Name(), "/", "_", -1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open the test database: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get the test connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	if err := db.AutoMigrate(&database.DBIdempotencyKey{}); err != nil {
+		t.Fatalf("migrate the test database: %v", err)
+	}
+
+	previous := config.DB
+	config.DB = db
+	t.Cleanup(func() {
+		config.DB = previous
+		sqlDB.Close()
+	})
+
+	return db
+}
+
+// newTestService returns a service replaying the responses for an hour and taking over the keys left for 30 seconds
+func newTestService() *Service {
+	return NewService(hclog.NewNullLogger(), &entities.IdempotencyConfiguration{Window: 3600, LockTimeout: 30})
+}
+
+// begin takes the given key of testUser for the given request hash
+func begin(t *testing.T, service *Service, key, requestHash string) (*database.DBIdempotencyKey, Outcome) {
+	t.Helper()
+
+	idempotencyKey, outcome, err := service.Begin(context.Background(), testUser, key, requestHash)
+	if err != nil {
+		t.Fatalf("begin %s: %v", key, err)
+	}
+
+	return idempotencyKey, outcome
+}
+
+// ageKey moves the modification and the expiry of the given key back by the given duration
+func ageKey(t *testing.T, db *gorm.DB, id uint, age time.Duration) {
+	t.Helper()
+
+	var idempotencyKey database.DBIdempotencyKey
+	if err := db.First(&idempotencyKey, id).Error; err != nil {
+		t.Fatalf("read the key: %v", err)
+	}
+
+	if err := db.Model(&idempotencyKey).Updates(map[string]interface{}{
+		"modified":   idempotencyKey.Modified.Add(-age),
+		"expires_at": idempotencyKey.ExpiresAt.Add(-age),
+	}).Error; err != nil {
+		t.Fatalf("age the key: %v", err)
+	}
+}
+
+func TestBeginReplaysTheCompletedRequest(t *testing.T) {
+	newTestDB(t)
+	service := newTestService()
+
+	idempotencyKey, outcome := begin(t, service, "key-1", "hash-1")
+	if outcome != OutcomeProceed || idempotencyKey == nil {
+		t.Fatalf("expected the new key to proceed, got %v", outcome)
+	}
+
+	// the other requests wait for the first one
+	if _, outcome := begin(t, service, "key-1", "hash-1"); outcome != OutcomeInProgress {
+		t.Fatalf("expected the retry told the key is in progress, got %v", outcome)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Location", "/book/1")
+	header.Set("X-Request-ID", "request-1")
+	if err := service.Complete(context.Background(), idempotencyKey, http.StatusCreated, header, []byte(`{"id":1}`)); err != nil {
+		t.Fatalf("complete the key: %v", err)
+	}
+
+	replayedKey, outcome := begin(t, service, "key-1", "hash-1")
+	if outcome != OutcomeReplay || replayedKey.ResponseCode != http.StatusCreated || replayedKey.ResponseBody != `{"id":1}` {
+		t.Fatalf("expected the stored response replayed, got %v %+v", outcome, replayedKey)
+	}
+
+	// the headers tied to the first request aren't replayed
+	storedHeader, err := service.StoredHeader(replayedKey)
+	if err != nil {
+		t.Fatalf("read the stored header: %v", err)
+	}
+
+	if storedHeader.Get("Location") != "/book/1" || storedHeader.Get("Content-Type") != "application/json" || storedHeader.Get("X-Request-ID") != "" {
+		t.Fatalf("expected only the replayed headers stored, got %v", storedHeader)
+	}
+
+	if _, outcome := begin(t, service, "key-1", "hash-2"); outcome != OutcomeMismatch {
+		t.Fatalf("expected the key refused for another request, got %v", outcome)
+	}
+
+	// the keys belong to their user
+	if _, outcome, err := service.Begin(context.Background(), &database.MasterUser{ID: 2, Username: "other"}, "key-1", "hash-2"); err != nil || outcome != OutcomeProceed {
+		t.Fatalf("expected the key of another user to proceed, got %v %v", outcome, err)
+	}
+}
+
+func TestBeginTakesOverTheAbandonedKey(t *testing.T) {
+	db := newTestDB(t)
+	service := newTestService()
+
+	idempotencyKey, _ := begin(t, service, "key-1", "hash-1")
+
+	// the replica handling the request crashed without releasing the key
+	ageKey(t, db, idempotencyKey.ID, time.Minute)
+
+	takenKey, outcome := begin(t, service, "key-1", "hash-1")
+	if outcome != OutcomeProceed || takenKey.ID != idempotencyKey.ID {
+		t.Fatalf("expected the abandoned key taken over, got %v %+v", outcome, takenKey)
+	}
+
+	// the key is held again by the retry
+	if _, outcome := begin(t, service, "key-1", "hash-1"); outcome != OutcomeInProgress {
+		t.Fatalf("expected the taken over key in progress, got %v", outcome)
+	}
+}
+
+func TestBeginReusesTheExpiredKey(t *testing.T) {
+	db := newTestDB(t)
+	service := newTestService()
+
+	idempotencyKey, _ := begin(t, service, "key-1", "hash-1")
+	if err := service.Complete(context.Background(), idempotencyKey, http.StatusCreated, http.Header{}, nil); err != nil {
+		t.Fatalf("complete the key: %v", err)
+	}
+
+	ageKey(t, db, idempotencyKey.ID, 2*time.Hour)
+
+	reusedKey, outcome := begin(t, service, "key-1", "hash-2")
+	if outcome != OutcomeProceed || reusedKey.RequestHash != "hash-2" || reusedKey.Status != StatusInProgress {
+		t.Fatalf("expected the expired key used for the new request, got %v %+v", outcome, reusedKey)
+	}
+}
+
+func TestReleaseFreesTheKey(t *testing.T) {
+	newTestDB(t)
+	service := newTestService()
+
+	idempotencyKey, _ := begin(t, service, "key-1", "hash-1")
+	if err := service.Release(context.Background(), idempotencyKey); err != nil {
+		t.Fatalf("release the key: %v", err)
+	}
+
+	if _, outcome := begin(t, service, "key-1", "hash-1"); outcome != OutcomeProceed {
+		t.Fatalf("expected the released key to proceed again, got %v", outcome)
+	}
+}
+
+func TestKeepAliveRefreshesTheKey(t *testing.T) {
+	db := newTestDB(t)
+	service := newTestService()
+	service.lockTimeout = 30 * time.Millisecond
+
+	idempotencyKey, _ := begin(t, service, "key-1", "hash-1")
+	ageKey(t, db, idempotencyKey.ID, time.Minute)
+
+	stop := service.KeepAlive(idempotencyKey)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+
+	// the request is still being handled, the retry must not take its key
+	if _, outcome := begin(t, service, "key-1", "hash-1"); outcome != OutcomeInProgress {
+		t.Fatalf("expected the key kept alive, got %v", outcome)
+	}
+}
+
+func TestPurgeExpiredDeletesABatchOfExpiredKeys(t *testing.T) {
+	db := newTestDB(t)
+	service := newTestService()
+
+	for i := 1; i <= 3; i++ {
+		idempotencyKey, _ := begin(t, service, fmt.Sprintf("key-%d", i), "hash")
+		if i != 3 {
+			ageKey(t, db, idempotencyKey.ID, 2*time.Hour)
+		}
+	}
+
+	if purged, err := service.PurgeExpired(context.Background(), 1); err != nil || purged != 1 {
+		t.Fatalf("expected a single key purged by the batch, got %d %v", purged, err)
+	}
+
+	if purged, err := service.PurgeExpired(context.Background(), 10); err != nil || purged != 1 {
+		t.Fatalf("expected the remaining expired key purged, got %d %v", purged, err)
+	}
+
+	var remaining []database.DBIdempotencyKey
+	if err := db.Find(&remaining).Error; err != nil {
+		t.Fatalf("read the keys: %v", err)
+	}
+
+	if len(remaining) != 1 || remaining[0].IdempotencyKey != "key-3" {
+		t.Fatalf("expected the live key kept, got %+v", remaining)
+	}
+}
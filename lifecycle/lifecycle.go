@@ -0,0 +1,190 @@
+package lifecycle
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// server is an http server along with the function serving it
+type server struct {
+	name   string
+	server *http.Server
+	serve  func() error
+}
+
+// worker is a background task running until its context is done
+type worker struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+// closer releases a resource once nothing uses it anymore
+type closer struct {
+	name  string
+	close func(ctx context.Context) error
+}
+
+// Manager runs the servers and the background workers of the service until SIGTERM or SIGINT,
+// then drains the in-flight requests, stops the workers and closes the resources, in this order
+type Manager struct {
+	logger        hclog.Logger
+	drainDelay    time.Duration
+	drainTimeout  time.Duration
+	workerTimeout time.Duration
+	onShutdown    []func()
+	servers       []server
+	workers       []worker
+	closers       []closer
+}
+
+// NewManager is a function to create new Manager struct,
+// the servers keep serving for the drain delay once shutting down and the requests are then given the drain timeout to complete,
+// the workers are then given the worker timeout to end their current run
+func NewManager(newLogger hclog.Logger, drainDelay, drainTimeout, workerTimeout time.Duration) *Manager {
+	return &Manager{logger: newLogger, drainDelay: drainDelay, drainTimeout: drainTimeout, workerTimeout: workerTimeout}
+}
+
+// OnShutdown adds a function called as soon as the shutdown starts, before the servers stop accepting requests
+func (manager *Manager) OnShutdown(function func()) {
+	manager.onShutdown = append(manager.onShutdown, function)
+}
+
+// AddServer adds a server started by Run with the given serve function, e.g. ListenAndServe
+func (manager *Manager) AddServer(name string, httpServer *http.Server, serve func() error) {
+	manager.servers = append(manager.servers, server{name: name, server: httpServer, serve: serve})
+}
+
+// AddWorker adds a background task started by Run, the task must return once its context is done
+func (manager *Manager) AddWorker(name string, run func(ctx context.Context)) {
+	manager.workers = append(manager.workers, worker{name: name, run: run})
+}
+
+// AddCloser adds a resource closed once the servers and the workers stopped,
+// the resources are closed in the reverse order they were added
+func (manager *Manager) AddCloser(name string, close func(ctx context.Context) error) {
+	manager.closers = append(manager.closers, closer{name: name, close: close})
+}
+
+// Run starts the workers and the servers then blocks until a signal is received or a server fails,
+// it returns once everything is stopped with the error of the failed server, if any
+func (manager *Manager) Run() error {
+
+	// trap the termination signals, SIGKILL can't be trapped
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	// start the background workers
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	var workers sync.WaitGroup
+	for _, w := range manager.workers {
+		workers.Add(1)
+		go func(w worker) {
+			defer workers.Done()
+			w.run(workerCtx)
+		}(w)
+	}
+
+	// start the servers, a server closed by the shutdown is not a failure
+	failures := make(chan error, len(manager.servers))
+	for _, s := range manager.servers {
+		go func(s server) {
+			manager.logger.Info("Starting server", "server", s.name, "address", s.server.Addr)
+
+			if err := s.serve(); err != nil && err != http.ErrServerClosed {
+				manager.logger.Error("Server failed", "server", s.name, "error", err)
+				failures <- err
+			}
+		}(s)
+	}
+
+	// Block until a signal is received or a server fails
+	var runErr error
+	select {
+	case sig := <-signals:
+		manager.logger.Info("Got signal, shutting down", "signal", sig)
+	case runErr = <-failures:
+	}
+
+	// a second signal skips the graceful shutdown
+	go func() {
+		sig := <-signals
+		manager.logger.Warn("Got a second signal, exiting now", "signal", sig)
+		os.Exit(1)
+	}()
+
+	manager.shutdown(stopWorkers, &workers)
+
+	return runErr
+}
+
+// shutdown stops everything started by Run, every step is bounded by its own timeout
+// so a step using up its time doesn't cut the next one short
+func (manager *Manager) shutdown(stopWorkers context.CancelFunc, workers *sync.WaitGroup) {
+	for _, function := range manager.onShutdown {
+		function()
+	}
+
+	// let the load balancer notice the replica is no longer ready
+	if manager.drainDelay > 0 {
+		manager.logger.Info("Waiting before draining the requests", "delay", manager.drainDelay)
+		time.Sleep(manager.drainDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), manager.drainTimeout)
+	defer cancel()
+
+	// drain the in-flight requests
+	var servers sync.WaitGroup
+	for _, s := range manager.servers {
+		servers.Add(1)
+		go func(s server) {
+			defer servers.Done()
+
+			if err := s.server.Shutdown(ctx); err != nil {
+				manager.logger.Error("Unable to drain the server requests", "server", s.name, "error", err)
+			}
+		}(s)
+	}
+
+	servers.Wait()
+
+	// stop the workers and wait for their current run to end
+	stopWorkers()
+
+	workerCtx, cancelWorkers := context.WithTimeout(context.Background(), manager.workerTimeout)
+	defer cancelWorkers()
+
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-workerCtx.Done():
+		manager.logger.Error("Background workers didn't stop in time")
+	}
+
+	// close the resources once nothing uses them
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), manager.drainTimeout)
+	defer cancelClose()
+
+	for i := len(manager.closers) - 1; i >= 0; i-- {
+		if err := manager.closers[i].close(closeCtx); err != nil {
+			manager.logger.Error("Unable to close the resource", "resource", manager.closers[i].name, "error", err)
+		}
+	}
+
+	manager.logger.Info("Shutdown complete")
+}
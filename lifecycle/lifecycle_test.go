@@ -0,0 +1,219 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestShutdownGivesTheWorkersTheirOwnTimeout(t *testing.T) {
+	manager := NewManager(hclog.NewNullLogger(), 0, 100*time.Millisecond, time.Second)
+
+	// a request outliving the drain timeout uses it up
+	started := make(chan struct{})
+	release := make(chan struct{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	go httpServer.Serve(listener)
+	defer httpServer.Close()
+	defer close(release)
+
+	go http.Get("http://" + listener.Addr().String())
+	<-started
+
+	manager.AddServer("api", httpServer, nil)
+
+	// a worker taking a while to end its current run once stopped
+	var stoppedCleanly int32
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+
+		<-workerCtx.Done()
+		time.Sleep(200 * time.Millisecond)
+		atomic.StoreInt32(&stoppedCleanly, 1)
+	}()
+
+	// the resources are closed once the worker stopped, in the reverse order they were added
+	var closed []string
+	for _, name := range []string{"database", "tracing"} {
+		name := name
+		manager.AddCloser(name, func(ctx context.Context) error {
+			if atomic.LoadInt32(&stoppedCleanly) == 0 {
+				t.Errorf("expected %s closed after the worker stopped", name)
+			}
+
+			closed = append(closed, name)
+
+			return nil
+		})
+	}
+
+	manager.shutdown(stopWorkers, &workers)
+
+	if atomic.LoadInt32(&stoppedCleanly) == 0 {
+		t.Fatalf("expected the shutdown to wait for the worker")
+	}
+
+	if len(closed) != 2 || closed[0] != "tracing" || closed[1] != "database" {
+		t.Fatalf("expected the resources closed in the reverse order, got %v", closed)
+	}
+}
+
+// recorder records the shutdown steps in the order they happen
+type recorder struct {
+	mu    sync.Mutex
+	steps []string
+}
+
+// record adds the given step
+func (recorder *recorder) record(step string) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	recorder.steps = append(recorder.steps, step)
+}
+
+// recorded returns the recorded steps
+func (recorder *recorder) recorded() []string {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	return append([]string(nil), recorder.steps...)
+}
+
+func TestRunDrainsTheRequestsOnSIGTERM(t *testing.T) {
+	manager := NewManager(hclog.NewNullLogger(), 0, 5*time.Second, 5*time.Second)
+	steps := &recorder{}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	// a request in flight when the signal arrives
+	started := make(chan struct{}, 1)
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			time.Sleep(100 * time.Millisecond)
+			steps.record("request")
+		}
+
+		rw.Write([]byte("ok"))
+	})}
+
+	manager.AddServer("api", httpServer, func() error { return httpServer.Serve(listener) })
+	manager.OnShutdown(func() { steps.record("not ready") })
+	manager.AddWorker("outbox", func(ctx context.Context) {
+		<-ctx.Done()
+		steps.record("worker")
+	})
+	manager.AddCloser("database", func(ctx context.Context) error {
+		steps.record("database")
+
+		return nil
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- manager.Run() }()
+
+	// the signals are trapped once the server answers
+	address := "http://" + listener.Addr().String()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		response, err := http.Get(address)
+		if err == nil {
+			response.Body.Close()
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the server started: %v", err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	responses := make(chan string, 1)
+	go func() {
+		response, err := http.Get(address + "/slow")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer response.Body.Close()
+
+		body, _ := ioutil.ReadAll(response.Body)
+		responses <- string(body)
+	}()
+	<-started
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("signal the process: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the run to return once shut down")
+	}
+
+	if body := <-responses; body != "ok" {
+		t.Fatalf("expected the in-flight request completed, got %q", body)
+	}
+
+	recorded := steps.recorded()
+	expected := []string{"not ready", "request", "worker", "database"}
+	if len(recorded) != len(expected) {
+		t.Fatalf("expected the steps %v, got %v", expected, recorded)
+	}
+
+	for i := range expected {
+		if recorded[i] != expected[i] {
+			t.Fatalf("expected the steps %v, got %v", expected, recorded)
+		}
+	}
+}
+
+func TestRunReturnsTheServerFailure(t *testing.T) {
+	manager := NewManager(hclog.NewNullLogger(), 0, time.Second, time.Second)
+
+	var closed int32
+	manager.AddServer("admin", &http.Server{}, func() error { return errors.New("address already in use") })
+	manager.AddCloser("database", func(ctx context.Context) error {
+		atomic.StoreInt32(&closed, 1)
+
+		return nil
+	})
+
+	if err := manager.Run(); err == nil || err.Error() != "address already in use" {
+		t.Fatalf("expected the server failure returned, got %v", err)
+	}
+
+	// the resources are still closed
+	if atomic.LoadInt32(&closed) == 0 {
+		t.Fatalf("expected the resources closed after the failure")
+	}
+}
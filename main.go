@@ -2,18 +2,32 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"os/signal"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/fakhripraya/book-service/certs"
 	"github.com/fakhripraya/book-service/config"
 	"github.com/fakhripraya/book-service/data"
+	"github.com/fakhripraya/book-service/database"
 	"github.com/fakhripraya/book-service/entities"
 	"github.com/fakhripraya/book-service/handlers"
+	"github.com/fakhripraya/book-service/health"
+	"github.com/fakhripraya/book-service/idempotency"
+	"github.com/fakhripraya/book-service/lifecycle"
+	"github.com/fakhripraya/book-service/metrics"
+	"github.com/fakhripraya/book-service/notification"
+	"github.com/fakhripraya/book-service/outbox"
+	"github.com/fakhripraya/book-service/ratelimit"
+	"github.com/fakhripraya/book-service/scheduler"
+	"github.com/fakhripraya/book-service/tracing"
+	"github.com/fakhripraya/book-service/webhook"
 	gohandlers "github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/hashicorp/go-hclog"
@@ -28,28 +42,15 @@ var err error
 // Session Store based on MYSQL database
 var sessionStore *mysqlstore.MySQLStore
 
-// Adapter is an alias
-type Adapter func(http.Handler) http.Handler
-
-// Adapt takes Handler funcs and chains them to the main handler.
-func Adapt(handler http.Handler, adapters ...Adapter) http.Handler {
-	// The loop is reversed so the adapters/middleware gets executed in the same
-	// order as provided in the array.
-	for i := len(adapters); i > 0; i-- {
-		handler = adapters[i-1](handler)
-	}
-	return handler
-}
-
 func main() {
 
 	// creates a structured logger for logging the entire program
 	logger := hclog.Default()
 
-	// load configuration from env file
+	// load configuration from env file, the env file is optional as containers get their env from the orchestrator
 	err = godotenv.Load(".env")
 
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		// log the fatal error if load env failed
 		log.Fatal(err)
 	}
@@ -58,11 +59,40 @@ func main() {
 	var appConfig entities.Configuration
 	err = data.ConfigInit(&appConfig)
 
+	// the subcommands run instead of the service
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:], &appConfig, err))
+	}
+
 	if err != nil {
 		// log the fatal error if config init failed
 		log.Fatal(err)
 	}
 
+	// creates the lifecycle manager, every resource opened from now on is closed by the shutdown
+	lifecycleManager := lifecycle.NewManager(logger, time.Duration(appConfig.Lifecycle.DrainDelay)*time.Second, time.Duration(appConfig.Lifecycle.DrainTimeout)*time.Second, time.Duration(appConfig.Lifecycle.WorkerTimeout)*time.Second)
+
+	// set up the span exporter and the trace context propagation
+	shutdownTracing, err := tracing.Init(context.Background(), &appConfig.Tracing)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lifecycleManager.AddCloser("tracing", shutdownTracing)
+
+	// load the JWKS when asymmetric tokens are configured
+	if appConfig.Jwt.JWKSURL != "" || appConfig.Jwt.JWKSFile != "" {
+		logger.Info("Loading the JWT verification keys")
+		data.MyKeySet = data.NewKeySet(logger, &appConfig.Jwt)
+		err = data.MyKeySet.Refresh()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// keep the keys fresh in the background
+		lifecycleManager.AddWorker("jwks", data.MyKeySet.Run)
+	}
+
 	// initialize db session based on dialector
 	logger.Info("Establishing database connection on " + appConfig.Database.Host + ":" + strconv.Itoa(appConfig.Database.Port))
 	config.DB, err = gorm.Open(mysql.Open(config.DbURL(config.BuildDBConfig(&appConfig.Database))), &gorm.Config{})
@@ -70,29 +100,106 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// trace every query made with a request or job context
+	err = config.DB.Use(tracing.NewGormPlugin())
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Open the database connection based on the initialized db session
 	mySQLDB, err := config.DB.DB()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	defer mySQLDB.Close()
+	// size the connection pool
+	config.ConfigurePool(mySQLDB, &appConfig.Database)
+
+	// create the tables and columns the service relies on, a schema managed elsewhere disables it
+	if appConfig.Database.AutoMigrate {
+		logger.Info("Migrating the database schema")
+		err = database.Migrate(config.DB)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	lifecycleManager.AddCloser("database", func(context.Context) error { return mySQLDB.Close() })
+
+	// expose the connection pool stats
+	metrics.RegisterDB(mySQLDB, appConfig.Database.Dbname)
 
 	// Creates a session store based on MYSQL database
 	// If table doesn't exist, creates a new one
 	logger.Info("Building session store based on " + appConfig.Database.Host + ":" + strconv.Itoa(appConfig.Database.Port))
-	sessionStore, err = mysqlstore.NewMySQLStore(config.DbURL(config.BuildDBConfig(&appConfig.Database)), "dbMasterSession", "/", 3600*24*7, []byte(appConfig.MySQLStore.Secret))
+	// the store owns its own connection pool so its reachability can be probed
+	sessionDB, err := sql.Open("mysql", config.DbURL(config.BuildDBConfig(&appConfig.Database)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.ConfigurePool(sessionDB, &appConfig.Database)
+
+	sessionStore, err = mysqlstore.NewMySQLStoreFromConnection(sessionDB, appConfig.MySQLStore.TableName, "/", appConfig.MySQLStore.MaxAge, []byte(appConfig.MySQLStore.Secret))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	defer sessionStore.Close()
+	lifecycleManager.AddCloser("session store", func(context.Context) error {
+		sessionStore.Close()
+		return nil
+	})
+
+	// creates the in-process caches of the resolved principals and the token denylist
+	principalCache := data.NewTTLCache(time.Duration(appConfig.Cache.PrincipalTTL)*time.Second, appConfig.Cache.MaxEntries)
+	revocationCache := data.NewTTLCache(time.Duration(appConfig.Cache.RevocationTTL)*time.Second, appConfig.Cache.MaxEntries)
 
 	// creates a book instance
-	book := data.NewBook(logger)
+	book := data.NewBook(logger, principalCache, revocationCache)
+
+	// creates the request payload validation
+	validation := data.NewValidation()
+
+	// creates the authorization policy
+	policy := data.NewPolicy(logger, &appConfig.Roles)
+
+	// creates the owner webhook subscriptions
+	webhooks := webhook.NewService(logger, &appConfig.Webhook)
+
+	// creates the rate limits of the routes, the buckets are kept in memory so every replica enforces its own limits
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(time.Duration(appConfig.RateLimit.SweepInterval)*time.Second), &appConfig.RateLimit)
+
+	// creates the idempotency keys of the retried requests
+	idempotencyKeys := idempotency.NewService(logger, &appConfig.Idempotency)
 
 	// creates the book handler
-	bookHandler := handlers.NewBookHandler(logger, book, sessionStore)
+	bookHandler := handlers.NewBookHandler(logger, book, sessionStore, validation, policy, webhooks, limiter, idempotencyKeys)
+
+	// creates the notification service of the booking events
+	notifier := notification.NewService(logger, appConfig.Notification.Language, notification.NewChannels(logger, &appConfig.Notification)...)
+
+	// publish the booking events written to the outbox in the background
+	dispatcher := outbox.NewDispatcher(logger, &appConfig.Outbox, notifier, webhooks)
+	lifecycleManager.AddWorker("outbox dispatcher", dispatcher.Run)
+
+	// post the queued owner webhooks in the background
+	deliverer := webhook.NewDeliverer(logger, &appConfig.Webhook)
+	lifecycleManager.AddWorker("webhook deliverer", deliverer.Run)
+
+	// run the background jobs, each job runs on a single replica at a time
+	jobs := scheduler.NewScheduler(logger, time.Duration(appConfig.Scheduler.Lease)*time.Second)
+	jobs.Add(scheduler.NewBookExpiry(logger, &appConfig.Scheduler), time.Duration(appConfig.Scheduler.ExpiryInterval)*time.Second)
+	jobs.Add(scheduler.NewRentBilling(logger, &appConfig.Scheduler), time.Duration(appConfig.Scheduler.RentInterval)*time.Second)
+	jobs.Add(scheduler.NewIdempotencyPurge(logger, idempotencyKeys, appConfig.Scheduler.BatchSize), time.Duration(appConfig.Idempotency.PurgeInterval)*time.Second)
+	lifecycleManager.AddWorker("scheduler", func(ctx context.Context) {
+		jobs.Run(ctx)
+		jobs.Wait()
+	})
+
+	// creates the orchestrator probes, the replica is ready once the db and the session store answer
+	probes := health.NewHandler(logger, appConfig.API.Environment, time.Duration(appConfig.Health.CheckTimeout)*time.Second)
+	probes.AddCheck("database", mySQLDB.PingContext)
+	probes.AddCheck("session_store", sessionDB.PingContext)
 
 	// creates a new serve mux
 	serveMux := mux.NewRouter()
@@ -100,80 +207,125 @@ func main() {
 	// handlers for the API
 	logger.Info("Setting handlers for the API")
 
-	// get handlers
-	getRequest := serveMux.Methods(http.MethodGet).Subrouter()
-
-	// get book handlers
-	getRequest.HandleFunc("/", bookHandler.GetMyBook)
-	getRequest.HandleFunc("/all", bookHandler.GetMyBookList)
-
-	// get global middleware
-	getRequest.Use(bookHandler.MiddlewareValidateAuth)
+	// global middleware
+	serveMux.Use(tracing.Middleware)
+	serveMux.Use(metrics.Middleware)
+	serveMux.Use(bookHandler.MiddlewareLogRequest)
+	serveMux.Use(bookHandler.MiddlewareNegotiateLanguage)
 
-	// post handlers
-	postRequest := serveMux.Methods(http.MethodPost).Subrouter()
+	// the admin endpoints get their own listener when configured, otherwise they are served along with the API
+	adminMux := serveMux
+	if appConfig.Admin.Port != 0 {
+		adminMux = mux.NewRouter()
+	}
 
-	// post add new book
-	postRequest.HandleFunc("/add", bookHandler.AddBook)
+	// orchestrator probes and build info, never behind the auth middleware
+	adminMux.HandleFunc("/healthz", probes.Healthz).Methods(http.MethodGet)
+	adminMux.HandleFunc("/readyz", probes.Readyz).Methods(http.MethodGet)
+	adminMux.HandleFunc("/version", probes.Version).Methods(http.MethodGet)
 
-	// post global middleware
-	postRequest.Use(
-		bookHandler.MiddlewareValidateAuth,
-		bookHandler.MiddlewareParseBookRequest,
-	)
+	// prometheus metrics
+	adminMux.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
 
-	// patch handlers
-	patchRequest := serveMux.Methods(http.MethodPatch).Subrouter()
+	// version 1 handlers, every route declares its own middleware chain
+	RegisterRoutes(serveMux.PathPrefix("/v1").Subrouter(), V1Routes(bookHandler))
 
-	// patch approve book
-	patchRequest.HandleFunc("/approve/owner", bookHandler.OwnerApprovalBookTransaction)
-	patchRequest.HandleFunc("/approve/tenant", bookHandler.TenantApprovalBookTransaction)
+	// CORS
+	corsOptions := []gohandlers.CORSOption{
+		gohandlers.AllowedOrigins(appConfig.CORS.AllowedOrigins),
+		gohandlers.AllowedMethods(appConfig.CORS.AllowedMethods),
+		gohandlers.AllowedHeaders(appConfig.CORS.AllowedHeaders),
+		gohandlers.ExposedHeaders(appConfig.CORS.ExposedHeaders),
+		gohandlers.MaxAge(appConfig.CORS.MaxAge),
+	}
 
-	// patch global middleware
-	patchRequest.Use(
-		bookHandler.MiddlewareValidateAuth,
-		bookHandler.MiddlewareParseApprovalRequest,
-	)
+	if appConfig.CORS.AllowCredentials {
+		corsOptions = append(corsOptions, gohandlers.AllowCredentials())
+	}
 
-	// CORS
-	corsHandler := gohandlers.CORS(gohandlers.AllowedOrigins([]string{"*"}))
+	corsHandler := gohandlers.CORS(corsOptions...)
 
 	// creates a new server
 	server := http.Server{
-		Addr:         appConfig.API.Host + ":" + strconv.Itoa(appConfig.API.Port), // configure the bind address
-		Handler:      corsHandler(serveMux),                                       // set the default handler
-		ErrorLog:     logger.StandardLogger(&hclog.StandardLoggerOptions{}),       // set the logger for the server
-		ReadTimeout:  5 * time.Second,                                             // max time to read request from the client
-		WriteTimeout: 10 * time.Second,                                            // max time to write response to the client
-		IdleTimeout:  120 * time.Second,                                           // max time for connections using TCP Keep-Alive
+		Addr:              appConfig.API.Host + ":" + strconv.Itoa(appConfig.API.Port),  // configure the bind address
+		Handler:           corsHandler(serveMux),                                        // set the default handler
+		ErrorLog:          logger.StandardLogger(&hclog.StandardLoggerOptions{}),        // set the logger for the server
+		ReadTimeout:       time.Duration(appConfig.API.ReadTimeout) * time.Second,       // max time to read request from the client
+		ReadHeaderTimeout: time.Duration(appConfig.API.ReadHeaderTimeout) * time.Second, // max time to read the request headers from the client
+		WriteTimeout:      time.Duration(appConfig.API.WriteTimeout) * time.Second,      // max time to write response to the client
+		IdleTimeout:       time.Duration(appConfig.API.IdleTimeout) * time.Second,       // max time for connections using TCP Keep-Alive
 	}
 
-	// start the server
-	go func() {
-		logger.Info("Starting server on port " + appConfig.API.Host + ":" + strconv.Itoa(appConfig.API.Port))
+	// HTTP/2 is negotiated over TLS unless disabled
+	if !appConfig.API.HTTP2 {
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
 
-		err = server.ListenAndServe()
+	// serve TLS when a certificate is configured, a renewed certificate is picked up without a restart
+	serve := server.ListenAndServe
+	if appConfig.TLS.CertFile != "" {
+		certificates, err := certs.NewReloader(logger, appConfig.TLS.CertFile, appConfig.TLS.KeyFile, time.Duration(appConfig.TLS.ReloadInterval)*time.Second)
 		if err != nil {
+			log.Fatal(err)
+		}
+
+		lifecycleManager.AddWorker("certificate reloader", certificates.Run)
 
-			if strings.Contains(err.Error(), "http: Server closed") == true {
-				os.Exit(0)
-			} else {
-				logger.Error("Error starting server", "error", err.Error())
-				os.Exit(1)
-			}
+		server.TLSConfig = &tls.Config{
+			MinVersion:     data.TLSVersions[appConfig.TLS.MinVersion],
+			GetCertificate: certificates.GetCertificate,
 		}
-	}()
 
-	// trap sigterm or interrupt and gracefully shutdown the server
-	channel := make(chan os.Signal, 1)
-	signal.Notify(channel, os.Interrupt)
-	signal.Notify(channel, os.Kill)
+		serve = func() error { return server.ListenAndServeTLS("", "") }
+	}
+
+	// stop routing new traffic to this replica as soon as the shutdown starts
+	lifecycleManager.OnShutdown(probes.SetShuttingDown)
+	lifecycleManager.AddServer("api", &server, serve)
+
+	// the admin listener is meant for the internal network only, it serves plain HTTP
+	if appConfig.Admin.Port != 0 {
+		adminServer := http.Server{
+			Addr:              appConfig.Admin.Host + ":" + strconv.Itoa(appConfig.Admin.Port),
+			Handler:           adminMux,
+			ErrorLog:          logger.StandardLogger(&hclog.StandardLoggerOptions{}),
+			ReadHeaderTimeout: time.Duration(appConfig.API.ReadHeaderTimeout) * time.Second,
+			WriteTimeout:      time.Duration(appConfig.API.WriteTimeout) * time.Second,
+		}
+
+		lifecycleManager.AddServer("admin", &adminServer, adminServer.ListenAndServe)
+	}
+
+	// serve until SIGTERM or SIGINT, then drain the requests, stop the workers and close the resources
+	err = lifecycleManager.Run()
+	if err != nil {
+		logger.Error("Server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// runCommand runs the given subcommand and returns the exit code of the process,
+// config print dumps the effective configuration with its secrets redacted
+func runCommand(args []string, appConfig *entities.Configuration, configErr error) int {
+	if len(args) != 2 || args[0] != "config" || args[1] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: book-service [config print]")
+		return 2
+	}
+
+	redactedConfig := data.RedactConfig(*appConfig)
+	output, err := json.MarshalIndent(&redactedConfig, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
 
-	// Block until a signal is received.
-	sig := <-channel
-	logger.Info("Got signal", "info", sig)
+	fmt.Println(string(output))
+
+	// the configuration is printed even when invalid so it can be fixed
+	if configErr != nil {
+		fmt.Fprintln(os.Stderr, configErr)
+		return 1
+	}
 
-	// gracefully shutdown the server, waiting max 30 seconds for current operations to complete
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
-	server.Shutdown(ctx)
+	return 0
 }
@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric name of the service
+const namespace = "book_service"
+
+// Book status labels of the booking transitions
+const (
+	BookStatusNew = "new" // the booking didn't exist before the transition
+)
+
+// bookStatusNames maps the room book statuses to their metric label
+var bookStatusNames = map[uint]string{
+	0: "pending_owner",
+	1: "pending_tenant",
+	2: "confirmed",
+	3: "rejected",
+	4: "expired",
+}
+
+var (
+	// httpRequests counts the handled requests by route template and status
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests handled, by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	// httpDuration observes the request latency by route template and status
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests, by method, route template and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// bookTransitions counts the booking status changes
+	bookTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "book_transitions_total",
+		Help:      "Number of booking status transitions, by previous and new status.",
+	}, []string{"from", "to"})
+
+	// paymentApprovals counts the payment approvals by result
+	paymentApprovals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payment_approvals_total",
+		Help:      "Number of booking payments approved or rejected.",
+	}, []string{"result"})
+
+	// sessionStoreErrors counts the failing session store operations
+	sessionStoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_store_errors_total",
+		Help:      "Number of failed session store operations, by operation.",
+	}, []string{"operation"})
+
+	// rateLimited counts the requests rejected by the rate limits
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Number of requests rejected by the rate limits, by rule.",
+	}, []string{"rule"})
+)
+
+// Registry holds every metric exposed by the service
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		bookTransitions,
+		paymentApprovals,
+		sessionStoreErrors,
+		rateLimited,
+	)
+}
+
+// RegisterDB exposes the connection pool stats of the given database
+func RegisterDB(db *sql.DB, dbName string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, dbName))
+}
+
+// Handler returns the handler serving the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// BookTransition counts the given number of bookings moved between the given statuses
+func BookTransition(from string, to uint, count int) {
+	bookTransitions.WithLabelValues(from, BookStatus(to)).Add(float64(count))
+}
+
+// BookStatus returns the metric label of the given room book status
+func BookStatus(status uint) string {
+	if name, ok := bookStatusNames[status]; ok {
+		return name
+	}
+
+	return strconv.FormatUint(uint64(status), 10)
+}
+
+// PaymentApproval counts a payment approved or rejected
+func PaymentApproval(approved bool) {
+	result := "rejected"
+	if approved {
+		result = "approved"
+	}
+
+	paymentApprovals.WithLabelValues(result).Inc()
+}
+
+// SessionStoreError counts a failed session store operation
+func SessionStoreError(operation string) {
+	sessionStoreErrors.WithLabelValues(operation).Inc()
+}
+
+// RateLimited counts a request rejected by the given rate limit rule
+func RateLimited(rule string) {
+	rateLimited.WithLabelValues(rule).Inc()
+}
+
+// statusRecorder records the status code written by the wrapped handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the first status code written
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the implicit 200 status of a body written without a status code
+func (recorder *statusRecorder) Write(body []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	return recorder.ResponseWriter.Write(body)
+}
+
+// Middleware counts and times every request by its route template,
+// it must be used on the router so the matched route is known
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: rw}
+
+		next.ServeHTTP(recorder, r)
+
+		// the route template keeps the label cardinality bounded, the raw path doesn't
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		labels := prometheus.Labels{"method": r.Method, "route": route, "status": strconv.Itoa(status)}
+		httpRequests.With(labels).Inc()
+		httpDuration.With(labels).Observe(time.Since(started).Seconds())
+	})
+}
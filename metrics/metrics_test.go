@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddlewareLabelsTheRouteTemplate(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/v1/{id:[0-9]+}", func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+		rw.WriteHeader(http.StatusInternalServerError)
+	})
+	router.HandleFunc("/v1/all", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("[]"))
+	})
+
+	notFound := httpRequests.WithLabelValues(http.MethodGet, "/v1/{id:[0-9]+}", "404")
+	ok := httpRequests.WithLabelValues(http.MethodGet, "/v1/all", "200")
+	before, beforeOK := testutil.ToFloat64(notFound), testutil.ToFloat64(ok)
+
+	// every booking id is counted under the same route, with the first status written
+	for _, target := range []string{"/v1/1", "/v1/2", "/v1/all"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	if counted := testutil.ToFloat64(notFound) - before; counted != 2 {
+		t.Fatalf("expected 2 requests counted under the route template, got %v", counted)
+	}
+
+	if counted := testutil.ToFloat64(ok) - beforeOK; counted != 1 {
+		t.Fatalf("expected the implicit 200 counted, got %v", counted)
+	}
+}
+
+func TestBookTransition(t *testing.T) {
+	counter := bookTransitions.WithLabelValues(BookStatusNew, "pending_owner")
+	before := testutil.ToFloat64(counter)
+
+	BookTransition(BookStatusNew, 0, 3)
+
+	if counted := testutil.ToFloat64(counter) - before; counted != 3 {
+		t.Fatalf("expected 3 transitions counted, got %v", counted)
+	}
+
+	if BookStatus(4) != "expired" || BookStatus(9) != "9" {
+		t.Fatalf("expected the known statuses named and the others kept as is, got %s and %s", BookStatus(4), BookStatus(9))
+	}
+}
+
+func TestHandlerServesTheRegistry(t *testing.T) {
+	RateLimited("addbook")
+
+	rw := httptest.NewRecorder()
+	Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	for _, metric := range []string{"book_service_rate_limited_requests_total{rule=\"addbook\"}", "go_goroutines"} {
+		if !strings.Contains(rw.Body.String(), metric) {
+			t.Fatalf("expected %s exposed, got %s", metric, rw.Body.String())
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/gorm/clause"
+)
+
+// SMTPChannel sends notifications as plain text emails through an SMTP server
+type SMTPChannel struct {
+	address string
+	auth    smtp.Auth
+	from    string
+}
+
+// NewSMTPChannel is a function to create new SMTPChannel struct, the auth is skipped without a username
+func NewSMTPChannel(smtpConfig *entities.SMTPConfiguration) *SMTPChannel {
+	var auth smtp.Auth
+	if smtpConfig.Username != "" {
+		auth = smtp.PlainAuth("", smtpConfig.Username, smtpConfig.Password, smtpConfig.Host)
+	}
+
+	return &SMTPChannel{
+		address: smtpConfig.Host + ":" + strconv.Itoa(smtpConfig.Port),
+		auth:    auth,
+		from:    smtpConfig.From,
+	}
+}
+
+// Name returns the channel name used in the logs
+func (channel *SMTPChannel) Name() string {
+	return "email"
+}
+
+// Send delivers the given message to the recipient email, recipients without email are skipped
+func (channel *SMTPChannel) Send(ctx context.Context, recipient Recipient, message Message) error {
+	if recipient.Email == "" {
+		return nil
+	}
+
+	var mail strings.Builder
+	mail.WriteString("From: " + channel.from + "\r\n")
+	mail.WriteString("To: " + recipient.Email + "\r\n")
+	mail.WriteString("Subject: " + message.Subject + "\r\n")
+	if message.DedupeKey != "" {
+		mail.WriteString("X-Notification-Id: " + message.DedupeKey + "\r\n")
+	}
+	mail.WriteString("MIME-Version: 1.0\r\n")
+	mail.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	mail.WriteString("\r\n")
+	mail.WriteString(message.Body + "\r\n")
+
+	return smtp.SendMail(channel.address, channel.auth, channel.from, []string{recipient.Email}, []byte(mail.String()))
+}
+
+// GatewayChannel posts notifications as JSON to an HTTP gateway, used for the SMS and push providers
+type GatewayChannel struct {
+	name   string
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewSMSChannel is a function to create new GatewayChannel struct sending SMS through the given gateway
+func NewSMSChannel(gatewayConfig *entities.GatewayConfiguration) *GatewayChannel {
+	return &GatewayChannel{"sms", gatewayConfig.URL, gatewayConfig.APIKey, &http.Client{Timeout: 10 * time.Second}}
+}
+
+// NewPushChannel is a function to create new GatewayChannel struct sending push notifications through the given gateway
+func NewPushChannel(gatewayConfig *entities.GatewayConfiguration) *GatewayChannel {
+	return &GatewayChannel{"push", gatewayConfig.URL, gatewayConfig.APIKey, &http.Client{Timeout: 10 * time.Second}}
+}
+
+// gatewayPayload is the JSON body posted to the gateway
+type gatewayPayload struct {
+	ID      string `json:"id,omitempty"` // the same on every retry of a notification, for the gateways that dedupe
+	UserID  uint   `json:"user_id"`
+	Phone   string `json:"phone,omitempty"`
+	Event   Event  `json:"event"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// Name returns the channel name used in the logs
+func (channel *GatewayChannel) Name() string {
+	return channel.name
+}
+
+// Send posts the given message to the gateway, SMS recipients without phone are skipped
+func (channel *GatewayChannel) Send(ctx context.Context, recipient Recipient, message Message) error {
+	if channel.name == "sms" && recipient.Phone == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(&gatewayPayload{
+		ID:      message.DedupeKey,
+		UserID:  recipient.UserID,
+		Phone:   recipient.Phone,
+		Event:   message.Event,
+		Title:   message.Subject,
+		Message: message.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, channel.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	if channel.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+channel.apiKey)
+	}
+
+	response, err := channel.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s gateway responded with status %d", channel.name, response.StatusCode)
+	}
+
+	return nil
+}
+
+// InAppChannel stores notifications in the database for the in-app inbox
+type InAppChannel struct{}
+
+// NewInAppChannel is a function to create new InAppChannel struct
+func NewInAppChannel() *InAppChannel {
+	return &InAppChannel{}
+}
+
+// Name returns the channel name used in the logs
+func (channel *InAppChannel) Name() string {
+	return "in-app"
+}
+
+// Send inserts the given message to the recipient inbox, a message already inserted for its dedupe key is skipped
+func (channel *InAppChannel) Send(ctx context.Context, recipient Recipient, message Message) error {
+
+	// set variables
+	var newNotification database.DBNotification
+
+	if message.DedupeKey != "" {
+		newNotification.DedupeKey = &message.DedupeKey
+	}
+
+	newNotification.UserID = recipient.UserID
+	newNotification.Event = string(message.Event)
+	newNotification.Title = message.Subject
+	newNotification.Body = message.Body
+	newNotification.IsActive = true
+	newNotification.Created = time.Now().Local()
+	newNotification.CreatedBy = "book-service"
+	newNotification.Modified = time.Now().Local()
+	newNotification.ModifiedBy = "book-service"
+
+	// insert the new notification to database, skipping the already inserted one
+	return config.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&newNotification).Error
+}
+
+// SentMessage is a message recorded by the FakeChannel
+type SentMessage struct {
+	Recipient Recipient
+	Message   Message
+}
+
+// FakeChannel logs and records every notification instead of delivering it, used for local development and tests
+type FakeChannel struct {
+	logger   hclog.Logger
+	mu       sync.Mutex
+	messages []SentMessage
+}
+
+// NewFakeChannel is a function to create new FakeChannel struct
+func NewFakeChannel(newLogger hclog.Logger) *FakeChannel {
+	return &FakeChannel{logger: newLogger}
+}
+
+// Name returns the channel name used in the logs
+func (channel *FakeChannel) Name() string {
+	return "fake"
+}
+
+// Send records the given message
+func (channel *FakeChannel) Send(ctx context.Context, recipient Recipient, message Message) error {
+	channel.mu.Lock()
+	defer channel.mu.Unlock()
+
+	channel.logger.Info("Notification sent", "event", message.Event, "user_id", recipient.UserID, "subject", message.Subject)
+	channel.messages = append(channel.messages, SentMessage{recipient, message})
+
+	return nil
+}
+
+// Messages returns every message recorded so far
+func (channel *FakeChannel) Messages() []SentMessage {
+	channel.mu.Lock()
+	defer channel.mu.Unlock()
+
+	return append([]SentMessage(nil), channel.messages...)
+}
+
+// NewChannels builds every channel enabled in the given notification configuration
+func NewChannels(newLogger hclog.Logger, notificationConfig *entities.NotificationConfiguration) []Channel {
+	var channels []Channel
+
+	if notificationConfig.SMTP.Host != "" {
+		channels = append(channels, NewSMTPChannel(&notificationConfig.SMTP))
+	}
+
+	if notificationConfig.SMS.URL != "" {
+		channels = append(channels, NewSMSChannel(&notificationConfig.SMS))
+	}
+
+	if notificationConfig.Push.URL != "" {
+		channels = append(channels, NewPushChannel(&notificationConfig.Push))
+	}
+
+	if notificationConfig.InApp {
+		channels = append(channels, NewInAppChannel())
+	}
+
+	if notificationConfig.Fake {
+		channels = append(channels, NewFakeChannel(newLogger))
+	}
+
+	return channels
+}
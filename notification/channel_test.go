@@ -0,0 +1,53 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fakhripraya/book-service/entities"
+)
+
+func TestGatewayChannelPostsTheMessage(t *testing.T) {
+	var received gatewayPayload
+	var authorization string
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&received)
+		rw.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	channel := NewSMSChannel(&entities.GatewayConfiguration{URL: server.URL, APIKey: "key"})
+	recipient := Recipient{UserID: 20, Phone: "0812"}
+	message := Message{Event: EventRentDue, DedupeKey: "rent.due:1", Subject: "Rent due", Body: "Hi"}
+
+	if err := channel.Send(context.Background(), recipient, message); err != nil {
+		t.Fatalf("send the SMS: %v", err)
+	}
+
+	if authorization != "Bearer key" || received.ID != "rent.due:1" || received.Phone != "0812" || received.Message != "Hi" {
+		t.Fatalf("expected the message posted with the API key, got %+v %s", received, authorization)
+	}
+
+	// the gateway refusing the message fails the send
+	status = http.StatusBadGateway
+	if err := channel.Send(context.Background(), recipient, message); err == nil {
+		t.Fatal("expected the refused message to fail")
+	}
+}
+
+func TestSMSChannelSkipsRecipientsWithoutPhone(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	if err := NewSMSChannel(&entities.GatewayConfiguration{URL: server.URL}).Send(context.Background(), Recipient{UserID: 20}, Message{}); err != nil || called {
+		t.Fatalf("expected the recipient without phone skipped, got %v", err)
+	}
+}
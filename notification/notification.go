@@ -0,0 +1,168 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/outbox"
+	"github.com/hashicorp/go-hclog"
+)
+
+// Event is a booking state change that is notified to the involved users
+type Event string
+
+// Booking events
+const (
+	EventBookCreated        Event = entities.EventBookCreated        // new booking, sent to the kost owner
+	EventBookOwnerApproved  Event = entities.EventBookOwnerApproved  // owner decision, sent to the tenant
+	EventBookOwnerRejected  Event = entities.EventBookOwnerRejected  // owner decision, sent to the tenant
+	EventBookTenantApproved Event = entities.EventBookTenantApproved // tenant confirmation, sent to the kost owner
+	EventBookTenantRejected Event = entities.EventBookTenantRejected // tenant confirmation, sent to the kost owner
+	EventPaymentReceived    Event = entities.EventPaymentReceived    // payment approval, sent to the kost owner
+	EventBookExpired        Event = entities.EventBookExpired        // unanswered booking, sent to the tenant
+	EventRentDue            Event = entities.EventRentDue            // upcoming rent, sent to the tenant
+	EventRentOverdue        Event = entities.EventRentOverdue        // unpaid rent past the grace period, sent to the tenant
+)
+
+// Recipient is the user receiving a notification
+type Recipient struct {
+	UserID uint
+	Name   string
+	Email  string
+	Phone  string
+}
+
+// TemplateData holds the values rendered in the notification templates
+type TemplateData struct {
+	RecipientName string
+	ActorName     string
+	BookCode      string
+	KostName      string
+	Amount        float64
+	DueDate       string
+}
+
+// Message is a rendered notification ready to be sent through a channel
+type Message struct {
+	Event     Event
+	DedupeKey string // identifies the notification across the retries, empty when it is not retried
+	Subject   string
+	Body      string
+}
+
+// Channel is a way to deliver a notification to a recipient
+type Channel interface {
+	// Name returns the channel name used in the logs and as delivery target
+	Name() string
+	// Send delivers the given message to the given recipient
+	Send(ctx context.Context, recipient Recipient, message Message) error
+}
+
+// Service defines a struct for the notification flow
+type Service struct {
+	logger   hclog.Logger
+	language string
+	channels []Channel
+}
+
+// NewService is a function to create new notification Service struct sending through the given channels
+func NewService(newLogger hclog.Logger, language string, channels ...Channel) *Service {
+	return &Service{logger: newLogger, language: language, channels: channels}
+}
+
+// Name returns the publisher name used in the logs
+func (service *Service) Name() string {
+	return "notification"
+}
+
+// Publish notifies the user concerned by the given outbox booking event
+func (service *Service) Publish(ctx context.Context, event *outbox.Event) error {
+
+	// the owner is told about the tenant actions and the tenant about the owner decisions, the expiries and the rent
+	recipientUserID := event.Book.OwnerID
+	switch event.Book.Type {
+	case entities.EventBookOwnerApproved, entities.EventBookOwnerRejected, entities.EventBookExpired, entities.EventRentDue, entities.EventRentOverdue:
+		recipientUserID = event.Book.BookerID
+	}
+
+	templateData := TemplateData{
+		ActorName: event.Book.ActorName,
+		BookCode:  event.Book.BookCode,
+		KostName:  event.Book.KostName,
+		Amount:    event.Book.Amount,
+	}
+
+	if event.Book.DueDate != nil {
+		templateData.DueDate = event.Book.DueDate.Local().Format("02-01-2006")
+	}
+
+	return service.Send(ctx, event.DedupeKey, Event(event.Book.Type), recipientUserID, templateData)
+}
+
+// Send renders the given event for the given user and delivers it through every channel,
+// a failing channel doesn't stop the others and the returned error lists the failed channels only,
+// with a dedupe key the channels it was already delivered through are skipped so a retry only resends the failed ones
+func (service *Service) Send(ctx context.Context, dedupeKey string, event Event, recipientUserID uint, templateData TemplateData) error {
+
+	// look for the recipient in the db
+	var user database.MasterUser
+	if err := config.DB.WithContext(ctx).Where("id = ?", recipientUserID).First(&user).Error; err != nil {
+		return err
+	}
+
+	recipient := Recipient{
+		UserID: user.ID,
+		Name:   user.DisplayName,
+		Email:  user.Email,
+		Phone:  user.Phone,
+	}
+
+	templateData.RecipientName = recipient.Name
+	message, err := Render(service.language, event, templateData)
+	if err != nil {
+		return err
+	}
+
+	message.DedupeKey = dedupeKey
+
+	// look for the channels the notification was already delivered through
+	delivered := map[string]bool{}
+	if dedupeKey != "" {
+		delivered, err = outbox.DeliveredTargets(ctx, dedupeKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	var failures []string
+	for _, channel := range service.channels {
+		target := service.Name() + ":" + channel.Name()
+		if delivered[target] {
+			continue
+		}
+
+		if err := channel.Send(ctx, recipient, message); err != nil {
+			service.logger.Error("Notification channel failed", "channel", channel.Name(), "event", event, "user_id", recipient.UserID, "error", err)
+			failures = append(failures, channel.Name()+": "+err.Error())
+
+			continue
+		}
+
+		// the channel is skipped on the next attempts, an unrecorded delivery is sent again
+		if dedupeKey != "" {
+			if err := outbox.MarkDelivered(ctx, dedupeKey, target); err != nil {
+				failures = append(failures, channel.Name()+": unable to record the delivery: "+err.Error())
+			}
+		}
+	}
+
+	if len(failures) != 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/data"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/outbox"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB replaces config.DB with an in-memory database holding the notification tables for the duration of the test
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.Replace(t.Name(), "/", "_", -1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open the test database: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get the test connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	if err := db.AutoMigrate(&database.MasterUser{}, &database.DBOutboxDelivery{}, &database.DBNotification{}); err != nil {
+		t.Fatalf("migrate the test database: %v", err)
+	}
+
+	previous := config.DB
+	config.DB = db
+	t.Cleanup(func() {
+		config.DB = previous
+		sqlDB.Close()
+	})
+
+	return db
+}
+
+// seedUser inserts a user of the given id and display name
+func seedUser(t *testing.T, db *gorm.DB, id uint, displayName string) {
+	t.Helper()
+
+	user := &database.MasterUser{ID: id, Username: strings.ToLower(displayName), DisplayName: displayName, Email: strings.ToLower(displayName) + "@example.com", Password: []byte("hash"), IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("seed the user: %v", err)
+	}
+}
+
+// failingChannel is a channel failing its first sends
+type failingChannel struct {
+	failures int
+	sent     int
+}
+
+// Name returns the channel name used in the logs
+func (channel *failingChannel) Name() string {
+	return "failing"
+}
+
+// Send fails while failures are left
+func (channel *failingChannel) Send(ctx context.Context, recipient Recipient, message Message) error {
+	if channel.failures > 0 {
+		channel.failures--
+
+		return errors.New("gateway unavailable")
+	}
+
+	channel.sent++
+
+	return nil
+}
+
+func TestPublishNotifiesTheConcernedUser(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, 10, "Owner")
+	seedUser(t, db, 20, "Tenant")
+
+	fake := NewFakeChannel(hclog.NewNullLogger())
+	service := NewService(hclog.NewNullLogger(), data.LanguageEnglish, fake)
+
+	// the owner is told about the tenant actions and the tenant about the owner decisions
+	for _, test := range []struct {
+		eventType string
+		recipient uint
+	}{
+		{entities.EventBookCreated, 10},
+		{entities.EventBookOwnerApproved, 20},
+		{entities.EventBookTenantApproved, 10},
+		{entities.EventBookExpired, 20},
+		{entities.EventRentOverdue, 20},
+	} {
+		event := &outbox.Event{Book: entities.BookEvent{Type: test.eventType, BookCode: "BOOK-1", KostName: "Kost Test", OwnerID: 10, BookerID: 20, ActorName: "Actor"}}
+		if err := service.Publish(context.Background(), event); err != nil {
+			t.Fatalf("publish %s: %v", test.eventType, err)
+		}
+
+		messages := fake.Messages()
+		last := messages[len(messages)-1]
+		if last.Recipient.UserID != test.recipient || last.Message.Event != Event(test.eventType) {
+			t.Fatalf("expected %s sent to user %d, got %+v", test.eventType, test.recipient, last)
+		}
+	}
+
+	last := fake.Messages()[len(fake.Messages())-1]
+	if last.Recipient.Email != "tenant@example.com" || !strings.Contains(last.Message.Body, "Hi Tenant") {
+		t.Fatalf("expected the message rendered for the recipient, got %+v", last)
+	}
+}
+
+func TestSendRetriesOnlyTheFailedChannels(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, 10, "Owner")
+
+	fake := NewFakeChannel(hclog.NewNullLogger())
+	failing := &failingChannel{failures: 1}
+	service := NewService(hclog.NewNullLogger(), data.LanguageEnglish, fake, failing, NewInAppChannel())
+
+	// a failing channel doesn't stop the others
+	err := service.Send(context.Background(), "book.created:1", EventBookCreated, 10, TemplateData{BookCode: "BOOK-1"})
+	if err == nil || !strings.Contains(err.Error(), "failing: gateway unavailable") {
+		t.Fatalf("expected the failed channel reported, got %v", err)
+	}
+
+	if len(fake.Messages()) != 1 {
+		t.Fatalf("expected the other channels sent, got %d messages", len(fake.Messages()))
+	}
+
+	// the retry only goes through the failed channel
+	if err := service.Send(context.Background(), "book.created:1", EventBookCreated, 10, TemplateData{BookCode: "BOOK-1"}); err != nil {
+		t.Fatalf("retry the notification: %v", err)
+	}
+
+	if len(fake.Messages()) != 1 || failing.sent != 1 {
+		t.Fatalf("expected only the failed channel retried, got %d messages and %d sends", len(fake.Messages()), failing.sent)
+	}
+
+	var notifications int64
+	if err := db.Model(&database.DBNotification{}).Where("user_id = ?", 10).Count(&notifications).Error; err != nil {
+		t.Fatalf("count the notifications: %v", err)
+	}
+
+	if notifications != 1 {
+		t.Fatalf("expected a single in-app notification, got %d", notifications)
+	}
+}
+
+func TestInAppChannelSkipsTheDuplicates(t *testing.T) {
+	db := newTestDB(t)
+	channel := NewInAppChannel()
+	message := Message{Event: EventBookCreated, DedupeKey: "book.created:1", Subject: "New booking", Body: "Hi"}
+
+	// a crash after sending makes the outbox send the same message again
+	for i := 0; i < 2; i++ {
+		if err := channel.Send(context.Background(), Recipient{UserID: 10}, message); err != nil {
+			t.Fatalf("send the notification: %v", err)
+		}
+	}
+
+	var notifications int64
+	if err := db.Model(&database.DBNotification{}).Count(&notifications).Error; err != nil {
+		t.Fatalf("count the notifications: %v", err)
+	}
+
+	if notifications != 1 {
+		t.Fatalf("expected the duplicate skipped, got %d notifications", notifications)
+	}
+}
@@ -0,0 +1,133 @@
+package notification
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/fakhripraya/book-service/data"
+)
+
+// messageTemplate is the subject and body template of a single event
+type messageTemplate struct {
+	subject string
+	body    string
+}
+
+// messageTemplates holds the templates of every event keyed by language
+var messageTemplates = map[string]map[Event]messageTemplate{
+	data.LanguageIndonesian: {
+		EventBookCreated: {
+			subject: "Booking baru {{.BookCode}}",
+			body:    "Halo {{.RecipientName}}, {{.ActorName}} mengajukan booking {{.BookCode}} di {{.KostName}}. Silakan approve atau reject booking ini.",
+		},
+		EventBookOwnerApproved: {
+			subject: "Booking {{.BookCode}} disetujui owner",
+			body:    "Halo {{.RecipientName}}, booking {{.BookCode}} di {{.KostName}} sudah disetujui owner. Silakan konfirmasi booking kamu.",
+		},
+		EventBookOwnerRejected: {
+			subject: "Booking {{.BookCode}} ditolak owner",
+			body:    "Halo {{.RecipientName}}, mohon maaf booking {{.BookCode}} di {{.KostName}} ditolak owner.",
+		},
+		EventBookTenantApproved: {
+			subject: "Booking {{.BookCode}} dikonfirmasi tenant",
+			body:    "Halo {{.RecipientName}}, {{.ActorName}} sudah mengkonfirmasi booking {{.BookCode}} di {{.KostName}}.",
+		},
+		EventBookTenantRejected: {
+			subject: "Booking {{.BookCode}} dibatalkan tenant",
+			body:    "Halo {{.RecipientName}}, {{.ActorName}} membatalkan booking {{.BookCode}} di {{.KostName}}.",
+		},
+		EventPaymentReceived: {
+			subject: "Pembayaran booking {{.BookCode}} diterima",
+			body:    "Halo {{.RecipientName}}, pembayaran sebesar {{printf \"%.2f\" .Amount}} untuk booking {{.BookCode}} di {{.KostName}} sudah diterima.",
+		},
+		EventBookExpired: {
+			subject: "Booking {{.BookCode}} kedaluwarsa",
+			body:    "Halo {{.RecipientName}}, booking {{.BookCode}} di {{.KostName}} kedaluwarsa karena tidak dijawab sampai batas waktu. Silakan ajukan booking baru.",
+		},
+		EventRentDue: {
+			subject: "Tagihan sewa {{.KostName}} jatuh tempo {{.DueDate}}",
+			body:    "Halo {{.RecipientName}}, sewa untuk booking {{.BookCode}} di {{.KostName}} sebesar {{printf \"%.2f\" .Amount}} jatuh tempo pada {{.DueDate}}.",
+		},
+		EventRentOverdue: {
+			subject: "Tagihan sewa {{.KostName}} terlambat",
+			body:    "Halo {{.RecipientName}}, sewa untuk booking {{.BookCode}} di {{.KostName}} yang jatuh tempo pada {{.DueDate}} belum dibayar. Total tagihan termasuk denda keterlambatan: {{printf \"%.2f\" .Amount}}.",
+		},
+	},
+	data.LanguageEnglish: {
+		EventBookCreated: {
+			subject: "New booking {{.BookCode}}",
+			body:    "Hi {{.RecipientName}}, {{.ActorName}} requested booking {{.BookCode}} at {{.KostName}}. Please approve or reject this booking.",
+		},
+		EventBookOwnerApproved: {
+			subject: "Booking {{.BookCode}} approved by the owner",
+			body:    "Hi {{.RecipientName}}, booking {{.BookCode}} at {{.KostName}} has been approved by the owner. Please confirm your booking.",
+		},
+		EventBookOwnerRejected: {
+			subject: "Booking {{.BookCode}} rejected by the owner",
+			body:    "Hi {{.RecipientName}}, we are sorry, booking {{.BookCode}} at {{.KostName}} has been rejected by the owner.",
+		},
+		EventBookTenantApproved: {
+			subject: "Booking {{.BookCode}} confirmed by the tenant",
+			body:    "Hi {{.RecipientName}}, {{.ActorName}} confirmed booking {{.BookCode}} at {{.KostName}}.",
+		},
+		EventBookTenantRejected: {
+			subject: "Booking {{.BookCode}} cancelled by the tenant",
+			body:    "Hi {{.RecipientName}}, {{.ActorName}} cancelled booking {{.BookCode}} at {{.KostName}}.",
+		},
+		EventPaymentReceived: {
+			subject: "Payment for booking {{.BookCode}} received",
+			body:    "Hi {{.RecipientName}}, the payment of {{printf \"%.2f\" .Amount}} for booking {{.BookCode}} at {{.KostName}} has been received.",
+		},
+		EventBookExpired: {
+			subject: "Booking {{.BookCode}} expired",
+			body:    "Hi {{.RecipientName}}, booking {{.BookCode}} at {{.KostName}} expired because it was not answered before the deadline. Please make a new booking.",
+		},
+		EventRentDue: {
+			subject: "Rent for {{.KostName}} is due on {{.DueDate}}",
+			body:    "Hi {{.RecipientName}}, the rent of {{printf \"%.2f\" .Amount}} for booking {{.BookCode}} at {{.KostName}} is due on {{.DueDate}}.",
+		},
+		EventRentOverdue: {
+			subject: "Rent for {{.KostName}} is overdue",
+			body:    "Hi {{.RecipientName}}, the rent for booking {{.BookCode}} at {{.KostName}} due on {{.DueDate}} has not been paid. The amount due including the late fee is {{printf \"%.2f\" .Amount}}.",
+		},
+	},
+}
+
+// Render renders the message of the given event in the given language, falling back to the default language
+func Render(language string, event Event, templateData TemplateData) (Message, error) {
+	eventTemplate, ok := messageTemplates[language][event]
+	if !ok {
+		eventTemplate, ok = messageTemplates[data.DefaultLanguage][event]
+		if !ok {
+			return Message{}, fmt.Errorf("no notification template for event %q", event)
+		}
+	}
+
+	subject, err := renderText(eventTemplate.subject, templateData)
+	if err != nil {
+		return Message{}, err
+	}
+
+	body, err := renderText(eventTemplate.body, templateData)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{Event: event, Subject: subject, Body: body}, nil
+}
+
+// renderText executes the given text template with the given data
+func renderText(text string, templateData TemplateData) (string, error) {
+	parsed, err := template.New("notification").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	if err := parsed.Execute(&buffer, templateData); err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
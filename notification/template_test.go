@@ -0,0 +1,59 @@
+package notification
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fakhripraya/book-service/data"
+)
+
+func TestRender(t *testing.T) {
+	message, err := Render(data.LanguageEnglish, EventPaymentReceived, TemplateData{RecipientName: "Owner", BookCode: "BOOK-1", KostName: "Kost Test", Amount: 1500000})
+	if err != nil {
+		t.Fatalf("render the message: %v", err)
+	}
+
+	if message.Event != EventPaymentReceived || message.Subject != "Payment for booking BOOK-1 received" {
+		t.Fatalf("expected the rendered subject, got %+v", message)
+	}
+
+	if !strings.Contains(message.Body, "Hi Owner") || !strings.Contains(message.Body, "1500000.00") {
+		t.Fatalf("expected the template data rendered in the body, got %s", message.Body)
+	}
+
+	// an unsupported language falls back to the default language
+	fallback, err := Render("fr", EventPaymentReceived, TemplateData{BookCode: "BOOK-1"})
+	if err != nil || fallback.Subject != "Pembayaran booking BOOK-1 diterima" {
+		t.Fatalf("expected the default language message, got %+v %v", fallback, err)
+	}
+
+	if _, err := Render(data.LanguageEnglish, Event("book.unknown"), TemplateData{}); err == nil {
+		t.Fatal("expected an unknown event refused")
+	}
+}
+
+func TestMessageTemplatesAreComplete(t *testing.T) {
+	events := []Event{
+		EventBookCreated,
+		EventBookOwnerApproved,
+		EventBookOwnerRejected,
+		EventBookTenantApproved,
+		EventBookTenantRejected,
+		EventPaymentReceived,
+		EventBookExpired,
+		EventRentDue,
+		EventRentOverdue,
+	}
+
+	for _, language := range []string{data.LanguageIndonesian, data.LanguageEnglish} {
+		for _, event := range events {
+			if _, err := Render(language, event, TemplateData{}); err != nil {
+				t.Fatalf("render %s in %s: %v", event, language, err)
+			}
+
+			if _, ok := messageTemplates[language][event]; !ok {
+				t.Fatalf("expected %s translated in %s", event, language)
+			}
+		}
+	}
+}
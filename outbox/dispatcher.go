@@ -0,0 +1,196 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Dispatcher defines a struct that publishes the pending outbox events with retry and backoff
+type Dispatcher struct {
+	logger       hclog.Logger
+	publishers   []Publisher
+	pollInterval time.Duration
+	lease        time.Duration
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+	batchSize    int
+	maxAttempts  uint
+}
+
+// NewDispatcher is a function to create new Dispatcher struct publishing to the given publishers
+func NewDispatcher(newLogger hclog.Logger, outboxConfig *entities.OutboxConfiguration, publishers ...Publisher) *Dispatcher {
+	return &Dispatcher{
+		logger:       newLogger,
+		publishers:   publishers,
+		pollInterval: time.Duration(outboxConfig.PollInterval) * time.Second,
+		lease:        time.Duration(outboxConfig.Lease) * time.Second,
+		baseBackoff:  time.Duration(outboxConfig.BaseBackoff) * time.Second,
+		maxBackoff:   time.Duration(outboxConfig.MaxBackoff) * time.Second,
+		batchSize:    outboxConfig.BatchSize,
+		maxAttempts:  uint(outboxConfig.MaxAttempts),
+	}
+}
+
+// Run publishes the pending events on every poll interval until the given context is done
+func (dispatcher *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(dispatcher.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// keep dispatching while full batches are found
+			for {
+				dispatched, err := dispatcher.DispatchPending(ctx)
+				if err != nil {
+					dispatcher.logger.Error("Unable to dispatch the outbox events", "error", err)
+				}
+
+				if err != nil || dispatched < dispatcher.batchSize || ctx.Err() != nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+// DispatchPending claims a batch of due events and publishes them, returning the number of claimed events
+func (dispatcher *Dispatcher) DispatchPending(ctx context.Context) (int, error) {
+	events, err := dispatcher.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range events {
+		dispatcher.publish(ctx, &events[i])
+	}
+
+	return len(events), nil
+}
+
+// claim locks a batch of due events and leases them to this dispatcher,
+// so other replicas skip them until the lease ends
+func (dispatcher *Dispatcher) claim(ctx context.Context) ([]database.DBOutboxEvent, error) {
+	var events []database.DBOutboxEvent
+
+	err := config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().Local()
+
+		// look for the due events, skipping the ones locked by another replica
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND is_active = ? AND next_attempt_at <= ?", StatusPending, true, now).
+			Order("id").
+			Limit(dispatcher.batchSize).
+			Find(&events).Error; err != nil {
+			return err
+		}
+
+		if len(events) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+
+		// lease the events, a crashed dispatcher releases them once the lease ends
+		return tx.Model(&database.DBOutboxEvent{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(dispatcher.lease)).Error
+	})
+
+	return events, err
+}
+
+// publish delivers the given event to every publisher it was not delivered to yet and records the outcome,
+// so a retry only goes through the publishers that failed
+func (dispatcher *Dispatcher) publish(ctx context.Context, outboxEvent *database.DBOutboxEvent) {
+	event := &Event{
+		ID:        outboxEvent.ID,
+		DedupeKey: outboxEvent.DedupeKey,
+		Attempts:  outboxEvent.Attempts,
+	}
+
+	var failures []string
+	if err := json.Unmarshal([]byte(outboxEvent.Payload), &event.Book); err != nil {
+		failures = append(failures, "payload: "+err.Error())
+	} else if delivered, err := DeliveredTargets(ctx, outboxEvent.DedupeKey); err != nil {
+		failures = append(failures, "deliveries: "+err.Error())
+	} else {
+		for _, publisher := range dispatcher.publishers {
+			if delivered[publisher.Name()] {
+				continue
+			}
+
+			if err := publisher.Publish(ctx, event); err != nil {
+				failures = append(failures, publisher.Name()+": "+err.Error())
+
+				continue
+			}
+
+			// the publisher is skipped on the next attempts, an unrecorded delivery is published again
+			if err := MarkDelivered(ctx, outboxEvent.DedupeKey, publisher.Name()); err != nil {
+				failures = append(failures, publisher.Name()+": unable to record the delivery: "+err.Error())
+			}
+		}
+	}
+
+	now := time.Now().Local()
+	updates := map[string]interface{}{
+		"modified":    now,
+		"modified_by": "outbox-dispatcher",
+	}
+
+	if len(failures) == 0 {
+		updates["status"] = StatusPublished
+		updates["published_at"] = now
+		updates["last_error"] = ""
+	} else {
+		attempts := outboxEvent.Attempts + 1
+		updates["attempts"] = attempts
+		updates["last_error"] = strings.Join(failures, "; ")
+		updates["next_attempt_at"] = now.Add(dispatcher.backoff(attempts))
+
+		if attempts >= dispatcher.maxAttempts {
+			updates["status"] = StatusFailed
+		}
+
+		dispatcher.logger.Warn("Outbox event publish failed", "id", outboxEvent.ID, "type", outboxEvent.EventType, "attempts", attempts, "error", updates["last_error"])
+	}
+
+	if err := config.DB.WithContext(ctx).Model(&database.DBOutboxEvent{}).Where("id = ?", outboxEvent.ID).Updates(updates).Error; err != nil {
+		dispatcher.logger.Error("Unable to record the outbox event outcome", "id", outboxEvent.ID, "error", err)
+	}
+}
+
+// backoff returns the exponential delay before the given attempt is retried
+func (dispatcher *Dispatcher) backoff(attempts uint) time.Duration {
+	return Backoff(dispatcher.baseBackoff, dispatcher.maxBackoff, attempts)
+}
+
+// Backoff returns the exponential delay before the given attempt is retried,
+// doubling the base delay on every attempt up to the max delay
+func Backoff(base, max time.Duration, attempts uint) time.Duration {
+	delay := base
+	for i := uint(1); i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	return delay
+}
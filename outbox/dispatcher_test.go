@@ -0,0 +1,234 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB replaces config.DB with an in-memory database holding the outbox tables for the duration of the test
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.Replace(t.Name(), "/", "_", -1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open the test database: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get the test connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	if err := db.AutoMigrate(&database.DBOutboxEvent{}, &database.DBOutboxDelivery{}); err != nil {
+		t.Fatalf("migrate the test database: %v", err)
+	}
+
+	previous := config.DB
+	config.DB = db
+	t.Cleanup(func() {
+		config.DB = previous
+		sqlDB.Close()
+	})
+
+	return db
+}
+
+// seedEvent writes a due booking event of the given book to the outbox
+func seedEvent(t *testing.T, db *gorm.DB, bookID uint) *database.DBOutboxEvent {
+	t.Helper()
+
+	bookEvent := entities.BookEvent{Type: entities.EventBookCreated, BookID: bookID, OwnerID: 10, BookerID: 20}
+	if err := AddBookEvent(db, &database.MasterUser{Username: "tenant"}, BookEventKey(&bookEvent), bookEvent); err != nil {
+		t.Fatalf("seed the outbox event: %v", err)
+	}
+
+	var event database.DBOutboxEvent
+	if err := db.Where("aggregate_id = ?", bookID).First(&event).Error; err != nil {
+		t.Fatalf("read the outbox event: %v", err)
+	}
+
+	// the event is due right away
+	if err := db.Model(&event).Update("next_attempt_at", time.Now().Local().Add(-time.Second)).Error; err != nil {
+		t.Fatalf("make the outbox event due: %v", err)
+	}
+
+	return &event
+}
+
+// readEvent returns the stored state of the given outbox event
+func readEvent(t *testing.T, db *gorm.DB, id uint) *database.DBOutboxEvent {
+	t.Helper()
+
+	var event database.DBOutboxEvent
+	if err := db.First(&event, id).Error; err != nil {
+		t.Fatalf("read the outbox event: %v", err)
+	}
+
+	return &event
+}
+
+// testPublisher records the published events and fails while failures are left
+type testPublisher struct {
+	name      string
+	failures  int
+	published []*Event
+}
+
+// Name returns the publisher name used in the logs
+func (publisher *testPublisher) Name() string {
+	return publisher.name
+}
+
+// Publish records the given event
+func (publisher *testPublisher) Publish(ctx context.Context, event *Event) error {
+	if publisher.failures > 0 {
+		publisher.failures--
+
+		return errors.New("subscriber unavailable")
+	}
+
+	publisher.published = append(publisher.published, event)
+
+	return nil
+}
+
+// newTestDispatcher returns a dispatcher publishing to the given publishers
+func newTestDispatcher(maxAttempts int, publishers ...Publisher) *Dispatcher {
+	return NewDispatcher(hclog.NewNullLogger(), &entities.OutboxConfiguration{
+		PollInterval: 1,
+		Lease:        60,
+		BaseBackoff:  10,
+		MaxBackoff:   60,
+		BatchSize:    10,
+		MaxAttempts:  maxAttempts,
+	}, publishers...)
+}
+
+func TestDispatchPendingPublishesTheEvents(t *testing.T) {
+	db := newTestDB(t)
+	event := seedEvent(t, db, 1)
+
+	publisher := &testPublisher{name: "webhook"}
+	dispatched, err := newTestDispatcher(3, publisher).DispatchPending(context.Background())
+	if err != nil || dispatched != 1 {
+		t.Fatalf("expected a single event dispatched, got %d: %v", dispatched, err)
+	}
+
+	if len(publisher.published) != 1 || publisher.published[0].DedupeKey != "book.created:1" || publisher.published[0].Book.BookID != 1 {
+		t.Fatalf("expected the decoded event published, got %+v", publisher.published)
+	}
+
+	stored := readEvent(t, db, event.ID)
+	if stored.Status != StatusPublished || stored.PublishedAt == nil {
+		t.Fatalf("expected the event published, got %+v", stored)
+	}
+
+	// a published event is never claimed again
+	if dispatched, err := newTestDispatcher(3, publisher).DispatchPending(context.Background()); err != nil || dispatched != 0 {
+		t.Fatalf("expected nothing left to dispatch, got %d: %v", dispatched, err)
+	}
+}
+
+func TestDispatchPendingLeasesTheClaimedEvents(t *testing.T) {
+	db := newTestDB(t)
+	event := seedEvent(t, db, 1)
+
+	// another replica doesn't claim the events leased by a dispatcher that crashed before publishing
+	claimed, err := newTestDispatcher(3).claim(context.Background())
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("expected the event claimed, got %d: %v", len(claimed), err)
+	}
+
+	if claimed, err := newTestDispatcher(3).claim(context.Background()); err != nil || len(claimed) != 0 {
+		t.Fatalf("expected the leased event skipped, got %d: %v", len(claimed), err)
+	}
+
+	// the event is claimed again once the lease ends
+	if err := db.Model(&database.DBOutboxEvent{}).Where("id = ?", event.ID).Update("next_attempt_at", time.Now().Local().Add(-time.Second)).Error; err != nil {
+		t.Fatalf("end the lease: %v", err)
+	}
+
+	if claimed, err := newTestDispatcher(3).claim(context.Background()); err != nil || len(claimed) != 1 {
+		t.Fatalf("expected the event claimed after the lease, got %d: %v", len(claimed), err)
+	}
+}
+
+func TestDispatchPendingRetriesOnlyTheFailedPublishers(t *testing.T) {
+	db := newTestDB(t)
+	event := seedEvent(t, db, 1)
+
+	notification := &testPublisher{name: "notification"}
+	webhook := &testPublisher{name: "webhook", failures: 1}
+	dispatcher := newTestDispatcher(3, notification, webhook)
+
+	if _, err := dispatcher.DispatchPending(context.Background()); err != nil {
+		t.Fatalf("dispatch the events: %v", err)
+	}
+
+	stored := readEvent(t, db, event.ID)
+	if stored.Status != StatusPending || stored.Attempts != 1 || !strings.Contains(stored.LastError, "webhook: subscriber unavailable") {
+		t.Fatalf("expected the event retried later, got %+v", stored)
+	}
+
+	// the retry is backed off by the base delay
+	if delay := time.Until(stored.NextAttemptAt); delay < 5*time.Second || delay > 10*time.Second {
+		t.Fatalf("expected the retry in about 10 seconds, got %v", delay)
+	}
+
+	if err := db.Model(&database.DBOutboxEvent{}).Where("id = ?", event.ID).Update("next_attempt_at", time.Now().Local().Add(-time.Second)).Error; err != nil {
+		t.Fatalf("make the retry due: %v", err)
+	}
+
+	if _, err := dispatcher.DispatchPending(context.Background()); err != nil {
+		t.Fatalf("dispatch the events: %v", err)
+	}
+
+	if len(notification.published) != 1 || len(webhook.published) != 1 {
+		t.Fatalf("expected each publisher to publish once, got %d and %d", len(notification.published), len(webhook.published))
+	}
+
+	if stored := readEvent(t, db, event.ID); stored.Status != StatusPublished || stored.LastError != "" {
+		t.Fatalf("expected the event published on the retry, got %+v", stored)
+	}
+}
+
+func TestDispatchPendingGivesUpAfterTheMaxAttempts(t *testing.T) {
+	db := newTestDB(t)
+	event := seedEvent(t, db, 1)
+
+	if _, err := newTestDispatcher(1, &testPublisher{name: "webhook", failures: 1}).DispatchPending(context.Background()); err != nil {
+		t.Fatalf("dispatch the events: %v", err)
+	}
+
+	if stored := readEvent(t, db, event.ID); stored.Status != StatusFailed || stored.Attempts != 1 {
+		t.Fatalf("expected the event failed, got %+v", stored)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, expected := range map[uint]time.Duration{
+		1: 10 * time.Second,
+		2: 20 * time.Second,
+		3: 40 * time.Second,
+		4: time.Minute,
+		9: time.Minute,
+	} {
+		if delay := Backoff(10*time.Second, time.Minute, attempts); delay != expected {
+			t.Fatalf("expected attempt %d delayed by %v, got %v", attempts, expected, delay)
+		}
+	}
+}
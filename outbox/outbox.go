@@ -0,0 +1,112 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Outbox event statuses
+const (
+	StatusPending   uint = 0
+	StatusPublished uint = 1
+	StatusFailed    uint = 2 // gave up after the maximum attempts
+)
+
+// Event is a decoded outbox event handed to the publishers
+type Event struct {
+	ID        uint
+	DedupeKey string
+	Attempts  uint
+	Book      entities.BookEvent
+}
+
+// Publisher publishes outbox events to a subscriber, a publisher is skipped once it published an event
+// but a crash right after publishing makes it publish the event again, so subscribers must dedupe on the event DedupeKey
+type Publisher interface {
+	// Name returns the publisher name used in the logs and as delivery target
+	Name() string
+	// Publish delivers the given event to the subscriber
+	Publish(ctx context.Context, event *Event) error
+}
+
+// BookEventKey returns the dedupe key of a booking event that happens once per booking
+func BookEventKey(bookEvent *entities.BookEvent) string {
+	return bookEvent.Type + ":" + strconv.FormatUint(uint64(bookEvent.BookID), 10)
+}
+
+// AddBookEvent writes the given booking event to the outbox within the given transaction,
+// so the event is only published once the booking changes are committed
+func AddBookEvent(tx *gorm.DB, currentUser *database.MasterUser, dedupeKey string, bookEvent entities.BookEvent) error {
+
+	// set variables
+	var newEvent database.DBOutboxEvent
+
+	if bookEvent.OccurredAt.IsZero() {
+		bookEvent.OccurredAt = time.Now()
+	}
+
+	payload, err := json.Marshal(&bookEvent)
+	if err != nil {
+		return err
+	}
+
+	newEvent.EventType = bookEvent.Type
+	newEvent.AggregateID = bookEvent.BookID
+	newEvent.DedupeKey = dedupeKey
+	newEvent.Payload = string(payload)
+	newEvent.Status = StatusPending
+	newEvent.NextAttemptAt = time.Now().Local()
+	newEvent.IsActive = true
+	newEvent.Created = time.Now().Local()
+	newEvent.CreatedBy = currentUser.Username
+	newEvent.Modified = time.Now().Local()
+	newEvent.ModifiedBy = currentUser.Username
+
+	// insert the new outbox event to database
+	return tx.Create(&newEvent).Error
+}
+
+// DeliveredTargets returns the targets the event of the given dedupe key was already delivered to
+func DeliveredTargets(ctx context.Context, dedupeKey string) (map[string]bool, error) {
+	var targets []string
+	if err := config.DB.WithContext(ctx).Model(&database.DBOutboxDelivery{}).
+		Where("dedupe_key = ? AND is_active = ?", dedupeKey, true).
+		Pluck("target", &targets).Error; err != nil {
+		return nil, err
+	}
+
+	delivered := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		delivered[target] = true
+	}
+
+	return delivered, nil
+}
+
+// MarkDelivered records that the event of the given dedupe key was delivered to the given target,
+// marking a target twice is a no-op
+func MarkDelivered(ctx context.Context, dedupeKey, target string) error {
+
+	// set variables
+	var newDelivery database.DBOutboxDelivery
+
+	newDelivery.DedupeKey = dedupeKey
+	newDelivery.Target = target
+	newDelivery.DeliveredAt = time.Now().Local()
+	newDelivery.IsActive = true
+	newDelivery.Created = time.Now().Local()
+	newDelivery.CreatedBy = "outbox-dispatcher"
+	newDelivery.Modified = time.Now().Local()
+	newDelivery.ModifiedBy = "outbox-dispatcher"
+
+	// insert the new delivery to database, skipping the already recorded ones
+	return config.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&newDelivery).Error
+}
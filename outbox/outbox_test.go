@@ -0,0 +1,86 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"gorm.io/gorm"
+)
+
+func TestAddBookEventIsRolledBackWithTheTransaction(t *testing.T) {
+	db := newTestDB(t)
+	bookEvent := entities.BookEvent{Type: entities.EventBookOwnerApproved, BookID: 7}
+
+	// the event is only written once the booking changes are committed
+	rollback := db.Transaction(func(tx *gorm.DB) error {
+		if err := AddBookEvent(tx, &database.MasterUser{Username: "owner"}, BookEventKey(&bookEvent), bookEvent); err != nil {
+			t.Fatalf("add the event: %v", err)
+		}
+
+		return errors.New("approval failed")
+	})
+	if rollback == nil {
+		t.Fatal("expected the transaction rolled back")
+	}
+
+	var count int64
+	if err := db.Model(&database.DBOutboxEvent{}).Count(&count).Error; err != nil {
+		t.Fatalf("count the events: %v", err)
+	}
+
+	if count != 0 {
+		t.Fatalf("expected the event rolled back, got %d events", count)
+	}
+
+	if err := AddBookEvent(db, &database.MasterUser{Username: "owner"}, BookEventKey(&bookEvent), bookEvent); err != nil {
+		t.Fatalf("add the event: %v", err)
+	}
+
+	var stored database.DBOutboxEvent
+	if err := db.First(&stored).Error; err != nil {
+		t.Fatalf("read the event: %v", err)
+	}
+
+	var payload entities.BookEvent
+	if err := json.Unmarshal([]byte(stored.Payload), &payload); err != nil {
+		t.Fatalf("decode the payload: %v", err)
+	}
+
+	if stored.DedupeKey != "book.owner_approved:7" || payload.BookID != 7 || payload.OccurredAt.IsZero() {
+		t.Fatalf("expected the event stored with its key and time, got %+v", stored)
+	}
+
+	// the same booking event is written once
+	if err := AddBookEvent(db, &database.MasterUser{Username: "owner"}, BookEventKey(&bookEvent), bookEvent); err == nil {
+		t.Fatal("expected the duplicate event refused")
+	}
+}
+
+func TestMarkDelivered(t *testing.T) {
+	newTestDB(t)
+	ctx := context.Background()
+
+	// marking a target twice is a no-op
+	for i := 0; i < 2; i++ {
+		if err := MarkDelivered(ctx, "book.created:1", "webhook"); err != nil {
+			t.Fatalf("mark the delivery: %v", err)
+		}
+	}
+
+	if err := MarkDelivered(ctx, "book.created:2", "notification"); err != nil {
+		t.Fatalf("mark the delivery: %v", err)
+	}
+
+	delivered, err := DeliveredTargets(ctx, "book.created:1")
+	if err != nil {
+		t.Fatalf("read the deliveries: %v", err)
+	}
+
+	if len(delivered) != 1 || !delivered["webhook"] {
+		t.Fatalf("expected only the webhook delivery of the event, got %v", delivered)
+	}
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fakhripraya/book-service/entities"
+)
+
+// Rule is the limits of a rate limited route, a limit with a zero rate is disabled
+type Rule struct {
+	User Limit // per authenticated user
+	IP   Limit // per client ip
+}
+
+// Limiter enforces the rate limit rules of the routes against a bucket store
+type Limiter struct {
+	store             Store
+	rules             map[string]Rule
+	trustForwardedFor bool
+}
+
+// NewLimiter is a function to create new Limiter struct based on the given rate limit configuration
+func NewLimiter(store Store, rateLimitConfig *entities.RateLimitConfiguration) *Limiter {
+	rules := map[string]Rule{}
+	for name, rule := range rateLimitConfig.Rules {
+		rules[strings.ToLower(name)] = Rule{
+			User: Limit{Rate: rule.UserRate / 60, Burst: rule.UserBurst},
+			IP:   Limit{Rate: rule.IPRate / 60, Burst: rule.IPBurst},
+		}
+	}
+
+	return &Limiter{store: store, rules: rules, trustForwardedFor: rateLimitConfig.TrustForwardedFor}
+}
+
+// Take takes a token from the user bucket and the client ip bucket of the given rule,
+// the request is allowed when both buckets have a token and an unknown rule allows every request
+func (limiter *Limiter) Take(ctx context.Context, ruleName string, userID uint, r *http.Request) (Result, error) {
+	result := Result{Allowed: true, Remaining: -1}
+
+	rule, ok := limiter.rules[strings.ToLower(ruleName)]
+	if !ok {
+		return result, nil
+	}
+
+	// the ip bucket is taken first so a user can't spare the bucket of its ip by being limited
+	if rule.IP.Rate > 0 {
+		ipResult, err := limiter.store.Take(ctx, ruleName+":ip:"+limiter.ClientIP(r), rule.IP)
+		if err != nil {
+			return Result{}, err
+		}
+
+		result = merge(result, ipResult)
+	}
+
+	if rule.User.Rate > 0 && userID != 0 {
+		userResult, err := limiter.store.Take(ctx, ruleName+":user:"+strconv.FormatUint(uint64(userID), 10), rule.User)
+		if err != nil {
+			return Result{}, err
+		}
+
+		result = merge(result, userResult)
+	}
+
+	return result, nil
+}
+
+// ClientIP returns the ip of the client of the given request, from the X-Forwarded-For header when the load balancer is trusted
+func (limiter *Limiter) ClientIP(r *http.Request) string {
+
+	// the load balancer appends the ip it received the request from, the entries before it are set by the client
+	if limiter.trustForwardedFor {
+		forwardedFor := r.Header.Get("X-Forwarded-For")
+		if forwardedFor != "" {
+			entries := strings.Split(forwardedFor, ",")
+			if ip := strings.TrimSpace(entries[len(entries)-1]); net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// merge combines the results of two buckets, the request waits for the slowest bucket
+func merge(current, next Result) Result {
+	if !next.Allowed {
+		current.Allowed = false
+		if next.RetryAfter > current.RetryAfter {
+			current.RetryAfter = next.RetryAfter
+		}
+	}
+
+	if current.Remaining < 0 || next.Remaining < current.Remaining {
+		current.Remaining = next.Remaining
+	}
+
+	return current
+}
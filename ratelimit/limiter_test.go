@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fakhripraya/book-service/entities"
+)
+
+// failingStore is a Store that is unreachable
+type failingStore struct{}
+
+// Take fails
+func (failingStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	return Result{}, errors.New("store unreachable")
+}
+
+// newTestLimiter returns a limiter of the addbook rule with the given per minute limits on a memory store
+func newTestLimiter(userRate float64, userBurst int, ipRate float64, ipBurst int, trustForwardedFor bool) *Limiter {
+	return NewLimiter(NewMemoryStore(time.Hour), &entities.RateLimitConfiguration{
+		SweepInterval:     3600,
+		TrustForwardedFor: trustForwardedFor,
+		Rules:             map[string]entities.RateLimitRule{"AddBook": {UserRate: userRate, UserBurst: userBurst, IPRate: ipRate, IPBurst: ipBurst}},
+	})
+}
+
+// newClientRequest returns a request of the given remote address
+func newClientRequest(remoteAddr string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/book", nil)
+	r.RemoteAddr = remoteAddr
+
+	return r
+}
+
+func TestLimiterLimitsEveryUser(t *testing.T) {
+	limiter := newTestLimiter(1, 2, 0, 0, false)
+
+	for i := 0; i < 2; i++ {
+		if result, err := limiter.Take(context.Background(), "addbook", 1, newClientRequest("10.0.0.1:1234")); err != nil || !result.Allowed {
+			t.Fatalf("expected the request within the burst allowed, got %+v %v", result, err)
+		}
+	}
+
+	result, err := limiter.Take(context.Background(), "addbook", 1, newClientRequest("10.0.0.2:1234"))
+	if err != nil || result.Allowed || result.RetryAfter <= 0 {
+		t.Fatalf("expected the user limited from any ip, got %+v %v", result, err)
+	}
+
+	if result, _ := limiter.Take(context.Background(), "addbook", 2, newClientRequest("10.0.0.1:1234")); !result.Allowed {
+		t.Fatalf("expected the other user allowed")
+	}
+}
+
+func TestLimiterLimitsEveryClientIP(t *testing.T) {
+	limiter := newTestLimiter(60, 10, 1, 1, false)
+
+	if result, _ := limiter.Take(context.Background(), "addbook", 1, newClientRequest("10.0.0.1:1234")); !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("expected the request allowed with the fewest tokens left, got %+v", result)
+	}
+
+	// a new account doesn't lift the limit of its ip
+	if result, _ := limiter.Take(context.Background(), "addbook", 2, newClientRequest("10.0.0.1:4321")); result.Allowed {
+		t.Fatalf("expected the ip limited for every user")
+	}
+
+	if result, _ := limiter.Take(context.Background(), "addbook", 0, newClientRequest("10.0.0.2:1234")); !result.Allowed {
+		t.Fatalf("expected the other ip allowed")
+	}
+}
+
+func TestLimiterAllowsTheUnknownRules(t *testing.T) {
+	limiter := NewLimiter(failingStore{}, &entities.RateLimitConfiguration{})
+
+	result, err := limiter.Take(context.Background(), "addbook", 1, newClientRequest("10.0.0.1:1234"))
+	if err != nil || !result.Allowed || result.Remaining != -1 {
+		t.Fatalf("expected the request without rule allowed, got %+v %v", result, err)
+	}
+
+	// a known rule reports the store failure
+	limiter = NewLimiter(failingStore{}, &entities.RateLimitConfiguration{Rules: map[string]entities.RateLimitRule{"addbook": {UserRate: 1, UserBurst: 1}}})
+	if _, err := limiter.Take(context.Background(), "addbook", 1, newClientRequest("10.0.0.1:1234")); err == nil {
+		t.Fatalf("expected the store failure reported")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := newClientRequest("10.0.0.1:1234")
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.2")
+
+	if ip := newTestLimiter(1, 1, 1, 1, false).ClientIP(r); ip != "10.0.0.1" {
+		t.Fatalf("expected the forwarded ip ignored without a trusted load balancer, got %s", ip)
+	}
+
+	// the entries before the last one are set by the client
+	if ip := newTestLimiter(1, 1, 1, 1, true).ClientIP(r); ip != "198.51.100.2" {
+		t.Fatalf("expected the ip appended by the load balancer, got %s", ip)
+	}
+
+	r.Header.Set("X-Forwarded-For", "not-an-ip")
+	if ip := newTestLimiter(1, 1, 1, 1, true).ClientIP(r); ip != "10.0.0.1" {
+		t.Fatalf("expected an invalid forwarded ip ignored, got %s", ip)
+	}
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is the token bucket of a single key, the bucket holds up to Burst tokens and refills at Rate tokens per second
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed    bool
+	Remaining  int           // tokens left in the bucket, -1 when no bucket applies
+	RetryAfter time.Duration // how long until a token is available again, only set when not allowed
+}
+
+// Store holds the token buckets, a store shared by the replicas enforces the limits across all of them
+type Store interface {
+	// Take takes a token from the bucket of the given key, creating a full bucket when the key is new
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// bucket is the state of a token bucket
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryStore is a Store holding the buckets in the process memory, every replica enforces its own limits
+type MemoryStore struct {
+	mu            sync.Mutex
+	buckets       map[string]*bucket
+	sweepInterval time.Duration
+	lastSweep     time.Time
+}
+
+// NewMemoryStore is a function to create new MemoryStore struct, the full buckets are dropped on every sweep interval
+func NewMemoryStore(sweepInterval time.Duration) *MemoryStore {
+	return &MemoryStore{
+		buckets:       map[string]*bucket{},
+		sweepInterval: sweepInterval,
+		lastSweep:     time.Now(),
+	}
+}
+
+// Take takes a token from the bucket of the given key
+func (store *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// drop the idle buckets, a missing bucket is the same as a full one
+	if now.Sub(store.lastSweep) >= store.sweepInterval {
+		store.sweep(now)
+	}
+
+	current, ok := store.buckets[key]
+	if !ok {
+		current = &bucket{tokens: float64(limit.Burst), updated: now}
+		store.buckets[key] = current
+	}
+
+	// refill the tokens earned since the last take
+	current.tokens = refill(current.tokens, limit, now.Sub(current.updated))
+	current.updated = now
+
+	if current.tokens < 1 {
+		missing := 1 - current.tokens
+		retryAfter := time.Duration(math.Ceil(missing / limit.Rate * float64(time.Second)))
+
+		return Result{Allowed: false, RetryAfter: retryAfter}, nil
+	}
+
+	current.tokens--
+
+	return Result{Allowed: true, Remaining: int(current.tokens)}, nil
+}
+
+// sweep drops every bucket refilled by now, the store must be locked
+func (store *MemoryStore) sweep(now time.Time) {
+	for key, current := range store.buckets {
+
+		// a bucket idle for the sweep interval is refilled by any sane limit
+		if now.Sub(current.updated) >= store.sweepInterval {
+			delete(store.buckets, key)
+		}
+	}
+
+	store.lastSweep = now
+}
+
+// refill returns the tokens of a bucket after the given elapsed time, capped at the burst
+func refill(tokens float64, limit Limit, elapsed time.Duration) float64 {
+	tokens += elapsed.Seconds() * limit.Rate
+	if tokens > float64(limit.Burst) {
+		tokens = float64(limit.Burst)
+	}
+
+	return tokens
+}
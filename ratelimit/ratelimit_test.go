@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTakesUpToTheBurst(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	limit := Limit{Rate: 1, Burst: 3}
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		result, err := store.Take(context.Background(), "user:1", limit)
+		if err != nil || !result.Allowed || result.Remaining != remaining {
+			t.Fatalf("expected the request allowed with %d left, got %+v %v", remaining, result, err)
+		}
+	}
+
+	result, err := store.Take(context.Background(), "user:1", limit)
+	if err != nil || result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > time.Second {
+		t.Fatalf("expected the request limited until the next token, got %+v %v", result, err)
+	}
+
+	// every key has its own bucket
+	if result, err := store.Take(context.Background(), "user:2", limit); err != nil || !result.Allowed {
+		t.Fatalf("expected the other key allowed, got %+v %v", result, err)
+	}
+}
+
+func TestMemoryStoreRefillsTheBucket(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	limit := Limit{Rate: 100, Burst: 1}
+
+	if result, _ := store.Take(context.Background(), "user:1", limit); !result.Allowed {
+		t.Fatalf("expected the first request allowed")
+	}
+
+	if result, _ := store.Take(context.Background(), "user:1", limit); result.Allowed {
+		t.Fatalf("expected the empty bucket limited")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if result, _ := store.Take(context.Background(), "user:1", limit); !result.Allowed {
+		t.Fatalf("expected the refilled bucket allowed")
+	}
+}
+
+func TestRefillIsCappedAtTheBurst(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 5}
+
+	if tokens := refill(1, limit, time.Second); tokens != 3 {
+		t.Fatalf("expected 2 tokens earned in a second, got %v", tokens)
+	}
+
+	if tokens := refill(1, limit, time.Hour); tokens != 5 {
+		t.Fatalf("expected the tokens capped at the burst, got %v", tokens)
+	}
+}
+
+func TestMemoryStoreSweepsTheIdleBuckets(t *testing.T) {
+	store := NewMemoryStore(time.Minute)
+	now := time.Now()
+
+	store.buckets["idle"] = &bucket{tokens: 0, updated: now.Add(-2 * time.Minute)}
+	store.buckets["busy"] = &bucket{tokens: 0, updated: now.Add(-time.Second)}
+
+	store.sweep(now)
+	if _, ok := store.buckets["idle"]; ok {
+		t.Fatalf("expected the idle bucket dropped")
+	}
+
+	if _, ok := store.buckets["busy"]; !ok {
+		t.Fatalf("expected the busy bucket kept")
+	}
+}
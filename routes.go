@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/fakhripraya/book-service/data"
+	"github.com/fakhripraya/book-service/handlers"
+	"github.com/gorilla/mux"
+)
+
+// Adapter is an alias
+type Adapter func(http.Handler) http.Handler
+
+// Adapt takes Handler funcs and chains them to the main handler.
+func Adapt(handler http.Handler, adapters ...Adapter) http.Handler {
+	// The loop is reversed so the adapters/middleware gets executed in the same
+	// order as provided in the array.
+	for i := len(adapters); i > 0; i-- {
+		handler = adapters[i-1](handler)
+	}
+	return handler
+}
+
+// Route describes a single API endpoint along with its own middleware chain
+type Route struct {
+	Method   string
+	Path     string
+	Handler  http.HandlerFunc
+	Adapters []Adapter
+}
+
+// RegisterRoutes mounts every given route on the router, chaining each route middleware in order
+func RegisterRoutes(router *mux.Router, routes []Route) {
+	for _, route := range routes {
+		router.Handle(route.Path, Adapt(route.Handler, route.Adapters...)).Methods(route.Method)
+	}
+}
+
+// V1Routes returns the version 1 routes of the book API
+func V1Routes(bookHandler *handlers.BookHandler) []Route {
+	return []Route{
+
+		// get book handlers
+		{
+			Method:  http.MethodGet,
+			Path:    "/",
+			Handler: bookHandler.GetMyBook,
+			Adapters: []Adapter{
+				bookHandler.MiddlewareValidateAuth,
+				bookHandler.MiddlewareRequirePermission(data.PermissionBookRead),
+			},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/all",
+			Handler: bookHandler.GetMyBookList,
+			Adapters: []Adapter{
+				bookHandler.MiddlewareValidateAuth,
+				bookHandler.MiddlewareRequirePermission(data.PermissionBookRead),
+			},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/{id:[0-9]+}",
+			Handler: bookHandler.GetBook,
+			Adapters: []Adapter{
+				bookHandler.MiddlewareValidateAuth,
+				bookHandler.MiddlewareRequirePermission(data.PermissionBookRead),
+			},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/kost/{id:[0-9]+}/all",
+			Handler: bookHandler.GetKostBookList,
+			Adapters: []Adapter{
+				bookHandler.MiddlewareValidateAuth,
+				bookHandler.MiddlewareRequirePermission(data.PermissionBookRead),
+			},
+		},
+
+		// post add new book
+		{
+			Method:  http.MethodPost,
+			Path:    "/add",
+			Handler: bookHandler.AddBook,
+			Adapters: []Adapter{
+				bookHandler.MiddlewareValidateAuth,
+				bookHandler.MiddlewareRequirePermission(data.PermissionBookCreate),
+				bookHandler.MiddlewareRateLimit("addbook"),
+				bookHandler.MiddlewareIdempotency,
+				bookHandler.MiddlewareParseBookRequest,
+			},
+		},
+
+		// patch approve book
+		{
+			Method:  http.MethodPatch,
+			Path:    "/approve/owner",
+			Handler: bookHandler.OwnerApprovalBookTransaction,
+			Adapters: []Adapter{
+				bookHandler.MiddlewareValidateAuth,
+				bookHandler.MiddlewareRequirePermission(data.PermissionBookApproveOwner),
+				bookHandler.MiddlewareIdempotency,
+				bookHandler.MiddlewareParseApprovalRequest,
+			},
+		},
+		{
+			Method:  http.MethodPatch,
+			Path:    "/approve/tenant",
+			Handler: bookHandler.TenantApprovalBookTransaction,
+			Adapters: []Adapter{
+				bookHandler.MiddlewareValidateAuth,
+				bookHandler.MiddlewareRequirePermission(data.PermissionBookApproveTenant),
+				bookHandler.MiddlewareIdempotency,
+				bookHandler.MiddlewareParseApprovalRequest,
+			},
+		},
+
+		// owner webhooks
+		{
+			Method:  http.MethodGet,
+			Path:    "/webhooks",
+			Handler: bookHandler.GetWebhooks,
+			Adapters: []Adapter{
+				bookHandler.MiddlewareValidateAuth,
+				bookHandler.MiddlewareRequirePermission(data.PermissionWebhookManage),
+			},
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/webhooks",
+			Handler: bookHandler.AddWebhook,
+			Adapters: []Adapter{
+				bookHandler.MiddlewareValidateAuth,
+				bookHandler.MiddlewareRequirePermission(data.PermissionWebhookManage),
+				bookHandler.MiddlewareIdempotency,
+				bookHandler.MiddlewareParseWebhookRequest,
+			},
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/webhooks/{id:[0-9]+}",
+			Handler: bookHandler.RemoveWebhook,
+			Adapters: []Adapter{
+				bookHandler.MiddlewareValidateAuth,
+				bookHandler.MiddlewareRequirePermission(data.PermissionWebhookManage),
+			},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/webhooks/{id:[0-9]+}/deliveries",
+			Handler: bookHandler.GetWebhookDeliveries,
+			Adapters: []Adapter{
+				bookHandler.MiddlewareValidateAuth,
+				bookHandler.MiddlewareRequirePermission(data.PermissionWebhookManage),
+			},
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/webhooks/deliveries/{id:[0-9]+}/redeliver",
+			Handler: bookHandler.RedeliverWebhook,
+			Adapters: []Adapter{
+				bookHandler.MiddlewareValidateAuth,
+				bookHandler.MiddlewareRequirePermission(data.PermissionWebhookManage),
+				bookHandler.MiddlewareIdempotency,
+			},
+		},
+
+		// post logout
+		{
+			Method:   http.MethodPost,
+			Path:     "/logout",
+			Handler:  bookHandler.Logout,
+			Adapters: []Adapter{bookHandler.MiddlewareValidateAuth},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/logout/all",
+			Handler:  bookHandler.LogoutEverywhere,
+			Adapters: []Adapter{bookHandler.MiddlewareValidateAuth},
+		},
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fakhripraya/book-service/handlers"
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+)
+
+// recordAdapter returns an adapter appending the given name to the calls before calling next
+func recordAdapter(calls *[]string, name string) Adapter {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			*calls = append(*calls, name)
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+func TestAdaptRunsTheAdaptersInOrder(t *testing.T) {
+	var calls []string
+	handler := Adapt(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}), recordAdapter(&calls, "first"), recordAdapter(&calls, "second"))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if strings.Join(calls, ",") != "first,second,handler" {
+		t.Fatalf("expected the adapters run in the given order, got %v", calls)
+	}
+}
+
+func TestRegisterRoutesScopesTheMiddlewareToItsRoute(t *testing.T) {
+	var calls []string
+	handler := func(name string) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			calls = append(calls, name)
+		}
+	}
+
+	router := mux.NewRouter()
+	RegisterRoutes(router.PathPrefix("/v1").Subrouter(), []Route{
+		{Method: http.MethodPost, Path: "/add", Handler: handler("add"), Adapters: []Adapter{recordAdapter(&calls, "parse book")}},
+		{Method: http.MethodPost, Path: "/logout", Handler: handler("logout")},
+	})
+
+	for _, test := range []struct {
+		method string
+		target string
+		status int
+		calls  string
+	}{
+		{http.MethodPost, "/v1/add", http.StatusOK, "parse book,add"},
+		{http.MethodPost, "/v1/logout", http.StatusOK, "logout"},
+		{http.MethodGet, "/v1/add", http.StatusMethodNotAllowed, ""},
+		{http.MethodPost, "/add", http.StatusNotFound, ""},
+	} {
+		calls = nil
+
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, httptest.NewRequest(test.method, test.target, nil))
+
+		if rw.Code != test.status || strings.Join(calls, ",") != test.calls {
+			t.Fatalf("%s %s: expected %d calling %q, got %d calling %v", test.method, test.target, test.status, test.calls, rw.Code, calls)
+		}
+	}
+}
+
+func TestV1RoutesRequireAuthentication(t *testing.T) {
+	bookHandler := handlers.NewBookHandler(hclog.NewNullLogger(), nil, nil, nil, nil, nil, nil, nil)
+
+	router := mux.NewRouter()
+	routes := V1Routes(bookHandler)
+	RegisterRoutes(router.PathPrefix("/v1").Subrouter(), routes)
+
+	// an invalid bearer token is refused before any route handler or body parser runs
+	for _, route := range routes {
+		target := "/v1" + strings.NewReplacer("{id:[0-9]+}", "1").Replace(route.Path)
+		r := httptest.NewRequest(route.Method, target, strings.NewReader(`{}`))
+		r.Header.Set("Authorization", "Bearer invalid")
+
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, r)
+
+		if rw.Code != http.StatusUnauthorized {
+			t.Fatalf("%s %s: expected 401, got %d", route.Method, target, rw.Code)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/data"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/metrics"
+	"github.com/fakhripraya/book-service/outbox"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/gorm"
+)
+
+// Room book statuses handled by the booking expiry
+const (
+	bookStatusPendingOwner  uint = 0 // waiting for the owner approval
+	bookStatusPendingTenant uint = 1 // approved by the owner, waiting for the tenant confirmation
+	bookStatusConfirmed     uint = 2 // confirmed by the tenant, the rent is billed every period
+	bookStatusExpired       uint = 4 // unanswered before the deadline, the room is free to book again
+)
+
+// systemUser is the audit user of the changes made by the scheduled jobs
+var systemUser = &database.MasterUser{Username: "scheduler"}
+
+// BookExpiry defines a job that expires the bookings left unanswered past their deadline,
+// voiding their transaction and releasing the booked room
+type BookExpiry struct {
+	logger         hclog.Logger
+	ownerDeadline  time.Duration
+	tenantDeadline time.Duration
+	batchSize      int
+}
+
+// NewBookExpiry is a function to create new BookExpiry struct based on the given scheduler configuration
+func NewBookExpiry(newLogger hclog.Logger, schedulerConfig *entities.SchedulerConfiguration) *BookExpiry {
+	return &BookExpiry{
+		logger:         newLogger,
+		ownerDeadline:  time.Duration(schedulerConfig.OwnerApprovalDeadline) * time.Second,
+		tenantDeadline: time.Duration(schedulerConfig.TenantApprovalDeadline) * time.Second,
+		batchSize:      schedulerConfig.BatchSize,
+	}
+}
+
+// Name returns the job name
+func (expiry *BookExpiry) Name() string {
+	return "book-expiry"
+}
+
+// Run expires every overdue booking waiting for the owner or for the tenant
+func (expiry *BookExpiry) Run(ctx context.Context) error {
+	if err := expiry.expireAll(ctx, bookStatusPendingOwner, expiry.ownerDeadline); err != nil {
+		return err
+	}
+
+	return expiry.expireAll(ctx, bookStatusPendingTenant, expiry.tenantDeadline)
+}
+
+// expireAll expires the overdue bookings of the given status batch by batch, a zero deadline disables it
+func (expiry *BookExpiry) expireAll(ctx context.Context, status uint, deadline time.Duration) error {
+	if deadline <= 0 {
+		return nil
+	}
+
+	for ctx.Err() == nil {
+		found, expired, err := expiry.expireBatch(ctx, status, deadline)
+
+		if expired > 0 {
+			expiry.logger.Info("Expired unanswered bookings", "status", status, "count", expired)
+			metrics.BookTransition(metrics.BookStatus(status), bookStatusExpired, expired)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if found < expiry.batchSize {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// expireBatch expires a batch of bookings of the given status last changed before the deadline,
+// returning the number of bookings found and the number of them expired
+func (expiry *BookExpiry) expireBatch(ctx context.Context, status uint, deadline time.Duration) (found, expired int, err error) {
+	now := time.Now().Local()
+
+	// look for the overdue bookings
+	var books []database.DBTransactionRoomBook
+	if err := config.DB.WithContext(ctx).
+		Where("status = ? AND is_active = ? AND modified <= ?", status, true, now.Add(-deadline)).
+		Order("id").
+		Limit(expiry.batchSize).
+		Find(&books).Error; err != nil {
+		return 0, 0, err
+	}
+
+	// the approvals don't lock the bookings, each booking is expired in its own transaction
+	// so a booking answered meanwhile fails its versioned update without holding back the others
+	for i := range books {
+		err := config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return expiry.expire(tx, &books[i], now)
+		})
+
+		if errors.Is(err, data.ErrStaleVersion) {
+			expiry.logger.Debug("Booking answered before it expired", "book_id", books[i].ID)
+
+			continue
+		}
+
+		if err != nil {
+			return len(books), expired, err
+		}
+
+		expired++
+	}
+
+	return len(books), expired, nil
+}
+
+// expire expires the given booking, voids its booking transaction and writes the expiry event to the outbox
+func (expiry *BookExpiry) expire(tx *gorm.DB, book *database.DBTransactionRoomBook, now time.Time) error {
+
+	// release the room, an expired booking no longer holds it
+	book.Status = bookStatusExpired
+	book.Modified = now
+	book.ModifiedBy = systemUser.Username
+
+	if err := data.SaveVersioned(tx, book, &book.Version); err != nil {
+		return err
+	}
+
+	// look for the booking transactions
+	var transactionIDs []uint
+	if err := tx.Model(&database.DBTransaction{}).
+		Where("trx_reference_id = ? AND trx_category = ? AND is_active = ?", book.ID, data.TrxCategoryBooking, true).
+		Pluck("id", &transactionIDs).Error; err != nil {
+		return err
+	}
+
+	// void the booking transactions along with their details
+	if len(transactionIDs) > 0 {
+		voided := map[string]interface{}{
+			"is_active":   false,
+			"modified":    now,
+			"modified_by": systemUser.Username,
+		}
+
+		if err := tx.Model(&database.DBTransactionDetail{}).Where("trx_id IN ?", transactionIDs).Updates(voided).Error; err != nil {
+			return err
+		}
+
+		// the transactions are versioned, unlike their details
+		voided["version"] = gorm.Expr("version + 1")
+
+		if err := tx.Model(&database.DBTransaction{}).Where("id IN ?", transactionIDs).Updates(voided).Error; err != nil {
+			return err
+		}
+	}
+
+	bookEvent, err := newBookEvent(tx, entities.EventBookExpired, book)
+	if err != nil {
+		return err
+	}
+
+	return outbox.AddBookEvent(tx, systemUser, outbox.BookEventKey(&bookEvent), bookEvent)
+}
+
+// newBookEvent builds the outbox event of the given booking change made by the scheduler
+func newBookEvent(tx *gorm.DB, eventType string, book *database.DBTransactionRoomBook) (entities.BookEvent, error) {
+
+	// look for the booked kost to notify its owner
+	var kost database.DBKost
+	if err := tx.Where("id = ?", book.KostID).First(&kost).Error; err != nil {
+		return entities.BookEvent{}, err
+	}
+
+	return entities.BookEvent{
+		Type:      eventType,
+		BookID:    book.ID,
+		BookCode:  book.BookCode,
+		KostID:    kost.ID,
+		KostName:  kost.KostName,
+		OwnerID:   kost.OwnerID,
+		BookerID:  book.BookerID,
+		Status:    book.Status,
+		ActorName: systemUser.Username,
+	}, nil
+}
@@ -0,0 +1,187 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/data"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB replaces config.DB with an in-memory database holding the booking tables for the duration of the test
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.Replace(t.Name(), "/", "_", -1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open the test database: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get the test connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	if err := db.AutoMigrate(
+		&database.DBKost{},
+		&database.MasterPeriod{},
+		&database.DBTransactionRoomBook{},
+		&database.DBTransaction{},
+		&database.DBTransactionDetail{},
+		&database.DBOutboxEvent{},
+		&database.DBSchedulerLock{},
+	); err != nil {
+		t.Fatalf("migrate the test database: %v", err)
+	}
+
+	previous := config.DB
+	config.DB = db
+	t.Cleanup(func() {
+		config.DB = previous
+		sqlDB.Close()
+	})
+
+	return db
+}
+
+// seedBooking inserts a booking of a new kost in the given status last changed at the given time,
+// along with its booking transaction
+func seedBooking(t *testing.T, db *gorm.DB, status uint, modified time.Time) *database.DBTransactionRoomBook {
+	t.Helper()
+
+	kost := &database.DBKost{OwnerID: 10, KostName: "Kost Test", IsActive: true}
+	if err := db.Create(kost).Error; err != nil {
+		t.Fatalf("seed the kost: %v", err)
+	}
+
+	book := &database.DBTransactionRoomBook{BookerID: 20, KostID: kost.ID, Status: status, BookCode: "BOOK-TEST", Version: 1, IsActive: true, Modified: modified}
+	if err := db.Create(book).Error; err != nil {
+		t.Fatalf("seed the booking: %v", err)
+	}
+
+	transaction := &database.DBTransaction{TrxReferenceID: book.ID, TrxCategory: data.TrxCategoryBooking, MustPay: 1500000, Version: 1, IsActive: true}
+	if err := db.Create(transaction).Error; err != nil {
+		t.Fatalf("seed the transaction: %v", err)
+	}
+
+	return book
+}
+
+// newTestExpiry returns a booking expiry with a one hour deadline
+func newTestExpiry(batchSize int) *BookExpiry {
+	return NewBookExpiry(hclog.NewNullLogger(), &entities.SchedulerConfiguration{
+		OwnerApprovalDeadline:  3600,
+		TenantApprovalDeadline: 3600,
+		BatchSize:              batchSize,
+	})
+}
+
+// readBooking returns the stored state of the given booking
+func readBooking(t *testing.T, db *gorm.DB, id uint) *database.DBTransactionRoomBook {
+	t.Helper()
+
+	var book database.DBTransactionRoomBook
+	if err := db.First(&book, id).Error; err != nil {
+		t.Fatalf("read the booking: %v", err)
+	}
+
+	return &book
+}
+
+func TestBookExpiry(t *testing.T) {
+	db := newTestDB(t)
+	overdue := time.Now().Add(-2 * time.Hour)
+
+	pendingOwner := seedBooking(t, db, bookStatusPendingOwner, overdue)
+	pendingTenant := seedBooking(t, db, bookStatusPendingTenant, overdue)
+	recent := seedBooking(t, db, bookStatusPendingOwner, time.Now())
+	confirmed := seedBooking(t, db, bookStatusConfirmed, overdue)
+
+	// a batch smaller than the overdue bookings takes more than one batch
+	if err := newTestExpiry(1).Run(context.Background()); err != nil {
+		t.Fatalf("run the expiry: %v", err)
+	}
+
+	for _, book := range []*database.DBTransactionRoomBook{pendingOwner, pendingTenant} {
+		if stored := readBooking(t, db, book.ID); stored.Status != bookStatusExpired || stored.Version != 2 {
+			t.Fatalf("expected booking %d expired at version 2, got status %d version %d", book.ID, stored.Status, stored.Version)
+		}
+	}
+
+	for _, book := range []*database.DBTransactionRoomBook{recent, confirmed} {
+		if stored := readBooking(t, db, book.ID); stored.Status == bookStatusExpired {
+			t.Fatalf("expected booking %d left as is", book.ID)
+		}
+	}
+
+	// the booking transactions of the expired bookings are voided
+	var voided int64
+	if err := db.Model(&database.DBTransaction{}).Where("is_active = ?", false).Count(&voided).Error; err != nil {
+		t.Fatalf("count the voided transactions: %v", err)
+	}
+
+	var events int64
+	if err := db.Model(&database.DBOutboxEvent{}).Where("event_type = ?", entities.EventBookExpired).Count(&events).Error; err != nil {
+		t.Fatalf("count the expiry events: %v", err)
+	}
+
+	if voided != 2 || events != 2 {
+		t.Fatalf("expected 2 voided transactions and 2 expiry events, got %d and %d", voided, events)
+	}
+}
+
+func TestBookExpirySkipsBookingsAnsweredMeanwhile(t *testing.T) {
+	db := newTestDB(t)
+	overdue := time.Now().Add(-2 * time.Hour)
+
+	answered := seedBooking(t, db, bookStatusPendingOwner, overdue)
+	unanswered := seedBooking(t, db, bookStatusPendingOwner, overdue)
+
+	// the owner answers the first booking between the read and the update of the expiry,
+	// the answer is made on the connection of the expiry so it is rolled back along with the expiry
+	changed := false
+	err := db.Callback().Update().Before("gorm:update").Register("test:concurrent_answer", func(tx *gorm.DB) {
+		if changed || tx.Statement.Table != "db_transaction_room_books" {
+			return
+		}
+
+		changed = true
+		tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE db_transaction_room_books SET status = 1, version = version + 1 WHERE id = ?", answered.ID)
+	})
+	if err != nil {
+		t.Fatalf("register the concurrent answer: %v", err)
+	}
+
+	if err := newTestExpiry(10).Run(context.Background()); err != nil {
+		t.Fatalf("run the expiry: %v", err)
+	}
+
+	if stored := readBooking(t, db, answered.ID); stored.Status == bookStatusExpired {
+		t.Fatalf("expected the answered booking kept")
+	}
+
+	if stored := readBooking(t, db, unanswered.ID); stored.Status != bookStatusExpired {
+		t.Fatalf("expected the other booking of the batch expired, got status %d", stored.Status)
+	}
+
+	// only the transaction of the expired booking is voided
+	var transaction database.DBTransaction
+	if err := db.Where("trx_reference_id = ?", answered.ID).First(&transaction).Error; err != nil {
+		t.Fatalf("read the transaction: %v", err)
+	}
+
+	if !transaction.IsActive {
+		t.Fatalf("expected the transaction of the answered booking kept")
+	}
+}
@@ -0,0 +1,48 @@
+package scheduler
+
+import (
+	"context"
+
+	"github.com/fakhripraya/book-service/idempotency"
+	"github.com/hashicorp/go-hclog"
+)
+
+// IdempotencyPurge defines a job that deletes the expired idempotency keys
+type IdempotencyPurge struct {
+	logger    hclog.Logger
+	keys      *idempotency.Service
+	batchSize int
+}
+
+// NewIdempotencyPurge is a function to create new IdempotencyPurge struct
+func NewIdempotencyPurge(newLogger hclog.Logger, newKeys *idempotency.Service, batchSize int) *IdempotencyPurge {
+	return &IdempotencyPurge{logger: newLogger, keys: newKeys, batchSize: batchSize}
+}
+
+// Name returns the job name
+func (purge *IdempotencyPurge) Name() string {
+	return "idempotency-purge"
+}
+
+// Run deletes the expired idempotency keys batch by batch
+func (purge *IdempotencyPurge) Run(ctx context.Context) error {
+	total := 0
+
+	for ctx.Err() == nil {
+		purged, err := purge.keys.PurgeExpired(ctx, purge.batchSize)
+		if err != nil {
+			return err
+		}
+
+		total += purged
+		if purged < purge.batchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		purge.logger.Info("Purged the expired idempotency keys", "count", total)
+	}
+
+	return nil
+}
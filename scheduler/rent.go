@@ -0,0 +1,350 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/data"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/outbox"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxGeneratedPeriods caps the rent periods generated for a single booking in a single run
+const maxGeneratedPeriods = 12
+
+// RentBilling defines a job that bills the rent of the confirmed bookings every period,
+// reminds the tenants before the rent is due and marks the unpaid rent overdue with a late fee
+type RentBilling struct {
+	logger         hclog.Logger
+	leadTime       time.Duration
+	reminderLead   time.Duration
+	grace          time.Duration
+	lateFeePercent float64
+	batchSize      int
+}
+
+// NewRentBilling is a function to create new RentBilling struct based on the given scheduler configuration
+func NewRentBilling(newLogger hclog.Logger, schedulerConfig *entities.SchedulerConfiguration) *RentBilling {
+	day := 24 * time.Hour
+
+	return &RentBilling{
+		logger:         newLogger,
+		leadTime:       time.Duration(schedulerConfig.BillingLeadDays) * day,
+		reminderLead:   time.Duration(schedulerConfig.ReminderDays) * day,
+		grace:          time.Duration(schedulerConfig.OverdueGraceDays) * day,
+		lateFeePercent: schedulerConfig.LateFeePercent,
+		batchSize:      schedulerConfig.BatchSize,
+	}
+}
+
+// Name returns the job name
+func (billing *RentBilling) Name() string {
+	return "rent-billing"
+}
+
+// Run generates the upcoming rent transactions, then sends the reminders and tracks the overdue rent
+func (billing *RentBilling) Run(ctx context.Context) error {
+	if err := billing.generateAll(ctx); err != nil {
+		return err
+	}
+
+	if err := billing.remindAll(ctx); err != nil {
+		return err
+	}
+
+	if err := billing.markOverdueAll(ctx); err != nil {
+		return err
+	}
+
+	return billing.clearOverdue(ctx)
+}
+
+// generateAll generates the rent transactions of every confirmed booking batch by batch
+func (billing *RentBilling) generateAll(ctx context.Context) error {
+	var lastID uint
+
+	for ctx.Err() == nil {
+
+		// look for the next batch of confirmed bookings
+		var books []database.DBTransactionRoomBook
+		if err := config.DB.WithContext(ctx).Where("status = ? AND is_active = ? AND id > ?", bookStatusConfirmed, true, lastID).
+			Order("id").
+			Limit(billing.batchSize).
+			Find(&books).Error; err != nil {
+			return err
+		}
+
+		for i := range books {
+			lastID = books[i].ID
+
+			err := config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				return billing.generate(tx, &books[i])
+			})
+
+			if err != nil {
+				billing.logger.Error("Unable to generate the rent", "book_id", books[i].ID, "error", err)
+			}
+		}
+
+		if len(books) < billing.batchSize {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// generate creates the rent transactions of the given booking due within the lead time,
+// then refreshes the next due date of the booking
+func (billing *RentBilling) generate(tx *gorm.DB, book *database.DBTransactionRoomBook) error {
+
+	// look for the booking period, only the recurring periods are billed
+	var period database.MasterPeriod
+	if err := tx.Where("id = ?", book.PeriodID).First(&period).Error; err != nil {
+		return err
+	}
+
+	// the booking payment covers the first period, every rent is billed the same amount
+	var bookingTransaction database.DBTransaction
+	if err := tx.Where("trx_reference_id = ? AND trx_category = ? AND is_active = ?", book.ID, data.TrxCategoryBooking, true).
+		First(&bookingTransaction).Error; err != nil {
+		return err
+	}
+
+	// the last billed period starts on the booking date or on the last rent period
+	periodStart := book.BookDate
+	var lastRent database.DBTransaction
+	err := tx.Where("trx_reference_id = ? AND trx_category = ?", book.ID, data.TrxCategoryRent).
+		Order("period_start desc").
+		First(&lastRent).Error
+
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	if err == nil && lastRent.PeriodStart != nil {
+		periodStart = *lastRent.PeriodStart
+	}
+
+	// bill every period starting within the lead time
+	now := time.Now().Local()
+	nextStart, recurring := data.NextPeriodStart(period.PeriodDesc, periodStart)
+	if !recurring {
+		return nil
+	}
+
+	for i := 0; i < maxGeneratedPeriods && !nextStart.After(now.Add(billing.leadTime)); i++ {
+		dueDate := nextStart
+
+		newTransaction := database.DBTransaction{
+			TrxReferenceID: book.ID,
+			TrxCategory:    data.TrxCategoryRent,
+			PaidOff:        0,
+			MustPay:        bookingTransaction.MustPay,
+			PeriodStart:    &dueDate,
+			DueDate:        &dueDate,
+			IsActive:       true,
+			Created:        now,
+			CreatedBy:      systemUser.Username,
+			Modified:       now,
+			ModifiedBy:     systemUser.Username,
+		}
+
+		// insert the new rent transaction, a period billed by another run is skipped
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&newTransaction).Error; err != nil {
+			return err
+		}
+
+		nextStart, _ = data.NextPeriodStart(period.PeriodDesc, nextStart)
+	}
+
+	// the next due date is the earliest unpaid rent, otherwise the next period to bill
+	nextDueDate := nextStart
+	var unpaidRent database.DBTransaction
+	err = tx.Where("trx_reference_id = ? AND trx_category = ? AND is_active = ? AND paid_off < must_pay", book.ID, data.TrxCategoryRent, true).
+		Order("due_date").
+		First(&unpaidRent).Error
+
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	if err == nil && unpaidRent.DueDate != nil {
+		nextDueDate = *unpaidRent.DueDate
+	}
+
+	return tx.Model(book).Updates(map[string]interface{}{
+		"next_due_date": nextDueDate,
+		"modified":      now,
+		"modified_by":   systemUser.Username,
+		"version":       gorm.Expr("version + 1"),
+	}).Error
+}
+
+// remindAll tells the tenants about the unpaid rent due within the reminder lead time, once per rent
+func (billing *RentBilling) remindAll(ctx context.Context) error {
+	if billing.reminderLead <= 0 {
+		return nil
+	}
+
+	for ctx.Err() == nil {
+		now := time.Now().Local()
+
+		reminded, err := billing.eachUnpaidRent(ctx,
+			"reminded_at IS NULL AND due_date > ? AND due_date <= ?", []interface{}{now, now.Add(billing.reminderLead)},
+			func(tx *gorm.DB, rent *database.DBTransaction, book *database.DBTransactionRoomBook) error {
+
+				// mark the rent as reminded
+				if err := tx.Model(rent).Updates(map[string]interface{}{
+					"reminded_at": now,
+					"modified":    now,
+					"modified_by": systemUser.Username,
+					"version":     gorm.Expr("version + 1"),
+				}).Error; err != nil {
+					return err
+				}
+
+				return billing.addRentEvent(tx, entities.EventRentDue, rent, book)
+			})
+
+		if err != nil || reminded < billing.batchSize {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// markOverdueAll adds the late fee to the rent still unpaid after the grace period and marks its booking overdue
+func (billing *RentBilling) markOverdueAll(ctx context.Context) error {
+	for ctx.Err() == nil {
+		now := time.Now().Local()
+
+		marked, err := billing.eachUnpaidRent(ctx,
+			"overdue_at IS NULL AND due_date <= ?", []interface{}{now.Add(-billing.grace)},
+			func(tx *gorm.DB, rent *database.DBTransaction, book *database.DBTransactionRoomBook) error {
+
+				// charge the late fee once
+				lateFee := rent.MustPay * billing.lateFeePercent / 100
+				rent.LateFee = lateFee
+				rent.MustPay += lateFee
+				rent.OverdueAt = &now
+				rent.Modified = now
+				rent.ModifiedBy = systemUser.Username
+
+				if err := data.SaveVersioned(tx, rent, &rent.Version); err != nil {
+					return err
+				}
+
+				// the booking is overdue since its oldest unpaid rent was due
+				if book.OverdueSince == nil || rent.DueDate.Before(*book.OverdueSince) {
+					if err := tx.Model(book).Updates(map[string]interface{}{
+						"overdue_since": *rent.DueDate,
+						"modified":      now,
+						"modified_by":   systemUser.Username,
+						"version":       gorm.Expr("version + 1"),
+					}).Error; err != nil {
+						return err
+					}
+				}
+
+				return billing.addRentEvent(tx, entities.EventRentOverdue, rent, book)
+			})
+
+		if err != nil || marked < billing.batchSize {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clearOverdue clears the overdue mark of the bookings whose overdue rent has been paid
+func (billing *RentBilling) clearOverdue(ctx context.Context) error {
+	now := time.Now().Local()
+
+	// look for the bookings still owing an overdue rent
+	owing := config.DB.WithContext(ctx).Model(&database.DBTransaction{}).
+		Select("trx_reference_id").
+		Where("trx_category = ? AND is_active = ? AND overdue_at IS NOT NULL AND paid_off < must_pay", data.TrxCategoryRent, true)
+
+	result := config.DB.WithContext(ctx).Model(&database.DBTransactionRoomBook{}).
+		Where("overdue_since IS NOT NULL AND id NOT IN (?)", owing).
+		Updates(map[string]interface{}{
+			"overdue_since": nil,
+			"modified":      now,
+			"modified_by":   systemUser.Username,
+			"version":       gorm.Expr("version + 1"),
+		})
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected > 0 {
+		billing.logger.Info("Cleared the paid overdue bookings", "count", result.RowsAffected)
+	}
+
+	return nil
+}
+
+// eachUnpaidRent looks for a batch of unpaid rent matching the given condition
+// and calls the given function for every rent in its own transaction, returning the number of rent found
+func (billing *RentBilling) eachUnpaidRent(ctx context.Context, condition string, args []interface{}, apply func(tx *gorm.DB, rent *database.DBTransaction, book *database.DBTransactionRoomBook) error) (int, error) {
+
+	// look for the matching unpaid rent
+	var rents []database.DBTransaction
+	if err := config.DB.WithContext(ctx).
+		Where("trx_category = ? AND is_active = ? AND paid_off < must_pay", data.TrxCategoryRent, true).
+		Where(condition, args...).
+		Order("id").
+		Limit(billing.batchSize).
+		Find(&rents).Error; err != nil {
+		return 0, err
+	}
+
+	// the payments don't lock the rent, each rent is handled in its own transaction
+	// so a rent paid meanwhile fails its versioned update without holding back the others
+	for i := range rents {
+		err := config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var book database.DBTransactionRoomBook
+			if err := tx.Where("id = ?", rents[i].TrxReferenceID).First(&book).Error; err != nil {
+				return err
+			}
+
+			return apply(tx, &rents[i], &book)
+		})
+
+		if errors.Is(err, data.ErrStaleVersion) {
+			billing.logger.Debug("Rent changed before it was handled", "transaction_id", rents[i].ID)
+
+			continue
+		}
+
+		if err != nil {
+			return len(rents), err
+		}
+	}
+
+	return len(rents), nil
+}
+
+// addRentEvent writes the given rent event to the outbox, the event happens once per rent transaction
+func (billing *RentBilling) addRentEvent(tx *gorm.DB, eventType string, rent *database.DBTransaction, book *database.DBTransactionRoomBook) error {
+	bookEvent, err := newBookEvent(tx, eventType, book)
+	if err != nil {
+		return err
+	}
+
+	bookEvent.Amount = rent.MustPay - rent.PaidOff
+	bookEvent.DueDate = rent.DueDate
+
+	return outbox.AddBookEvent(tx, systemUser, eventType+":"+strconv.FormatUint(uint64(rent.ID), 10), bookEvent)
+}
@@ -0,0 +1,227 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fakhripraya/book-service/data"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/gorm"
+)
+
+// seedConfirmedBooking inserts a monthly booking confirmed on the given date along with its booking transaction
+func seedConfirmedBooking(t *testing.T, db *gorm.DB, bookDate time.Time) *database.DBTransactionRoomBook {
+	t.Helper()
+
+	period := &database.MasterPeriod{PeriodDesc: "Monthly", IsActive: true}
+	if err := db.Create(period).Error; err != nil {
+		t.Fatalf("seed the period: %v", err)
+	}
+
+	book := seedBooking(t, db, bookStatusConfirmed, bookDate)
+	if err := db.Model(book).Updates(map[string]interface{}{"period_id": period.ID, "book_date": bookDate}).Error; err != nil {
+		t.Fatalf("seed the booking period: %v", err)
+	}
+
+	book.PeriodID = period.ID
+	book.BookDate = bookDate
+
+	return book
+}
+
+// seedRent inserts an unpaid rent of the given booking due on the given date
+func seedRent(t *testing.T, db *gorm.DB, book *database.DBTransactionRoomBook, dueDate time.Time) *database.DBTransaction {
+	t.Helper()
+
+	rent := &database.DBTransaction{TrxReferenceID: book.ID, TrxCategory: data.TrxCategoryRent, MustPay: 1000, PeriodStart: &dueDate, DueDate: &dueDate, Version: 1, IsActive: true}
+	if err := db.Create(rent).Error; err != nil {
+		t.Fatalf("seed the rent: %v", err)
+	}
+
+	return rent
+}
+
+// newTestRentBilling returns a rent billing generating the rent a week ahead, reminding the tenants 3 days ahead
+// and charging a 10% late fee 3 days after the due date
+func newTestRentBilling(batchSize int) *RentBilling {
+	return NewRentBilling(hclog.NewNullLogger(), &entities.SchedulerConfiguration{
+		BatchSize:        batchSize,
+		BillingLeadDays:  7,
+		ReminderDays:     3,
+		OverdueGraceDays: 3,
+		LateFeePercent:   10,
+	})
+}
+
+// readRent returns the stored state of the given rent
+func readRent(t *testing.T, db *gorm.DB, id uint) *database.DBTransaction {
+	t.Helper()
+
+	var rent database.DBTransaction
+	if err := db.First(&rent, id).Error; err != nil {
+		t.Fatalf("read the rent: %v", err)
+	}
+
+	return &rent
+}
+
+// countEvents returns the number of the outbox events of the given type
+func countEvents(t *testing.T, db *gorm.DB, eventType string) int64 {
+	t.Helper()
+
+	var count int64
+	if err := db.Model(&database.DBOutboxEvent{}).Where("event_type = ?", eventType).Count(&count).Error; err != nil {
+		t.Fatalf("count the %s events: %v", eventType, err)
+	}
+
+	return count
+}
+
+func TestRentBillingGeneratesThePeriods(t *testing.T) {
+	db := newTestDB(t)
+	bookDate := time.Now().Local().AddDate(0, -2, -10)
+	book := seedConfirmedBooking(t, db, bookDate)
+
+	// the booking payment covers the first month, the next months starting within the week are billed
+	var expected []time.Time
+	for start := bookDate.AddDate(0, 1, 0); !start.After(time.Now().Add(7 * 24 * time.Hour)); start = start.AddDate(0, 1, 0) {
+		expected = append(expected, start)
+	}
+
+	// a second run bills nothing more
+	for run := 0; run < 2; run++ {
+		if err := newTestRentBilling(10).generateAll(context.Background()); err != nil {
+			t.Fatalf("generate the rent: %v", err)
+		}
+	}
+
+	var rents []database.DBTransaction
+	if err := db.Where("trx_reference_id = ? AND trx_category = ?", book.ID, data.TrxCategoryRent).Order("period_start").Find(&rents).Error; err != nil {
+		t.Fatalf("read the rent: %v", err)
+	}
+
+	if len(rents) != len(expected) {
+		t.Fatalf("expected %d rent periods, got %d", len(expected), len(rents))
+	}
+
+	for i, rent := range rents {
+		if !rent.PeriodStart.Equal(expected[i]) || rent.MustPay != 1500000 {
+			t.Fatalf("expected the rent of %v billed 1500000, got %v billed %v", expected[i], rent.PeriodStart, rent.MustPay)
+		}
+	}
+
+	// the booking is due on its earliest unpaid rent
+	var stored database.DBTransactionRoomBook
+	if err := db.First(&stored, book.ID).Error; err != nil {
+		t.Fatalf("read the booking: %v", err)
+	}
+
+	if stored.NextDueDate == nil || !stored.NextDueDate.Equal(expected[0]) {
+		t.Fatalf("expected the booking due on %v, got %v", expected[0], stored.NextDueDate)
+	}
+}
+
+func TestRentBillingRemindsOnce(t *testing.T) {
+	db := newTestDB(t)
+	book := seedConfirmedBooking(t, db, time.Now().Local().AddDate(0, 0, -10))
+	rent := seedRent(t, db, book, time.Now().Local().Add(48*time.Hour))
+
+	for run := 0; run < 2; run++ {
+		if err := newTestRentBilling(10).remindAll(context.Background()); err != nil {
+			t.Fatalf("remind the tenants: %v", err)
+		}
+	}
+
+	if stored := readRent(t, db, rent.ID); stored.RemindedAt == nil {
+		t.Fatalf("expected the rent reminded")
+	}
+
+	if count := countEvents(t, db, entities.EventRentDue); count != 1 {
+		t.Fatalf("expected a single reminder, got %d", count)
+	}
+}
+
+func TestRentBillingMarksOverdueUntilPaid(t *testing.T) {
+	db := newTestDB(t)
+	book := seedConfirmedBooking(t, db, time.Now().Local().AddDate(0, 0, -10))
+	dueDate := time.Now().Local().AddDate(0, 0, -5)
+	rent := seedRent(t, db, book, dueDate)
+
+	if err := newTestRentBilling(10).Run(context.Background()); err != nil {
+		t.Fatalf("run the rent billing: %v", err)
+	}
+
+	// the late fee is charged once
+	stored := readRent(t, db, rent.ID)
+	if stored.LateFee != 100 || stored.MustPay != 1100 || stored.OverdueAt == nil {
+		t.Fatalf("expected a late fee of 100 charged, got %+v", stored)
+	}
+
+	var overdueBook database.DBTransactionRoomBook
+	if err := db.First(&overdueBook, book.ID).Error; err != nil {
+		t.Fatalf("read the booking: %v", err)
+	}
+
+	if overdueBook.OverdueSince == nil || !overdueBook.OverdueSince.Equal(dueDate) {
+		t.Fatalf("expected the booking overdue since %v, got %v", dueDate, overdueBook.OverdueSince)
+	}
+
+	// the overdue mark is cleared once the rent is paid
+	if err := db.Model(stored).Update("paid_off", stored.MustPay).Error; err != nil {
+		t.Fatalf("pay the rent: %v", err)
+	}
+
+	if err := newTestRentBilling(10).Run(context.Background()); err != nil {
+		t.Fatalf("run the rent billing: %v", err)
+	}
+
+	var paidBook database.DBTransactionRoomBook
+	if err := db.First(&paidBook, book.ID).Error; err != nil {
+		t.Fatalf("read the booking: %v", err)
+	}
+
+	if paidBook.OverdueSince != nil {
+		t.Fatalf("expected the overdue mark cleared, got %v", paidBook.OverdueSince)
+	}
+
+	if count := countEvents(t, db, entities.EventRentOverdue); count != 1 {
+		t.Fatalf("expected a single overdue event, got %d", count)
+	}
+}
+
+func TestRentBillingSkipsRentChangedMeanwhile(t *testing.T) {
+	db := newTestDB(t)
+	book := seedConfirmedBooking(t, db, time.Now().Local().AddDate(0, 0, -10))
+	changed := seedRent(t, db, book, time.Now().Local().AddDate(0, 0, -5))
+	unchanged := seedRent(t, db, book, time.Now().Local().AddDate(0, 0, -4))
+
+	// the tenant pays the first rent between the read and the update of the overdue mark,
+	// the payment is made on the connection of the job so it is rolled back along with the mark
+	bumped := false
+	err := db.Callback().Update().Before("gorm:update").Register("test:concurrent_payment", func(tx *gorm.DB) {
+		if bumped || tx.Statement.Table != "db_transactions" {
+			return
+		}
+
+		bumped = true
+		tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE db_transactions SET version = version + 1 WHERE id = ?", changed.ID)
+	})
+	if err != nil {
+		t.Fatalf("register the concurrent payment: %v", err)
+	}
+
+	if err := newTestRentBilling(10).markOverdueAll(context.Background()); err != nil {
+		t.Fatalf("mark the overdue rent: %v", err)
+	}
+
+	if stored := readRent(t, db, changed.ID); stored.OverdueAt != nil {
+		t.Fatalf("expected the changed rent left for the next run")
+	}
+
+	if stored := readRent(t, db, unchanged.ID); stored.OverdueAt == nil || stored.LateFee != 100 {
+		t.Fatalf("expected the other rent of the batch marked overdue, got %+v", stored)
+	}
+}
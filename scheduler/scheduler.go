@@ -0,0 +1,177 @@
+package scheduler
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/tracing"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/gorm/clause"
+)
+
+// Job is a task run periodically by the scheduler
+type Job interface {
+	// Name returns the job name, it identifies the job lock shared by the replicas
+	Name() string
+	// Run runs the job once
+	Run(ctx context.Context) error
+}
+
+// scheduledJob is a job with its run interval
+type scheduledJob struct {
+	job      Job
+	interval time.Duration
+}
+
+// Scheduler defines a struct that runs the registered jobs periodically,
+// every run first takes the job lock in the db so only one replica runs a job at a time
+type Scheduler struct {
+	logger hclog.Logger
+	holder string
+	lease  time.Duration
+	jobs   []scheduledJob
+	wg     sync.WaitGroup
+}
+
+// NewScheduler is a function to create new Scheduler struct, a job lock is held for at most the given lease
+func NewScheduler(newLogger hclog.Logger, lease time.Duration) *Scheduler {
+	hostname, _ := os.Hostname()
+
+	return &Scheduler{
+		logger: newLogger,
+		holder: hostname + ":" + strconv.Itoa(os.Getpid()),
+		lease:  lease,
+	}
+}
+
+// Add registers the given job to run on every given interval
+func (scheduler *Scheduler) Add(job Job, interval time.Duration) {
+	scheduler.jobs = append(scheduler.jobs, scheduledJob{job, interval})
+}
+
+// Run starts every registered job until the given context is done
+func (scheduler *Scheduler) Run(ctx context.Context) {
+	for _, scheduled := range scheduler.jobs {
+		if scheduled.interval <= 0 {
+			scheduler.logger.Info("Scheduled job disabled", "job", scheduled.job.Name())
+			continue
+		}
+
+		scheduler.wg.Add(1)
+		go scheduler.loop(ctx, scheduled)
+	}
+}
+
+// Wait blocks until every job stopped after the context given to Run is done
+func (scheduler *Scheduler) Wait() {
+	scheduler.wg.Wait()
+}
+
+// loop runs the given job on every interval
+func (scheduler *Scheduler) loop(ctx context.Context, scheduled scheduledJob) {
+	defer scheduler.wg.Done()
+
+	ticker := time.NewTicker(scheduled.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scheduler.runOnce(ctx, scheduled.job)
+		}
+	}
+}
+
+// runOnce runs the given job if this replica gets its lock
+func (scheduler *Scheduler) runOnce(ctx context.Context, job Job) {
+	acquired, err := scheduler.lock(job.Name())
+	if err != nil {
+		scheduler.logger.Error("Unable to take the job lock", "job", job.Name(), "error", err)
+
+		return
+	}
+
+	// another replica is running the job
+	if !acquired {
+		return
+	}
+
+	defer scheduler.unlock(job.Name())
+
+	// every run is traced as its own root span
+	ctx, span := tracing.Start(ctx, "Job "+job.Name())
+
+	started := time.Now()
+	err = job.Run(ctx)
+	tracing.End(span, err)
+
+	if err != nil {
+		scheduler.logger.Error("Scheduled job failed", "job", job.Name(), "error", err)
+
+		return
+	}
+
+	scheduler.logger.Debug("Scheduled job done", "job", job.Name(), "duration", time.Since(started))
+}
+
+// lock takes the lock of the given job when it is free or expired,
+// a crashed replica releases its locks once the lease ends
+func (scheduler *Scheduler) lock(jobName string) (bool, error) {
+	now := time.Now().Local()
+
+	// make sure the lock row exists
+	newLock := database.DBSchedulerLock{
+		JobName:     jobName,
+		Holder:      "",
+		LockedUntil: now,
+		IsActive:    true,
+		Created:     now,
+		CreatedBy:   "scheduler",
+		Modified:    now,
+		ModifiedBy:  "scheduler",
+	}
+
+	if err := config.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&newLock).Error; err != nil {
+		return false, err
+	}
+
+	// take the lock, only one replica can update the row while it is free
+	result := config.DB.Model(&database.DBSchedulerLock{}).
+		Where("job_name = ? AND (locked_until <= ? OR holder = ?)", jobName, now, scheduler.holder).
+		Updates(map[string]interface{}{
+			"holder":       scheduler.holder,
+			"locked_until": now.Add(scheduler.lease),
+			"modified":     now,
+			"modified_by":  "scheduler",
+		})
+
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// unlock releases the lock of the given job held by this replica
+func (scheduler *Scheduler) unlock(jobName string) {
+	now := time.Now().Local()
+
+	err := config.DB.Model(&database.DBSchedulerLock{}).
+		Where("job_name = ? AND holder = ?", jobName, scheduler.holder).
+		Updates(map[string]interface{}{
+			"locked_until": now,
+			"modified":     now,
+			"modified_by":  "scheduler",
+		}).Error
+
+	if err != nil {
+		scheduler.logger.Error("Unable to release the job lock", "job", jobName, "error", err)
+	}
+}
@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// countingJob counts its runs and runs the given function on each
+type countingJob struct {
+	runs int32
+	run  func() error
+}
+
+// Name returns the job name
+func (job *countingJob) Name() string {
+	return "counting"
+}
+
+// Run counts the run
+func (job *countingJob) Run(ctx context.Context) error {
+	atomic.AddInt32(&job.runs, 1)
+
+	if job.run != nil {
+		return job.run()
+	}
+
+	return nil
+}
+
+// newTestScheduler returns a scheduler standing for the replica of the given name
+func newTestScheduler(holder string, lease time.Duration) *Scheduler {
+	scheduler := NewScheduler(hclog.NewNullLogger(), lease)
+	scheduler.holder = holder
+
+	return scheduler
+}
+
+func TestSchedulerRunsAJobOnOneReplicaAtATime(t *testing.T) {
+	newTestDB(t)
+	first, second := newTestScheduler("first", time.Minute), newTestScheduler("second", time.Minute)
+
+	// the second replica skips the job while the first one runs it
+	job := &countingJob{}
+	job.run = func() error {
+		if atomic.LoadInt32(&job.runs) == 1 {
+			second.runOnce(context.Background(), job)
+		}
+
+		return nil
+	}
+
+	first.runOnce(context.Background(), job)
+	if runs := atomic.LoadInt32(&job.runs); runs != 1 {
+		t.Fatalf("expected the job run by the first replica only, got %d runs", runs)
+	}
+
+	// the lock is released once the run ends, even when the run fails
+	job.run = func() error { return errors.New("job failed") }
+	second.runOnce(context.Background(), job)
+	first.runOnce(context.Background(), job)
+	if runs := atomic.LoadInt32(&job.runs); runs != 3 {
+		t.Fatalf("expected the job run once the lock was released, got %d runs", runs)
+	}
+}
+
+func TestSchedulerLockExpiresWithItsLease(t *testing.T) {
+	newTestDB(t)
+	crashed, other := newTestScheduler("crashed", 50*time.Millisecond), newTestScheduler("other", time.Minute)
+
+	// a replica crashing while holding the lock never releases it
+	if acquired, err := crashed.lock("counting"); err != nil || !acquired {
+		t.Fatalf("expected the lock taken, got %v: %v", acquired, err)
+	}
+
+	if acquired, err := other.lock("counting"); err != nil || acquired {
+		t.Fatalf("expected the held lock refused, got %v: %v", acquired, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if acquired, err := other.lock("counting"); err != nil || !acquired {
+		t.Fatalf("expected the lock taken once the lease ended, got %v: %v", acquired, err)
+	}
+}
+
+func TestSchedulerStopsWithTheContext(t *testing.T) {
+	newTestDB(t)
+	scheduler := newTestScheduler("first", time.Minute)
+
+	job, disabled := &countingJob{}, &countingJob{}
+	scheduler.Add(job, 10*time.Millisecond)
+	scheduler.Add(disabled, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler.Run(ctx)
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	scheduler.Wait()
+
+	if atomic.LoadInt32(&job.runs) == 0 || atomic.LoadInt32(&disabled.runs) != 0 {
+		t.Fatalf("expected only the enabled job run, got %d and %d runs", job.runs, disabled.runs)
+	}
+}
@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// gormSpanKey is the key of the query span in the gorm statement instance
+const gormSpanKey = "tracing:span"
+
+// GormPlugin is a gorm plugin that traces every query as a child of the span of the statement context,
+// queries must be run with db.WithContext to join the request trace
+type GormPlugin struct{}
+
+// NewGormPlugin is a function to create new GormPlugin struct
+func NewGormPlugin() *GormPlugin {
+	return &GormPlugin{}
+}
+
+// Name returns the plugin name
+func (plugin *GormPlugin) Name() string {
+	return "tracing"
+}
+
+// Initialize registers the span callbacks around every gorm operation
+func (plugin *GormPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+
+	if err := callback.Create().Before("gorm:create").Register("tracing:before_create", plugin.before("gorm.Create")); err != nil {
+		return err
+	}
+	if err := callback.Create().After("gorm:create").Register("tracing:after_create", plugin.after); err != nil {
+		return err
+	}
+	if err := callback.Query().Before("gorm:query").Register("tracing:before_query", plugin.before("gorm.Query")); err != nil {
+		return err
+	}
+	if err := callback.Query().After("gorm:query").Register("tracing:after_query", plugin.after); err != nil {
+		return err
+	}
+	if err := callback.Update().Before("gorm:update").Register("tracing:before_update", plugin.before("gorm.Update")); err != nil {
+		return err
+	}
+	if err := callback.Update().After("gorm:update").Register("tracing:after_update", plugin.after); err != nil {
+		return err
+	}
+	if err := callback.Delete().Before("gorm:delete").Register("tracing:before_delete", plugin.before("gorm.Delete")); err != nil {
+		return err
+	}
+	if err := callback.Delete().After("gorm:delete").Register("tracing:after_delete", plugin.after); err != nil {
+		return err
+	}
+	if err := callback.Row().Before("gorm:row").Register("tracing:before_row", plugin.before("gorm.Row")); err != nil {
+		return err
+	}
+	if err := callback.Row().After("gorm:row").Register("tracing:after_row", plugin.after); err != nil {
+		return err
+	}
+	if err := callback.Raw().Before("gorm:raw").Register("tracing:before_raw", plugin.before("gorm.Raw")); err != nil {
+		return err
+	}
+
+	return callback.Raw().After("gorm:raw").Register("tracing:after_raw", plugin.after)
+}
+
+// before starts the span of the given gorm operation
+func (plugin *GormPlugin) before(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		_, span := otel.Tracer(instrumentationName).Start(db.Statement.Context, operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemMySQL),
+		)
+
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+// after ends the span of the gorm operation with its statement, only the placeholders are recorded and never the values
+func (plugin *GormPlugin) after(db *gorm.DB) {
+	value, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+
+	span, ok := value.(trace.Span)
+	if !ok {
+		return
+	}
+
+	span.SetAttributes(
+		semconv.DBStatementKey.String(db.Statement.SQL.String()),
+		semconv.DBSQLTableKey.String(db.Statement.Table),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+
+	// a missing record is an expected outcome rather than a failure
+	err := db.Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+
+	End(span, err)
+}
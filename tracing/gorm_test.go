@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// tracedRow is the table of the traced queries
+type tracedRow struct {
+	ID   uint
+	Name string
+}
+
+func TestGormPluginTracesTheQueries(t *testing.T) {
+	recorder := useSpanRecorder(t)
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open the test database: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get the test connection pool: %v", err)
+	}
+	defer sqlDB.Close()
+
+	if err := db.AutoMigrate(&tracedRow{}); err != nil {
+		t.Fatalf("migrate the test database: %v", err)
+	}
+
+	if err := db.Use(NewGormPlugin()); err != nil {
+		t.Fatalf("use the tracing plugin: %v", err)
+	}
+
+	ctx, parent := Start(context.Background(), "Book.GetBook")
+	db.WithContext(ctx).Create(&tracedRow{Name: "secret-value"})
+
+	var row tracedRow
+	err = db.WithContext(ctx).Where("name = ?", "missing").First(&row).Error
+	parent.End()
+
+	if err != gorm.ErrRecordNotFound {
+		t.Fatalf("expected the row not found, got %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 || spans[0].Name() != "gorm.Create" || spans[1].Name() != "gorm.Query" {
+		t.Fatalf("expected the create and query spans, got %d spans", len(spans))
+	}
+
+	for _, span := range spans[:2] {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Fatalf("expected %s under the request span", span.Name())
+		}
+
+		// only the placeholders are recorded, never the values
+		if statement := spanAttribute(span, "db.statement").AsString(); statement == "" || strings.Contains(statement, "secret-value") || strings.Contains(statement, "missing") {
+			t.Fatalf("expected the statement recorded without its values, got %q", statement)
+		}
+	}
+
+	// a missing record is an expected outcome
+	if spans[1].Status().Code == codes.Error {
+		t.Fatal("expected the record not found not recorded as an error")
+	}
+}
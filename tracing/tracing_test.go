@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// useSpanRecorder records every span of the global tracer provider for the duration of the test
+func useSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	return recorder
+}
+
+// spanAttribute returns the value of the given attribute of the given span
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, keyValue := range span.Attributes() {
+		if keyValue.Key == key {
+			return keyValue.Value
+		}
+	}
+
+	return attribute.Value{}
+}
+
+func TestMiddlewareContinuesTheCallerTrace(t *testing.T) {
+	recorder := useSpanRecorder(t)
+
+	var handlerTraceID string
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/v1/{id:[0-9]+}", func(rw http.ResponseWriter, r *http.Request) {
+		handlerTraceID = TraceID(r.Context())
+		rw.WriteHeader(http.StatusInternalServerError)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/7", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected a single server span, got %d", len(spans))
+	}
+
+	span := spans[0]
+	if span.Name() != "GET /v1/{id:[0-9]+}" || span.SpanKind() != trace.SpanKindServer {
+		t.Fatalf("expected a server span named after the route template, got %s", span.Name())
+	}
+
+	if span.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || handlerTraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected the trace of the caller continued, got %s", span.SpanContext().TraceID())
+	}
+
+	if span.Status().Code != codes.Error || spanAttribute(span, "http.status_code").AsInt64() != http.StatusInternalServerError {
+		t.Fatalf("expected the server error recorded, got %v", span.Status())
+	}
+}
+
+func TestStartAndEnd(t *testing.T) {
+	recorder := useSpanRecorder(t)
+
+	ctx, parent := Start(context.Background(), "Book.AddBook")
+	_, child := Start(ctx, "SessionStore.Get")
+	End(child, errors.New("session store unavailable"))
+	End(parent, nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+
+	if spans[0].Parent().SpanID() != spans[1].SpanContext().SpanID() {
+		t.Fatal("expected the child span under its parent")
+	}
+
+	if spans[0].Status().Code != codes.Error || spans[1].Status().Code == codes.Error {
+		t.Fatalf("expected only the failed span in error, got %v and %v", spans[0].Status(), spans[1].Status())
+	}
+
+	if TraceID(context.Background()) != "" {
+		t.Fatal("expected no trace id outside a trace")
+	}
+}
+
+func TestInitRefusesAnUnknownExporter(t *testing.T) {
+	if _, err := Init(context.Background(), &entities.TracingConfiguration{Exporter: "zipkin"}); err == nil {
+		t.Fatal("expected the unknown exporter refused")
+	}
+
+	shutdown, err := Init(context.Background(), &entities.TracingConfiguration{Exporter: ExporterNone})
+	if err != nil || shutdown(context.Background()) != nil {
+		t.Fatalf("expected the disabled tracing to start and stop, got %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/outbox"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxLoggedResponseBody is the largest part of a response body kept in the attempt log
+const maxLoggedResponseBody = 1024
+
+// Deliverer defines a struct that posts the pending webhook deliveries with retry and backoff
+type Deliverer struct {
+	logger       hclog.Logger
+	client       *http.Client
+	pollInterval time.Duration
+	lease        time.Duration
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+	batchSize    int
+	maxAttempts  uint
+}
+
+// NewDeliverer is a function to create new Deliverer struct based on the given webhook configuration
+func NewDeliverer(newLogger hclog.Logger, webhookConfig *entities.WebhookConfiguration) *Deliverer {
+	return &Deliverer{
+		logger:       newLogger,
+		client:       NewGuard(webhookConfig.AllowPrivateNetworks).NewClient(time.Duration(webhookConfig.Timeout) * time.Second),
+		pollInterval: time.Duration(webhookConfig.PollInterval) * time.Second,
+		lease:        time.Duration(webhookConfig.Lease) * time.Second,
+		baseBackoff:  time.Duration(webhookConfig.BaseBackoff) * time.Second,
+		maxBackoff:   time.Duration(webhookConfig.MaxBackoff) * time.Second,
+		batchSize:    webhookConfig.BatchSize,
+		maxAttempts:  uint(webhookConfig.MaxAttempts),
+	}
+}
+
+// Run posts the pending deliveries on every poll interval until the given context is done
+func (deliverer *Deliverer) Run(ctx context.Context) {
+	ticker := time.NewTicker(deliverer.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// keep delivering while full batches are found
+			for {
+				delivered, err := deliverer.DeliverPending(ctx)
+				if err != nil {
+					deliverer.logger.Error("Unable to deliver the webhooks", "error", err)
+				}
+
+				if err != nil || delivered < deliverer.batchSize || ctx.Err() != nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+// DeliverPending claims a batch of due deliveries and posts them, returning the number of claimed deliveries
+func (deliverer *Deliverer) DeliverPending(ctx context.Context) (int, error) {
+	deliveries, err := deliverer.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range deliveries {
+		deliverer.deliver(ctx, &deliveries[i])
+	}
+
+	return len(deliveries), nil
+}
+
+// claim locks a batch of due deliveries and leases them to this deliverer,
+// so other replicas skip them until the lease ends
+func (deliverer *Deliverer) claim(ctx context.Context) ([]database.DBWebhookDelivery, error) {
+	var deliveries []database.DBWebhookDelivery
+
+	err := config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().Local()
+
+		// look for the due deliveries, skipping the ones locked by another replica
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND is_active = ? AND next_attempt_at <= ?", StatusPending, true, now).
+			Order("id").
+			Limit(deliverer.batchSize).
+			Find(&deliveries).Error; err != nil {
+			return err
+		}
+
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(deliveries))
+		for _, delivery := range deliveries {
+			ids = append(ids, delivery.ID)
+		}
+
+		// lease the deliveries, a crashed deliverer releases them once the lease ends
+		return tx.Model(&database.DBWebhookDelivery{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(deliverer.lease)).Error
+	})
+
+	return deliveries, err
+}
+
+// deliver posts the given delivery to its subscription, logs the attempt and records the outcome
+func (deliverer *Deliverer) deliver(ctx context.Context, delivery *database.DBWebhookDelivery) {
+	now := time.Now().Local()
+	updates := map[string]interface{}{
+		"modified":    now,
+		"modified_by": "webhook-deliverer",
+	}
+
+	// look for the subscription, deliveries of a removed subscription are dropped
+	var subscription database.DBWebhookSubscription
+	err := config.DB.WithContext(ctx).Where("id = ? AND is_active = ?", delivery.SubscriptionID, true).First(&subscription).Error
+	if err == gorm.ErrRecordNotFound {
+		updates["status"] = StatusFailed
+		updates["last_error"] = "subscription removed"
+		deliverer.record(ctx, delivery, updates)
+
+		return
+	}
+
+	if err != nil {
+		deliverer.logger.Error("Unable to look for the webhook subscription", "delivery_id", delivery.ID, "error", err)
+
+		return
+	}
+
+	// post the payload and log the attempt
+	attempt := deliverer.post(ctx, &subscription, delivery)
+	if err := config.DB.WithContext(ctx).Create(attempt).Error; err != nil {
+		deliverer.logger.Error("Unable to log the webhook attempt", "delivery_id", delivery.ID, "error", err)
+	}
+
+	attempts := delivery.Attempts + 1
+	updates["attempts"] = attempts
+	updates["response_status"] = attempt.ResponseStatus
+
+	if attempt.Error == "" {
+		updates["status"] = StatusDelivered
+		updates["delivered_at"] = now
+		updates["last_error"] = ""
+	} else {
+		updates["last_error"] = attempt.Error
+		updates["next_attempt_at"] = now.Add(outbox.Backoff(deliverer.baseBackoff, deliverer.maxBackoff, attempts))
+
+		if attempts >= deliverer.maxAttempts {
+			updates["status"] = StatusFailed
+		}
+
+		deliverer.logger.Warn("Webhook delivery failed", "delivery_id", delivery.ID, "subscription_id", subscription.ID, "attempts", attempts, "error", attempt.Error)
+	}
+
+	deliverer.record(ctx, delivery, updates)
+}
+
+// post sends the signed payload of the given delivery to the subscription URL and returns the attempt log
+func (deliverer *Deliverer) post(ctx context.Context, subscription *database.DBWebhookSubscription, delivery *database.DBWebhookDelivery) *database.DBWebhookAttempt {
+	started := time.Now()
+
+	attempt := &database.DBWebhookAttempt{
+		DeliveryID: delivery.ID,
+		IsActive:   true,
+		Created:    started.Local(),
+		CreatedBy:  "webhook-deliverer",
+		Modified:   started.Local(),
+		ModifiedBy: "webhook-deliverer",
+	}
+
+	payload := []byte(delivery.Payload)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(payload))
+	if err != nil {
+		attempt.Error = err.Error()
+
+		return attempt
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "book-service-webhook")
+	request.Header.Set(HeaderEvent, delivery.EventType)
+	request.Header.Set(HeaderID, delivery.DedupeKey)
+	request.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(delivery.ID), 10))
+	request.Header.Set(HeaderSignature, Sign(subscription.Secret, started, payload))
+
+	response, err := deliverer.client.Do(request)
+	attempt.Duration = time.Since(started).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+
+		return attempt
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxLoggedResponseBody))
+	attempt.ResponseStatus = response.StatusCode
+	attempt.ResponseBody = string(body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("subscriber responded with status %d", response.StatusCode)
+	}
+
+	return attempt
+}
+
+// record saves the outcome of the given delivery
+func (deliverer *Deliverer) record(ctx context.Context, delivery *database.DBWebhookDelivery, updates map[string]interface{}) {
+	if err := config.DB.WithContext(ctx).Model(&database.DBWebhookDelivery{}).Where("id = ?", delivery.ID).Updates(updates).Error; err != nil {
+		deliverer.logger.Error("Unable to record the webhook delivery outcome", "delivery_id", delivery.ID, "error", err)
+	}
+}
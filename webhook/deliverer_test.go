@@ -0,0 +1,196 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB replaces config.DB with an in-memory database holding the webhook tables for the duration of the test
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.Replace(t.Name(), "/", "_", -1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open the test database: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get the test connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	if err := db.AutoMigrate(&database.DBWebhookSubscription{}, &database.DBWebhookDelivery{}, &database.DBWebhookAttempt{}); err != nil {
+		t.Fatalf("migrate the test database: %v", err)
+	}
+
+	previous := config.DB
+	config.DB = db
+	t.Cleanup(func() {
+		config.DB = previous
+		sqlDB.Close()
+	})
+
+	return db
+}
+
+// newTestDeliverer returns a deliverer allowed to post to the local test servers
+func newTestDeliverer() *Deliverer {
+	return NewDeliverer(hclog.NewNullLogger(), &entities.WebhookConfiguration{
+		Lease:                60,
+		BaseBackoff:          10,
+		MaxBackoff:           60,
+		BatchSize:            10,
+		MaxAttempts:          3,
+		Timeout:              5,
+		AllowPrivateNetworks: true,
+	})
+}
+
+// seedDelivery inserts a subscription to the given URL along with a due delivery
+func seedDelivery(t *testing.T, db *gorm.DB, url string) *database.DBWebhookDelivery {
+	t.Helper()
+
+	subscription := &database.DBWebhookSubscription{OwnerID: 10, URL: url, Secret: "whsec_test", EventTypes: "book.created", IsActive: true}
+	if err := db.Create(subscription).Error; err != nil {
+		t.Fatalf("seed the subscription: %v", err)
+	}
+
+	delivery := &database.DBWebhookDelivery{
+		SubscriptionID: subscription.ID,
+		OutboxEventID:  1,
+		EventType:      "book.created",
+		DedupeKey:      "event-1",
+		Payload:        `{"id":"event-1"}`,
+		Status:         StatusPending,
+		NextAttemptAt:  time.Now().Local().Add(-time.Second),
+		IsActive:       true,
+	}
+	if err := db.Create(delivery).Error; err != nil {
+		t.Fatalf("seed the delivery: %v", err)
+	}
+
+	return delivery
+}
+
+// readDelivery returns the stored state of the given delivery
+func readDelivery(t *testing.T, db *gorm.DB, id uint) *database.DBWebhookDelivery {
+	t.Helper()
+
+	var delivery database.DBWebhookDelivery
+	if err := db.First(&delivery, id).Error; err != nil {
+		t.Fatalf("read the delivery: %v", err)
+	}
+
+	return &delivery
+}
+
+func TestDeliverPendingSignsThePayload(t *testing.T) {
+	db := newTestDB(t)
+
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = ioutil.ReadAll(r.Body)
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	delivery := seedDelivery(t, db, server.URL)
+
+	delivered, err := newTestDeliverer().DeliverPending(context.Background())
+	if err != nil || delivered != 1 {
+		t.Fatalf("expected a single delivery, got %d: %v", delivered, err)
+	}
+
+	// the receiver recomputes the signature from the timestamp it carries
+	signature := received.Header.Get(HeaderSignature)
+	unix := strings.TrimPrefix(strings.Split(signature, ",")[0], "t=")
+	var timestamp int64
+	fmt.Sscan(unix, &timestamp)
+	if expected := Sign("whsec_test", time.Unix(timestamp, 0), body); signature != expected {
+		t.Fatalf("expected the signature %s, got %s", expected, signature)
+	}
+
+	if received.Header.Get(HeaderID) != delivery.DedupeKey || received.Header.Get(HeaderEvent) != delivery.EventType {
+		t.Fatalf("expected the event headers of the delivery, got %v", received.Header)
+	}
+
+	stored := readDelivery(t, db, delivery.ID)
+	if stored.Status != StatusDelivered || stored.Attempts != 1 || stored.ResponseStatus != http.StatusNoContent {
+		t.Fatalf("expected the delivery delivered on the first attempt, got %+v", stored)
+	}
+}
+
+func TestDeliverPendingBacksOffFailedAttempts(t *testing.T) {
+	db := newTestDB(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	delivery := seedDelivery(t, db, server.URL)
+
+	if _, err := newTestDeliverer().DeliverPending(context.Background()); err != nil {
+		t.Fatalf("deliver the pending webhooks: %v", err)
+	}
+
+	stored := readDelivery(t, db, delivery.ID)
+	if stored.Status != StatusPending || stored.Attempts != 1 || !stored.NextAttemptAt.After(time.Now()) {
+		t.Fatalf("expected the delivery retried later, got %+v", stored)
+	}
+
+	var attempts int64
+	if err := db.Model(&database.DBWebhookAttempt{}).Where("delivery_id = ?", delivery.ID).Count(&attempts).Error; err != nil {
+		t.Fatalf("count the attempts: %v", err)
+	}
+
+	if attempts != 1 {
+		t.Fatalf("expected the failed attempt logged, got %d attempts", attempts)
+	}
+}
+
+func TestDeliverPendingStopsWithTheContext(t *testing.T) {
+	db := newTestDB(t)
+
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	delivery := seedDelivery(t, db, server.URL)
+
+	// a deliverer stopped by the shutdown claims and posts nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := newTestDeliverer().DeliverPending(ctx); err == nil {
+		t.Fatalf("expected the cancelled context to stop the delivery")
+	}
+
+	if called {
+		t.Fatalf("expected no request made to the subscriber")
+	}
+
+	if stored := readDelivery(t, db, delivery.ID); stored.Status != StatusPending || stored.Attempts != 0 {
+		t.Fatalf("expected the delivery left pending, got %+v", stored)
+	}
+}
@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned when a webhook URL resolves to an address the deliveries must not reach
+var ErrForbiddenAddress = errors.New("webhook URL resolves to a private, loopback or link-local address")
+
+// forbiddenNetworks are the networks of the service itself, its cluster and the cloud metadata endpoints,
+// the owners read the responses of their webhooks so the deliveries must never reach them
+var forbiddenNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+// Guard keeps the webhook deliveries away from the internal addresses
+type Guard struct {
+	allowPrivate bool
+}
+
+// NewGuard is a function to create new Guard struct, allowing the private addresses is meant for local development only
+func NewGuard(allowPrivate bool) *Guard {
+	return &Guard{allowPrivate}
+}
+
+// IsAllowed checks whether the deliveries may be posted to the given ip
+func (guard *Guard) IsAllowed(ip net.IP) bool {
+	if guard.allowPrivate {
+		return true
+	}
+
+	if ip == nil || ip.IsUnspecified() || ip.IsMulticast() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+
+	for _, network := range forbiddenNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckURL checks that the given URL is an http endpoint whose host only resolves to allowed addresses
+func (guard *Guard) CheckURL(ctx context.Context, rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return fmt.Errorf("webhook URL must be an http or https URL")
+	}
+
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, target.Hostname())
+	if err != nil {
+		return err
+	}
+
+	for _, address := range addresses {
+		if !guard.IsAllowed(address.IP) {
+			return ErrForbiddenAddress
+		}
+	}
+
+	return nil
+}
+
+// NewClient returns an http client that only connects to allowed addresses and doesn't follow redirects,
+// the address is checked once resolved so a host changing its DNS records after the subscription is still refused
+func (guard *Guard) NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if !guard.IsAllowed(net.ParseIP(host)) {
+				return ErrForbiddenAddress
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+
+		// a redirect could lead anywhere, the subscriber gets the 3xx recorded as a failed attempt
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// parseNetworks parses the given CIDR networks
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks
+}
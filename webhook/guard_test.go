@@ -0,0 +1,113 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGuardIsAllowed(t *testing.T) {
+	guard := NewGuard(false)
+
+	for _, address := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fd00::1", "fe80::1", "224.0.0.1"} {
+		if guard.IsAllowed(net.ParseIP(address)) {
+			t.Fatalf("expected %s refused", address)
+		}
+	}
+
+	for _, address := range []string{"8.8.8.8", "1.1.1.1", "2606:4700:4700::1111"} {
+		if !guard.IsAllowed(net.ParseIP(address)) {
+			t.Fatalf("expected %s allowed", address)
+		}
+	}
+
+	if !NewGuard(true).IsAllowed(net.ParseIP("127.0.0.1")) {
+		t.Fatalf("expected the private addresses allowed for local development")
+	}
+}
+
+func TestGuardCheckURL(t *testing.T) {
+	guard := NewGuard(false)
+
+	if err := guard.CheckURL(context.Background(), "http://127.0.0.1:8080/hook"); err != ErrForbiddenAddress {
+		t.Fatalf("expected a loopback URL refused, got %v", err)
+	}
+
+	for _, rawURL := range []string{"ftp://8.8.8.8/hook", "http:///hook", "::"} {
+		if err := guard.CheckURL(context.Background(), rawURL); err == nil {
+			t.Fatalf("expected %s refused", rawURL)
+		}
+	}
+
+	if err := guard.CheckURL(context.Background(), "https://8.8.8.8/hook"); err != nil {
+		t.Fatalf("expected a public URL allowed, got %v", err)
+	}
+}
+
+func TestGuardClientRefusesPrivateConnections(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	// the address is checked on connect, whatever the URL looked like when subscribing
+	_, err := NewGuard(false).NewClient(time.Second).Get(server.URL)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("expected the connection refused, got %v", err)
+	}
+
+	if called {
+		t.Fatalf("expected no request reaching the server")
+	}
+}
+
+func TestGuardClientDoesNotFollowRedirects(t *testing.T) {
+	redirected := false
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+
+	server := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+	defer server.Close()
+
+	response, err := NewGuard(true).NewClient(time.Second).Get(server.URL)
+	if err != nil {
+		t.Fatalf("post to the subscriber: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusFound || redirected {
+		t.Fatalf("expected the redirect returned as is, got %d", response.StatusCode)
+	}
+}
+
+func TestSign(t *testing.T) {
+	timestamp := time.Unix(1600000000, 0)
+	payload := []byte(`{"id":"event-1"}`)
+
+	signature := Sign("whsec_test", timestamp, payload)
+	if signature != Sign("whsec_test", timestamp, payload) {
+		t.Fatalf("expected the signature to be deterministic")
+	}
+
+	if signature[:13] != "t=1600000000," {
+		t.Fatalf("expected the signature to carry the timestamp, got %s", signature)
+	}
+
+	// a different secret, timestamp or payload gives a different signature
+	for _, other := range []string{
+		Sign("whsec_other", timestamp, payload),
+		Sign("whsec_test", timestamp.Add(time.Second), payload),
+		Sign("whsec_test", timestamp, []byte(`{"id":"event-2"}`)),
+	} {
+		if other == signature {
+			t.Fatalf("expected a different signature, got %s", other)
+		}
+	}
+}
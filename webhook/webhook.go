@@ -0,0 +1,265 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fakhripraya/book-service/config"
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/outbox"
+	"github.com/hashicorp/go-hclog"
+	"gorm.io/gorm/clause"
+)
+
+// Webhook delivery statuses
+const (
+	StatusPending   uint = 0
+	StatusDelivered uint = 1
+	StatusFailed    uint = 2 // gave up after the maximum attempts
+)
+
+// Headers sent with every webhook delivery
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderID        = "X-Webhook-Id" // the event dedupe key, the same on every redelivery
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Payload is the JSON body posted to the subscribed URL
+type Payload struct {
+	ID         string             `json:"id"`
+	Type       string             `json:"type"`
+	OccurredAt time.Time          `json:"occurred_at"`
+	Data       entities.BookEvent `json:"data"`
+}
+
+// Service defines a struct for the owner webhook subscriptions,
+// it is an outbox publisher that queues a delivery for every subscription of the event owner
+type Service struct {
+	logger hclog.Logger
+	guard  *Guard
+}
+
+// NewService is a function to create new webhook Service struct based on the given webhook configuration
+func NewService(newLogger hclog.Logger, webhookConfig *entities.WebhookConfiguration) *Service {
+	return &Service{newLogger, NewGuard(webhookConfig.AllowPrivateNetworks)}
+}
+
+// CheckURL checks that the given subscription URL is an http endpoint reachable by the deliveries
+func (service *Service) CheckURL(ctx context.Context, rawURL string) error {
+	return service.guard.CheckURL(ctx, rawURL)
+}
+
+// Name returns the publisher name used in the logs
+func (service *Service) Name() string {
+	return "webhook"
+}
+
+// Publish queues a delivery of the given outbox booking event for every matching subscription of the kost owner,
+// an event published twice is only queued once per subscription
+func (service *Service) Publish(ctx context.Context, event *outbox.Event) error {
+
+	// look for the active subscriptions of the kost owner
+	var subscriptions []database.DBWebhookSubscription
+	if err := config.DB.WithContext(ctx).Where("owner_id = ? AND is_active = ?", event.Book.OwnerID, true).Find(&subscriptions).Error; err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(&Payload{
+		ID:         event.DedupeKey,
+		Type:       event.Book.Type,
+		OccurredAt: event.Book.OccurredAt,
+		Data:       event.Book,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if !subscribesTo(&subscription, event.Book.Type) {
+			continue
+		}
+
+		// set variables
+		var newDelivery database.DBWebhookDelivery
+
+		newDelivery.SubscriptionID = subscription.ID
+		newDelivery.OutboxEventID = event.ID
+		newDelivery.EventType = event.Book.Type
+		newDelivery.DedupeKey = event.DedupeKey
+		newDelivery.Payload = string(payload)
+		newDelivery.Status = StatusPending
+		newDelivery.NextAttemptAt = time.Now().Local()
+		newDelivery.IsActive = true
+		newDelivery.Created = time.Now().Local()
+		newDelivery.CreatedBy = "webhook-service"
+		newDelivery.Modified = time.Now().Local()
+		newDelivery.ModifiedBy = "webhook-service"
+
+		// insert the new delivery to database, skipping the already queued ones
+		if err := config.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&newDelivery).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddSubscription subscribes the given owner to the given event types, the returned subscription holds the generated secret
+func (service *Service) AddSubscription(ctx context.Context, currentUser *database.MasterUser, url string, eventTypes []string) (*database.DBWebhookSubscription, error) {
+
+	// set variables
+	var newSubscription database.DBWebhookSubscription
+
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	newSubscription.OwnerID = currentUser.ID
+	newSubscription.URL = url
+	newSubscription.Secret = secret
+	newSubscription.EventTypes = strings.Join(eventTypes, ",")
+	newSubscription.IsActive = true
+	newSubscription.Created = time.Now().Local()
+	newSubscription.CreatedBy = currentUser.Username
+	newSubscription.Modified = time.Now().Local()
+	newSubscription.ModifiedBy = currentUser.Username
+
+	// insert the new subscription to database
+	if err := config.DB.WithContext(ctx).Create(&newSubscription).Error; err != nil {
+		return nil, err
+	}
+
+	return &newSubscription, nil
+}
+
+// GetSubscriptions returns the active subscriptions of the given owner
+func (service *Service) GetSubscriptions(ctx context.Context, ownerID uint) ([]database.DBWebhookSubscription, error) {
+	var subscriptions []database.DBWebhookSubscription
+	err := config.DB.WithContext(ctx).Where("owner_id = ? AND is_active = ?", ownerID, true).Order("id").Find(&subscriptions).Error
+
+	return subscriptions, err
+}
+
+// GetSubscription returns the given active subscription of the given owner
+func (service *Service) GetSubscription(ctx context.Context, ownerID, subscriptionID uint) (*database.DBWebhookSubscription, error) {
+	var subscription database.DBWebhookSubscription
+	if err := config.DB.WithContext(ctx).Where("id = ? AND owner_id = ? AND is_active = ?", subscriptionID, ownerID, true).First(&subscription).Error; err != nil {
+		return nil, err
+	}
+
+	return &subscription, nil
+}
+
+// RemoveSubscription deactivates the given subscription of the current user, its pending deliveries are dropped
+func (service *Service) RemoveSubscription(ctx context.Context, currentUser *database.MasterUser, subscriptionID uint) error {
+	subscription, err := service.GetSubscription(ctx, currentUser.ID, subscriptionID)
+	if err != nil {
+		return err
+	}
+
+	return config.DB.WithContext(ctx).Model(subscription).Updates(map[string]interface{}{
+		"is_active":   false,
+		"modified":    time.Now().Local(),
+		"modified_by": currentUser.Username,
+	}).Error
+}
+
+// GetDeliveries returns the latest deliveries of the given subscription, newest first
+func (service *Service) GetDeliveries(ctx context.Context, ownerID, subscriptionID uint, limit int) ([]database.DBWebhookDelivery, error) {
+	if _, err := service.GetSubscription(ctx, ownerID, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	var deliveries []database.DBWebhookDelivery
+	err := config.DB.WithContext(ctx).Where("subscription_id = ?", subscriptionID).Order("id desc").Limit(limit).Find(&deliveries).Error
+
+	return deliveries, err
+}
+
+// GetAttempts returns the logged requests of the given deliveries keyed by delivery id
+func (service *Service) GetAttempts(ctx context.Context, deliveryIDs []uint) (map[uint][]database.DBWebhookAttempt, error) {
+	attempts := map[uint][]database.DBWebhookAttempt{}
+	if len(deliveryIDs) == 0 {
+		return attempts, nil
+	}
+
+	var rows []database.DBWebhookAttempt
+	if err := config.DB.WithContext(ctx).Where("delivery_id IN ?", deliveryIDs).Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		attempts[row.DeliveryID] = append(attempts[row.DeliveryID], row)
+	}
+
+	return attempts, nil
+}
+
+// Redeliver queues the given delivery of the current user again with a fresh set of attempts
+func (service *Service) Redeliver(ctx context.Context, currentUser *database.MasterUser, deliveryID uint) (*database.DBWebhookDelivery, error) {
+
+	// look for the delivery
+	var delivery database.DBWebhookDelivery
+	if err := config.DB.WithContext(ctx).Where("id = ?", deliveryID).First(&delivery).Error; err != nil {
+		return nil, err
+	}
+
+	// only the deliveries of an active subscription of the current user can be redelivered
+	if _, err := service.GetSubscription(ctx, currentUser.ID, delivery.SubscriptionID); err != nil {
+		return nil, err
+	}
+
+	err := config.DB.WithContext(ctx).Model(&delivery).Updates(map[string]interface{}{
+		"status":          StatusPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now().Local(),
+		"modified":        time.Now().Local(),
+		"modified_by":     currentUser.Username,
+	}).Error
+
+	return &delivery, err
+}
+
+// Sign returns the signature header value of the given payload, the HMAC-SHA256 of "<timestamp>.<payload>"
+// keyed with the subscription secret, receivers recompute it to authenticate the delivery
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix + "."))
+	mac.Write(payload)
+
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// subscribesTo checks whether the given subscription wants the given event type
+func subscribesTo(subscription *database.DBWebhookSubscription, eventType string) bool {
+	for _, subscribed := range strings.Split(subscription.EventTypes, ",") {
+		if subscribed == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// generateSecret returns a new random subscription secret
+func generateSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	return "whsec_" + hex.EncodeToString(raw), nil
+}
@@ -0,0 +1,117 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/fakhripraya/book-service/database"
+	"github.com/fakhripraya/book-service/entities"
+	"github.com/fakhripraya/book-service/outbox"
+	"github.com/hashicorp/go-hclog"
+)
+
+// the owners of the test subscriptions
+var (
+	webhookOwner = &database.MasterUser{ID: 10, Username: "owner"}
+	otherOwner   = &database.MasterUser{ID: 11, Username: "other"}
+)
+
+// newTestService returns a webhook service allowed to subscribe the local test servers
+func newTestService() *Service {
+	return NewService(hclog.NewNullLogger(), &entities.WebhookConfiguration{AllowPrivateNetworks: true})
+}
+
+func TestPublishQueuesADeliveryPerMatchingSubscription(t *testing.T) {
+	db := newTestDB(t)
+	service := newTestService()
+	ctx := context.Background()
+
+	created, err := service.AddSubscription(ctx, webhookOwner, "https://example.com/created", []string{entities.EventBookCreated})
+	if err != nil {
+		t.Fatalf("add the subscription: %v", err)
+	}
+
+	if len(created.Secret) != len("whsec_")+64 {
+		t.Fatalf("expected a generated secret, got %q", created.Secret)
+	}
+
+	// a subscription to other event types and a subscription of another owner get nothing
+	for _, subscription := range []struct {
+		owner      *database.MasterUser
+		eventTypes []string
+	}{
+		{webhookOwner, []string{entities.EventPaymentReceived}},
+		{otherOwner, []string{entities.EventBookCreated}},
+	} {
+		if _, err := service.AddSubscription(ctx, subscription.owner, "https://example.com/other", subscription.eventTypes); err != nil {
+			t.Fatalf("add the subscription: %v", err)
+		}
+	}
+
+	event := &outbox.Event{ID: 1, DedupeKey: "book.created:1", Book: entities.BookEvent{Type: entities.EventBookCreated, BookID: 1, OwnerID: webhookOwner.ID}}
+
+	// an event published twice is queued once
+	for i := 0; i < 2; i++ {
+		if err := service.Publish(ctx, event); err != nil {
+			t.Fatalf("publish the event: %v", err)
+		}
+	}
+
+	var deliveries []database.DBWebhookDelivery
+	if err := db.Find(&deliveries).Error; err != nil {
+		t.Fatalf("read the deliveries: %v", err)
+	}
+
+	if len(deliveries) != 1 || deliveries[0].SubscriptionID != created.ID || deliveries[0].Status != StatusPending {
+		t.Fatalf("expected a single pending delivery to the matching subscription, got %+v", deliveries)
+	}
+
+	var payload Payload
+	if err := json.Unmarshal([]byte(deliveries[0].Payload), &payload); err != nil {
+		t.Fatalf("decode the payload: %v", err)
+	}
+
+	if payload.ID != "book.created:1" || payload.Type != entities.EventBookCreated || payload.Data.BookID != 1 {
+		t.Fatalf("expected the event in the payload, got %+v", payload)
+	}
+}
+
+func TestSubscriptionsAreScopedToTheirOwner(t *testing.T) {
+	db := newTestDB(t)
+	service := newTestService()
+	ctx := context.Background()
+
+	// the delivery belongs to a subscription of webhookOwner
+	delivery := seedDelivery(t, db, "https://example.com/hook")
+	subscriptionID := delivery.SubscriptionID
+	if err := db.Model(delivery).Updates(map[string]interface{}{"status": StatusFailed, "attempts": 3}).Error; err != nil {
+		t.Fatalf("fail the delivery: %v", err)
+	}
+
+	// another owner can neither redeliver nor remove the subscription
+	if _, err := service.Redeliver(ctx, otherOwner, delivery.ID); err == nil {
+		t.Fatal("expected the redelivery of another owner refused")
+	}
+
+	if err := service.RemoveSubscription(ctx, otherOwner, subscriptionID); err == nil {
+		t.Fatal("expected the removal by another owner refused")
+	}
+
+	if _, err := service.Redeliver(ctx, webhookOwner, delivery.ID); err != nil {
+		t.Fatalf("redeliver: %v", err)
+	}
+
+	if stored := readDelivery(t, db, delivery.ID); stored.Status != StatusPending || stored.Attempts != 0 {
+		t.Fatalf("expected the delivery queued again, got %+v", stored)
+	}
+
+	if err := service.RemoveSubscription(ctx, webhookOwner, subscriptionID); err != nil {
+		t.Fatalf("remove the subscription: %v", err)
+	}
+
+	subscriptions, err := service.GetSubscriptions(ctx, webhookOwner.ID)
+	if err != nil || len(subscriptions) != 0 {
+		t.Fatalf("expected the removed subscription hidden, got %d: %v", len(subscriptions), err)
+	}
+}